@@ -84,6 +84,7 @@ func (anyTypeGateway *AnyTypeEdgeGateway) GetNsxtEdgeGateway() (*NsxtEdgeGateway
 		EdgeGateway: anyTypeGateway.EdgeGateway,
 		client:      anyTypeGateway.client,
 	}
+	nsxtEdgeGateway.initOpenApiMetadata()
 
 	return nsxtEdgeGateway, nil
 }
@@ -7,8 +7,10 @@ package govcd
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -53,6 +55,36 @@ type Client struct {
 	// "User-Agent: <product> / <product-version> <comment>"
 	UserAgent string
 
+	// MetadataRetryCount is the number of times that a request to add, merge or delete metadata is retried when VCD
+	// responds with a 5xx error or the request fails because of a network error. 0 (default) disables retries.
+	MetadataRetryCount int
+	// MetadataRetryBackoff is the time to wait before retrying a metadata request, which is doubled on every retry
+	MetadataRetryBackoff time.Duration
+	// MetadataStreamThresholdBytes is the size of a response with all the metadata of an entity above which it is
+	// decoded while it is read, instead of being buffered in memory first. Responses of unknown size are also streamed.
+	// Streamed responses are not logged. 0 (default) disables streaming.
+	MetadataStreamThresholdBytes int64
+	// OnMetadataChange, if not nil, is called for every metadata entry that the SDK adds, updates or deletes, once the
+	// operation has finished successfully. It receives the HREF of the entity (its ID for OpenAPI metadata), the key,
+	// the operation (MetadataChangeAdd, MetadataChangeMerge or MetadataChangeDelete) and the values before and after
+	// the change, which are nil when the entry is absent or its previous value could not be retrieved.
+	// Methods that return a Task without waiting for it don't call it.
+	OnMetadataChange func(entityHref, key, op string, oldValue, newValue *types.MetadataValue)
+	// AllowUnknownMetadataDomains, if true, makes the metadata methods that take an explicit domain, such as
+	// VCDClient.GetMetadataByKeyDomainAndHref, accept domains other than GENERAL and SYSTEM and send them to VCD as
+	// they are, for forward compatibility. By default, unknown domains are rejected before sending any request.
+	AllowUnknownMetadataDomains bool
+	// OpenApiDiskMetadata, if true, makes the Disk metadata methods use the OpenAPI metadata endpoint for disks with an
+	// OpenAPI ID when the negotiated API version is 38.0+ (VCD 10.5+), as their XML HREF can be stale right after
+	// attaching or detaching them. The OpenAPI endpoint doesn't support the DateTime type, so by default (false) the
+	// XML API is always used.
+	OpenApiDiskMetadata bool
+	// ProtectSystemMetadataKeys, if not empty, makes the SDK refuse to add, modify or delete the SYSTEM domain metadata
+	// entries whose key matches any of these patterns, with the syntax of path.Match (e.g. "owner.*"), returning a
+	// *MetadataValidationError that wraps ErrProtectedMetadataKey before sending any request. GENERAL domain entries
+	// are never affected. It protects entries owned by other controllers in shared environments. Empty by default.
+	ProtectSystemMetadataKeys []string
+
 	supportedVersions SupportedVersions // Versions from /api/versions endpoint
 	customHeader      http.Header
 }
@@ -419,6 +451,12 @@ func (client *Client) ExecuteTaskRequest(pathURL, requestType, contentType, erro
 	return client.executeTaskRequest(pathURL, requestType, contentType, errorMessage, payload, client.APIVersion)
 }
 
+// ExecuteTaskRequestWithContext behaves like ExecuteTaskRequest, but the request is bound to the given context, so it
+// is aborted as soon as the context is cancelled.
+func (client *Client) ExecuteTaskRequestWithContext(ctx context.Context, pathURL, requestType, contentType, errorMessage string, payload interface{}) (Task, error) {
+	return client.executeTaskRequestWithContext(ctx, pathURL, requestType, contentType, errorMessage, payload, client.APIVersion)
+}
+
 // ExecuteTaskRequestWithApiVersion helper function creates request, runs it, checks response and parses task from response.
 // pathURL - request URL
 // requestType - HTTP method type
@@ -440,23 +478,74 @@ func (client *Client) ExecuteTaskRequestWithApiVersion(pathURL, requestType, con
 // apiVersion - api version which will be used in request
 // E.g. client.ExecuteTaskRequest(updateDiskLink.HREF, http.MethodPut, updateDiskLink.Type, "error updating disk: %s", xmlPayload)
 func (client *Client) executeTaskRequest(pathURL, requestType, contentType, errorMessage string, payload interface{}, apiVersion string) (Task, error) {
+	return client.executeTaskRequestWithContext(context.Background(), pathURL, requestType, contentType, errorMessage, payload, apiVersion)
+}
+
+// executeTaskRequestWithContext is the implementation of executeTaskRequest, with the request bound to the given context
+func (client *Client) executeTaskRequestWithContext(ctx context.Context, pathURL, requestType, contentType, errorMessage string, payload interface{}, apiVersion string) (Task, error) {
+	return client.executeTaskRequestWithRetry(ctx, 0, 0, pathURL, requestType, contentType, errorMessage, payload, apiVersion)
+}
+
+// executeTaskRequestWithRetry behaves like executeTaskRequestWithContext, but the request is retried up to 'retries'
+// times when it fails with a 5xx error or a network error, as they are usually transient. Other errors, like 4xx ones,
+// are returned immediately. The first retry waits 'backoff', and the wait is doubled on every subsequent retry.
+// If the request was retried, the returned error contains the number of attempts made, and wraps the last error, so
+// callers can still inspect it.
+func (client *Client) executeTaskRequestWithRetry(ctx context.Context, retries int, backoff time.Duration, pathURL, requestType, contentType, errorMessage string, payload interface{}, apiVersion string) (Task, error) {
 
 	if !isMessageWithPlaceHolder(errorMessage) {
 		return Task{}, fmt.Errorf("error message has to include place holder for error")
 	}
 
-	resp, err := executeRequestWithApiVersion(pathURL, requestType, contentType, payload, client, apiVersion)
+	resp, err := client.executeRequestWithRetry(ctx, retries, backoff, pathURL, requestType, contentType, payload, apiVersion)
 	if err != nil {
+		// A cancelled context is returned as is, so callers can identify it
+		if ctx.Err() != nil {
+			return Task{}, ctx.Err()
+		}
 		return Task{}, fmt.Errorf(errorMessage, err)
 	}
 
+	return decodeTaskResponse(client, resp, errorMessage)
+}
+
+// executeRequestWithRetry sends the request of executeTaskRequestWithRetry, retrying it as described there, and
+// returns the response. The error of the last attempt is returned as it is when the request was not retried.
+func (client *Client) executeRequestWithRetry(ctx context.Context, retries int, backoff time.Duration, pathURL, requestType, contentType string, payload interface{}, apiVersion string) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := executeRequestCustomErrWithContext(ctx, pathURL, map[string]string{}, requestType, contentType, payload, client, &types.Error{}, apiVersion)
+		if err == nil {
+			return resp, nil
+		}
+		// A cancelled context is returned as is, so callers can identify it
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if attempt > retries || !isRetryableRequestError(err) {
+			if attempt > 1 {
+				return nil, fmt.Errorf("request failed after %d attempts: %w", attempt, err)
+			}
+			return nil, err
+		}
+		util.Logger.Printf("[TRACE] retrying request %s %s after error (attempt %d): %s", requestType, pathURL, attempt, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff << (attempt - 1)):
+		}
+	}
+}
+
+// decodeTaskResponse decodes the task returned in the given response. The given error message, which must include a
+// place holder, is used if the body can't be closed.
+func decodeTaskResponse(client *Client, resp *http.Response, errorMessage string) (Task, error) {
 	task := NewTask(client)
 
-	if err = decodeBody(types.BodyTypeXML, resp, task.Task); err != nil {
+	if err := decodeBody(types.BodyTypeXML, resp, task.Task); err != nil {
 		return Task{}, fmt.Errorf("error decoding Task response: %s", err)
 	}
 
-	err = resp.Body.Close()
+	err := resp.Body.Close()
 	if err != nil {
 		return Task{}, fmt.Errorf(errorMessage, err)
 	}
@@ -533,6 +622,12 @@ func (client *Client) ExecuteRequest(pathURL, requestType, contentType, errorMes
 	return client.executeRequest(pathURL, requestType, contentType, errorMessage, payload, out, client.APIVersion)
 }
 
+// ExecuteRequestWithContext behaves like ExecuteRequest, but the request is bound to the given context, so it is
+// aborted as soon as the context is cancelled.
+func (client *Client) ExecuteRequestWithContext(ctx context.Context, pathURL, requestType, contentType, errorMessage string, payload, out interface{}) (*http.Response, error) {
+	return client.executeRequestWithContext(ctx, pathURL, requestType, contentType, errorMessage, payload, out, client.APIVersion)
+}
+
 // ExecuteRequestWithApiVersion helper function creates request, runs it, check responses and parses out interface from response.
 // pathURL - request URL
 // requestType - HTTP method type
@@ -558,13 +653,22 @@ func (client *Client) ExecuteRequestWithApiVersion(pathURL, requestType, content
 // E.g. 	unmarshalledAdminOrg := &types.AdminOrg{}
 // client.ExecuteRequest(adminOrg.AdminOrg.HREF, http.MethodGet, "", "error refreshing organization: %s", nil, unmarshalledAdminOrg)
 func (client *Client) executeRequest(pathURL, requestType, contentType, errorMessage string, payload, out interface{}, apiVersion string) (*http.Response, error) {
+	return client.executeRequestWithContext(context.Background(), pathURL, requestType, contentType, errorMessage, payload, out, apiVersion)
+}
+
+// executeRequestWithContext is the implementation of executeRequest, with the request bound to the given context
+func (client *Client) executeRequestWithContext(ctx context.Context, pathURL, requestType, contentType, errorMessage string, payload, out interface{}, apiVersion string) (*http.Response, error) {
 
 	if !isMessageWithPlaceHolder(errorMessage) {
 		return &http.Response{}, fmt.Errorf("error message has to include place holder for error")
 	}
 
-	resp, err := executeRequestWithApiVersion(pathURL, requestType, contentType, payload, client, apiVersion)
+	resp, err := executeRequestCustomErrWithContext(ctx, pathURL, map[string]string{}, requestType, contentType, payload, client, &types.Error{}, apiVersion)
 	if err != nil {
+		// A cancelled context is returned as is, so callers can identify it
+		if ctx.Err() != nil {
+			return resp, ctx.Err()
+		}
 		return resp, fmt.Errorf(errorMessage, err)
 	}
 
@@ -627,6 +731,13 @@ func executeRequestWithApiVersion(pathURL, requestType, contentType string, payl
 
 // executeRequestCustomErr performs request and unmarshals API error to errType if not 2xx status was returned
 func executeRequestCustomErr(pathURL string, params map[string]string, requestType, contentType string, payload interface{}, client *Client, errType error, apiVersion string) (*http.Response, error) {
+	return executeRequestCustomErrWithContext(context.Background(), pathURL, params, requestType, contentType, payload, client, errType, apiVersion)
+}
+
+// executeRequestCustomErrWithContext is the implementation of executeRequestCustomErr, with the request bound to the
+// given context
+func executeRequestCustomErrWithContext(ctx context.Context, pathURL string, params map[string]string, requestType, contentType string, payload interface{}, client *Client, errType error, apiVersion string) (*http.Response, error) {
+	apiVersion = contextApiVersion(ctx, apiVersion)
 	requestURI, err := url.ParseRequestURI(pathURL)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't parse path request URI '%s': %s", pathURL, err)
@@ -654,8 +765,9 @@ func executeRequestCustomErr(pathURL string, params map[string]string, requestTy
 	}
 
 	setHttpUserAgent(client.UserAgent, req)
+	addContextHttpHeaders(ctx, req)
 
-	resp, err := client.Http.Do(req)
+	resp, err := client.Http.Do(req.WithContext(ctx))
 	if err != nil {
 		return resp, err
 	}
@@ -663,6 +775,17 @@ func executeRequestCustomErr(pathURL string, params map[string]string, requestTy
 	return checkRespWithErrType(types.BodyTypeXML, resp, err, errType)
 }
 
+// isRetryableRequestError returns true if the given error, returned by executeRequestCustomErr, is a 5xx error sent by
+// VCD or a network error, which may not happen again if the request is retried
+func isRetryableRequestError(err error) bool {
+	var vcdError *types.Error
+	if errors.As(err, &vcdError) {
+		return vcdError.MajorErrorCode >= http.StatusInternalServerError
+	}
+	var urlError *url.Error
+	return errors.As(err, &urlError)
+}
+
 // setHttpUserAgent adds User-Agent string to HTTP request. When supplied string is empty - header will not be set
 func setHttpUserAgent(userAgent string, req *http.Request) {
 	if userAgent != "" {
@@ -775,6 +898,81 @@ func (client *Client) RemoveProvidedCustomHeaders(values map[string]string) {
 	}
 }
 
+// httpHeadersContextKey is the key of the HTTP header values stored in a context by ContextWithHttpHeaders
+type httpHeadersContextKey struct{}
+
+// ContextWithHttpHeaders returns a copy of the given context that carries the given HTTP header values, which are
+// added to every request bound to the returned context, such as the ones sent by the metadata methods with Ctx suffix.
+// Unlike SetCustomHeader, the client is not modified, so it can be used to set per-call headers, like an X-Request-ID
+// for tracing, in an environment with concurrent operations.
+// Values set in a parent context are kept, unless overridden. In XML API requests, values for the same header set with
+// SetCustomHeader are replaced, while OpenAPI requests receive them as additional header values (see
+// contextHttpHeaderValues), so they are sent next to the ones set with SetCustomHeader.
+func ContextWithHttpHeaders(ctx context.Context, values map[string]string) context.Context {
+	headers := make(http.Header)
+	if parentHeaders, ok := ctx.Value(httpHeadersContextKey{}).(http.Header); ok {
+		headers = parentHeaders.Clone()
+	}
+	for k, v := range values {
+		headers.Set(k, v)
+	}
+	return context.WithValue(ctx, httpHeadersContextKey{}, headers)
+}
+
+// apiVersionContextKey is the key of the API version stored in a context by ContextWithApiVersion
+type apiVersionContextKey struct{}
+
+// ContextWithApiVersion returns a copy of the given context that carries the given API version, which is sent in the
+// Accept header of every request bound to the returned context, such as the ones sent by the metadata methods with Ctx
+// suffix, instead of the version negotiated by the client. The client is not modified, so it can be used to test the
+// behavior of a single request with another API version. An empty version has no effect.
+func ContextWithApiVersion(ctx context.Context, apiVersion string) context.Context {
+	return context.WithValue(ctx, apiVersionContextKey{}, apiVersion)
+}
+
+// contextApiVersion returns the API version stored in the given context by ContextWithApiVersion, if any, or the
+// given default one otherwise
+func contextApiVersion(ctx context.Context, defaultApiVersion string) string {
+	apiVersion, ok := ctx.Value(apiVersionContextKey{}).(string)
+	if !ok || apiVersion == "" {
+		return defaultApiVersion
+	}
+	return apiVersion
+}
+
+// addContextHttpHeaders adds to the given request the HTTP header values stored in the given context by
+// ContextWithHttpHeaders
+func addContextHttpHeaders(ctx context.Context, req *http.Request) {
+	headers, ok := ctx.Value(httpHeadersContextKey{}).(http.Header)
+	if !ok {
+		return
+	}
+	for k, v := range headers {
+		req.Header.Del(k)
+		for _, v1 := range v {
+			req.Header.Add(k, v1)
+		}
+	}
+}
+
+// contextHttpHeaderValues returns a copy of the given header values that also contains the HTTP header values stored
+// in the given context by ContextWithHttpHeaders, which take precedence. It is meant for the OpenAPI metadata requests,
+// which receive their headers as the additionalHeader map of the generic OpenAPI functions.
+func contextHttpHeaderValues(ctx context.Context, values map[string]string) map[string]string {
+	headers, ok := ctx.Value(httpHeadersContextKey{}).(http.Header)
+	if !ok {
+		return values
+	}
+	result := make(map[string]string, len(values)+len(headers))
+	for k, v := range values {
+		result[k] = v
+	}
+	for k := range headers {
+		result[k] = headers.Get(k)
+	}
+	return result
+}
+
 // Retrieves the administrator URL of a given HREF
 func getAdminURL(href string) string {
 	adminApi := "/api/admin/"
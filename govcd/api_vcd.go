@@ -291,6 +291,34 @@ func WithMaxRetryTimeout(timeoutSeconds int) VCDClientOption {
 	}
 }
 
+// WithMetadataRetry allows to retry the requests that add, merge or delete metadata up to 'retries' times when they
+// fail with a 5xx error or a network error, waiting 'backoff' before the first retry and doubling it on every retry
+func WithMetadataRetry(retries int, backoff time.Duration) VCDClientOption {
+	return func(vcdClient *VCDClient) error {
+		vcdClient.Client.MetadataRetryCount = retries
+		vcdClient.Client.MetadataRetryBackoff = backoff
+		return nil
+	}
+}
+
+// WithMetadataStreamThreshold allows to decode the responses with all the metadata of an entity while they are read
+// when they are bigger than 'thresholdBytes' or their size is unknown, reducing the memory used for big metadata sets
+func WithMetadataStreamThreshold(thresholdBytes int64) VCDClientOption {
+	return func(vcdClient *VCDClient) error {
+		vcdClient.Client.MetadataStreamThresholdBytes = thresholdBytes
+		return nil
+	}
+}
+
+// WithOpenApiDiskMetadata makes the Disk metadata methods use the OpenAPI metadata endpoint for disks with an OpenAPI
+// ID when the negotiated API version is 38.0+, instead of the XML API. The DateTime type is not supported in that case.
+func WithOpenApiDiskMetadata() VCDClientOption {
+	return func(vcdClient *VCDClient) error {
+		vcdClient.Client.OpenApiDiskMetadata = true
+		return nil
+	}
+}
+
 // WithAPIVersion allows to override default API version. Please be cautious
 // about changing the version as the default specified is the most tested.
 func WithAPIVersion(version string) VCDClientOption {
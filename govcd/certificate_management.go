@@ -16,6 +16,9 @@ type Certificate struct {
 	CertificateLibrary *types.CertificateLibraryItem
 	Href               string
 	client             *Client
+	// tenantContext is the tenant context the certificate was retrieved with, or nil for the System context
+	tenantContext *TenantContext
+	OpenApiMetadataEntity
 }
 
 // GetCertificateFromLibraryById Returns certificate from library of certificates
@@ -43,6 +46,7 @@ func getCertificateFromLibraryById(client *Client, id string, additionalHeader m
 		client:             client,
 		Href:               urlRef.String(),
 	}
+	certificate.initOpenApiMetadata()
 
 	err = client.OpenApiGetItem(minimumApiVersion, urlRef, nil, certificate.CertificateLibrary, additionalHeader)
 	if err != nil {
@@ -76,7 +80,12 @@ func (adminOrg *AdminOrg) GetCertificateFromLibraryById(id string) (*Certificate
 	if err != nil {
 		return nil, err
 	}
-	return getCertificateFromLibraryById(adminOrg.client, id, getTenantContextHeader(tenantContext))
+	certificate, err := getCertificateFromLibraryById(adminOrg.client, id, getTenantContextHeader(tenantContext))
+	if err != nil {
+		return nil, err
+	}
+	certificate.tenantContext = tenantContext
+	return certificate, nil
 }
 
 // addCertificateToLibrary uploads certificates with configuration details
@@ -98,6 +107,7 @@ func addCertificateToLibrary(client *Client, certificateConfig *types.Certificat
 		client:             client,
 		Href:               urlRef.String(),
 	}
+	typeResponse.initOpenApiMetadata()
 
 	err = client.OpenApiPostItem(apiVersion, urlRef, nil,
 		certificateConfig, typeResponse.CertificateLibrary, additionalHeader)
@@ -114,7 +124,12 @@ func (adminOrg *AdminOrg) AddCertificateToLibrary(certificateConfig *types.Certi
 	if err != nil {
 		return nil, err
 	}
-	return addCertificateToLibrary(adminOrg.client, certificateConfig, getTenantContextHeader(tenantContext))
+	certificate, err := addCertificateToLibrary(adminOrg.client, certificateConfig, getTenantContextHeader(tenantContext))
+	if err != nil {
+		return nil, err
+	}
+	certificate.tenantContext = tenantContext
+	return certificate, nil
 }
 
 // AddCertificateToLibrary uploads certificates with configuration details
@@ -153,6 +168,7 @@ func getAllCertificateFromLibrary(client *Client, queryParameters url.Values, ad
 			client:             client,
 			Href:               urlRef.String(),
 		}
+		wrappedCertificate.initOpenApiMetadata()
 		wrappedCertificates = append(wrappedCertificates, wrappedCertificate)
 	}
 
@@ -172,7 +188,14 @@ func (adminOrg *AdminOrg) GetAllCertificatesFromLibrary(queryParameters url.Valu
 	if err != nil {
 		return nil, err
 	}
-	return getAllCertificateFromLibrary(adminOrg.client, queryParameters, getTenantContextHeader(tenantContext))
+	certificates, err := getAllCertificateFromLibrary(adminOrg.client, queryParameters, getTenantContextHeader(tenantContext))
+	if err != nil {
+		return nil, err
+	}
+	for _, certificate := range certificates {
+		certificate.tenantContext = tenantContext
+	}
+	return certificates, nil
 }
 
 // getCertificateFromLibraryByName retrieves certificate from certificate library by given name
@@ -233,7 +256,12 @@ func (adminOrg *AdminOrg) GetCertificateFromLibraryByName(name string) (*Certifi
 	if err != nil {
 		return nil, err
 	}
-	return getCertificateFromLibraryByName(adminOrg.client, name, getTenantContextHeader(tenantContext))
+	certificate, err := getCertificateFromLibraryByName(adminOrg.client, name, getTenantContextHeader(tenantContext))
+	if err != nil {
+		return nil, err
+	}
+	certificate.tenantContext = tenantContext
+	return certificate, nil
 }
 
 // Update updates existing Certificate. Allows changing only alias and description
@@ -259,7 +287,9 @@ func (certificate *Certificate) Update() (*Certificate, error) {
 	returnCertificate := &Certificate{
 		CertificateLibrary: &types.CertificateLibraryItem{},
 		client:             certificate.client,
+		tenantContext:      certificate.tenantContext,
 	}
+	returnCertificate.initOpenApiMetadata()
 
 	err = certificate.client.OpenApiPutItem(minimumApiVersion, urlRef, nil, certificate.CertificateLibrary,
 		returnCertificate.CertificateLibrary, nil)
@@ -298,3 +328,27 @@ func (certificate *Certificate) Delete() error {
 
 	return nil
 }
+
+// initOpenApiMetadata makes the embedded OpenApiMetadataEntity manage the metadata of the receiver Certificate. The
+// requests are sent in the tenant context the certificate was retrieved with, so the certificates of an Organization
+// are managed in its scope, and the ones retrieved from System context are managed by the System administrator.
+// The OpenAPI metadata endpoint of certificate library items requires VCD 10.5+, and a *MetadataNotSupportedError is
+// returned for older versions.
+func (certificate *Certificate) initOpenApiMetadata() {
+	certificate.OpenApiMetadataEntity = *certificate.openApiMetadata()
+}
+
+// openApiMetadata returns an OpenApiMetadataEntity that manages the metadata of the receiver Certificate, built from its
+// current fields, so the metadata methods also work when the embedded one was not initialized
+func (certificate *Certificate) openApiMetadata() *OpenApiMetadataEntity {
+	return newOpenApiMetadataEntity(certificate.client, types.OpenApiEndpointSSLCertificateLibraryMetadata,
+		func() string {
+			if certificate.CertificateLibrary == nil {
+				return ""
+			}
+			return certificate.CertificateLibrary.Id
+		},
+		func() (*TenantContext, error) {
+			return certificate.tenantContext, nil
+		})
+}
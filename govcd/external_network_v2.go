@@ -16,6 +16,7 @@ import (
 type ExternalNetworkV2 struct {
 	ExternalNetwork *types.ExternalNetworkV2
 	client          *Client
+	OpenApiMetadataEntity
 }
 
 // CreateExternalNetworkV2 creates a new external network using OpenAPI endpoint. It can create
@@ -37,6 +38,7 @@ func CreateExternalNetworkV2(vcdClient *VCDClient, newExtNet *types.ExternalNetw
 		ExternalNetwork: &types.ExternalNetworkV2{},
 		client:          &vcdClient.Client,
 	}
+	returnExtNet.initOpenApiMetadata()
 
 	err = vcdClient.Client.OpenApiPostItem(apiVersion, urlRef, nil, newExtNet, returnExtNet.ExternalNetwork, nil)
 	if err != nil {
@@ -67,6 +69,7 @@ func GetExternalNetworkV2ById(vcdClient *VCDClient, id string) (*ExternalNetwork
 		ExternalNetwork: &types.ExternalNetworkV2{},
 		client:          &vcdClient.Client,
 	}
+	extNet.initOpenApiMetadata()
 
 	err = vcdClient.Client.OpenApiGetItem(apiVersion, urlRef, nil, extNet.ExternalNetwork, nil)
 	if err != nil {
@@ -130,6 +133,7 @@ func GetAllExternalNetworksV2(vcdClient *VCDClient, queryParameters url.Values)
 			ExternalNetwork: typeResponses[sliceIndex],
 			client:          &vcdClient.Client,
 		}
+		returnExtNetworks[sliceIndex].initOpenApiMetadata()
 	}
 
 	return returnExtNetworks, nil
@@ -156,6 +160,7 @@ func (extNet *ExternalNetworkV2) Update() (*ExternalNetworkV2, error) {
 		ExternalNetwork: &types.ExternalNetworkV2{},
 		client:          extNet.client,
 	}
+	returnExtNet.initOpenApiMetadata()
 
 	err = extNet.client.OpenApiPutItem(apiVersion, urlRef, nil, extNet.ExternalNetwork, returnExtNet.ExternalNetwork, nil)
 	if err != nil {
@@ -190,3 +195,23 @@ func (extNet *ExternalNetworkV2) Delete() error {
 
 	return nil
 }
+
+// initOpenApiMetadata makes the embedded OpenApiMetadataEntity manage the metadata of the receiver ExternalNetworkV2.
+// The OpenAPI metadata endpoint of external networks requires VCD 10.5+ and system administrator privileges. The
+// metadata methods of ExternalNetworkV2 fall back to the XML API on older versions, see
+// ExternalNetworkV2.metadataRequestOptions.
+func (extNet *ExternalNetworkV2) initOpenApiMetadata() {
+	extNet.OpenApiMetadataEntity = *extNet.openApiMetadata()
+}
+
+// openApiMetadata returns an OpenApiMetadataEntity that manages the metadata of the receiver ExternalNetworkV2, built from its
+// current fields, so the metadata methods also work when the embedded one was not initialized
+func (extNet *ExternalNetworkV2) openApiMetadata() *OpenApiMetadataEntity {
+	return newOpenApiMetadataEntity(extNet.client, types.OpenApiEndpointExternalNetworksMetadata,
+		func() string {
+			if extNet.ExternalNetwork == nil {
+				return ""
+			}
+			return extNet.ExternalNetwork.ID
+		}, nil)
+}
@@ -1032,6 +1032,7 @@ func addMetadataDeprecated(client *Client, typedValue, key, value, requestUri st
 	apiEndpoint.Path += "/metadata/" + key
 
 	// Return the task
+	defer recordMetadataWrite(requestUri)
 	return client.ExecuteTaskRequest(apiEndpoint.String(), http.MethodPut,
 		types.MimeMetaDataValue, "error adding metadata: %s", newMetadata)
 }
@@ -1062,6 +1063,7 @@ func mergeAllMetadataDeprecated(client *Client, typedValue string, metadata map[
 	apiEndpoint.Path += "/metadata"
 
 	// Return the task
+	defer recordMetadataWrite(requestUri)
 	return client.ExecuteTaskRequest(apiEndpoint.String(), http.MethodPost,
 		types.MimeMetaData, "error adding metadata: %s", newMetadata)
 }
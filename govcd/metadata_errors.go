@@ -0,0 +1,378 @@
+/*
+ * Copyright 2023 VMware, Inc.  All rights reserved.  Licensed under the Apache v2 License.
+ */
+
+package govcd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/vmware/go-vcloud-director/v2/types/v56"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ------------------------------------------------------------------------------------------------
+// Metadata errors
+// ------------------------------------------------------------------------------------------------
+
+// MetadataValueParseError is returned when one or more metadata values can't be converted to the type declared by
+// their XsiType. Errors contains the parsing error of each offending metadata key.
+type MetadataValueParseError struct {
+	Errors map[string]error
+}
+
+// Error returns all the keys with malformed values, sorted alphabetically, with their respective parsing errors
+func (parseError *MetadataValueParseError) Error() string {
+	keys := make([]string, 0, len(parseError.Errors))
+	for key := range parseError.Errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	messages := make([]string, 0, len(keys))
+	for _, key := range keys {
+		messages = append(messages, fmt.Sprintf("key '%s': %s", key, parseError.Errors[key]))
+	}
+	return fmt.Sprintf("found %d metadata entries with malformed values: [%s]", len(keys), strings.Join(messages, "; "))
+}
+
+// MetadataMultiError aggregates the errors of a metadata operation that is performed on several entities or keys, so
+// that a single failure doesn't abort the whole operation. Errors is indexed by entity identifier or metadata key,
+// depending on the operation.
+type MetadataMultiError struct {
+	Operation string
+	Errors    map[string]error
+}
+
+// newMetadataMultiError returns an empty *MetadataMultiError for the given operation, ready to collect errors with add
+func newMetadataMultiError(operation string) *MetadataMultiError {
+	return &MetadataMultiError{Operation: operation, Errors: map[string]error{}}
+}
+
+// add records the given error with the given identifier. Nil errors are ignored, so the result of every step of the
+// operation can be added without checking it first. It is not safe for concurrent use.
+func (multiError *MetadataMultiError) add(identifier string, err error) {
+	if err != nil {
+		multiError.Errors[identifier] = err
+	}
+}
+
+// addAll records all the given errors, indexed by their identifier
+func (multiError *MetadataMultiError) addAll(errs map[string]error) {
+	for identifier, err := range errs {
+		multiError.add(identifier, err)
+	}
+}
+
+// errorOrNil returns the receiver when it has collected any error, or nil otherwise, so it can be returned as the
+// error of the operation
+func (multiError *MetadataMultiError) errorOrNil() error {
+	if len(multiError.Errors) == 0 {
+		return nil
+	}
+	return multiError
+}
+
+// Error returns all the aggregated errors, sorted by their identifier
+func (multiError *MetadataMultiError) Error() string {
+	identifiers := make([]string, 0, len(multiError.Errors))
+	for identifier := range multiError.Errors {
+		identifiers = append(identifiers, identifier)
+	}
+	sort.Strings(identifiers)
+
+	messages := make([]string, 0, len(identifiers))
+	for _, identifier := range identifiers {
+		messages = append(messages, fmt.Sprintf("'%s': %s", identifier, multiError.Errors[identifier]))
+	}
+	return fmt.Sprintf("%d errors %s: [%s]", len(identifiers), multiError.Operation, strings.Join(messages, "; "))
+}
+
+var (
+	// ErrInvalidMetadataKey is wrapped by the *MetadataValidationError returned when a metadata key is empty or too long
+	ErrInvalidMetadataKey = errors.New("invalid metadata key")
+	// ErrInvalidMetadataValue is wrapped by the *MetadataValidationError returned when a metadata value is too long,
+	// or its type or visibility are unknown
+	ErrInvalidMetadataValue = errors.New("invalid metadata value")
+	// ErrProtectedMetadataKey is wrapped by the *MetadataValidationError returned when a SYSTEM domain metadata key
+	// that matches Client.ProtectSystemMetadataKeys is going to be modified or deleted
+	ErrProtectedMetadataKey = errors.New("protected metadata key")
+)
+
+// MetadataValidationError is returned when a metadata entry is rejected, either before sending it to VCD or by VCD
+// itself with a 400 error. It wraps ErrInvalidMetadataKey, ErrInvalidMetadataValue or ErrProtectedMetadataKey, so it
+// can be checked with errors.Is.
+type MetadataValidationError struct {
+	Key    string // The key of the invalid metadata entry. It is empty when VCD rejected several entries at once
+	Reason string // Why the entry is invalid
+	Err    error  // ErrInvalidMetadataKey, ErrInvalidMetadataValue or ErrProtectedMetadataKey
+	Cause  error  // The error returned by VCD when it rejected the entry, if any
+
+	message string // The message of the failed request, when VCD rejected the entry
+}
+
+// Error returns the invalid metadata key and the reason. When VCD rejected the entry, it returns the message of the
+// failed request unchanged.
+func (validationError *MetadataValidationError) Error() string {
+	if validationError.message != "" {
+		return validationError.message
+	}
+	return fmt.Sprintf("%s '%s': %s", validationError.Err, validationError.Key, validationError.Reason)
+}
+
+// Unwrap returns ErrInvalidMetadataKey, ErrInvalidMetadataValue or ErrProtectedMetadataKey
+func (validationError *MetadataValidationError) Unwrap() error {
+	return validationError.Err
+}
+
+// As finds the first error that matches the target in the error returned by VCD, if any, so the original
+// *types.Error can still be retrieved with errors.As
+func (validationError *MetadataValidationError) As(target interface{}) bool {
+	return validationError.Cause != nil && errors.As(validationError.Cause, target)
+}
+
+// MetadataKeyNotFoundError is returned when a metadata key doesn't exist in the requested domain, or when the entity
+// whose metadata was requested doesn't exist. It matches ErrorEntityNotFound with errors.Is, and unwraps to the
+// original *types.Error returned by VCD.
+type MetadataKeyNotFoundError struct {
+	Key string // The metadata key that was not found. It is empty when the entity was not found
+	Err error  // The error returned by VCD
+
+	message string // The message of the failed request, when it was converted by categorizeMetadataRequestError
+}
+
+// Error returns the missing metadata key and the original error. It contains ErrorEntityNotFound text, so
+// ContainsNotFound works with it. When it was converted from a failed request, it returns the message of the request
+// unchanged.
+func (notFoundError *MetadataKeyNotFoundError) Error() string {
+	if notFoundError.message != "" {
+		return notFoundError.message
+	}
+	return fmt.Sprintf("%s: metadata key '%s': %s", ErrorEntityNotFound, notFoundError.Key, notFoundError.Err)
+}
+
+// Is returns true if the target is ErrorEntityNotFound
+func (notFoundError *MetadataKeyNotFoundError) Is(target error) bool {
+	return target == ErrorEntityNotFound
+}
+
+// Unwrap returns the original error returned by VCD
+func (notFoundError *MetadataKeyNotFoundError) Unwrap() error {
+	return notFoundError.Err
+}
+
+// metadataRequestFailure is wrapped by the typed metadata errors returned when VCD rejects a metadata request. It
+// keeps the message of the failed request and unwraps to the original *types.Error returned by VCD.
+type metadataRequestFailure struct {
+	message string
+	err     error
+}
+
+// Error returns the message of the failed request
+func (failure *metadataRequestFailure) Error() string {
+	return failure.message
+}
+
+// Unwrap returns the original error returned by VCD
+func (failure *metadataRequestFailure) Unwrap() error {
+	return failure.err
+}
+
+// categorizeMetadataRequestError converts the given error, returned by VCD when it rejected a metadata request with the
+// given method to the given HREF, to the typed metadata error of its category, so callers can tell them apart with
+// errors.As:
+//   - *MetadataKeyNotFoundError for 404 errors, and 403 errors that VCD returns for missing entries. Its key is only
+//     set for GET and DELETE requests, as adding entries fails like that only when the entity doesn't exist.
+//   - *MetadataPermissionError for 401 and 403 errors.
+//   - *MetadataValidationError wrapping ErrInvalidMetadataValue for 400 errors.
+//
+// The message of the typed errors is the given message, unchanged, so the category is only exposed to errors.As and
+// errors.Is, and they unwrap to the original *types.Error. Any other error is returned as the given message.
+func categorizeMetadataRequestError(method, href, key string, message, err error) error {
+	var vcdError *types.Error
+	if !errors.As(err, &vcdError) {
+		return message
+	}
+	failure := &metadataRequestFailure{message: message.Error(), err: err}
+	switch {
+	case isMetadataKeyNotFound(err):
+		if method != http.MethodGet && method != http.MethodDelete {
+			key = ""
+		}
+		return &MetadataKeyNotFoundError{Key: key, Err: failure, message: failure.message}
+	case vcdError.MajorErrorCode == http.StatusUnauthorized || vcdError.MajorErrorCode == http.StatusForbidden:
+		return &MetadataPermissionError{Entity: fmt.Sprintf("'%s'", href), Err: failure, message: failure.message}
+	case vcdError.MajorErrorCode == http.StatusBadRequest:
+		return &MetadataValidationError{Key: key, Reason: failure.message, Err: ErrInvalidMetadataValue, Cause: err, message: failure.message}
+	}
+	return message
+}
+
+// MetadataPermissionError is returned when the user lacks the rights to modify the metadata of an entity. It tells
+// apart operations that can only be done by system administrators from the ones that failed because the user lacks
+// Org administrator rights.
+type MetadataPermissionError struct {
+	Entity              string // The entity whose metadata was being modified
+	RequiresSystemAdmin bool   // True if the operation can only be done by system administrators
+	Err                 error  // The error returned by VCD, if any
+
+	message string // The message of the failed request, when it was converted by categorizeMetadataRequestError
+}
+
+// Error returns a description of the missing rights, including the error returned by VCD, if any. When it was
+// converted from a failed request, it returns the message of the request unchanged.
+func (permissionError *MetadataPermissionError) Error() string {
+	if permissionError.message != "" {
+		return permissionError.message
+	}
+	message := fmt.Sprintf("missing Org administrator rights to modify the metadata of %s", permissionError.Entity)
+	if permissionError.RequiresSystemAdmin {
+		message = fmt.Sprintf("modifying the SYSTEM metadata of %s requires system administrator privileges", permissionError.Entity)
+	}
+	if permissionError.Err != nil {
+		message += ": " + permissionError.Err.Error()
+	}
+	return message
+}
+
+// Unwrap returns the error returned by VCD, if any
+func (permissionError *MetadataPermissionError) Unwrap() error {
+	return permissionError.Err
+}
+
+// MetadataNotSupportedError is returned when the requested metadata operation can't be performed on an entity, either
+// because VCD doesn't expose metadata for it or because the connected VCD API version is too old.
+type MetadataNotSupportedError struct {
+	Entity            string // The kind of entity that was targeted, e.g. "Catalog subscription"
+	Reason            string // Why the operation is not supported
+	MinimumApiVersion string // If not empty, the minimum VCD API version that supports metadata for the entity
+}
+
+// Error returns a description of the unsupported metadata operation
+func (notSupportedError *MetadataNotSupportedError) Error() string {
+	message := fmt.Sprintf("metadata is not supported for %s", notSupportedError.Entity)
+	if notSupportedError.Reason != "" {
+		message += ": " + notSupportedError.Reason
+	}
+	if notSupportedError.MinimumApiVersion != "" {
+		message += fmt.Sprintf(" (requires API version %s or higher)", notSupportedError.MinimumApiVersion)
+	}
+	return message
+}
+
+// catalogSubscriptionMetadataNotSupported returns the error for metadata operations on Catalog subscription settings
+func catalogSubscriptionMetadataNotSupported() error {
+	return &MetadataNotSupportedError{
+		Entity: "Catalog subscription",
+		Reason: "synchronization settings are part of the Catalog and don't have metadata of their own, use the Catalog metadata instead",
+	}
+}
+
+// vmSizingMetadataNotSupported returns the error for metadata operations on the VM sizing configuration of a
+// VDC Compute Policy
+func vmSizingMetadataNotSupported() error {
+	return &MetadataNotSupportedError{
+		Entity: "VDC Compute Policy VM sizing configuration",
+		Reason: "the sizing configuration is a set of attributes of the VDC Compute Policy and doesn't have metadata of its own",
+	}
+}
+
+// nsxtNatRuleMetadataNotSupported returns the error for metadata operations on NSX-T Edge Gateway NAT rules
+func nsxtNatRuleMetadataNotSupported() error {
+	return &MetadataNotSupportedError{
+		Entity: "NSX-T Edge Gateway NAT rule",
+		Reason: "NAT rules are part of the Edge Gateway configuration and VCD doesn't provide a metadata endpoint for them",
+	}
+}
+
+// nsxtAlbControllerMetadataNotSupported returns the error for metadata operations on NSX-T ALB Controllers
+func nsxtAlbControllerMetadataNotSupported() error {
+	return &MetadataNotSupportedError{
+		Entity: "NSX-T ALB Controller",
+		Reason: "VCD doesn't provide a metadata endpoint for ALB Controllers, Service Engine Groups can be tagged instead",
+	}
+}
+
+// nsxtAlbCloudMetadataNotSupported returns the error for metadata operations on NSX-T ALB Clouds
+func nsxtAlbCloudMetadataNotSupported() error {
+	return &MetadataNotSupportedError{
+		Entity: "NSX-T ALB Cloud",
+		Reason: "VCD doesn't provide a metadata endpoint for ALB Clouds, Service Engine Groups can be tagged instead",
+	}
+}
+
+// orgUserRoleAssignmentMetadataNotSupported returns the error for metadata operations on the role assignment of an
+// Org user
+func orgUserRoleAssignmentMetadataNotSupported() error {
+	return &MetadataNotSupportedError{
+		Entity: "Org user role assignment",
+		Reason: "the role of a user is a reference inside the user definition and doesn't have metadata of its own",
+	}
+}
+
+// vmAffinityRuleMetadataNotSupported returns the error for metadata operations on VM affinity rules
+func vmAffinityRuleMetadataNotSupported() error {
+	return &MetadataNotSupportedError{
+		Entity: "VM affinity rule",
+		Reason: "VCD doesn't provide a metadata endpoint for VM affinity and anti-affinity rules",
+	}
+}
+
+// roleMetadataNotSupported returns the error for metadata operations on roles
+func roleMetadataNotSupported() error {
+	return &MetadataNotSupportedError{
+		Entity: "Role",
+		Reason: "VCD doesn't provide a metadata endpoint for roles in any API version",
+	}
+}
+
+// globalRoleMetadataNotSupported returns the error for metadata operations on global roles
+func globalRoleMetadataNotSupported() error {
+	return &MetadataNotSupportedError{
+		Entity: "Global role",
+		Reason: "VCD doesn't provide a metadata endpoint for global roles in any API version",
+	}
+}
+
+// networkPoolMetadataNotSupported returns the error for metadata operations on network pools
+func networkPoolMetadataNotSupported() error {
+	return &MetadataNotSupportedError{
+		Entity: "network pool",
+		Reason: "VCD doesn't provide a metadata endpoint for network pools, neither in the legacy API nor in OpenAPI",
+	}
+}
+
+// nsxtManagerMetadataNotSupported returns the error for metadata operations on NSX-T Managers
+func nsxtManagerMetadataNotSupported() error {
+	return &MetadataNotSupportedError{
+		Entity: "NSX-T Manager",
+		Reason: "VCD doesn't provide a metadata endpoint for NSX-T Managers, neither in the legacy API nor in OpenAPI",
+	}
+}
+
+// vAppSnapshotMetadataNotSupported returns the error for metadata operations on vApp snapshots
+func vAppSnapshotMetadataNotSupported() error {
+	return &MetadataNotSupportedError{
+		Entity: "vApp snapshot",
+		Reason: "snapshots are listed in the snapshot section of the vApp and its VMs and VCD doesn't provide a metadata endpoint for them, use the vApp metadata instead",
+	}
+}
+
+// uiPluginMetadataNotSupported returns the error for metadata operations on UI plugins
+func uiPluginMetadataNotSupported() error {
+	return &MetadataNotSupportedError{
+		Entity: "UI plugin",
+		Reason: "UI plugins are provider-level extensions and VCD doesn't provide a metadata endpoint for them in any API version",
+	}
+}
+
+// catalogItemFileRecordMetadataNotSupported returns the error for metadata operations on the file records of a
+// Catalog Item that references an entity of the given type, which is neither a vApp Template nor a Media
+func catalogItemFileRecordMetadataNotSupported(entityType string) error {
+	return &MetadataNotSupportedError{
+		Entity: "Catalog Item file record",
+		Reason: fmt.Sprintf("file records don't have metadata of their own and the referenced entity type '%s' is neither a vApp Template nor a Media", entityType),
+	}
+}
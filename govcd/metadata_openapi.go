@@ -0,0 +1,935 @@
+/*
+ * Copyright 2023 VMware, Inc.  All rights reserved.  Licensed under the Apache v2 License.
+ */
+
+package govcd
+
+import (
+	"context"
+	"fmt"
+	"github.com/vmware/go-vcloud-director/v2/types/v56"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// ------------------------------------------------------------------------------------------------
+// CRUD metadata of OpenAPI entities
+// ------------------------------------------------------------------------------------------------
+
+// OpenApiMetadataEntity manages the metadata of an OpenAPI entity with one of the OpenAPI metadata endpoints, like
+// types.OpenApiEndpointEdgeGatewaysMetadata, sending the tenant context of the entity with every request.
+// It can be created with NewOpenApiMetadataEntity to manage the metadata of any OpenAPI entity given its ID, and it
+// can be embedded in the types of the OpenAPI entities, like NsxtEdgeGateway, VdcGroup, ExternalNetworkV2, Certificate
+// and VdcComputePolicyV2, to give them the metadata methods. In the latter case, it is initialized when the type is
+// created, with newOpenApiMetadataEntity, and the type also defines the metadata methods on top of an
+// OpenApiMetadataEntity built from its current fields, so they work even when the embedded one was not initialized.
+// NOTE: The OpenAPI metadata endpoints require VCD 10.5+. A *MetadataNotSupportedError is returned for older versions.
+type OpenApiMetadataEntity struct {
+	metadataClient        *Client
+	metadataEndpoint      string
+	metadataEntityId      func() string
+	metadataTenantContext func() (*TenantContext, error)
+}
+
+// NewOpenApiMetadataEntity returns an OpenApiMetadataEntity that manages the metadata of the entity with the given ID,
+// using the given OpenAPI metadata endpoint, like types.OpenApiEndpointVdcGroupsMetadata. The tenant context is
+// optional.
+func NewOpenApiMetadataEntity(client *Client, endpoint, entityId string, tenantContext *TenantContext) *OpenApiMetadataEntity {
+	return newOpenApiMetadataEntity(client, endpoint, func() string { return entityId }, func() (*TenantContext, error) {
+		return tenantContext, nil
+	})
+}
+
+// GetDefinedEntityMetadata returns an OpenApiMetadataEntity that manages the VCD metadata of the Runtime Defined Entity
+// with the given ID, with the types.OpenApiEndpointRdeEntitiesMetadata endpoint. This metadata is not part of the
+// entity JSON contents, and it can be managed with the usual GetMetadata, AddMetadataEntryWithVisibility,
+// MergeMetadataWithMetadataValues and DeleteMetadataEntryWithDomain methods. The tenant context is optional.
+// NOTE: It requires VCD 10.5+. A *MetadataNotSupportedError is returned for older versions.
+func (vcdClient *VCDClient) GetDefinedEntityMetadata(entityId string, tenantContext *TenantContext) *OpenApiMetadataEntity {
+	return NewOpenApiMetadataEntity(&vcdClient.Client, types.OpenApiEndpointRdeEntitiesMetadata, entityId, tenantContext)
+}
+
+// GetMetadata returns the metadata of the entity.
+func (entity *OpenApiMetadataEntity) GetMetadata() (*types.Metadata, error) {
+	client, entityId, header, err := entity.openApiMetadataTarget()
+	if err != nil {
+		return nil, err
+	}
+	return getOpenApiMetadata(client, entity.metadataEndpoint, entityId, header)
+}
+
+// GetMetadataByKey returns the metadata of the entity corresponding to the given key and domain.
+func (entity *OpenApiMetadataEntity) GetMetadataByKey(key string, isSystem bool) (*types.MetadataValue, error) {
+	client, entityId, header, err := entity.openApiMetadataTarget()
+	if err != nil {
+		return nil, err
+	}
+	return getOpenApiMetadataByKey(client, entity.metadataEndpoint, entityId, header, key, isSystem)
+}
+
+// GetTypedMetadataByKey returns the metadata of the entity corresponding to the given key and domain, converted to its
+// Go type. See getTypedMetadataByKey for details.
+func (entity *OpenApiMetadataEntity) GetTypedMetadataByKey(key string, isSystem bool) (interface{}, error) {
+	metadataValue, err := entity.GetMetadataByKey(key, isSystem)
+	if err != nil {
+		return nil, err
+	}
+	return typedMetadataValue(key, metadataValue)
+}
+
+// GetMetadataAsMap returns the metadata of the entity as a map of key to value. See getMetadataAsMap for details.
+func (entity *OpenApiMetadataEntity) GetMetadataAsMap(isSystem bool) (map[string]string, error) {
+	metadata, err := entity.GetMetadata()
+	if err != nil {
+		return nil, err
+	}
+	return metadataAsMap(metadata, isSystem), nil
+}
+
+// AddMetadataEntryWithVisibility adds metadata to the entity.
+func (entity *OpenApiMetadataEntity) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	client, entityId, header, err := entity.openApiMetadataTarget()
+	if err != nil {
+		return err
+	}
+	return addOpenApiMetadata(client, entity.metadataEndpoint, entityId, header, key, value, typedValue, visibility, isSystem)
+}
+
+// MergeMetadataWithMetadataValues updates the metadata values that are already present in the entity and creates the
+// ones not present. The input metadata map has a "metadata key"->"metadata value" relation.
+func (entity *OpenApiMetadataEntity) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	client, entityId, header, err := entity.openApiMetadataTarget()
+	if err != nil {
+		return err
+	}
+	return mergeOpenApiMetadata(client, entity.metadataEndpoint, entityId, header, metadata)
+}
+
+// DeleteMetadataEntryWithDomain deletes the metadata of the entity associated to the given key and domain.
+func (entity *OpenApiMetadataEntity) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
+	client, entityId, header, err := entity.openApiMetadataTarget()
+	if err != nil {
+		return err
+	}
+	return deleteOpenApiMetadata(client, entity.metadataEndpoint, entityId, header, key, isSystem)
+}
+
+// ------------------------------------------------------------------------------------------------
+// CRUD metadata of the OpenAPI types that embed OpenApiMetadataEntity
+// ------------------------------------------------------------------------------------------------
+
+// The methods of this section take precedence over the ones of the embedded OpenApiMetadataEntity, so the metadata of
+// these types is always managed with their current ID and tenant context, even when the embedded OpenApiMetadataEntity
+// was not initialized, like in a NsxtEdgeGateway created as a struct literal. The ones of ExternalNetworkV2 also fall
+// back to the XML API when VCD doesn't support the OpenAPI metadata endpoint of external networks.
+
+// GetMetadata returns the metadata of the receiver NSX-T Edge Gateway.
+func (egw *NsxtEdgeGateway) GetMetadata() (*types.Metadata, error) {
+	return egw.openApiMetadata().GetMetadata()
+}
+
+// GetMetadataByKey returns the metadata of the receiver NSX-T Edge Gateway corresponding to the given key and domain.
+func (egw *NsxtEdgeGateway) GetMetadataByKey(key string, isSystem bool) (*types.MetadataValue, error) {
+	return egw.openApiMetadata().GetMetadataByKey(key, isSystem)
+}
+
+// GetTypedMetadataByKey returns the metadata of the receiver NSX-T Edge Gateway corresponding to the given key and domain,
+// converted to its Go type. See getTypedMetadataByKey for details.
+func (egw *NsxtEdgeGateway) GetTypedMetadataByKey(key string, isSystem bool) (interface{}, error) {
+	return egw.openApiMetadata().GetTypedMetadataByKey(key, isSystem)
+}
+
+// GetMetadataAsMap returns the metadata of the receiver NSX-T Edge Gateway as a map of key to value. See
+// getMetadataAsMap for details.
+func (egw *NsxtEdgeGateway) GetMetadataAsMap(isSystem bool) (map[string]string, error) {
+	return egw.openApiMetadata().GetMetadataAsMap(isSystem)
+}
+
+// AddMetadataEntryWithVisibility adds metadata to the receiver NSX-T Edge Gateway.
+func (egw *NsxtEdgeGateway) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	return egw.openApiMetadata().AddMetadataEntryWithVisibility(key, value, typedValue, visibility, isSystem)
+}
+
+// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver
+// NSX-T Edge Gateway and creates the ones not present.
+func (egw *NsxtEdgeGateway) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	return egw.openApiMetadata().MergeMetadataWithMetadataValues(metadata)
+}
+
+// DeleteMetadataEntryWithDomain deletes the metadata of the receiver NSX-T Edge Gateway associated to the given key and
+// domain.
+func (egw *NsxtEdgeGateway) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
+	return egw.openApiMetadata().DeleteMetadataEntryWithDomain(key, isSystem)
+}
+
+// GetMetadataCtx is the same as NsxtEdgeGateway.GetMetadata, but no request is sent once the given context is done,
+// returning ctx.Err().
+func (egw *NsxtEdgeGateway) GetMetadataCtx(ctx context.Context) (*types.Metadata, error) {
+	return egw.openApiMetadata().GetMetadataCtx(ctx)
+}
+
+// GetMetadataByKeyCtx is the same as NsxtEdgeGateway.GetMetadataByKey, but no request is sent once the given context is done,
+// returning ctx.Err().
+func (egw *NsxtEdgeGateway) GetMetadataByKeyCtx(ctx context.Context, key string, isSystem bool) (*types.MetadataValue, error) {
+	return egw.openApiMetadata().GetMetadataByKeyCtx(ctx, key, isSystem)
+}
+
+// AddMetadataEntryWithVisibilityCtx is the same as NsxtEdgeGateway.AddMetadataEntryWithVisibility, but no request is sent once the given context is done,
+// returning ctx.Err().
+func (egw *NsxtEdgeGateway) AddMetadataEntryWithVisibilityCtx(ctx context.Context, key, value, typedValue, visibility string, isSystem bool) error {
+	return egw.openApiMetadata().AddMetadataEntryWithVisibilityCtx(ctx, key, value, typedValue, visibility, isSystem)
+}
+
+// MergeMetadataWithMetadataValuesCtx is the same as NsxtEdgeGateway.MergeMetadataWithMetadataValues, but no request is sent once the given context is done,
+// returning ctx.Err().
+func (egw *NsxtEdgeGateway) MergeMetadataWithMetadataValuesCtx(ctx context.Context, metadata map[string]types.MetadataValue) error {
+	return egw.openApiMetadata().MergeMetadataWithMetadataValuesCtx(ctx, metadata)
+}
+
+// DeleteMetadataEntryWithDomainCtx is the same as NsxtEdgeGateway.DeleteMetadataEntryWithDomain, but no request is sent once the given context is done,
+// returning ctx.Err().
+func (egw *NsxtEdgeGateway) DeleteMetadataEntryWithDomainCtx(ctx context.Context, key string, isSystem bool) error {
+	return egw.openApiMetadata().DeleteMetadataEntryWithDomainCtx(ctx, key, isSystem)
+}
+
+// GetMetadata returns the metadata of the receiver VDC Group.
+func (vdcGroup *VdcGroup) GetMetadata() (*types.Metadata, error) {
+	return vdcGroup.openApiMetadata().GetMetadata()
+}
+
+// GetMetadataByKey returns the metadata of the receiver VDC Group corresponding to the given key and domain.
+func (vdcGroup *VdcGroup) GetMetadataByKey(key string, isSystem bool) (*types.MetadataValue, error) {
+	return vdcGroup.openApiMetadata().GetMetadataByKey(key, isSystem)
+}
+
+// GetTypedMetadataByKey returns the metadata of the receiver VDC Group corresponding to the given key and domain,
+// converted to its Go type. See getTypedMetadataByKey for details.
+func (vdcGroup *VdcGroup) GetTypedMetadataByKey(key string, isSystem bool) (interface{}, error) {
+	return vdcGroup.openApiMetadata().GetTypedMetadataByKey(key, isSystem)
+}
+
+// GetMetadataAsMap returns the metadata of the receiver VDC Group as a map of key to value. See
+// getMetadataAsMap for details.
+func (vdcGroup *VdcGroup) GetMetadataAsMap(isSystem bool) (map[string]string, error) {
+	return vdcGroup.openApiMetadata().GetMetadataAsMap(isSystem)
+}
+
+// AddMetadataEntryWithVisibility adds metadata to the receiver VDC Group.
+func (vdcGroup *VdcGroup) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	return vdcGroup.openApiMetadata().AddMetadataEntryWithVisibility(key, value, typedValue, visibility, isSystem)
+}
+
+// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver
+// VDC Group and creates the ones not present.
+func (vdcGroup *VdcGroup) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	return vdcGroup.openApiMetadata().MergeMetadataWithMetadataValues(metadata)
+}
+
+// DeleteMetadataEntryWithDomain deletes the metadata of the receiver VDC Group associated to the given key and
+// domain.
+func (vdcGroup *VdcGroup) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
+	return vdcGroup.openApiMetadata().DeleteMetadataEntryWithDomain(key, isSystem)
+}
+
+// GetMetadataCtx is the same as VdcGroup.GetMetadata, but no request is sent once the given context is done,
+// returning ctx.Err().
+func (vdcGroup *VdcGroup) GetMetadataCtx(ctx context.Context) (*types.Metadata, error) {
+	return vdcGroup.openApiMetadata().GetMetadataCtx(ctx)
+}
+
+// GetMetadataByKeyCtx is the same as VdcGroup.GetMetadataByKey, but no request is sent once the given context is done,
+// returning ctx.Err().
+func (vdcGroup *VdcGroup) GetMetadataByKeyCtx(ctx context.Context, key string, isSystem bool) (*types.MetadataValue, error) {
+	return vdcGroup.openApiMetadata().GetMetadataByKeyCtx(ctx, key, isSystem)
+}
+
+// AddMetadataEntryWithVisibilityCtx is the same as VdcGroup.AddMetadataEntryWithVisibility, but no request is sent once the given context is done,
+// returning ctx.Err().
+func (vdcGroup *VdcGroup) AddMetadataEntryWithVisibilityCtx(ctx context.Context, key, value, typedValue, visibility string, isSystem bool) error {
+	return vdcGroup.openApiMetadata().AddMetadataEntryWithVisibilityCtx(ctx, key, value, typedValue, visibility, isSystem)
+}
+
+// MergeMetadataWithMetadataValuesCtx is the same as VdcGroup.MergeMetadataWithMetadataValues, but no request is sent once the given context is done,
+// returning ctx.Err().
+func (vdcGroup *VdcGroup) MergeMetadataWithMetadataValuesCtx(ctx context.Context, metadata map[string]types.MetadataValue) error {
+	return vdcGroup.openApiMetadata().MergeMetadataWithMetadataValuesCtx(ctx, metadata)
+}
+
+// DeleteMetadataEntryWithDomainCtx is the same as VdcGroup.DeleteMetadataEntryWithDomain, but no request is sent once the given context is done,
+// returning ctx.Err().
+func (vdcGroup *VdcGroup) DeleteMetadataEntryWithDomainCtx(ctx context.Context, key string, isSystem bool) error {
+	return vdcGroup.openApiMetadata().DeleteMetadataEntryWithDomainCtx(ctx, key, isSystem)
+}
+
+// GetMetadata returns the metadata of the receiver External Network.
+// NOTE: The OpenAPI metadata endpoint is used when VCD supports it (VCD 10.5+), and the XML API otherwise. See
+// ExternalNetworkV2.metadataRequestOptions for details.
+func (extNet *ExternalNetworkV2) GetMetadata() (*types.Metadata, error) {
+	return extNet.GetMetadataCtx(context.Background())
+}
+
+// GetMetadataByKey returns the metadata of the receiver External Network corresponding to the given key and domain.
+// See ExternalNetworkV2.GetMetadata for the endpoint that is used.
+func (extNet *ExternalNetworkV2) GetMetadataByKey(key string, isSystem bool) (*types.MetadataValue, error) {
+	return extNet.GetMetadataByKeyCtx(context.Background(), key, isSystem)
+}
+
+// GetTypedMetadataByKey returns the metadata of the receiver External Network corresponding to the given key and domain,
+// converted to its Go type. See getTypedMetadataByKey for details.
+func (extNet *ExternalNetworkV2) GetTypedMetadataByKey(key string, isSystem bool) (interface{}, error) {
+	metadataValue, err := extNet.GetMetadataByKey(key, isSystem)
+	if err != nil {
+		return nil, err
+	}
+	return typedMetadataValue(key, metadataValue)
+}
+
+// GetMetadataAsMap returns the metadata of the receiver External Network as a map of key to value. See
+// getMetadataAsMap for details.
+func (extNet *ExternalNetworkV2) GetMetadataAsMap(isSystem bool) (map[string]string, error) {
+	metadata, err := extNet.GetMetadata()
+	if err != nil {
+		return nil, err
+	}
+	return metadataAsMap(metadata, isSystem), nil
+}
+
+// AddMetadataEntryWithVisibility adds metadata to the receiver External Network.
+// See ExternalNetworkV2.GetMetadata for the endpoint that is used.
+func (extNet *ExternalNetworkV2) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	return extNet.AddMetadataEntryWithVisibilityCtx(context.Background(), key, value, typedValue, visibility, isSystem)
+}
+
+// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver
+// External Network and creates the ones not present. See ExternalNetworkV2.GetMetadata for the endpoint that is used.
+func (extNet *ExternalNetworkV2) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	return extNet.MergeMetadataWithMetadataValuesCtx(context.Background(), metadata)
+}
+
+// DeleteMetadataEntryWithDomain deletes the metadata of the receiver External Network associated to the given key and
+// domain. See ExternalNetworkV2.GetMetadata for the endpoint that is used.
+func (extNet *ExternalNetworkV2) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
+	return extNet.DeleteMetadataEntryWithDomainCtx(context.Background(), key, isSystem)
+}
+
+// GetMetadataCtx is the same as ExternalNetworkV2.GetMetadata, but no request is sent once the given context is done,
+// returning ctx.Err().
+func (extNet *ExternalNetworkV2) GetMetadataCtx(ctx context.Context) (*types.Metadata, error) {
+	response, err := metadataRequestWithContext(ctx, extNet.client, extNet.metadataRequestOptions(metadataOperationGet))
+	if err != nil {
+		return nil, err
+	}
+	return response.metadata, nil
+}
+
+// GetMetadataByKeyCtx is the same as ExternalNetworkV2.GetMetadataByKey, but no request is sent once the given context is done,
+// returning ctx.Err().
+func (extNet *ExternalNetworkV2) GetMetadataByKeyCtx(ctx context.Context, key string, isSystem bool) (*types.MetadataValue, error) {
+	opts := extNet.metadataRequestOptions(metadataOperationGetByKey)
+	opts.key, opts.isSystem = key, isSystem
+	response, err := metadataRequestWithContext(ctx, extNet.client, opts)
+	if err != nil {
+		return nil, err
+	}
+	return response.value, nil
+}
+
+// AddMetadataEntryWithVisibilityCtx is the same as ExternalNetworkV2.AddMetadataEntryWithVisibility, but no request is sent once the given context is done,
+// returning ctx.Err().
+func (extNet *ExternalNetworkV2) AddMetadataEntryWithVisibilityCtx(ctx context.Context, key, value, typedValue, visibility string, isSystem bool) error {
+	opts := extNet.metadataRequestOptions(metadataOperationAdd)
+	opts.key, opts.value, opts.typedValue, opts.visibility, opts.isSystem = key, value, typedValue, visibility, isSystem
+	_, err := metadataRequestWithContext(ctx, extNet.client, opts)
+	return err
+}
+
+// MergeMetadataWithMetadataValuesCtx is the same as ExternalNetworkV2.MergeMetadataWithMetadataValues, but no request is sent once the given context is done,
+// returning ctx.Err().
+func (extNet *ExternalNetworkV2) MergeMetadataWithMetadataValuesCtx(ctx context.Context, metadata map[string]types.MetadataValue) error {
+	opts := extNet.metadataRequestOptions(metadataOperationMerge)
+	opts.metadata = metadata
+	_, err := metadataRequestWithContext(ctx, extNet.client, opts)
+	return err
+}
+
+// DeleteMetadataEntryWithDomainCtx is the same as ExternalNetworkV2.DeleteMetadataEntryWithDomain, but no request is sent once the given context is done,
+// returning ctx.Err().
+func (extNet *ExternalNetworkV2) DeleteMetadataEntryWithDomainCtx(ctx context.Context, key string, isSystem bool) error {
+	opts := extNet.metadataRequestOptions(metadataOperationDelete)
+	opts.key, opts.isSystem = key, isSystem
+	_, err := metadataRequestWithContext(ctx, extNet.client, opts)
+	return err
+}
+
+// GetMetadata returns the metadata of the receiver Certificate.
+func (certificate *Certificate) GetMetadata() (*types.Metadata, error) {
+	return certificate.openApiMetadata().GetMetadata()
+}
+
+// GetMetadataByKey returns the metadata of the receiver Certificate corresponding to the given key and domain.
+func (certificate *Certificate) GetMetadataByKey(key string, isSystem bool) (*types.MetadataValue, error) {
+	return certificate.openApiMetadata().GetMetadataByKey(key, isSystem)
+}
+
+// GetTypedMetadataByKey returns the metadata of the receiver Certificate corresponding to the given key and domain,
+// converted to its Go type. See getTypedMetadataByKey for details.
+func (certificate *Certificate) GetTypedMetadataByKey(key string, isSystem bool) (interface{}, error) {
+	return certificate.openApiMetadata().GetTypedMetadataByKey(key, isSystem)
+}
+
+// GetMetadataAsMap returns the metadata of the receiver Certificate as a map of key to value. See
+// getMetadataAsMap for details.
+func (certificate *Certificate) GetMetadataAsMap(isSystem bool) (map[string]string, error) {
+	return certificate.openApiMetadata().GetMetadataAsMap(isSystem)
+}
+
+// AddMetadataEntryWithVisibility adds metadata to the receiver Certificate.
+func (certificate *Certificate) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	return certificate.openApiMetadata().AddMetadataEntryWithVisibility(key, value, typedValue, visibility, isSystem)
+}
+
+// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver
+// Certificate and creates the ones not present.
+func (certificate *Certificate) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	return certificate.openApiMetadata().MergeMetadataWithMetadataValues(metadata)
+}
+
+// DeleteMetadataEntryWithDomain deletes the metadata of the receiver Certificate associated to the given key and
+// domain.
+func (certificate *Certificate) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
+	return certificate.openApiMetadata().DeleteMetadataEntryWithDomain(key, isSystem)
+}
+
+// GetMetadataCtx is the same as Certificate.GetMetadata, but no request is sent once the given context is done,
+// returning ctx.Err().
+func (certificate *Certificate) GetMetadataCtx(ctx context.Context) (*types.Metadata, error) {
+	return certificate.openApiMetadata().GetMetadataCtx(ctx)
+}
+
+// GetMetadataByKeyCtx is the same as Certificate.GetMetadataByKey, but no request is sent once the given context is done,
+// returning ctx.Err().
+func (certificate *Certificate) GetMetadataByKeyCtx(ctx context.Context, key string, isSystem bool) (*types.MetadataValue, error) {
+	return certificate.openApiMetadata().GetMetadataByKeyCtx(ctx, key, isSystem)
+}
+
+// AddMetadataEntryWithVisibilityCtx is the same as Certificate.AddMetadataEntryWithVisibility, but no request is sent once the given context is done,
+// returning ctx.Err().
+func (certificate *Certificate) AddMetadataEntryWithVisibilityCtx(ctx context.Context, key, value, typedValue, visibility string, isSystem bool) error {
+	return certificate.openApiMetadata().AddMetadataEntryWithVisibilityCtx(ctx, key, value, typedValue, visibility, isSystem)
+}
+
+// MergeMetadataWithMetadataValuesCtx is the same as Certificate.MergeMetadataWithMetadataValues, but no request is sent once the given context is done,
+// returning ctx.Err().
+func (certificate *Certificate) MergeMetadataWithMetadataValuesCtx(ctx context.Context, metadata map[string]types.MetadataValue) error {
+	return certificate.openApiMetadata().MergeMetadataWithMetadataValuesCtx(ctx, metadata)
+}
+
+// DeleteMetadataEntryWithDomainCtx is the same as Certificate.DeleteMetadataEntryWithDomain, but no request is sent once the given context is done,
+// returning ctx.Err().
+func (certificate *Certificate) DeleteMetadataEntryWithDomainCtx(ctx context.Context, key string, isSystem bool) error {
+	return certificate.openApiMetadata().DeleteMetadataEntryWithDomainCtx(ctx, key, isSystem)
+}
+
+// GetMetadata returns the metadata of the receiver VDC Compute Policy.
+func (vdcComputePolicy *VdcComputePolicyV2) GetMetadata() (*types.Metadata, error) {
+	return vdcComputePolicy.openApiMetadata().GetMetadata()
+}
+
+// GetMetadataByKey returns the metadata of the receiver VDC Compute Policy corresponding to the given key and domain.
+func (vdcComputePolicy *VdcComputePolicyV2) GetMetadataByKey(key string, isSystem bool) (*types.MetadataValue, error) {
+	return vdcComputePolicy.openApiMetadata().GetMetadataByKey(key, isSystem)
+}
+
+// GetTypedMetadataByKey returns the metadata of the receiver VDC Compute Policy corresponding to the given key and domain,
+// converted to its Go type. See getTypedMetadataByKey for details.
+func (vdcComputePolicy *VdcComputePolicyV2) GetTypedMetadataByKey(key string, isSystem bool) (interface{}, error) {
+	return vdcComputePolicy.openApiMetadata().GetTypedMetadataByKey(key, isSystem)
+}
+
+// GetMetadataAsMap returns the metadata of the receiver VDC Compute Policy as a map of key to value. See
+// getMetadataAsMap for details.
+func (vdcComputePolicy *VdcComputePolicyV2) GetMetadataAsMap(isSystem bool) (map[string]string, error) {
+	return vdcComputePolicy.openApiMetadata().GetMetadataAsMap(isSystem)
+}
+
+// AddMetadataEntryWithVisibility adds metadata to the receiver VDC Compute Policy.
+func (vdcComputePolicy *VdcComputePolicyV2) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	return vdcComputePolicy.openApiMetadata().AddMetadataEntryWithVisibility(key, value, typedValue, visibility, isSystem)
+}
+
+// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver
+// VDC Compute Policy and creates the ones not present.
+func (vdcComputePolicy *VdcComputePolicyV2) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	return vdcComputePolicy.openApiMetadata().MergeMetadataWithMetadataValues(metadata)
+}
+
+// DeleteMetadataEntryWithDomain deletes the metadata of the receiver VDC Compute Policy associated to the given key and
+// domain.
+func (vdcComputePolicy *VdcComputePolicyV2) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
+	return vdcComputePolicy.openApiMetadata().DeleteMetadataEntryWithDomain(key, isSystem)
+}
+
+// GetMetadataCtx is the same as VdcComputePolicyV2.GetMetadata, but no request is sent once the given context is done,
+// returning ctx.Err().
+func (vdcComputePolicy *VdcComputePolicyV2) GetMetadataCtx(ctx context.Context) (*types.Metadata, error) {
+	return vdcComputePolicy.openApiMetadata().GetMetadataCtx(ctx)
+}
+
+// GetMetadataByKeyCtx is the same as VdcComputePolicyV2.GetMetadataByKey, but no request is sent once the given context is done,
+// returning ctx.Err().
+func (vdcComputePolicy *VdcComputePolicyV2) GetMetadataByKeyCtx(ctx context.Context, key string, isSystem bool) (*types.MetadataValue, error) {
+	return vdcComputePolicy.openApiMetadata().GetMetadataByKeyCtx(ctx, key, isSystem)
+}
+
+// AddMetadataEntryWithVisibilityCtx is the same as VdcComputePolicyV2.AddMetadataEntryWithVisibility, but no request is sent once the given context is done,
+// returning ctx.Err().
+func (vdcComputePolicy *VdcComputePolicyV2) AddMetadataEntryWithVisibilityCtx(ctx context.Context, key, value, typedValue, visibility string, isSystem bool) error {
+	return vdcComputePolicy.openApiMetadata().AddMetadataEntryWithVisibilityCtx(ctx, key, value, typedValue, visibility, isSystem)
+}
+
+// MergeMetadataWithMetadataValuesCtx is the same as VdcComputePolicyV2.MergeMetadataWithMetadataValues, but no request is sent once the given context is done,
+// returning ctx.Err().
+func (vdcComputePolicy *VdcComputePolicyV2) MergeMetadataWithMetadataValuesCtx(ctx context.Context, metadata map[string]types.MetadataValue) error {
+	return vdcComputePolicy.openApiMetadata().MergeMetadataWithMetadataValuesCtx(ctx, metadata)
+}
+
+// DeleteMetadataEntryWithDomainCtx is the same as VdcComputePolicyV2.DeleteMetadataEntryWithDomain, but no request is sent once the given context is done,
+// returning ctx.Err().
+func (vdcComputePolicy *VdcComputePolicyV2) DeleteMetadataEntryWithDomainCtx(ctx context.Context, key string, isSystem bool) error {
+	return vdcComputePolicy.openApiMetadata().DeleteMetadataEntryWithDomainCtx(ctx, key, isSystem)
+}
+
+// ------------------------------------------------------------------------------------------------
+// CRUD metadata of OpenAPI entities with context
+// ------------------------------------------------------------------------------------------------
+
+// GetMetadataCtx is the same as OpenApiMetadataEntity.GetMetadata, but no request is sent once the given context is
+// done, returning ctx.Err().
+func (entity *OpenApiMetadataEntity) GetMetadataCtx(ctx context.Context) (*types.Metadata, error) {
+	client, entityId, header, err := entity.openApiMetadataTarget()
+	if err != nil {
+		return nil, err
+	}
+	return getOpenApiMetadataWithContext(ctx, client, entity.metadataEndpoint, entityId, header)
+}
+
+// GetMetadataByKeyCtx is the same as OpenApiMetadataEntity.GetMetadataByKey, but no request is sent once the given
+// context is done, returning ctx.Err().
+func (entity *OpenApiMetadataEntity) GetMetadataByKeyCtx(ctx context.Context, key string, isSystem bool) (*types.MetadataValue, error) {
+	client, entityId, header, err := entity.openApiMetadataTarget()
+	if err != nil {
+		return nil, err
+	}
+	return getOpenApiMetadataByKeyWithContext(ctx, client, entity.metadataEndpoint, entityId, header, key, isSystem)
+}
+
+// AddMetadataEntryWithVisibilityCtx is the same as OpenApiMetadataEntity.AddMetadataEntryWithVisibility, but no
+// request is sent once the given context is done, returning ctx.Err().
+// NOTE: A request that was already sent is not interrupted, as OpenAPI requests can't be bound to a context.
+func (entity *OpenApiMetadataEntity) AddMetadataEntryWithVisibilityCtx(ctx context.Context, key, value, typedValue, visibility string, isSystem bool) error {
+	client, entityId, header, err := entity.openApiMetadataTarget()
+	if err != nil {
+		return err
+	}
+	return addOpenApiMetadataWithContext(ctx, client, entity.metadataEndpoint, entityId, header, key, value, typedValue, visibility, isSystem)
+}
+
+// MergeMetadataWithMetadataValuesCtx is the same as OpenApiMetadataEntity.MergeMetadataWithMetadataValues, but no
+// request is sent once the given context is done, returning ctx.Err().
+// NOTE: A request that was already sent is not interrupted, as OpenAPI requests can't be bound to a context.
+func (entity *OpenApiMetadataEntity) MergeMetadataWithMetadataValuesCtx(ctx context.Context, metadata map[string]types.MetadataValue) error {
+	client, entityId, header, err := entity.openApiMetadataTarget()
+	if err != nil {
+		return err
+	}
+	return mergeOpenApiMetadataWithContext(ctx, client, entity.metadataEndpoint, entityId, header, metadata)
+}
+
+// DeleteMetadataEntryWithDomainCtx is the same as OpenApiMetadataEntity.DeleteMetadataEntryWithDomain, but no request
+// is sent once the given context is done, returning ctx.Err().
+// NOTE: A request that was already sent is not interrupted, as OpenAPI requests can't be bound to a context.
+func (entity *OpenApiMetadataEntity) DeleteMetadataEntryWithDomainCtx(ctx context.Context, key string, isSystem bool) error {
+	client, entityId, header, err := entity.openApiMetadataTarget()
+	if err != nil {
+		return err
+	}
+	return deleteOpenApiMetadataWithContext(ctx, client, entity.metadataEndpoint, entityId, header, key, isSystem)
+}
+
+// ------------------------------------------------------------------------------------------------
+// Generic private functions for OpenAPI metadata
+// ------------------------------------------------------------------------------------------------
+
+// getOpenApiMetadataEndpoint returns the API version and the URL to manage the OpenAPI metadata of the entity with the
+// given ID, being endpoint one of the OpenAPI metadata endpoints, like types.OpenApiEndpointOrgVdcNetworksMetadata.
+// If VCD doesn't support the endpoint, a *MetadataNotSupportedError is returned.
+func getOpenApiMetadataEndpoint(client *Client, endpoint, entityId string) (string, *url.URL, error) {
+	if entityId == "" {
+		return "", nil, fmt.Errorf("the entity ID is required to manage its OpenAPI metadata")
+	}
+	endpoint = types.OpenApiPathVersion1_0_0 + endpoint
+	apiVersion, err := client.getOpenApiHighestElevatedVersion(endpoint)
+	if err != nil {
+		return "", nil, &MetadataNotSupportedError{
+			Entity:            fmt.Sprintf("entity '%s'", entityId),
+			Reason:            fmt.Sprintf("the OpenAPI metadata endpoint is not available: %s", err),
+			MinimumApiVersion: endpointMinApiVersions[endpoint],
+		}
+	}
+	urlRef, err := client.OpenApiBuildEndpoint(fmt.Sprintf(endpoint, entityId))
+	if err != nil {
+		return "", nil, err
+	}
+	return apiVersion, urlRef, nil
+}
+
+// getOpenApiMetadataEntries retrieves all the OpenAPI metadata entries of the entity with the given ID, together with
+// the API version and the URL that were used, so they can be reused to modify the entries.
+// The given additional header, such as the one returned by getTenantContextHeader, is sent with every request of the
+// OpenAPI metadata functions that receive it.
+// The functions with context add the HTTP header values set in the context with ContextWithHttpHeaders to the
+// additional header, and use the API version set in the context with ContextWithApiVersion, if any, instead of the
+// highest one supported by the endpoint. As OpenAPI requests can't be bound to a context, the context is checked before sending each
+// request instead, so no more requests are sent once it is done.
+func getOpenApiMetadataEntries(ctx context.Context, client *Client, endpoint, entityId string, additionalHeader map[string]string) ([]*types.OpenApiMetadataEntry, string, *url.URL, error) {
+	apiVersion, urlRef, err := getOpenApiMetadataEndpoint(client, endpoint, entityId)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if ctx.Err() != nil {
+		return nil, "", nil, ctx.Err()
+	}
+	apiVersion = contextApiVersion(ctx, apiVersion)
+
+	var entries []*types.OpenApiMetadataEntry
+	err = client.OpenApiGetAllItems(apiVersion, urlRef, nil, &entries, additionalHeader)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("error retrieving metadata of entity '%s': %s", entityId, err)
+	}
+	return entries, apiVersion, urlRef, nil
+}
+
+// getOpenApiMetadata retrieves all the OpenAPI metadata of the entity with the given ID, converted to types.Metadata.
+func getOpenApiMetadata(client *Client, endpoint, entityId string, additionalHeader map[string]string) (*types.Metadata, error) {
+	return getOpenApiMetadataWithContext(context.Background(), client, endpoint, entityId, additionalHeader)
+}
+
+// getOpenApiMetadataWithContext is the implementation of getOpenApiMetadata, with the requests bound to the given
+// context as described in getOpenApiMetadataEntries
+func getOpenApiMetadataWithContext(ctx context.Context, client *Client, endpoint, entityId string, additionalHeader map[string]string) (*types.Metadata, error) {
+	opLog := metadataOperationLog{operation: "get", href: entityId, domain: "GENERAL,SYSTEM"}
+	opLog.start()
+	entries, _, _, err := getOpenApiMetadataEntries(ctx, client, endpoint, entityId, contextHttpHeaderValues(ctx, additionalHeader))
+	opLog.end(nil, err)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := &types.Metadata{
+		Xmlns: types.XMLNamespaceVCloud,
+		Xsi:   types.XMLNamespaceXSI,
+	}
+	for _, entry := range entries {
+		metadata.MetadataEntry = append(metadata.MetadataEntry, convertOpenApiMetadataEntry(entry))
+	}
+	return metadata, nil
+}
+
+// getOpenApiMetadataByKey retrieves the OpenAPI metadata entry of the entity with the given ID that corresponds to the
+// given key and domain, converted to types.MetadataValue.
+func getOpenApiMetadataByKey(client *Client, endpoint, entityId string, additionalHeader map[string]string, key string, isSystem bool) (*types.MetadataValue, error) {
+	return getOpenApiMetadataByKeyWithContext(context.Background(), client, endpoint, entityId, additionalHeader, key, isSystem)
+}
+
+// getOpenApiMetadataByKeyWithContext is the implementation of getOpenApiMetadataByKey, with the requests bound to the
+// given context as described in getOpenApiMetadataEntries
+func getOpenApiMetadataByKeyWithContext(ctx context.Context, client *Client, endpoint, entityId string, additionalHeader map[string]string, key string, isSystem bool) (*types.MetadataValue, error) {
+	opLog := metadataOperationLog{operation: "get by key", href: entityId, keys: []string{key}, domain: metadataDomainName(isSystem)}
+	opLog.start()
+	entries, _, _, err := getOpenApiMetadataEntries(ctx, client, endpoint, entityId, contextHttpHeaderValues(ctx, additionalHeader))
+	opLog.end(nil, err)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := findOpenApiMetadataEntry(entries, key, isSystem)
+	if entry == nil {
+		return nil, fmt.Errorf("%s: metadata entry with key '%s' not found in entity '%s'", ErrorEntityNotFound, key, entityId)
+	}
+	metadataEntry := convertOpenApiMetadataEntry(entry)
+	return &types.MetadataValue{
+		Xmlns:      types.XMLNamespaceVCloud,
+		Xsi:        types.XMLNamespaceXSI,
+		Domain:     metadataEntry.Domain,
+		TypedValue: metadataEntry.TypedValue,
+	}, nil
+}
+
+// addOpenApiMetadata creates or updates the OpenAPI metadata entry of the entity with the given ID that corresponds to
+// the given key and domain. The typedValue and visibility follow the same rules as in addMetadata.
+func addOpenApiMetadata(client *Client, endpoint, entityId string, additionalHeader map[string]string, key, value, typedValue, visibility string, isSystem bool) error {
+	return addOpenApiMetadataWithContext(context.Background(), client, endpoint, entityId, additionalHeader, key, value, typedValue, visibility, isSystem)
+}
+
+// addOpenApiMetadataWithContext is the implementation of addOpenApiMetadata, with the requests bound to the given
+// context as described in getOpenApiMetadataEntries
+func addOpenApiMetadataWithContext(ctx context.Context, client *Client, endpoint, entityId string, additionalHeader map[string]string, key, value, typedValue, visibility string, isSystem bool) (err error) {
+	if isSystem {
+		err := checkProtectedSystemMetadataKey(client, key)
+		if err != nil {
+			return err
+		}
+	}
+	opLog := metadataOperationLog{operation: "add", href: entityId, keys: []string{key}, domain: metadataDomainName(isSystem), values: map[string]string{key: value}}
+	opLog.start()
+	defer func() { opLog.end(nil, err) }()
+	additionalHeader = contextHttpHeaderValues(ctx, additionalHeader)
+	entries, apiVersion, urlRef, err := getOpenApiMetadataEntries(ctx, client, endpoint, entityId, additionalHeader)
+	if err != nil {
+		return err
+	}
+	err = putOpenApiMetadataEntry(ctx, client, apiVersion, urlRef, additionalHeader, entries, key, value, typedValue, visibility, isSystem)
+	if err != nil {
+		return err
+	}
+	notifyMetadataChange(client, entityId, key, MetadataChangeAdd, openApiMetadataValue(findOpenApiMetadataEntry(entries, key, isSystem)), storedMetadataValue(value, typedValue, visibility, isSystem))
+	return nil
+}
+
+// mergeOpenApiMetadata creates or updates all the given metadata entries in the entity with the given ID. The domain
+// of every entry is taken from its Domain, being GENERAL when it is missing.
+// OpenAPI doesn't allow modifying several entries at once, hence they are written one by one, stopping at the first
+// failure.
+func mergeOpenApiMetadata(client *Client, endpoint, entityId string, additionalHeader map[string]string, metadata map[string]types.MetadataValue) error {
+	return mergeOpenApiMetadataWithContext(context.Background(), client, endpoint, entityId, additionalHeader, metadata)
+}
+
+// mergeOpenApiMetadataWithContext is the implementation of mergeOpenApiMetadata, with the requests bound to the given
+// context as described in getOpenApiMetadataEntries
+func mergeOpenApiMetadataWithContext(ctx context.Context, client *Client, endpoint, entityId string, additionalHeader map[string]string, metadata map[string]types.MetadataValue) (err error) {
+	err = checkProtectedSystemMetadataValues(client, metadata)
+	if err != nil {
+		return err
+	}
+	opLog := newMergeMetadataOperationLog(entityId, metadata)
+	opLog.start()
+	defer func() { opLog.end(nil, err) }()
+	additionalHeader = contextHttpHeaderValues(ctx, additionalHeader)
+	entries, apiVersion, urlRef, err := getOpenApiMetadataEntries(ctx, client, endpoint, entityId, additionalHeader)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := metadata[key]
+		if value.TypedValue == nil {
+			return fmt.Errorf("error merging metadata with key '%s': the typed value is missing", key)
+		}
+		isSystem := value.Domain != nil && value.Domain.Domain == "SYSTEM"
+		visibility := types.MetadataReadWriteVisibility
+		if value.Domain != nil && value.Domain.Visibility != "" {
+			visibility = value.Domain.Visibility
+		}
+		err = putOpenApiMetadataEntry(ctx, client, apiVersion, urlRef, additionalHeader, entries, key, value.TypedValue.Value, value.TypedValue.XsiType, visibility, isSystem)
+		if err != nil {
+			return err
+		}
+		notifyMetadataChange(client, entityId, key, MetadataChangeMerge, openApiMetadataValue(findOpenApiMetadataEntry(entries, key, isSystem)), storedMetadataValue(value.TypedValue.Value, value.TypedValue.XsiType, visibility, isSystem))
+	}
+	return nil
+}
+
+// deleteOpenApiMetadata deletes the OpenAPI metadata entry of the entity with the given ID that corresponds to the
+// given key and domain.
+func deleteOpenApiMetadata(client *Client, endpoint, entityId string, additionalHeader map[string]string, key string, isSystem bool) error {
+	return deleteOpenApiMetadataWithContext(context.Background(), client, endpoint, entityId, additionalHeader, key, isSystem)
+}
+
+// deleteOpenApiMetadataWithContext is the implementation of deleteOpenApiMetadata, with the requests bound to the given
+// context as described in getOpenApiMetadataEntries
+func deleteOpenApiMetadataWithContext(ctx context.Context, client *Client, endpoint, entityId string, additionalHeader map[string]string, key string, isSystem bool) (err error) {
+	if isSystem {
+		err := checkProtectedSystemMetadataKey(client, key)
+		if err != nil {
+			return err
+		}
+	}
+	opLog := metadataOperationLog{operation: "delete", href: entityId, keys: []string{key}, domain: metadataDomainName(isSystem)}
+	opLog.start()
+	defer func() { opLog.end(nil, err) }()
+	additionalHeader = contextHttpHeaderValues(ctx, additionalHeader)
+	entries, apiVersion, urlRef, err := getOpenApiMetadataEntries(ctx, client, endpoint, entityId, additionalHeader)
+	if err != nil {
+		return err
+	}
+
+	entry := findOpenApiMetadataEntry(entries, key, isSystem)
+	if entry == nil {
+		return fmt.Errorf("%s: metadata entry with key '%s' not found in entity '%s'", ErrorEntityNotFound, key, entityId)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	err = client.OpenApiDeleteItem(apiVersion, urlParseRequestURI(urlRef.String()+entry.ID), nil, additionalHeader)
+	if err != nil {
+		return fmt.Errorf("error deleting metadata with key '%s': %s", key, err)
+	}
+	notifyMetadataChange(client, entityId, key, MetadataChangeDelete, openApiMetadataValue(entry), nil)
+	return nil
+}
+
+// openApiMetadataValue converts the given OpenAPI metadata entry to a metadata value of the XML API, returning nil if
+// the entry is nil
+func openApiMetadataValue(entry *types.OpenApiMetadataEntry) *types.MetadataValue {
+	if entry == nil {
+		return nil
+	}
+	metadataEntry := convertOpenApiMetadataEntry(entry)
+	return &types.MetadataValue{Domain: metadataEntry.Domain, TypedValue: metadataEntry.TypedValue}
+}
+
+// putOpenApiMetadataEntry updates the entry with the given key and domain if it is present in the given entries,
+// or creates it otherwise, using the metadata URL of an entity.
+func putOpenApiMetadataEntry(ctx context.Context, client *Client, apiVersion string, urlRef *url.URL, additionalHeader map[string]string, entries []*types.OpenApiMetadataEntry, key, value, typedValue, visibility string, isSystem bool) error {
+	payload, err := newOpenApiMetadataEntry(key, value, typedValue, visibility, isSystem)
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	existingEntry := findOpenApiMetadataEntry(entries, key, isSystem)
+	if existingEntry != nil {
+		payload.ID = existingEntry.ID
+		err = client.OpenApiPutItem(apiVersion, urlParseRequestURI(urlRef.String()+existingEntry.ID), nil, payload, &types.OpenApiMetadataEntry{}, additionalHeader)
+	} else {
+		err = client.OpenApiPostItem(apiVersion, urlRef, nil, payload, &types.OpenApiMetadataEntry{}, additionalHeader)
+	}
+	if err != nil {
+		return fmt.Errorf("error adding metadata with key '%s': %s", key, err)
+	}
+	return nil
+}
+
+// findOpenApiMetadataEntry returns the entry with the given key that belongs to the PROVIDER domain when isSystem=true,
+// or to the TENANT domain otherwise. If there is no such entry, it returns nil.
+func findOpenApiMetadataEntry(entries []*types.OpenApiMetadataEntry, key string, isSystem bool) *types.OpenApiMetadataEntry {
+	for _, entry := range entries {
+		if entry != nil && entry.KeyValue.Key == key && (entry.KeyValue.Domain == types.OpenApiMetadataProviderDomain) == isSystem {
+			return entry
+		}
+	}
+	return nil
+}
+
+// newOpenApiMetadataEntry converts the given metadata, in terms of the XML API, to an OpenAPI metadata entry:
+//   - The SYSTEM domain becomes the PROVIDER domain, being read-only for types.MetadataReadOnlyVisibility. The GENERAL
+//     domain becomes the TENANT domain, which is always writable, as types.MetadataReadWriteVisibility is enforced.
+//   - Only types.MetadataStringValue, types.MetadataNumberValue and types.MetadataBooleanValue are supported.
+func newOpenApiMetadataEntry(key, value, typedValue, visibility string, isSystem bool) (*types.OpenApiMetadataEntry, error) {
+	entry := &types.OpenApiMetadataEntry{
+		KeyValue: types.OpenApiMetadataKeyValue{
+			Domain: types.OpenApiMetadataTenantDomain,
+			Key:    key,
+		},
+	}
+	if isSystem {
+		entry.KeyValue.Domain = types.OpenApiMetadataProviderDomain
+		entry.IsReadOnly = visibility == types.MetadataReadOnlyVisibility
+	}
+
+	switch typedValue {
+	case types.MetadataStringValue:
+		entry.KeyValue.Value = types.OpenApiMetadataTypedValue{Type: types.OpenApiMetadataStringEntry, Value: value}
+	case types.MetadataNumberValue:
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("error converting metadata with key '%s': '%s' is not a valid number: %s", key, value, err)
+		}
+		entry.KeyValue.Value = types.OpenApiMetadataTypedValue{Type: types.OpenApiMetadataNumberEntry, Value: number}
+	case types.MetadataBooleanValue:
+		boolean, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("error converting metadata with key '%s': '%s' is not a valid boolean: %s", key, value, err)
+		}
+		entry.KeyValue.Value = types.OpenApiMetadataTypedValue{Type: types.OpenApiMetadataBooleanEntry, Value: boolean}
+	default:
+		return nil, fmt.Errorf("error converting metadata with key '%s': type '%s' is not supported by OpenAPI metadata", key, typedValue)
+	}
+	return entry, nil
+}
+
+// convertOpenApiMetadataEntry converts the given OpenAPI metadata entry to a metadata entry of the XML API, doing the
+// opposite mapping of newOpenApiMetadataEntry. Entries of the PROVIDER domain that are not read-only get
+// types.MetadataHiddenVisibility.
+func convertOpenApiMetadataEntry(entry *types.OpenApiMetadataEntry) *types.MetadataEntry {
+	domain := &types.MetadataDomainTag{Domain: "GENERAL", Visibility: types.MetadataReadWriteVisibility}
+	if entry.KeyValue.Domain == types.OpenApiMetadataProviderDomain {
+		domain = &types.MetadataDomainTag{Domain: "SYSTEM", Visibility: types.MetadataHiddenVisibility}
+	}
+	if entry.IsReadOnly {
+		domain.Visibility = types.MetadataReadOnlyVisibility
+	}
+
+	typedValue := &types.MetadataTypedValue{XsiType: types.MetadataStringValue}
+	switch entry.KeyValue.Value.Type {
+	case types.OpenApiMetadataNumberEntry:
+		typedValue.XsiType = types.MetadataNumberValue
+	case types.OpenApiMetadataBooleanEntry:
+		typedValue.XsiType = types.MetadataBooleanValue
+	}
+	switch value := entry.KeyValue.Value.Value.(type) {
+	case nil:
+		typedValue.Value = ""
+	case float64:
+		typedValue.Value = strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		typedValue.Value = fmt.Sprintf("%v", value)
+	}
+
+	return &types.MetadataEntry{
+		Xmlns:      types.XMLNamespaceVCloud,
+		Xsi:        types.XMLNamespaceXSI,
+		Key:        entry.KeyValue.Key,
+		TypedValue: typedValue,
+		Domain:     domain,
+	}
+}
+
+// newOpenApiMetadataEntity returns an OpenApiMetadataEntity that manages the metadata of an entity whose ID and tenant
+// context are retrieved when every operation starts, so they are always the current ones of the entity that embeds it.
+// The tenantContext function can be nil.
+func newOpenApiMetadataEntity(client *Client, endpoint string, entityId func() string, tenantContext func() (*TenantContext, error)) *OpenApiMetadataEntity {
+	return &OpenApiMetadataEntity{
+		metadataClient:        client,
+		metadataEndpoint:      endpoint,
+		metadataEntityId:      entityId,
+		metadataTenantContext: tenantContext,
+	}
+}
+
+// openApiMetadataTarget returns the client, the ID and the tenant context header needed to manage the metadata of the
+// entity. An error retrieving the tenant context is returned, while a nil tenant context means that the entity is
+// managed without it.
+func (entity *OpenApiMetadataEntity) openApiMetadataTarget() (*Client, string, map[string]string, error) {
+	if entity == nil || entity.metadataClient == nil || entity.metadataEntityId == nil {
+		return nil, "", nil, fmt.Errorf("the OpenAPI metadata of the entity is not initialized")
+	}
+	var header map[string]string
+	if entity.metadataTenantContext != nil {
+		tenantContext, err := entity.metadataTenantContext()
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("error retrieving the tenant context to manage OpenAPI metadata: %s", err)
+		}
+		header = getTenantContextHeader(tenantContext)
+	}
+	return entity.metadataClient, entity.metadataEntityId(), header, nil
+}
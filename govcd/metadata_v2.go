@@ -5,10 +5,26 @@
 package govcd
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"github.com/vmware/go-vcloud-director/v2/types/v56"
+	"github.com/vmware/go-vcloud-director/v2/util"
+	"io"
+	"math"
 	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode/utf8"
 )
 
 // NOTE: This "v2" is not v2 in terms of API versioning, it's just a way to separate the functions that handle
@@ -20,18 +36,75 @@ import (
 // as this is classified using "CRUD blocks" (meaning that all Create functions are together, same for Read... etc),
 // which makes the code more readable.
 
+// MetadataCompatible is implemented by all the entities that support reading and modifying their metadata, so
+// generic operations can be performed on any of them, for example:
+//
+//	func tagEnvironment(entity MetadataCompatible, environment string) error {
+//		return entity.AddMetadataEntryWithVisibility("environment", environment, types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+//	}
+//
+// Entities that can't have metadata, like NsxtNatRule or VmAffinityRule, implement it by returning a
+// *MetadataNotSupportedError.
+//
+// Storage profiles have no type in this package, so their metadata is managed with VCDClient methods that receive the
+// storage profile HREF, like VCDClient.GetStorageProfileMetadata.
+type MetadataCompatible interface {
+	GetMetadata() (*types.Metadata, error)
+	GetMetadataByKey(key string, isSystem bool) (*types.MetadataValue, error)
+	AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error
+	MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error
+	DeleteMetadataEntryWithDomain(key string, isSystem bool) error
+}
+
+// Compile-time checks that all the metadata compatible entities implement MetadataCompatible
+var (
+	_ MetadataCompatible = (*VM)(nil)
+	_ MetadataCompatible = (*Vdc)(nil)
+	_ MetadataCompatible = (*AdminVdc)(nil)
+	_ MetadataCompatible = (*ProviderVdc)(nil)
+	_ MetadataCompatible = (*VApp)(nil)
+	_ MetadataCompatible = (*VAppTemplate)(nil)
+	_ MetadataCompatible = (*MediaRecord)(nil)
+	_ MetadataCompatible = (*Media)(nil)
+	_ MetadataCompatible = (*Catalog)(nil)
+	_ MetadataCompatible = (*AdminCatalog)(nil)
+	_ MetadataCompatible = (*Org)(nil)
+	_ MetadataCompatible = (*AdminOrg)(nil)
+	_ MetadataCompatible = (*Disk)(nil)
+	_ MetadataCompatible = (*OrgVDCNetwork)(nil)
+	_ MetadataCompatible = (*CatalogItem)(nil)
+	_ MetadataCompatible = (*OpenApiOrgVdcNetwork)(nil)
+	_ MetadataCompatible = (*NsxtEdgeGateway)(nil)
+	_ MetadataCompatible = (*VdcGroup)(nil)
+	_ MetadataCompatible = (*ExternalNetworkV2)(nil)
+	_ MetadataCompatible = (*Certificate)(nil)
+	_ MetadataCompatible = (*VdcComputePolicyV2)(nil)
+	_ MetadataCompatible = (*NsxtAlbServiceEngineGroup)(nil)
+	_ MetadataCompatible = (*NsxtNatRule)(nil)
+	_ MetadataCompatible = (*NsxtAlbController)(nil)
+	_ MetadataCompatible = (*NsxtAlbCloud)(nil)
+	_ MetadataCompatible = (*VmAffinityRule)(nil)
+)
+
 // ------------------------------------------------------------------------------------------------
 // GET metadata by key
 // ------------------------------------------------------------------------------------------------
 
 // GetMetadataByKeyAndHref returns metadata from the given resource reference, corresponding to the given key and domain.
 func (vcdClient *VCDClient) GetMetadataByKeyAndHref(href, key string, isSystem bool) (*types.MetadataValue, error) {
-	return getMetadataByKey(&vcdClient.Client, href, key, isSystem)
+	return vcdClient.GetMetadataByKeyDomainAndHref(href, key, metadataDomainName(isSystem))
+}
+
+// GetMetadataByKeyDomainAndHref returns metadata from the given resource reference, corresponding to the given key and
+// explicit domain, such as "GENERAL" or "SYSTEM". Other domains are rejected, unless Client.AllowUnknownMetadataDomains
+// is true, in which case they are sent to VCD as they are.
+func (vcdClient *VCDClient) GetMetadataByKeyDomainAndHref(href, key, domain string) (*types.MetadataValue, error) {
+	return getMetadataByKeyAndDomainWithContext(context.Background(), &vcdClient.Client, href, key, domain)
 }
 
 // GetMetadataByKey returns VM metadata corresponding to the given key and domain.
 func (vm *VM) GetMetadataByKey(key string, isSystem bool) (*types.MetadataValue, error) {
-	return getMetadataByKey(vm.client, vm.VM.HREF, key, isSystem)
+	return vm.GetMetadataByKeyCtx(context.Background(), key, isSystem)
 }
 
 // GetMetadataByKey returns VDC metadata corresponding to the given key and domain.
@@ -92,8 +165,16 @@ func (adminOrg *AdminOrg) GetMetadataByKey(key string, isSystem bool) (*types.Me
 }
 
 // GetMetadataByKey returns the metadata corresponding to the given key and domain.
+// NOTE: If Client.OpenApiDiskMetadata is set, the disk has an OpenAPI ID and the negotiated API version is 38.0+
+// (VCD 10.5+), the OpenAPI metadata endpoint is used, so it doesn't depend on the XML HREF of the disk.
 func (disk *Disk) GetMetadataByKey(key string, isSystem bool) (*types.MetadataValue, error) {
-	return getMetadataByKey(disk.client, disk.Disk.HREF, key, isSystem)
+	opts := disk.metadataRequestOptions(metadataOperationGetByKey)
+	opts.key, opts.isSystem = key, isSystem
+	response, err := metadataRequest(disk.client, opts)
+	if err != nil {
+		return nil, err
+	}
+	return response.value, nil
 }
 
 // GetMetadataByKey returns OrgVDCNetwork metadata corresponding to the given key and domain.
@@ -107,11 +188,382 @@ func (catalogItem *CatalogItem) GetMetadataByKey(key string, isSystem bool) (*ty
 }
 
 // GetMetadataByKey returns OpenApiOrgVdcNetwork metadata corresponding to the given key and domain.
-// NOTE: This function cannot retrieve metadata if the network belongs to a VDC Group.
-// TODO: This function is currently using XML API underneath as OpenAPI metadata is still not supported.
+// NOTE: If the network belongs to a VDC Group, the OpenAPI metadata endpoint is used, which requires VCD 10.5+.
+// Otherwise, the XML API is used.
 func (openApiOrgVdcNetwork *OpenApiOrgVdcNetwork) GetMetadataByKey(key string, isSystem bool) (*types.MetadataValue, error) {
-	href := fmt.Sprintf("%s/network/%s", openApiOrgVdcNetwork.client.VCDHREF.String(), extractUuid(openApiOrgVdcNetwork.OpenApiOrgVdcNetwork.ID))
-	return getMetadataByKey(openApiOrgVdcNetwork.client, href, key, isSystem)
+	opts := openApiOrgVdcNetwork.metadataRequestOptions(metadataOperationGetByKey, false)
+	opts.key, opts.isSystem = key, isSystem
+	response, err := metadataRequest(openApiOrgVdcNetwork.client, opts)
+	if err != nil {
+		return nil, err
+	}
+	return response.value, nil
+}
+
+// GetMetadataByKey returns NSX-T ALB Service Engine Group metadata corresponding to the given key and domain.
+// NOTE: The OpenAPI metadata endpoint is used, which requires VCD 10.5+. A *MetadataNotSupportedError is returned
+// for older versions.
+// Note: Requires system administrator privileges.
+func (nsxtAlbServiceEngineGroup *NsxtAlbServiceEngineGroup) GetMetadataByKey(key string, isSystem bool) (*types.MetadataValue, error) {
+	return getOpenApiMetadataByKey(&nsxtAlbServiceEngineGroup.vcdClient.Client, types.OpenApiEndpointAlbServiceEngineGroupsMetadata, nsxtAlbServiceEngineGroup.NsxtAlbServiceEngineGroup.ID, nil, key, isSystem)
+}
+
+// GetSubscriptionMetadataByKey is not supported, as VCD doesn't expose metadata for the subscription and
+// synchronization settings of a Catalog. It always returns a *MetadataNotSupportedError.
+// The metadata of the subscribed Catalog itself can be retrieved with AdminCatalog.GetMetadataByKey.
+func (adminCatalog *AdminCatalog) GetSubscriptionMetadataByKey(key string, isSystem bool) (*types.MetadataValue, error) {
+	return nil, catalogSubscriptionMetadataNotSupported()
+}
+
+// GetVmSizingMetadataByKey is not supported, as VCD doesn't expose metadata for the VM sizing configuration (CPU,
+// memory and their reservations) of a VDC Compute Policy. It always returns a *MetadataNotSupportedError.
+func (vdcComputePolicy *VdcComputePolicyV2) GetVmSizingMetadataByKey(key string, isSystem bool) (*types.MetadataValue, error) {
+	return nil, vmSizingMetadataNotSupported()
+}
+
+// GetMetadataByKey is not supported, as VCD doesn't expose metadata for NSX-T Edge Gateway NAT rules.
+// It always returns a *MetadataNotSupportedError.
+func (nsxtNatRule *NsxtNatRule) GetMetadataByKey(key string, isSystem bool) (*types.MetadataValue, error) {
+	return nil, nsxtNatRuleMetadataNotSupported()
+}
+
+// GetMetadataByKey is not supported, as VCD doesn't expose metadata for NSX-T ALB Controllers.
+// It always returns a *MetadataNotSupportedError.
+func (nsxtAlbController *NsxtAlbController) GetMetadataByKey(key string, isSystem bool) (*types.MetadataValue, error) {
+	return nil, nsxtAlbControllerMetadataNotSupported()
+}
+
+// GetMetadataByKey is not supported, as VCD doesn't expose metadata for NSX-T ALB Clouds.
+// It always returns a *MetadataNotSupportedError.
+func (nsxtAlbCloud *NsxtAlbCloud) GetMetadataByKey(key string, isSystem bool) (*types.MetadataValue, error) {
+	return nil, nsxtAlbCloudMetadataNotSupported()
+}
+
+// GetRoleAssignmentMetadataByKey is not supported, as VCD doesn't expose metadata for the role assigned to an Org user.
+// It always returns a *MetadataNotSupportedError.
+func (orgUser *OrgUser) GetRoleAssignmentMetadataByKey(key string, isSystem bool) (*types.MetadataValue, error) {
+	return nil, orgUserRoleAssignmentMetadataNotSupported()
+}
+
+// GetMetadataByKey is not supported, as VCD doesn't expose metadata for VM affinity rules.
+// It always returns a *MetadataNotSupportedError.
+func (vmAffinityRule *VmAffinityRule) GetMetadataByKey(key string, isSystem bool) (*types.MetadataValue, error) {
+	return nil, vmAffinityRuleMetadataNotSupported()
+}
+
+// GetRoleMetadataByKey is not supported, as VCD doesn't expose metadata for roles.
+// It always returns a *MetadataNotSupportedError.
+func (role *Role) GetRoleMetadataByKey(key string, isSystem bool) (*types.MetadataValue, error) {
+	return nil, roleMetadataNotSupported()
+}
+
+// GetGlobalRoleMetadataByKey is not supported, as VCD doesn't expose metadata for global roles.
+// It always returns a *MetadataNotSupportedError.
+func (globalRole *GlobalRole) GetGlobalRoleMetadataByKey(key string, isSystem bool) (*types.MetadataValue, error) {
+	return nil, globalRoleMetadataNotSupported()
+}
+
+// GetStorageProfileMetadataByKey returns the metadata of the storage profile with the given HREF, corresponding to
+// the given key and domain.
+// The storage profile can be a VDC storage profile or a Provider VDC storage profile.
+// Note: Requires system administrator privileges for Provider VDC storage profiles.
+func (vcdClient *VCDClient) GetStorageProfileMetadataByKey(storageProfileHref, key string, isSystem bool) (*types.MetadataValue, error) {
+	return getMetadataByKey(&vcdClient.Client, storageProfileHref, key, isSystem)
+}
+
+// GetNetworkPoolMetadataByKey is not supported, as VCD doesn't expose metadata for network pools.
+// It always returns a *MetadataNotSupportedError.
+func (vcdClient *VCDClient) GetNetworkPoolMetadataByKey(networkPoolHref, key string, isSystem bool) (*types.MetadataValue, error) {
+	return nil, networkPoolMetadataNotSupported()
+}
+
+// GetNsxtManagerMetadataByKey is not supported, as VCD doesn't expose metadata for NSX-T Managers.
+// It always returns a *MetadataNotSupportedError.
+func (vcdClient *VCDClient) GetNsxtManagerMetadataByKey(nsxtManagerHref, key string, isSystem bool) (*types.MetadataValue, error) {
+	return nil, nsxtManagerMetadataNotSupported()
+}
+
+// GetVAppSnapshotMetadataByKey is not supported, as VCD doesn't expose metadata for vApp snapshots.
+// It always returns a *MetadataNotSupportedError.
+func (vcdClient *VCDClient) GetVAppSnapshotMetadataByKey(snapshotHref, key string, isSystem bool) (*types.MetadataValue, error) {
+	return nil, vAppSnapshotMetadataNotSupported()
+}
+
+// GetUIPluginMetadataByKey is not supported, as VCD doesn't expose metadata for UI plugins.
+// It always returns a *MetadataNotSupportedError.
+func (vcdClient *VCDClient) GetUIPluginMetadataByKey(pluginId, key string, isSystem bool) (*types.MetadataValue, error) {
+	return nil, uiPluginMetadataNotSupported()
+}
+
+// ------------------------------------------------------------------------------------------------
+// GET typed metadata by key
+// ------------------------------------------------------------------------------------------------
+
+// GetTypedMetadataByKeyAndHref returns the value of the metadata of the given resource reference that corresponds to
+// the given key and domain, converted to its Go type. See getTypedMetadataByKey for details.
+func (vcdClient *VCDClient) GetTypedMetadataByKeyAndHref(href, key string, isSystem bool) (interface{}, error) {
+	return getTypedMetadataByKey(&vcdClient.Client, href, key, isSystem)
+}
+
+// GetTypedMetadataByKey returns VM metadata corresponding to the given key and domain, converted to its Go type.
+// See getTypedMetadataByKey for details.
+func (vm *VM) GetTypedMetadataByKey(key string, isSystem bool) (interface{}, error) {
+	metadataValue, err := vm.GetMetadataByKey(key, isSystem)
+	if err != nil {
+		return nil, err
+	}
+	return typedMetadataValue(key, metadataValue)
+}
+
+// GetTypedMetadataByKey returns VDC metadata corresponding to the given key and domain, converted to its Go type.
+// See getTypedMetadataByKey for details.
+func (vdc *Vdc) GetTypedMetadataByKey(key string, isSystem bool) (interface{}, error) {
+	return getTypedMetadataByKey(vdc.client, vdc.Vdc.HREF, key, isSystem)
+}
+
+// GetTypedMetadataByKey returns AdminVdc metadata corresponding to the given key and domain, converted to its Go type.
+// See getTypedMetadataByKey for details.
+func (adminVdc *AdminVdc) GetTypedMetadataByKey(key string, isSystem bool) (interface{}, error) {
+	return getTypedMetadataByKey(adminVdc.client, adminVdc.AdminVdc.HREF, key, isSystem)
+}
+
+// GetTypedMetadataByKey returns ProviderVdc metadata corresponding to the given key and domain, converted to its Go type.
+// See getTypedMetadataByKey for details.
+// Note: Requires system administrator privileges.
+func (providerVdc *ProviderVdc) GetTypedMetadataByKey(key string, isSystem bool) (interface{}, error) {
+	return getTypedMetadataByKey(providerVdc.client, providerVdc.ProviderVdc.HREF, key, isSystem)
+}
+
+// GetTypedMetadataByKey returns VApp metadata corresponding to the given key and domain, converted to its Go type.
+// See getTypedMetadataByKey for details.
+func (vapp *VApp) GetTypedMetadataByKey(key string, isSystem bool) (interface{}, error) {
+	return getTypedMetadataByKey(vapp.client, vapp.VApp.HREF, key, isSystem)
+}
+
+// GetTypedMetadataByKey returns VAppTemplate metadata corresponding to the given key and domain, converted to its Go type.
+// See getTypedMetadataByKey for details.
+func (vAppTemplate *VAppTemplate) GetTypedMetadataByKey(key string, isSystem bool) (interface{}, error) {
+	return getTypedMetadataByKey(vAppTemplate.client, vAppTemplate.VAppTemplate.HREF, key, isSystem)
+}
+
+// GetTypedMetadataByKey returns MediaRecord metadata corresponding to the given key and domain, converted to its Go type.
+// See getTypedMetadataByKey for details.
+func (mediaRecord *MediaRecord) GetTypedMetadataByKey(key string, isSystem bool) (interface{}, error) {
+	return getTypedMetadataByKey(mediaRecord.client, mediaRecord.MediaRecord.HREF, key, isSystem)
+}
+
+// GetTypedMetadataByKey returns Media metadata corresponding to the given key and domain, converted to its Go type.
+// See getTypedMetadataByKey for details.
+func (media *Media) GetTypedMetadataByKey(key string, isSystem bool) (interface{}, error) {
+	return getTypedMetadataByKey(media.client, media.Media.HREF, key, isSystem)
+}
+
+// GetTypedMetadataByKey returns Catalog metadata corresponding to the given key and domain, converted to its Go type.
+// See getTypedMetadataByKey for details.
+func (catalog *Catalog) GetTypedMetadataByKey(key string, isSystem bool) (interface{}, error) {
+	return getTypedMetadataByKey(catalog.client, catalog.Catalog.HREF, key, isSystem)
+}
+
+// GetTypedMetadataByKey returns AdminCatalog metadata corresponding to the given key and domain, converted to its Go type.
+// See getTypedMetadataByKey for details.
+func (adminCatalog *AdminCatalog) GetTypedMetadataByKey(key string, isSystem bool) (interface{}, error) {
+	return getTypedMetadataByKey(adminCatalog.client, adminCatalog.AdminCatalog.HREF, key, isSystem)
+}
+
+// GetTypedMetadataByKey returns Org metadata corresponding to the given key and domain, converted to its Go type.
+// See getTypedMetadataByKey for details.
+func (org *Org) GetTypedMetadataByKey(key string, isSystem bool) (interface{}, error) {
+	return getTypedMetadataByKey(org.client, org.Org.HREF, key, isSystem)
+}
+
+// GetTypedMetadataByKey returns AdminOrg metadata corresponding to the given key and domain, converted to its Go type.
+// See getTypedMetadataByKey for details.
+// Note: Requires system administrator privileges.
+func (adminOrg *AdminOrg) GetTypedMetadataByKey(key string, isSystem bool) (interface{}, error) {
+	return getTypedMetadataByKey(adminOrg.client, adminOrg.AdminOrg.HREF, key, isSystem)
+}
+
+// GetTypedMetadataByKey returns Disk metadata corresponding to the given key and domain, converted to its Go type.
+// See getTypedMetadataByKey for details.
+// NOTE: Like GetMetadataByKey, it uses the OpenAPI metadata endpoint when Client.OpenApiDiskMetadata is set.
+func (disk *Disk) GetTypedMetadataByKey(key string, isSystem bool) (interface{}, error) {
+	metadataValue, err := disk.GetMetadataByKey(key, isSystem)
+	if err != nil {
+		return nil, err
+	}
+	return typedMetadataValue(key, metadataValue)
+}
+
+// GetTypedMetadataByKey returns OrgVDCNetwork metadata corresponding to the given key and domain, converted to its Go type.
+// See getTypedMetadataByKey for details.
+func (orgVdcNetwork *OrgVDCNetwork) GetTypedMetadataByKey(key string, isSystem bool) (interface{}, error) {
+	return getTypedMetadataByKey(orgVdcNetwork.client, orgVdcNetwork.OrgVDCNetwork.HREF, key, isSystem)
+}
+
+// GetTypedMetadataByKey returns CatalogItem metadata corresponding to the given key and domain, converted to its Go type.
+// See getTypedMetadataByKey for details.
+func (catalogItem *CatalogItem) GetTypedMetadataByKey(key string, isSystem bool) (interface{}, error) {
+	return getTypedMetadataByKey(catalogItem.client, catalogItem.CatalogItem.HREF, key, isSystem)
+}
+
+// GetTypedMetadataByKey returns OpenApiOrgVdcNetwork metadata corresponding to the given key and domain, converted to
+// its Go type. See getTypedMetadataByKey for details.
+// NOTE: If the network belongs to a VDC Group, the OpenAPI metadata endpoint is used, which requires VCD 10.5+.
+// Otherwise, the XML API is used.
+func (openApiOrgVdcNetwork *OpenApiOrgVdcNetwork) GetTypedMetadataByKey(key string, isSystem bool) (interface{}, error) {
+	metadataValue, err := openApiOrgVdcNetwork.GetMetadataByKey(key, isSystem)
+	if err != nil {
+		return nil, err
+	}
+	return typedMetadataValue(key, metadataValue)
+}
+
+// GetTypedMetadataByKey returns NSX-T ALB Service Engine Group metadata corresponding to the given key and domain,
+// converted to its Go type. See getTypedMetadataByKey for details.
+// NOTE: The OpenAPI metadata endpoint is used, which requires VCD 10.5+. A *MetadataNotSupportedError is returned
+// for older versions.
+// Note: Requires system administrator privileges.
+func (nsxtAlbServiceEngineGroup *NsxtAlbServiceEngineGroup) GetTypedMetadataByKey(key string, isSystem bool) (interface{}, error) {
+	metadataValue, err := nsxtAlbServiceEngineGroup.GetMetadataByKey(key, isSystem)
+	if err != nil {
+		return nil, err
+	}
+	return typedMetadataValue(key, metadataValue)
+}
+
+// ------------------------------------------------------------------------------------------------
+// GET metadata by key if present
+// ------------------------------------------------------------------------------------------------
+
+// GetMetadataByKeyAndHrefIfPresent returns the metadata value of the given resource reference that corresponds to the
+// given key and domain, and whether it was present. See getMetadataByKeyIfPresent for details.
+func (vcdClient *VCDClient) GetMetadataByKeyAndHrefIfPresent(href, key string, isSystem bool) (*types.MetadataValue, bool, error) {
+	return getMetadataByKeyIfPresent(&vcdClient.Client, href, key, isSystem)
+}
+
+// GetMetadataByKeyIfPresent returns VM metadata corresponding to the given key and domain, and whether it was present.
+// See getMetadataByKeyIfPresent for details.
+func (vm *VM) GetMetadataByKeyIfPresent(key string, isSystem bool) (*types.MetadataValue, bool, error) {
+	metadataValue, err := vm.GetMetadataByKey(key, isSystem)
+	if err != nil {
+		if errors.Is(err, ErrorEntityNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return metadataValue, true, nil
+}
+
+// GetMetadataByKeyIfPresent returns VApp metadata corresponding to the given key and domain, and whether it was present.
+// See getMetadataByKeyIfPresent for details.
+func (vapp *VApp) GetMetadataByKeyIfPresent(key string, isSystem bool) (*types.MetadataValue, bool, error) {
+	return getMetadataByKeyIfPresent(vapp.client, vapp.VApp.HREF, key, isSystem)
+}
+
+// GetMetadataByKeyIfPresent returns VDC metadata corresponding to the given key and domain, and whether it was present.
+// See getMetadataByKeyIfPresent for details.
+func (vdc *Vdc) GetMetadataByKeyIfPresent(key string, isSystem bool) (*types.MetadataValue, bool, error) {
+	return getMetadataByKeyIfPresent(vdc.client, vdc.Vdc.HREF, key, isSystem)
+}
+
+// GetMetadataByKeyIfPresent returns Catalog metadata corresponding to the given key and domain, and whether it was present.
+// See getMetadataByKeyIfPresent for details.
+func (catalog *Catalog) GetMetadataByKeyIfPresent(key string, isSystem bool) (*types.MetadataValue, bool, error) {
+	return getMetadataByKeyIfPresent(catalog.client, catalog.Catalog.HREF, key, isSystem)
+}
+
+// GetMetadataByKeyIfPresent returns Org metadata corresponding to the given key and domain, and whether it was present.
+// See getMetadataByKeyIfPresent for details.
+func (org *Org) GetMetadataByKeyIfPresent(key string, isSystem bool) (*types.MetadataValue, bool, error) {
+	return getMetadataByKeyIfPresent(org.client, org.Org.HREF, key, isSystem)
+}
+
+// ------------------------------------------------------------------------------------------------
+// GET metadata by key in both domains
+// ------------------------------------------------------------------------------------------------
+
+// MetadataKeyDomains contains the values of a metadata key in the SYSTEM and GENERAL domains, as the same key can
+// exist in both. A nil value means that the key is not present in that domain.
+type MetadataKeyDomains struct {
+	Key     string
+	System  *types.MetadataValue
+	General *types.MetadataValue
+}
+
+// IsPresent returns true if the key exists in any of the domains
+func (keyDomains *MetadataKeyDomains) IsPresent() bool {
+	return keyDomains.System != nil || keyDomains.General != nil
+}
+
+// GetMetadataByKeyWithDomainAndHref returns the values of the given key in both domains of the given resource
+// reference. See getMetadataByKeyWithDomain for details.
+func (vcdClient *VCDClient) GetMetadataByKeyWithDomainAndHref(href, key string) (*MetadataKeyDomains, error) {
+	return getMetadataByKeyWithDomain(&vcdClient.Client, href, key)
+}
+
+// GetMetadataByKeyWithDomain returns the values of the given key in both domains of the receiver VM.
+// See getMetadataByKeyWithDomain for details.
+func (vm *VM) GetMetadataByKeyWithDomain(key string) (*MetadataKeyDomains, error) {
+	if !vm.metadataCacheEnabled {
+		return getMetadataByKeyWithDomain(vm.client, vm.VM.HREF, key)
+	}
+	keyDomains := &MetadataKeyDomains{Key: key}
+	var err error
+	keyDomains.System, _, err = vm.GetMetadataByKeyIfPresent(key, true)
+	if err != nil {
+		return nil, err
+	}
+	keyDomains.General, _, err = vm.GetMetadataByKeyIfPresent(key, false)
+	if err != nil {
+		return nil, err
+	}
+	return keyDomains, nil
+}
+
+// GetMetadataByKeyWithDomain returns the values of the given key in both domains of the receiver VApp.
+// See getMetadataByKeyWithDomain for details.
+func (vapp *VApp) GetMetadataByKeyWithDomain(key string) (*MetadataKeyDomains, error) {
+	return getMetadataByKeyWithDomain(vapp.client, vapp.VApp.HREF, key)
+}
+
+// GetMetadataByKeyWithDomain returns the values of the given key in both domains of the receiver VDC.
+// See getMetadataByKeyWithDomain for details.
+func (vdc *Vdc) GetMetadataByKeyWithDomain(key string) (*MetadataKeyDomains, error) {
+	return getMetadataByKeyWithDomain(vdc.client, vdc.Vdc.HREF, key)
+}
+
+// GetMetadataByKeyWithDomain returns the values of the given key in both domains of the receiver Catalog.
+// See getMetadataByKeyWithDomain for details.
+func (catalog *Catalog) GetMetadataByKeyWithDomain(key string) (*MetadataKeyDomains, error) {
+	return getMetadataByKeyWithDomain(catalog.client, catalog.Catalog.HREF, key)
+}
+
+// GetMetadataByKeyWithDomain returns the values of the given key in both domains of the receiver Org.
+// See getMetadataByKeyWithDomain for details.
+func (org *Org) GetMetadataByKeyWithDomain(key string) (*MetadataKeyDomains, error) {
+	return getMetadataByKeyWithDomain(org.client, org.Org.HREF, key)
+}
+
+// ------------------------------------------------------------------------------------------------
+// WAIT for a metadata key
+// ------------------------------------------------------------------------------------------------
+
+// WaitForMetadataKeyByHref waits until the given resource reference has the given metadata key with the expected value.
+// See waitForMetadataKey for details.
+func (vcdClient *VCDClient) WaitForMetadataKeyByHref(href, key, expectedValue string, isSystem bool, timeout, pollInterval time.Duration) error {
+	return waitForMetadataKey(context.Background(), &vcdClient.Client, href, key, expectedValue, isSystem, timeout, pollInterval)
+}
+
+// WaitForMetadataKey waits until the receiver VM has the given metadata key with the expected value, which is useful
+// when the metadata is set by an out-of-band process. See waitForMetadataKey for details.
+func (vm *VM) WaitForMetadataKey(key, expectedValue string, isSystem bool, timeout, pollInterval time.Duration) error {
+	return waitForMetadataKey(context.Background(), vm.client, vm.VM.HREF, key, expectedValue, isSystem, timeout, pollInterval)
+}
+
+// WaitForMetadataKeyCtx is the same as VM.WaitForMetadataKey, but it stops waiting as soon as the given context is
+// done, returning ctx.Err().
+func (vm *VM) WaitForMetadataKeyCtx(ctx context.Context, key, expectedValue string, isSystem bool, timeout, pollInterval time.Duration) error {
+	return waitForMetadataKey(ctx, vm.client, vm.VM.HREF, key, expectedValue, isSystem, timeout, pollInterval)
 }
 
 // ------------------------------------------------------------------------------------------------
@@ -123,9 +575,17 @@ func (vcdClient *VCDClient) GetMetadataByHref(href string) (*types.Metadata, err
 	return getMetadata(&vcdClient.Client, href)
 }
 
-// GetMetadata returns VM metadata.
+// GetMetadata returns VM metadata of both the SYSTEM and the GENERAL domains in a single request, as the metadata
+// endpoint returns the entries of both domains that the caller can see, depending on its privileges:
+//   - System administrators see all the entries.
+//   - Any other user sees the GENERAL entries and the SYSTEM entries with types.MetadataReadOnlyVisibility, while the
+//     SYSTEM entries with types.MetadataHiddenVisibility are left out without any error.
+//
+// The domain of each entry can be checked with FilterByDomain.
+// If the metadata cache is enabled with VM.EnableMetadataCache, the metadata is only retrieved from VCD when the cache
+// is empty or stale.
 func (vm *VM) GetMetadata() (*types.Metadata, error) {
-	return getMetadata(vm.client, vm.VM.HREF)
+	return vm.GetMetadataCtx(context.Background())
 }
 
 // GetMetadata returns VDC metadata.
@@ -185,8 +645,14 @@ func (adminOrg *AdminOrg) GetMetadata() (*types.Metadata, error) {
 }
 
 // GetMetadata returns the metadata of the corresponding independent disk
+// NOTE: If Client.OpenApiDiskMetadata is set, the disk has an OpenAPI ID and the negotiated API version is 38.0+
+// (VCD 10.5+), the OpenAPI metadata endpoint is used, so it doesn't depend on the XML HREF of the disk.
 func (disk *Disk) GetMetadata() (*types.Metadata, error) {
-	return getMetadata(disk.client, disk.Disk.HREF)
+	response, err := metadataRequest(disk.client, disk.metadataRequestOptions(metadataOperationGet))
+	if err != nil {
+		return nil, err
+	}
+	return response.metadata, nil
 }
 
 // GetMetadata returns OrgVDCNetwork metadata.
@@ -200,671 +666,6396 @@ func (catalogItem *CatalogItem) GetMetadata() (*types.Metadata, error) {
 }
 
 // GetMetadata returns OpenApiOrgVdcNetwork metadata.
-// NOTE: This function cannot retrieve metadata if the network belongs to a VDC Group.
-// TODO: This function is currently using XML API underneath as OpenAPI metadata is still not supported.
+// NOTE: If the network belongs to a VDC Group, the OpenAPI metadata endpoint is used, which requires VCD 10.5+.
+// Otherwise, the XML API is used.
 func (openApiOrgVdcNetwork *OpenApiOrgVdcNetwork) GetMetadata() (*types.Metadata, error) {
-	href := fmt.Sprintf("%s/network/%s", openApiOrgVdcNetwork.client.VCDHREF.String(), extractUuid(openApiOrgVdcNetwork.OpenApiOrgVdcNetwork.ID))
-	return getMetadata(openApiOrgVdcNetwork.client, href)
+	response, err := metadataRequest(openApiOrgVdcNetwork.client, openApiOrgVdcNetwork.metadataRequestOptions(metadataOperationGet, false))
+	if err != nil {
+		return nil, err
+	}
+	return response.metadata, nil
 }
 
-// ------------------------------------------------------------------------------------------------
-// ADD metadata async
-// ------------------------------------------------------------------------------------------------
+// GetMetadata returns NSX-T ALB Service Engine Group metadata.
+// NOTE: The OpenAPI metadata endpoint is used, which requires VCD 10.5+. A *MetadataNotSupportedError is returned
+// for older versions.
+// Note: Requires system administrator privileges.
+func (nsxtAlbServiceEngineGroup *NsxtAlbServiceEngineGroup) GetMetadata() (*types.Metadata, error) {
+	return getOpenApiMetadata(&nsxtAlbServiceEngineGroup.vcdClient.Client, types.OpenApiEndpointAlbServiceEngineGroupsMetadata, nsxtAlbServiceEngineGroup.NsxtAlbServiceEngineGroup.ID, nil)
+}
 
-// AddMetadataEntryWithVisibilityByHrefAsync adds metadata to the given resource reference with the given key, value, type and visibility
-// and returns the task.
-func (vcdClient *VCDClient) AddMetadataEntryWithVisibilityByHrefAsync(href, key, value, typedValue, visibility string, isSystem bool) (Task, error) {
-	return addMetadata(&vcdClient.Client, href, key, value, typedValue, visibility, isSystem)
+// GetSubscriptionMetadata is not supported, as VCD doesn't expose metadata for the subscription and
+// synchronization settings of a Catalog. It always returns a *MetadataNotSupportedError.
+// The metadata of the subscribed Catalog itself can be retrieved with AdminCatalog.GetMetadata.
+func (adminCatalog *AdminCatalog) GetSubscriptionMetadata() (*types.Metadata, error) {
+	return nil, catalogSubscriptionMetadataNotSupported()
 }
 
-// AddMetadataEntryWithVisibilityAsync adds metadata to the given VM with the given key, value, type and visibility
-// // and returns the task.
-func (vm *VM) AddMetadataEntryWithVisibilityAsync(key, value, typedValue, visibility string, isSystem bool) (Task, error) {
-	return addMetadata(vm.client, vm.VM.HREF, key, value, typedValue, visibility, isSystem)
+// GetVmSizingMetadata is not supported, as VCD doesn't expose metadata for the VM sizing configuration (CPU,
+// memory and their reservations) of a VDC Compute Policy. It always returns a *MetadataNotSupportedError.
+// The metadata of the VDC Compute Policy itself can be retrieved with VdcComputePolicyV2.GetMetadata.
+func (vdcComputePolicy *VdcComputePolicyV2) GetVmSizingMetadata() (*types.Metadata, error) {
+	return nil, vmSizingMetadataNotSupported()
 }
 
-// AddMetadataEntryWithVisibilityAsync adds metadata to the given AdminVdc with the given key, value, type and visibility
-// and returns the task.
-func (adminVdc *AdminVdc) AddMetadataEntryWithVisibilityAsync(key, value, typedValue, visibility string, isSystem bool) (Task, error) {
-	return addMetadata(adminVdc.client, adminVdc.AdminVdc.HREF, key, value, typedValue, visibility, isSystem)
+// GetMetadata is not supported, as VCD doesn't expose metadata for NSX-T Edge Gateway NAT rules.
+// It always returns a *MetadataNotSupportedError.
+func (nsxtNatRule *NsxtNatRule) GetMetadata() (*types.Metadata, error) {
+	return nil, nsxtNatRuleMetadataNotSupported()
 }
 
-// AddMetadataEntryWithVisibilityAsync adds metadata to the given ProviderVdc with the given key, value, type and visibility
-// and returns the task.
-// Note: Requires system administrator privileges.
-func (providerVdc *ProviderVdc) AddMetadataEntryWithVisibilityAsync(key, value, typedValue, visibility string, isSystem bool) (Task, error) {
-	return addMetadata(providerVdc.client, providerVdc.ProviderVdc.HREF, key, value, typedValue, visibility, isSystem)
+// GetMetadata is not supported, as VCD doesn't expose metadata for NSX-T ALB Controllers.
+// It always returns a *MetadataNotSupportedError.
+func (nsxtAlbController *NsxtAlbController) GetMetadata() (*types.Metadata, error) {
+	return nil, nsxtAlbControllerMetadataNotSupported()
 }
 
-// AddMetadataEntryWithVisibilityAsync adds metadata to the given VApp with the given key, value, type and visibility
-// and returns the task.
-func (vapp *VApp) AddMetadataEntryWithVisibilityAsync(key, value, typedValue, visibility string, isSystem bool) (Task, error) {
-	return addMetadata(vapp.client, vapp.VApp.HREF, key, value, typedValue, visibility, isSystem)
+// GetMetadata is not supported, as VCD doesn't expose metadata for NSX-T ALB Clouds.
+// It always returns a *MetadataNotSupportedError.
+func (nsxtAlbCloud *NsxtAlbCloud) GetMetadata() (*types.Metadata, error) {
+	return nil, nsxtAlbCloudMetadataNotSupported()
 }
 
-// AddMetadataEntryWithVisibilityAsync adds metadata to the given VAppTemplate with the given key, value, type and visibility
-// and returns the task.
-func (vAppTemplate *VAppTemplate) AddMetadataEntryWithVisibilityAsync(key, value, typedValue, visibility string, isSystem bool) (Task, error) {
-	return addMetadata(vAppTemplate.client, vAppTemplate.VAppTemplate.HREF, key, value, typedValue, visibility, isSystem)
+// GetRoleAssignmentMetadata is not supported, as VCD doesn't expose metadata for the role assigned to an Org user.
+// It always returns a *MetadataNotSupportedError.
+func (orgUser *OrgUser) GetRoleAssignmentMetadata() (*types.Metadata, error) {
+	return nil, orgUserRoleAssignmentMetadataNotSupported()
 }
 
-// AddMetadataEntryWithVisibilityAsync adds metadata to the given MediaRecord with the given key, value, type and visibility
-// and returns the task.
-func (mediaRecord *MediaRecord) AddMetadataEntryWithVisibilityAsync(key, value, typedValue, visibility string, isSystem bool) (Task, error) {
-	return addMetadata(mediaRecord.client, mediaRecord.MediaRecord.HREF, key, value, typedValue, visibility, isSystem)
+// GetMetadata is not supported, as VCD doesn't expose metadata for VM affinity rules.
+// It always returns a *MetadataNotSupportedError.
+func (vmAffinityRule *VmAffinityRule) GetMetadata() (*types.Metadata, error) {
+	return nil, vmAffinityRuleMetadataNotSupported()
 }
 
-// AddMetadataEntryWithVisibilityAsync adds metadata to the given Media with the given key, value, type and visibility
-// and returns the task.
-func (media *Media) AddMetadataEntryWithVisibilityAsync(key, value, typedValue, visibility string, isSystem bool) (Task, error) {
-	return addMetadata(media.client, media.Media.HREF, key, value, typedValue, visibility, isSystem)
+// GetRoleMetadata is not supported, as VCD doesn't expose metadata for roles.
+// It always returns a *MetadataNotSupportedError.
+func (role *Role) GetRoleMetadata() (*types.Metadata, error) {
+	return nil, roleMetadataNotSupported()
 }
 
-// AddMetadataEntryWithVisibilityAsync adds metadata to the given AdminCatalog with the given key, value, type and visibility
-// and returns the task.
-func (adminCatalog *AdminCatalog) AddMetadataEntryWithVisibilityAsync(key, value, typedValue, visibility string, isSystem bool) (Task, error) {
-	return addMetadata(adminCatalog.client, adminCatalog.AdminCatalog.HREF, key, value, typedValue, visibility, isSystem)
+// GetGlobalRoleMetadata is not supported, as VCD doesn't expose metadata for global roles.
+// It always returns a *MetadataNotSupportedError.
+func (globalRole *GlobalRole) GetGlobalRoleMetadata() (*types.Metadata, error) {
+	return nil, globalRoleMetadataNotSupported()
 }
 
-// AddMetadataEntryWithVisibilityAsync adds metadata to the given AdminOrg with the given key, value, type and visibility
-// and returns the task.
-func (adminOrg *AdminOrg) AddMetadataEntryWithVisibilityAsync(key, value, typedValue, visibility string, isSystem bool) (Task, error) {
-	return addMetadata(adminOrg.client, adminOrg.AdminOrg.HREF, key, value, typedValue, visibility, isSystem)
+// GetStorageProfileMetadata returns the metadata of the storage profile with the given HREF.
+// The storage profile can be a VDC storage profile or a Provider VDC storage profile.
+// Note: Requires system administrator privileges for Provider VDC storage profiles.
+func (vcdClient *VCDClient) GetStorageProfileMetadata(storageProfileHref string) (*types.Metadata, error) {
+	return getMetadata(&vcdClient.Client, storageProfileHref)
 }
 
-// AddMetadataEntryWithVisibilityAsync adds metadata to the given Disk with the given key, value, type and visibility
-// and returns the task.
-func (disk *Disk) AddMetadataEntryWithVisibilityAsync(key, value, typedValue, visibility string, isSystem bool) (Task, error) {
-	return addMetadata(disk.client, disk.Disk.HREF, key, value, typedValue, visibility, isSystem)
+// GetNetworkPoolMetadata is not supported, as VCD doesn't expose metadata for network pools.
+// It always returns a *MetadataNotSupportedError.
+func (vcdClient *VCDClient) GetNetworkPoolMetadata(networkPoolHref string) (*types.Metadata, error) {
+	return nil, networkPoolMetadataNotSupported()
 }
 
-// AddMetadataEntryWithVisibilityAsync adds metadata to the given OrgVDCNetwork with the given key, value, type and visibility
-// and returns the task.
-// Note: Requires system administrator privileges.
-func (orgVdcNetwork *OrgVDCNetwork) AddMetadataEntryWithVisibilityAsync(key, value, typedValue, visibility string, isSystem bool) (Task, error) {
-	return addMetadata(orgVdcNetwork.client, getAdminURL(orgVdcNetwork.OrgVDCNetwork.HREF), key, value, typedValue, visibility, isSystem)
+// GetNsxtManagerMetadata is not supported, as VCD doesn't expose metadata for NSX-T Managers.
+// It always returns a *MetadataNotSupportedError.
+func (vcdClient *VCDClient) GetNsxtManagerMetadata(nsxtManagerHref string) (*types.Metadata, error) {
+	return nil, nsxtManagerMetadataNotSupported()
 }
 
-// AddMetadataEntryWithVisibilityAsync adds metadata to the given Catalog Item with the given key, value, type and visibility
-// and returns the task.
-func (catalogItem *CatalogItem) AddMetadataEntryWithVisibilityAsync(key, value, typedValue, visibility string, isSystem bool) (Task, error) {
-	return addMetadata(catalogItem.client, catalogItem.CatalogItem.HREF, key, value, typedValue, visibility, isSystem)
+// GetVAppSnapshotMetadata is not supported, as VCD doesn't expose metadata for vApp snapshots.
+// It always returns a *MetadataNotSupportedError.
+func (vcdClient *VCDClient) GetVAppSnapshotMetadata(snapshotHref string) (*types.Metadata, error) {
+	return nil, vAppSnapshotMetadataNotSupported()
+}
+
+// GetUIPluginMetadata is not supported, as VCD doesn't expose metadata for UI plugins.
+// It always returns a *MetadataNotSupportedError.
+func (vcdClient *VCDClient) GetUIPluginMetadata(pluginId string) (*types.Metadata, error) {
+	return nil, uiPluginMetadataNotSupported()
 }
 
 // ------------------------------------------------------------------------------------------------
-// ADD metadata
+// CACHE metadata reads
 // ------------------------------------------------------------------------------------------------
 
-// AddMetadataEntryWithVisibilityByHref adds metadata to the given resource reference with the given key, value, type and visibility
-// and waits for completion.
-func (vcdClient *VCDClient) AddMetadataEntryWithVisibilityByHref(href, key, value, typedValue, visibility string, isSystem bool) error {
-	task, err := vcdClient.AddMetadataEntryWithVisibilityByHrefAsync(href, key, value, typedValue, visibility, isSystem)
-	if err != nil {
-		return err
+// EnableMetadataCache makes the receiver VM keep its metadata after the first read, so calling VM.GetMetadata or any
+// other method that reads metadata several times, like in a reconciliation loop, only sends one request. The cache is
+// disabled by default. The methods that need the latest value from VCD, like VM.WaitForMetadataKey, or the raw
+// response, like VM.GetMetadataStrict, don't use it.
+// The cache becomes stale once a metadata write of the VM is sent by any metadata function of the SDK, whatever the
+// instance or the function used, and again when the functions that wait for the task see it finish. Changes done by
+// anybody else in VCD are not detected: call VM.InvalidateMetadataCache to read them.
+// NOTE: The cache is not thread-safe. A VM instance with the cache enabled must not be used by several goroutines at
+// the same time.
+// Call VM.DisableMetadataCache once the cache is no longer needed, so the writes of the VM stop being tracked.
+func (vm *VM) EnableMetadataCache() {
+	if !vm.metadataCacheEnabled {
+		registerMetadataCache(vm.VM.HREF)
 	}
-	return task.WaitTaskCompletion()
+	vm.metadataCacheEnabled = true
 }
 
-// AddMetadataEntryWithVisibility adds metadata to the receiver VM and waits for the task to finish.
-func (vm *VM) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
-	return addMetadataAndWait(vm.client, vm.VM.HREF, key, value, typedValue, visibility, isSystem)
+// DisableMetadataCache disables the metadata cache of the receiver VM and discards its content, so the metadata
+// methods send a request every time again
+func (vm *VM) DisableMetadataCache() {
+	if vm.metadataCacheEnabled {
+		unregisterMetadataCache(vm.VM.HREF)
+	}
+	vm.metadataCacheEnabled = false
+	vm.metadataCache = nil
 }
 
-// AddMetadataEntryWithVisibility adds metadata to the receiver AdminVdc and waits for the task to finish.
-func (adminVdc *AdminVdc) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
-	return addMetadataAndWait(adminVdc.client, adminVdc.AdminVdc.HREF, key, value, typedValue, visibility, isSystem)
+// InvalidateMetadataCache discards the cached metadata of the receiver VM, so the next read retrieves it from VCD.
+// It does nothing if the cache is disabled.
+func (vm *VM) InvalidateMetadataCache() {
+	vm.metadataCache = nil
 }
 
-// AddMetadataEntryWithVisibility adds metadata to the receiver ProviderVdc and waits for the task to finish.
-// Note: Requires system administrator privileges.
-func (providerVdc *ProviderVdc) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
-	return addMetadataAndWait(providerVdc.client, providerVdc.ProviderVdc.HREF, key, value, typedValue, visibility, isSystem)
+// cachedMetadata returns a copy of the metadata of the receiver VM from its cache, retrieving it from VCD when the
+// cache is empty or when a metadata write of the VM was recorded after it was filled (see recordMetadataWrite).
+// It must only be called when the cache is enabled.
+func (vm *VM) cachedMetadata(ctx context.Context) (*types.Metadata, error) {
+	// The generation is read before the request, so a write sent while it runs makes the new content stale
+	generation := metadataWriteGeneration(vm.VM.HREF)
+	if vm.metadataCache == nil || vm.metadataCacheGeneration != generation {
+		metadata, err := getMetadataWithContext(ctx, vm.client, vm.VM.HREF)
+		if err != nil {
+			return nil, err
+		}
+		vm.metadataCache, vm.metadataCacheGeneration = metadata, generation
+	}
+	return copyMetadata(vm.metadataCache), nil
 }
 
-// AddMetadataEntryWithVisibility adds metadata to the receiver VApp and waits for the task to finish.
-func (vapp *VApp) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
-	return addMetadataAndWait(vapp.client, vapp.VApp.HREF, key, value, typedValue, visibility, isSystem)
+// cachedMetadataByKey returns the value of the given key and domain from the metadata cache of the receiver VM, or a
+// *MetadataKeyNotFoundError if it is not present, as the request to retrieve a single key does.
+// It must only be called when the cache is enabled.
+func (vm *VM) cachedMetadataByKey(ctx context.Context, key string, isSystem bool) (*types.MetadataValue, error) {
+	metadata, err := vm.cachedMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entry := findMetadataEntry(metadata, key, isSystem)
+	if entry == nil {
+		return nil, &MetadataKeyNotFoundError{Key: key, Err: fmt.Errorf("not present in domain %s of the cached metadata of %s", metadataDomainName(isSystem), vm.VM.HREF)}
+	}
+	return &types.MetadataValue{Domain: entry.Domain, TypedValue: entry.TypedValue}, nil
 }
 
-// AddMetadataEntryWithVisibility adds metadata to the receiver VAppTemplate and waits for the task to finish.
-func (vAppTemplate *VAppTemplate) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
-	return addMetadataAndWait(vAppTemplate.client, vAppTemplate.VAppTemplate.HREF, key, value, typedValue, visibility, isSystem)
+// metadataCacheTracking holds the number of enabled metadata caches of an entity and the number of metadata writes
+// recorded for it while any of them is enabled
+type metadataCacheTracking struct {
+	caches     int
+	generation uint64
 }
 
-// AddMetadataEntryWithVisibility adds metadata to the receiver MediaRecord and waits for the task to finish.
-func (mediaRecord *MediaRecord) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
-	return addMetadataAndWait(mediaRecord.client, mediaRecord.MediaRecord.HREF, key, value, typedValue, visibility, isSystem)
-}
+// metadataCacheTrackings contains, per entity HREF, the tracking of the entities with at least one enabled metadata
+// cache, so the caches can tell whether their content is stale. Entities without enabled caches are not tracked.
+var (
+	metadataCacheTrackings      = map[string]*metadataCacheTracking{}
+	metadataCacheTrackingsMutex sync.Mutex
+)
 
-// AddMetadataEntryWithVisibility adds metadata to the receiver Media and waits for the task to finish.
-func (media *Media) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
-	return addMetadataAndWait(media.client, media.Media.HREF, key, value, typedValue, visibility, isSystem)
+// registerMetadataCache starts tracking the metadata writes of the entity with the given HREF for a newly enabled
+// metadata cache
+func registerMetadataCache(href string) {
+	metadataCacheTrackingsMutex.Lock()
+	defer metadataCacheTrackingsMutex.Unlock()
+	tracking, ok := metadataCacheTrackings[href]
+	if !ok {
+		tracking = &metadataCacheTracking{}
+		metadataCacheTrackings[href] = tracking
+	}
+	tracking.caches++
 }
 
-// AddMetadataEntryWithVisibility adds metadata to the receiver AdminCatalog and waits for the task to finish.
-func (adminCatalog *AdminCatalog) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
-	return addMetadataAndWait(adminCatalog.client, adminCatalog.AdminCatalog.HREF, key, value, typedValue, visibility, isSystem)
+// unregisterMetadataCache stops tracking the metadata writes of the entity with the given HREF for a disabled metadata
+// cache, forgetting the entity once it has no enabled caches
+func unregisterMetadataCache(href string) {
+	metadataCacheTrackingsMutex.Lock()
+	defer metadataCacheTrackingsMutex.Unlock()
+	tracking, ok := metadataCacheTrackings[href]
+	if !ok {
+		return
+	}
+	tracking.caches--
+	if tracking.caches <= 0 {
+		delete(metadataCacheTrackings, href)
+	}
 }
 
-// AddMetadataEntryWithVisibility adds metadata to the receiver AdminOrg and waits for the task to finish.
-func (adminOrg *AdminOrg) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
-	return addMetadataAndWait(adminOrg.client, adminOrg.AdminOrg.HREF, key, value, typedValue, visibility, isSystem)
+// recordMetadataWrite records that the metadata of the entity with the given HREF was modified, making stale any
+// metadata cache of the entity filled before. It is called by executeMetadataWriteRequest when a write is sent, and by
+// the functions that notify the metadata changes once the task has finished. It does nothing if the entity doesn't
+// have any enabled metadata cache.
+func recordMetadataWrite(href string) {
+	metadataCacheTrackingsMutex.Lock()
+	defer metadataCacheTrackingsMutex.Unlock()
+	if tracking, ok := metadataCacheTrackings[href]; ok {
+		tracking.generation++
+	}
 }
 
-// AddMetadataEntryWithVisibility adds metadata to the receiver Disk and waits for the task to finish.
-func (disk *Disk) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
-	return addMetadataAndWait(disk.client, disk.Disk.HREF, key, value, typedValue, visibility, isSystem)
+// metadataWriteGeneration returns the number of metadata writes recorded for the entity with the given HREF while it
+// had enabled metadata caches
+func metadataWriteGeneration(href string) uint64 {
+	metadataCacheTrackingsMutex.Lock()
+	defer metadataCacheTrackingsMutex.Unlock()
+	if tracking, ok := metadataCacheTrackings[href]; ok {
+		return tracking.generation
+	}
+	return 0
 }
 
-// AddMetadataEntryWithVisibility adds metadata to the receiver OrgVDCNetwork and waits for the task to finish.
-// Note: Requires system administrator privileges.
-func (orgVdcNetwork *OrgVDCNetwork) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
-	return addMetadataAndWait(orgVdcNetwork.client, getAdminURL(orgVdcNetwork.OrgVDCNetwork.HREF), key, value, typedValue, visibility, isSystem)
+// ------------------------------------------------------------------------------------------------
+// GET metadata of a single domain
+// ------------------------------------------------------------------------------------------------
+
+// GetSystemMetadataByHref returns only the SYSTEM domain metadata of the given resource reference.
+// See VM.GetSystemMetadata for details.
+func (vcdClient *VCDClient) GetSystemMetadataByHref(href string) (*types.Metadata, error) {
+	return getSystemMetadata(&vcdClient.Client, href)
 }
 
-// AddMetadataEntryWithVisibility adds metadata to the receiver CatalogItem and waits for the task to finish.
-func (catalogItem *CatalogItem) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
-	return addMetadataAndWait(catalogItem.client, catalogItem.CatalogItem.HREF, key, value, typedValue, visibility, isSystem)
+// GetGeneralMetadataByHref returns only the GENERAL domain metadata of the given resource reference. Unlike
+// GetSystemMetadataByHref, it downloads the whole metadata. See VM.GetGeneralMetadata for details.
+func (vcdClient *VCDClient) GetGeneralMetadataByHref(href string) (*types.Metadata, error) {
+	return getGeneralMetadata(&vcdClient.Client, href)
 }
 
-// AddMetadataEntryWithVisibility adds metadata to the receiver OpenApiOrgVdcNetwork and waits for the task to finish.
-// Note: It doesn't add metadata to networks that belong to a VDC Group.
-// TODO: This function is currently using XML API underneath as OpenAPI metadata is still not supported.
-func (openApiOrgVdcNetwork *OpenApiOrgVdcNetwork) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
-	href := fmt.Sprintf("%s/admin/network/%s", openApiOrgVdcNetwork.client.VCDHREF.String(), extractUuid(openApiOrgVdcNetwork.OpenApiOrgVdcNetwork.ID))
-	task, err := addMetadata(openApiOrgVdcNetwork.client, href, key, value, typedValue, visibility, isSystem)
-	if err != nil {
-		return err
-	}
-	return task.WaitTaskCompletion()
+// GetSystemMetadata returns only the SYSTEM domain metadata of the receiver VM, requesting the SYSTEM domain path so
+// that VCD doesn't send the GENERAL entries. The entries returned depend on the privileges of the caller:
+//   - System administrators see all the SYSTEM entries.
+//   - Any other user only sees the SYSTEM entries with types.MetadataReadOnlyVisibility, while the ones with
+//     types.MetadataHiddenVisibility are left out without any error.
+//
+// If the metadata cache is enabled with VM.EnableMetadataCache, the SYSTEM entries are taken from the cache, as in
+// VM.GetMetadata, and the SYSTEM domain path is not requested.
+func (vm *VM) GetSystemMetadata() (*types.Metadata, error) {
+	return vm.GetSystemMetadataCtx(context.Background())
+}
+
+// GetGeneralMetadata returns only the GENERAL domain metadata of the receiver VM, which any user that can see the VM
+// can read. It is not the counterpart of VM.GetSystemMetadata: the GENERAL domain doesn't have a path of its own, so
+// the whole metadata is downloaded, as in VM.GetMetadata, and the SYSTEM entries are filtered out of it. It saves no
+// payload, and is only a shortcut for FilterMetadata with FilterByDomain(false).
+// If the metadata cache is enabled with VM.EnableMetadataCache, it is used as in VM.GetMetadata.
+func (vm *VM) GetGeneralMetadata() (*types.Metadata, error) {
+	return vm.GetGeneralMetadataCtx(context.Background())
 }
 
 // ------------------------------------------------------------------------------------------------
-// MERGE metadata async
+// COUNT metadata entries
 // ------------------------------------------------------------------------------------------------
 
-// MergeMetadataWithVisibilityByHrefAsync updates the metadata entries present in the referenced entity and creates the ones not present, then
-// returns the task.
-func (vcdClient *VCDClient) MergeMetadataWithVisibilityByHrefAsync(href string, metadata map[string]types.MetadataValue) (Task, error) {
-	return mergeAllMetadata(&vcdClient.Client, href, metadata)
+// CountMetadataByHref returns the number of metadata entries of the given resource reference in the SYSTEM domain if
+// isSystem=true, or in the GENERAL domain otherwise. See countMetadata for details.
+func (vcdClient *VCDClient) CountMetadataByHref(href string, isSystem bool) (int, error) {
+	return countMetadata(&vcdClient.Client, href, isSystem)
 }
 
-// MergeMetadataWithMetadataValuesAsync merges VM metadata provided as a key-value map of type `typedValue` with the already present in VCD,
-// then returns the task.
-func (vm *VM) MergeMetadataWithMetadataValuesAsync(metadata map[string]types.MetadataValue) (Task, error) {
-	return mergeAllMetadata(vm.client, vm.VM.HREF, metadata)
+// CountMetadata returns the number of metadata entries of the receiver VM in the SYSTEM domain if isSystem=true, or in
+// the GENERAL domain otherwise. See countMetadata for details.
+func (vm *VM) CountMetadata(isSystem bool) (int, error) {
+	metadata, err := vm.GetMetadata()
+	if err != nil {
+		return 0, err
+	}
+	return len(metadata.Keys(isSystem)), nil
 }
 
-// MergeMetadataWithMetadataValuesAsync merges AdminVdc metadata provided as a key-value map of type `typedValue` with the already present in VCD,
-// then waits for the task to complete.
-func (adminVdc *AdminVdc) MergeMetadataWithMetadataValuesAsync(metadata map[string]types.MetadataValue) (Task, error) {
-	return mergeAllMetadata(adminVdc.client, adminVdc.AdminVdc.HREF, metadata)
-}
+// ------------------------------------------------------------------------------------------------
+// GET metadata as a map
+// ------------------------------------------------------------------------------------------------
 
-// MergeMetadataWithMetadataValuesAsync merges Provider VDC metadata provided as a key-value map of type `typedValue` with the already present in VCD,
-// then waits for the task to complete.
-// Note: Requires system administrator privileges.
-func (providerVdc *ProviderVdc) MergeMetadataWithMetadataValuesAsync(metadata map[string]types.MetadataValue) (Task, error) {
-	return mergeAllMetadata(providerVdc.client, providerVdc.ProviderVdc.HREF, metadata)
+// GetMetadataAsMapByHref returns the metadata of the given resource reference as a map of key to value.
+// See getMetadataAsMap for details.
+func (vcdClient *VCDClient) GetMetadataAsMapByHref(href string, isSystem bool) (map[string]string, error) {
+	return getMetadataAsMap(&vcdClient.Client, href, isSystem)
 }
 
-// MergeMetadataWithMetadataValuesAsync merges VApp metadata provided as a key-value map of type `typedValue` with the already present in VCD,
-// then waits for the task to complete.
-func (vapp *VApp) MergeMetadataWithMetadataValuesAsync(metadata map[string]types.MetadataValue) (Task, error) {
-	return mergeAllMetadata(vapp.client, vapp.VApp.HREF, metadata)
+// GetMetadataAsMap returns VM metadata as a map of key to value. See getMetadataAsMap for details.
+func (vm *VM) GetMetadataAsMap(isSystem bool) (map[string]string, error) {
+	metadata, err := vm.GetMetadata()
+	if err != nil {
+		return nil, err
+	}
+	return metadataAsMap(metadata, isSystem), nil
 }
 
-// MergeMetadataWithMetadataValuesAsync merges VAppTemplate metadata provided as a key-value map of type `typedValue` with the already present in VCD,
-// then waits for the task to complete.
-func (vAppTemplate *VAppTemplate) MergeMetadataWithMetadataValuesAsync(metadata map[string]types.MetadataValue) (Task, error) {
-	return mergeAllMetadata(vAppTemplate.client, vAppTemplate.VAppTemplate.HREF, metadata)
+// GetMetadataAsMap returns VDC metadata as a map of key to value. See getMetadataAsMap for details.
+func (vdc *Vdc) GetMetadataAsMap(isSystem bool) (map[string]string, error) {
+	return getMetadataAsMap(vdc.client, vdc.Vdc.HREF, isSystem)
 }
 
-// MergeMetadataWithMetadataValuesAsync merges MediaRecord metadata provided as a key-value map of type `typedValue` with the already present in VCD,
-// then waits for the task to complete.
-func (mediaRecord *MediaRecord) MergeMetadataWithMetadataValuesAsync(metadata map[string]types.MetadataValue) (Task, error) {
-	return mergeAllMetadata(mediaRecord.client, mediaRecord.MediaRecord.HREF, metadata)
+// GetMetadataAsMap returns AdminVdc metadata as a map of key to value. See getMetadataAsMap for details.
+func (adminVdc *AdminVdc) GetMetadataAsMap(isSystem bool) (map[string]string, error) {
+	return getMetadataAsMap(adminVdc.client, adminVdc.AdminVdc.HREF, isSystem)
 }
 
-// MergeMetadataWithMetadataValuesAsync merges Media metadata provided as a key-value map of type `typedValue` with the already present in VCD,
-// then waits for the task to complete.
-func (media *Media) MergeMetadataWithMetadataValuesAsync(metadata map[string]types.MetadataValue) (Task, error) {
-	return mergeAllMetadata(media.client, media.Media.HREF, metadata)
+// GetMetadataAsMap returns ProviderVdc metadata as a map of key to value. See getMetadataAsMap for details.
+// Note: Requires system administrator privileges.
+func (providerVdc *ProviderVdc) GetMetadataAsMap(isSystem bool) (map[string]string, error) {
+	return getMetadataAsMap(providerVdc.client, providerVdc.ProviderVdc.HREF, isSystem)
 }
 
-// MergeMetadataWithMetadataValuesAsync merges AdminCatalog metadata provided as a key-value map of type `typedValue` with the already present in VCD,
-// then waits for the task to complete.
-func (adminCatalog *AdminCatalog) MergeMetadataWithMetadataValuesAsync(metadata map[string]types.MetadataValue) (Task, error) {
-	return mergeAllMetadata(adminCatalog.client, adminCatalog.AdminCatalog.HREF, metadata)
+// GetMetadataAsMap returns VApp metadata as a map of key to value. See getMetadataAsMap for details.
+func (vapp *VApp) GetMetadataAsMap(isSystem bool) (map[string]string, error) {
+	return getMetadataAsMap(vapp.client, vapp.VApp.HREF, isSystem)
 }
 
-// MergeMetadataWithMetadataValuesAsync merges AdminOrg metadata provided as a key-value map of type `typedValue` with the already present in VCD,
-// then waits for the task to complete.
-func (adminOrg *AdminOrg) MergeMetadataWithMetadataValuesAsync(metadata map[string]types.MetadataValue) (Task, error) {
-	return mergeAllMetadata(adminOrg.client, adminOrg.AdminOrg.HREF, metadata)
+// GetMetadataAsMap returns VAppTemplate metadata as a map of key to value. See getMetadataAsMap for details.
+func (vAppTemplate *VAppTemplate) GetMetadataAsMap(isSystem bool) (map[string]string, error) {
+	return getMetadataAsMap(vAppTemplate.client, vAppTemplate.VAppTemplate.HREF, isSystem)
 }
 
-// MergeMetadataWithMetadataValuesAsync merges Disk metadata provided as a key-value map of type `typedValue` with the already present in VCD,
-// then waits for the task to complete.
-func (disk *Disk) MergeMetadataWithMetadataValuesAsync(metadata map[string]types.MetadataValue) (Task, error) {
-	return mergeAllMetadata(disk.client, disk.Disk.HREF, metadata)
+// GetMetadataAsMap returns MediaRecord metadata as a map of key to value. See getMetadataAsMap for details.
+func (mediaRecord *MediaRecord) GetMetadataAsMap(isSystem bool) (map[string]string, error) {
+	return getMetadataAsMap(mediaRecord.client, mediaRecord.MediaRecord.HREF, isSystem)
 }
 
-// MergeMetadataWithMetadataValuesAsync merges OrgVDCNetwork metadata provided as a key-value map of type `typedValue` with the already present in VCD,
-// then waits for the task to complete.
-// Note: Requires system administrator privileges.
-func (orgVdcNetwork *OrgVDCNetwork) MergeMetadataWithMetadataValuesAsync(metadata map[string]types.MetadataValue) (Task, error) {
-	return mergeAllMetadata(orgVdcNetwork.client, getAdminURL(orgVdcNetwork.OrgVDCNetwork.HREF), metadata)
+// GetMetadataAsMap returns Media metadata as a map of key to value. See getMetadataAsMap for details.
+func (media *Media) GetMetadataAsMap(isSystem bool) (map[string]string, error) {
+	return getMetadataAsMap(media.client, media.Media.HREF, isSystem)
 }
 
-// MergeMetadataWithMetadataValuesAsync merges CatalogItem metadata provided as a key-value map of type `typedValue` with the already present in VCD,
-// then waits for the task to complete.
-func (catalogItem *CatalogItem) MergeMetadataWithMetadataValuesAsync(metadata map[string]types.MetadataValue) (Task, error) {
-	return mergeAllMetadata(catalogItem.client, catalogItem.CatalogItem.HREF, metadata)
+// GetMetadataAsMap returns Catalog metadata as a map of key to value. See getMetadataAsMap for details.
+func (catalog *Catalog) GetMetadataAsMap(isSystem bool) (map[string]string, error) {
+	return getMetadataAsMap(catalog.client, catalog.Catalog.HREF, isSystem)
 }
 
-// ------------------------------------------------------------------------------------------------
-// MERGE metadata
-// ------------------------------------------------------------------------------------------------
-
-// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver VM and creates the ones not present.
-// The input metadata map has a "metadata key"->"metadata value" relation.
-// This function waits until merge finishes.
-func (vm *VM) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
-	return mergeMetadataAndWait(vm.client, vm.VM.HREF, metadata)
+// GetMetadataAsMap returns AdminCatalog metadata as a map of key to value. See getMetadataAsMap for details.
+func (adminCatalog *AdminCatalog) GetMetadataAsMap(isSystem bool) (map[string]string, error) {
+	return getMetadataAsMap(adminCatalog.client, adminCatalog.AdminCatalog.HREF, isSystem)
 }
 
-// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver AdminVdc and creates the ones not present.
-// The input metadata map has a "metadata key"->"metadata value" relation.
-// This function waits until merge finishes.
-func (adminVdc *AdminVdc) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
-	return mergeMetadataAndWait(adminVdc.client, adminVdc.AdminVdc.HREF, metadata)
+// GetMetadataAsMap returns Org metadata as a map of key to value. See getMetadataAsMap for details.
+func (org *Org) GetMetadataAsMap(isSystem bool) (map[string]string, error) {
+	return getMetadataAsMap(org.client, org.Org.HREF, isSystem)
 }
 
-// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver ProviderVdc and creates the ones not present.
-// The input metadata map has a "metadata key"->"metadata value" relation.
-// This function waits until merge finishes.
-// Note: Requires system administrator privileges.
-func (providerVdc *ProviderVdc) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
-	return mergeMetadataAndWait(providerVdc.client, providerVdc.ProviderVdc.HREF, metadata)
+// GetMetadataAsMap returns AdminOrg metadata as a map of key to value. See getMetadataAsMap for details.
+func (adminOrg *AdminOrg) GetMetadataAsMap(isSystem bool) (map[string]string, error) {
+	return getMetadataAsMap(adminOrg.client, adminOrg.AdminOrg.HREF, isSystem)
 }
 
-// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver VApp and creates the ones not present.
-// The input metadata map has a "metadata key"->"metadata value" relation.
-// This function waits until merge finishes.
-func (vApp *VApp) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
-	return mergeMetadataAndWait(vApp.client, vApp.VApp.HREF, metadata)
+// GetMetadataAsMap returns Disk metadata as a map of key to value. See getMetadataAsMap for details.
+// NOTE: Like GetMetadata, it uses the OpenAPI metadata endpoint when Client.OpenApiDiskMetadata is set.
+func (disk *Disk) GetMetadataAsMap(isSystem bool) (map[string]string, error) {
+	metadata, err := disk.GetMetadata()
+	if err != nil {
+		return nil, err
+	}
+	return metadataAsMap(metadata, isSystem), nil
 }
 
-// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver VAppTemplate and creates the ones not present.
-// The input metadata map has a "metadata key"->"metadata value" relation.
-// This function waits until merge finishes.
-func (vAppTemplate *VAppTemplate) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
-	return mergeMetadataAndWait(vAppTemplate.client, vAppTemplate.VAppTemplate.HREF, metadata)
+// GetMetadataAsMap returns OrgVDCNetwork metadata as a map of key to value. See getMetadataAsMap for details.
+func (orgVdcNetwork *OrgVDCNetwork) GetMetadataAsMap(isSystem bool) (map[string]string, error) {
+	return getMetadataAsMap(orgVdcNetwork.client, orgVdcNetwork.OrgVDCNetwork.HREF, isSystem)
 }
 
-// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver MediaRecord and creates the ones not present.
-// The input metadata map has a "metadata key"->"metadata value" relation.
-// This function waits until merge finishes.
-func (mediaRecord *MediaRecord) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
-	return mergeMetadataAndWait(mediaRecord.client, mediaRecord.MediaRecord.HREF, metadata)
+// GetMetadataAsMap returns CatalogItem metadata as a map of key to value. See getMetadataAsMap for details.
+func (catalogItem *CatalogItem) GetMetadataAsMap(isSystem bool) (map[string]string, error) {
+	return getMetadataAsMap(catalogItem.client, catalogItem.CatalogItem.HREF, isSystem)
 }
 
-// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver Media and creates the ones not present.
-// The input metadata map has a "metadata key"->"metadata value" relation.
-// This function waits until merge finishes.
-func (media *Media) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
-	return mergeMetadataAndWait(media.client, media.Media.HREF, metadata)
+// GetMetadataAsMap returns OpenApiOrgVdcNetwork metadata as a map of key to value. See getMetadataAsMap for details.
+// NOTE: If the network belongs to a VDC Group, the OpenAPI metadata endpoint is used, which requires VCD 10.5+.
+// Otherwise, the XML API is used.
+func (openApiOrgVdcNetwork *OpenApiOrgVdcNetwork) GetMetadataAsMap(isSystem bool) (map[string]string, error) {
+	metadata, err := openApiOrgVdcNetwork.GetMetadata()
+	if err != nil {
+		return nil, err
+	}
+	return metadataAsMap(metadata, isSystem), nil
 }
 
-// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver AdminCatalog and creates the ones not present.
-// The input metadata map has a "metadata key"->"metadata value" relation.
-// This function waits until merge finishes.
-func (adminCatalog *AdminCatalog) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
-	return mergeMetadataAndWait(adminCatalog.client, adminCatalog.AdminCatalog.HREF, metadata)
+// GetMetadataAsMap returns NSX-T ALB Service Engine Group metadata as a map of key to value. See getMetadataAsMap for
+// details.
+// NOTE: The OpenAPI metadata endpoint is used, which requires VCD 10.5+. A *MetadataNotSupportedError is returned
+// for older versions.
+// Note: Requires system administrator privileges.
+func (nsxtAlbServiceEngineGroup *NsxtAlbServiceEngineGroup) GetMetadataAsMap(isSystem bool) (map[string]string, error) {
+	metadata, err := nsxtAlbServiceEngineGroup.GetMetadata()
+	if err != nil {
+		return nil, err
+	}
+	return metadataAsMap(metadata, isSystem), nil
 }
 
-// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver AdminOrg and creates the ones not present.
-// The input metadata map has a "metadata key"->"metadata value" relation.
-// This function waits until merge finishes.
-func (adminOrg *AdminOrg) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
-	return mergeMetadataAndWait(adminOrg.client, adminOrg.AdminOrg.HREF, metadata)
-}
+// ------------------------------------------------------------------------------------------------
+// GET metadata with strict value validation
+// ------------------------------------------------------------------------------------------------
 
-// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver Disk and creates the ones not present.
-// The input metadata map has a "metadata key"->"metadata value" relation.
-// This function waits until merge finishes.
-func (disk *Disk) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
-	return mergeMetadataAndWait(disk.client, disk.Disk.HREF, metadata)
+// GetMetadataStrictByHref returns the metadata entries of the given domain from the given resource reference, checking
+// that every value can be converted to the type declared by its XsiType. If any value is malformed, it returns a
+// *MetadataValueParseError listing all the offending keys.
+func (vcdClient *VCDClient) GetMetadataStrictByHref(href string, isSystem bool) (*types.Metadata, error) {
+	return getMetadataStrict(&vcdClient.Client, href, isSystem)
 }
 
-// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver OrgVDCNetwork and creates the ones not present.
-// The input metadata map has a "metadata key"->"metadata value" relation.
-// This function waits until merge finishes.
-// Note: Requires system administrator privileges.
-func (orgVdcNetwork *OrgVDCNetwork) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
-	return mergeMetadataAndWait(orgVdcNetwork.client, getAdminURL(orgVdcNetwork.OrgVDCNetwork.HREF), metadata)
+// GetMetadataStrict returns the VM metadata entries of the given domain, checking that every value can be converted
+// to the type declared by its XsiType. If any value is malformed, it returns a *MetadataValueParseError listing all
+// the offending keys.
+func (vm *VM) GetMetadataStrict(isSystem bool) (*types.Metadata, error) {
+	return getMetadataStrict(vm.client, vm.VM.HREF, isSystem)
 }
 
-// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver CatalogItem and creates the ones not present.
-// The input metadata map has a "metadata key"->"metadata value" relation.
-// This function waits until merge finishes.
-func (catalogItem *CatalogItem) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
-	return mergeMetadataAndWait(catalogItem.client, catalogItem.CatalogItem.HREF, metadata)
+// ------------------------------------------------------------------------------------------------
+// GET metadata of a vApp and its children
+// ------------------------------------------------------------------------------------------------
+
+// VappMetadataTree contains the metadata of a vApp and of all its child VMs
+type VappMetadataTree struct {
+	VApp *types.Metadata            // Metadata of the vApp itself
+	VMs  map[string]*types.Metadata // Metadata of every child VM, indexed by VM name
 }
 
-// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver OpenApiOrgVdcNetwork and creates the ones not present.
-// The input metadata map has a "metadata key"->"metadata value" relation.
-// This function waits until merge finishes.
-// Note: It doesn't merge metadata to networks that belong to a VDC Group.
-// TODO: This function is currently using XML API underneath as OpenAPI metadata is still not supported.
-func (openApiOrgVdcNetwork *OpenApiOrgVdcNetwork) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
-	href := fmt.Sprintf("%s/admin/network/%s", openApiOrgVdcNetwork.client.VCDHREF.String(), extractUuid(openApiOrgVdcNetwork.OpenApiOrgVdcNetwork.ID))
-	task, err := mergeAllMetadata(openApiOrgVdcNetwork.client, href, metadata)
+// GetFullMetadataTree returns the metadata of the receiver vApp and all its child VMs. The VM metadata is retrieved
+// concurrently, with at most 'concurrency' simultaneous requests. A failure retrieving the metadata of a single VM
+// doesn't stop the others: the returned tree contains all the metadata that could be retrieved, and the error is a
+// *MetadataMultiError indexed by the name of the failing vApp or VMs.
+// VMs are indexed by name. VM names are not guaranteed to be unique within a vApp, so the metadata of VMs that share
+// their name with another one is not retrieved, and the name is reported in the *MetadataMultiError instead.
+func (vapp *VApp) GetFullMetadataTree(concurrency int) (VappMetadataTree, error) {
+	tree := VappMetadataTree{
+		VMs: map[string]*types.Metadata{},
+	}
+	multiError := newMetadataMultiError("retrieving vApp metadata tree")
+
+	metadata, err := getMetadata(vapp.client, vapp.VApp.HREF)
 	if err != nil {
-		return err
+		multiError.add(vapp.VApp.Name, err)
+	} else {
+		tree.VApp = metadata
+	}
+
+	var children []*types.Vm
+	if vapp.VApp.Children != nil {
+		children = vapp.VApp.Children.VM
+	}
+	vmHrefsByName := map[string][]string{}
+	for _, vm := range children {
+		vmHrefsByName[vm.Name] = append(vmHrefsByName[vm.Name], vm.HREF)
+	}
+	var vms []*types.Vm
+	for _, vm := range children {
+		hrefs := vmHrefsByName[vm.Name]
+		if len(hrefs) == 1 {
+			vms = append(vms, vm)
+			continue
+		}
+		multiError.add(vm.Name, fmt.Errorf("found %d VMs with the same name: %s", len(hrefs), strings.Join(hrefs, ", ")))
 	}
-	return task.WaitTaskCompletion()
+
+	var mutex sync.Mutex
+	runMetadataWorkers(len(vms), concurrency, func(index int) {
+		vmMetadata, err := getMetadata(vapp.client, vms[index].HREF)
+		mutex.Lock()
+		defer mutex.Unlock()
+		if err != nil {
+			multiError.add(vms[index].Name, err)
+			return
+		}
+		tree.VMs[vms[index].Name] = vmMetadata
+	})
+
+	return tree, multiError.errorOrNil()
 }
 
 // ------------------------------------------------------------------------------------------------
-// DELETE metadata async
+// GET metadata summary of several entities
 // ------------------------------------------------------------------------------------------------
 
-// DeleteMetadataEntryWithDomainByHrefAsync deletes metadata from the given resource reference, depending on key provided as input
-// and returns a task.
-func (vcdClient *VCDClient) DeleteMetadataEntryWithDomainByHrefAsync(href, key string, isSystem bool) (Task, error) {
-	return deleteMetadata(&vcdClient.Client, href, key, isSystem)
-}
+// MetadataAbsentBucket is the histogram bucket used by SummarizeMetadataKey to count the entities that don't have
+// the requested metadata key.
+const MetadataAbsentBucket = "<absent>"
+
+// SummarizeMetadataKey reads the metadata of all the given entities, with at most 'concurrency' simultaneous requests,
+// and returns a histogram that counts how many of them have each value of the given key in the given domain.
+// Entities that don't have the key are counted in the MetadataAbsentBucket bucket.
+// Entities whose metadata can't be read are not counted, and are reported in a *MetadataMultiError, indexed by
+// the entity HREF, that is returned alongside the histogram of the remaining entities.
+func SummarizeMetadataKey(entities []MetadataCompatible, key string, isSystem bool, concurrency int) (map[string]int, error) {
+	histogram := map[string]int{}
+	multiError := newMetadataMultiError(fmt.Sprintf("summarizing metadata key '%s'", key))
+
+	var mutex sync.Mutex
+	runMetadataWorkers(len(entities), concurrency, func(index int) {
+		metadata, err := entities[index].GetMetadata()
+		mutex.Lock()
+		defer mutex.Unlock()
+		if err != nil {
+			multiError.add(metadataEntityIdentifier(entities[index], index), err)
+			return
+		}
+		histogram[metadataValueOrAbsent(metadata, key, isSystem)]++
+	})
 
-// DeleteMetadataEntryWithDomainAsync deletes VM metadata associated to the input key and returns the task.
-func (vm *VM) DeleteMetadataEntryWithDomainAsync(key string, isSystem bool) (Task, error) {
-	return deleteMetadata(vm.client, vm.VM.HREF, key, isSystem)
+	return histogram, multiError.errorOrNil()
 }
 
-// DeleteMetadataEntryWithDomainAsync deletes AdminVdc metadata associated to the input key and returns the task.
-func (adminVdc *AdminVdc) DeleteMetadataEntryWithDomainAsync(key string, isSystem bool) (Task, error) {
-	return deleteMetadata(adminVdc.client, adminVdc.AdminVdc.HREF, key, isSystem)
-}
+// ------------------------------------------------------------------------------------------------
+// GET metadata key usage of a VDC
+// ------------------------------------------------------------------------------------------------
 
-// DeleteMetadataEntryWithDomainAsync deletes ProviderVdc metadata associated to the input key and returns the task.
-// Note: Requires system administrator privileges.
-func (providerVdc *ProviderVdc) DeleteMetadataEntryWithDomainAsync(key string, isSystem bool) (Task, error) {
-	return deleteMetadata(providerVdc.client, providerVdc.ProviderVdc.HREF, key, isSystem)
-}
+// metadataAggregateConcurrency is the maximum number of simultaneous requests that Vdc.AggregateMetadataKeys sends to
+// VCD to retrieve the metadata of the entities of the VDC
+const metadataAggregateConcurrency = 5
+
+// AggregateMetadataKeys returns every metadata key used by the VMs, vApps and networks of the receiver VDC, with the
+// number of entities that use it. A key present in both GENERAL and SYSTEM domains of the same entity is counted once.
+// The entities are listed with paginated queries, and their metadata is retrieved concurrently, with at most
+// metadataAggregateConcurrency simultaneous requests. A failure doesn't stop the aggregation: the result contains the
+// keys of all the entities whose metadata could be retrieved, and the failures are returned as a *MetadataMultiError,
+// indexed by entity HREF, or by entity kind when they couldn't be listed.
+func (vdc *Vdc) AggregateMetadataKeys() (map[string]int, error) {
+	keyUsage := map[string]int{}
+	multiError := newMetadataMultiError(fmt.Sprintf("aggregating metadata keys of VDC '%s'", vdc.Vdc.Name))
+
+	hrefs, listErrors := vdc.listMetadataAggregateEntities()
+	multiError.addAll(listErrors)
+
+	var mutex sync.Mutex
+	runMetadataWorkers(len(hrefs), metadataAggregateConcurrency, func(index int) {
+		metadata, err := getMetadata(vdc.client, hrefs[index])
+		mutex.Lock()
+		defer mutex.Unlock()
+		if err != nil {
+			multiError.add(hrefs[index], err)
+			return
+		}
+		keys := map[string]bool{}
+		for _, entry := range metadata.MetadataEntry {
+			if entry != nil {
+				keys[entry.Key] = true
+			}
+		}
+		for key := range keys {
+			keyUsage[key]++
+		}
+	})
 
-// DeleteMetadataEntryWithDomainAsync deletes VApp metadata associated to the input key and returns the task.
-func (vapp *VApp) DeleteMetadataEntryWithDomainAsync(key string, isSystem bool) (Task, error) {
-	return deleteMetadata(vapp.client, vapp.VApp.HREF, key, isSystem)
+	return keyUsage, multiError.errorOrNil()
 }
 
-// DeleteMetadataEntryWithDomainAsync deletes VAppTemplate metadata associated to the input key and returns the task.
-func (vAppTemplate *VAppTemplate) DeleteMetadataEntryWithDomainAsync(key string, isSystem bool) (Task, error) {
-	return deleteMetadata(vAppTemplate.client, vAppTemplate.VAppTemplate.HREF, key, isSystem)
-}
+// listMetadataAggregateEntities returns the HREFs of the VMs, vApps and networks of the receiver VDC, without
+// duplicates, retrieving all the pages of each query. The entity kinds that couldn't be listed are returned in the
+// map of errors.
+func (vdc *Vdc) listMetadataAggregateEntities() ([]string, map[string]error) {
+	var hrefs []string
+	listErrors := map[string]error{}
+	seen := map[string]bool{}
+	add := func(href string) {
+		if href != "" && !seen[href] {
+			seen[href] = true
+			hrefs = append(hrefs, href)
+		}
+	}
 
-// DeleteMetadataEntryWithDomainAsync deletes MediaRecord metadata associated to the input key and returns the task.
-func (mediaRecord *MediaRecord) DeleteMetadataEntryWithDomainAsync(key string, isSystem bool) (Task, error) {
-	return deleteMetadata(mediaRecord.client, mediaRecord.MediaRecord.HREF, key, isSystem)
-}
+	vms, err := vdc.QueryVmList(types.VmQueryFilterOnlyDeployed)
+	if err != nil {
+		listErrors["VMs"] = err
+	}
+	for _, vm := range vms {
+		add(vm.HREF)
+	}
 
-// DeleteMetadataEntryWithDomainAsync deletes Media metadata associated to the input key and returns the task.
-func (media *Media) DeleteMetadataEntryWithDomainAsync(key string, isSystem bool) (Task, error) {
-	return deleteMetadata(media.client, media.Media.HREF, key, isSystem)
-}
+	queryType := vdc.client.GetQueryType(types.QtVapp)
+	vAppResults, err := vdc.client.cumulativeQuery(queryType, nil, map[string]string{
+		"type":          queryType,
+		"filter":        fmt.Sprintf("vdc==%s", vdc.Vdc.HREF),
+		"filterEncoded": "true",
+	})
+	if err != nil {
+		listErrors["vApps"] = fmt.Errorf("error getting vApp list: %s", err)
+	} else {
+		vApps := vAppResults.Results.VAppRecord
+		if vdc.client.IsSysAdmin {
+			vApps = vAppResults.Results.AdminVAppRecord
+		}
+		for _, vApp := range vApps {
+			add(vApp.HREF)
+		}
+	}
 
-// DeleteMetadataEntryWithDomainAsync deletes AdminCatalog metadata associated to the input key and returns the task.
-func (adminCatalog *AdminCatalog) DeleteMetadataEntryWithDomainAsync(key string, isSystem bool) (Task, error) {
-	return deleteMetadata(adminCatalog.client, adminCatalog.AdminCatalog.HREF, key, isSystem)
+	networks, err := vdc.GetNetworkList()
+	if err != nil {
+		listErrors["networks"] = err
+	}
+	for _, network := range networks {
+		add(network.HREF)
+	}
+	return hrefs, listErrors
 }
 
-// DeleteMetadataEntryWithDomainAsync deletes AdminOrg metadata associated to the input key and returns the task.
-func (adminOrg *AdminOrg) DeleteMetadataEntryWithDomainAsync(key string, isSystem bool) (Task, error) {
-	return deleteMetadata(adminOrg.client, adminOrg.AdminOrg.HREF, key, isSystem)
-}
+// ------------------------------------------------------------------------------------------------
+// GET metadata of several entities
+// ------------------------------------------------------------------------------------------------
 
-// DeleteMetadataEntryWithDomainAsync deletes Disk metadata associated to the input key and returns the task.
-func (disk *Disk) DeleteMetadataEntryWithDomainAsync(key string, isSystem bool) (Task, error) {
-	return deleteMetadata(disk.client, disk.Disk.HREF, key, isSystem)
-}
+// GetMetadataBulk reads the metadata of all the given entities, with at most 'concurrency' simultaneous requests, and
+// returns it indexed by the entity identifier, which is its HREF, or its ID for OpenAPI entities (see
+// metadataEntityIdentifier). It is meant for inventory tools that need the metadata of thousands of entities.
+// Entities whose metadata can't be read don't stop the rest of the batch. They are reported in a *MetadataMultiError,
+// indexed by the same identifier, that is returned alongside the metadata of the remaining entities.
+// The SDK doesn't throttle requests by itself, so 'concurrency' is what limits the load put on VCD.
+func GetMetadataBulk(entities []MetadataCompatible, concurrency int) (map[string]*types.Metadata, error) {
+	result := make(map[string]*types.Metadata, len(entities))
+	multiError := newMetadataMultiError("retrieving metadata")
+
+	var mutex sync.Mutex
+	runMetadataWorkers(len(entities), concurrency, func(index int) {
+		metadata, err := entities[index].GetMetadata()
+		identifier := metadataEntityIdentifier(entities[index], index)
+		mutex.Lock()
+		defer mutex.Unlock()
+		if err != nil {
+			multiError.add(identifier, err)
+			return
+		}
+		result[identifier] = metadata
+	})
 
-// DeleteMetadataEntryWithDomainAsync deletes OrgVDCNetwork metadata associated to the input key and returns the task.
-// Note: Requires system administrator privileges.
-func (orgVdcNetwork *OrgVDCNetwork) DeleteMetadataEntryWithDomainAsync(key string, isSystem bool) (Task, error) {
-	return deleteMetadata(orgVdcNetwork.client, getAdminURL(orgVdcNetwork.OrgVDCNetwork.HREF), key, isSystem)
+	return result, multiError.errorOrNil()
 }
 
-// DeleteMetadataEntryWithDomainAsync deletes CatalogItem metadata associated to the input key and returns the task.
-func (catalogItem *CatalogItem) DeleteMetadataEntryWithDomainAsync(key string, isSystem bool) (Task, error) {
-	return deleteMetadata(catalogItem.client, catalogItem.CatalogItem.HREF, key, isSystem)
+// ------------------------------------------------------------------------------------------------
+// ADD the same metadata entry to several entities
+// ------------------------------------------------------------------------------------------------
+
+// metadataTagConcurrency is the maximum number of entities that TagEntities modifies at the same time
+const metadataTagConcurrency = 5
+
+// TagEntities adds the same metadata entry to all the given entities, with at most metadataTagConcurrency entities
+// being processed at the same time. It is meant for fleet-wide tagging, like setting the same cost center to all the
+// VMs of a VDC. Entities that already have the entry with the same value, type and visibility are left untouched, as
+// with AddMetadataEntryWithVisibilityIfChanged, so running it again doesn't create any task.
+// The entry is validated once before touching any entity. A failure doesn't stop the rest of the entities, and all
+// of them are returned in a single *MetadataMultiError, indexed by the entity identifier (see metadataEntityIdentifier).
+func TagEntities(entities []MetadataCompatible, key, value, typedValue, visibility string, isSystem bool) error {
+	err := validateMetadataEntry(key, value, typedValue, visibility)
+	if err != nil {
+		return err
+	}
+
+	errs := make([]error, len(entities))
+	runMetadataWorkers(len(entities), metadataTagConcurrency, func(index int) {
+		_, errs[index] = addMetadataEntityEntryIfChanged(entities[index], key, value, typedValue, visibility, isSystem)
+	})
+
+	multiError := newMetadataMultiError(fmt.Sprintf("tagging entities with metadata key '%s'", key))
+	for index, err := range errs {
+		multiError.add(metadataEntityIdentifier(entities[index], index), err)
+	}
+	return multiError.errorOrNil()
 }
 
 // ------------------------------------------------------------------------------------------------
-// DELETE metadata
+// MERGE the same metadata into several entities
 // ------------------------------------------------------------------------------------------------
 
-// DeleteMetadataEntryWithDomainByHref deletes metadata from the given resource reference, depending on key provided as input
-// and waits for the task to finish.
-func (vcdClient *VCDClient) DeleteMetadataEntryWithDomainByHref(href, key string, isSystem bool) error {
-	task, err := vcdClient.DeleteMetadataEntryWithDomainByHrefAsync(href, key, isSystem)
+// MergeMetadataBulk merges the same metadata into all the given entities, with at most 'concurrency' entities being
+// processed at the same time. Each entity is updated with its own MergeMetadataWithMetadataValues, so it creates a
+// single merge task per entity, and transient errors are retried as configured by Client.MetadataRetryCount and
+// Client.MetadataRetryBackoff. It is meant for fleet-wide labelling, like tagging a set of newly created VMs.
+// The SDK doesn't throttle requests by itself, so 'concurrency' is what limits the load put on VCD.
+// The metadata is validated once before touching any entity. A failure doesn't stop the rest of the entities, and all
+// of them are returned in a single *MetadataMultiError, indexed by the entity identifier (see metadataEntityIdentifier).
+func MergeMetadataBulk(entities []MetadataCompatible, metadata map[string]types.MetadataValue, concurrency int) error {
+	err := validateMetadataValues(metadata)
 	if err != nil {
 		return err
 	}
-	return task.WaitTaskCompletion()
-}
 
-// DeleteMetadataEntryWithDomain deletes VM metadata associated to the input key and waits for the task to finish.
-func (vm *VM) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
-	return deleteMetadataAndWait(vm.client, vm.VM.HREF, key, isSystem)
+	errs := make([]error, len(entities))
+	runMetadataWorkers(len(entities), concurrency, func(index int) {
+		errs[index] = entities[index].MergeMetadataWithMetadataValues(metadata)
+	})
+
+	multiError := newMetadataMultiError("merging metadata")
+	for index, err := range errs {
+		multiError.add(metadataEntityIdentifier(entities[index], index), err)
+	}
+	return multiError.errorOrNil()
 }
 
-// DeleteMetadataEntryWithDomain deletes AdminVdc metadata associated to the input key and waits for the task to finish.
+// ------------------------------------------------------------------------------------------------
+// EXPORT metadata of all the entities of an Org
+// ------------------------------------------------------------------------------------------------
+
+// metadataExportConcurrency is the maximum number of simultaneous requests that AdminOrg.ExportAllMetadata sends to
+// VCD, both to discover the entities of the Org and to retrieve their metadata
+const metadataExportConcurrency = 5
+
+// OrgMetadataSnapshot contains the metadata of all the entities of an Org, indexed by their URN, such as
+// urn:vcloud:vm:<uuid>. It is created by AdminOrg.ExportAllMetadata and can be serialized to JSON.
+type OrgMetadataSnapshot struct {
+	OrgName   string                                `json:"orgName"`
+	OrgId     string                                `json:"orgId"`
+	CreatedAt time.Time                             `json:"createdAt"`
+	Entities  map[string]*OrgMetadataSnapshotEntity `json:"entities"`
+	// Errors contains the entities, indexed by URN or HREF, whose metadata or children couldn't be retrieved
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// OrgMetadataSnapshotEntity contains the metadata of a single entity of an OrgMetadataSnapshot
+type OrgMetadataSnapshotEntity struct {
+	Name     string          `json:"name"`
+	Type     string          `json:"type"` // The URN namespace of the entity, such as vm, vapp or catalog
+	Href     string          `json:"href"`
+	Metadata *types.Metadata `json:"metadata"`
+}
+
+// ExportAllMetadata returns a snapshot with the metadata of the receiver AdminOrg and of all its VDCs, vApps, VMs,
+// media, independent disks, VDC networks, Catalogs and Catalog Items, indexed by URN.
+// The entities are discovered and their metadata retrieved concurrently, with at most metadataExportConcurrency
+// simultaneous requests. A failure doesn't stop the export: the snapshot contains all the metadata that could be
+// retrieved, and the failures are recorded in its Errors field and returned as a *MetadataMultiError alongside it.
+func (adminOrg *AdminOrg) ExportAllMetadata() (*OrgMetadataSnapshot, error) {
+	snapshot := &OrgMetadataSnapshot{
+		OrgName:   adminOrg.AdminOrg.Name,
+		OrgId:     adminOrg.AdminOrg.ID,
+		CreatedAt: time.Now().UTC(),
+		Entities:  map[string]*OrgMetadataSnapshotEntity{},
+		Errors:    map[string]string{},
+	}
+	multiError := newMetadataMultiError(fmt.Sprintf("exporting metadata of Org '%s'", adminOrg.AdminOrg.Name))
+
+	entities, discoveryErrors := adminOrg.discoverMetadataEntities()
+	multiError.addAll(discoveryErrors)
+
+	urns := make([]string, 0, len(entities))
+	for urn := range entities {
+		urns = append(urns, urn)
+	}
+	var mutex sync.Mutex
+	runMetadataWorkers(len(urns), metadataExportConcurrency, func(index int) {
+		entity := entities[urns[index]]
+		metadata, err := getMetadata(adminOrg.client, entity.Href)
+		mutex.Lock()
+		defer mutex.Unlock()
+		if err != nil {
+			multiError.add(urns[index], err)
+			return
+		}
+		entity.Metadata = metadata
+		snapshot.Entities[urns[index]] = entity
+	})
+
+	if len(multiError.Errors) > 0 {
+		for identifier, err := range multiError.Errors {
+			snapshot.Errors[identifier] = err.Error()
+		}
+		return snapshot, multiError
+	}
+	return snapshot, nil
+}
+
+// ------------------------------------------------------------------------------------------------
+// APPLY a metadata snapshot to the entities of an Org
+// ------------------------------------------------------------------------------------------------
+
+// ApplyMode defines how AdminOrg.ApplyMetadataSnapshot applies the metadata of a snapshot to an entity
+type ApplyMode int
+
+const (
+	// ApplyModeMerge creates or updates the entries of the snapshot, keeping any other entry of the entity
+	ApplyModeMerge ApplyMode = iota
+	// ApplyModeReplace makes the metadata of the entity match exactly the snapshot, deleting any other entry
+	ApplyModeReplace
+)
+
+// String returns a human readable name of the mode
+func (mode ApplyMode) String() string {
+	switch mode {
+	case ApplyModeMerge:
+		return "merge"
+	case ApplyModeReplace:
+		return "replace"
+	default:
+		return fmt.Sprintf("unknown mode %d", int(mode))
+	}
+}
+
+// ApplyMetadataSnapshot applies the metadata of the given snapshot, as created by AdminOrg.ExportAllMetadata, to the
+// entities of the receiver AdminOrg. See ApplyMetadataSnapshotWithWarnings for details. The warnings are logged.
+func (adminOrg *AdminOrg) ApplyMetadataSnapshot(snapshot *OrgMetadataSnapshot, mode ApplyMode) error {
+	warnings, err := adminOrg.ApplyMetadataSnapshotWithWarnings(snapshot, mode)
+	for _, warning := range warnings {
+		util.Logger.Printf("[WARN] applying metadata snapshot to Org '%s': %s", adminOrg.AdminOrg.Name, warning)
+	}
+	return err
+}
+
+// ApplyMetadataSnapshotWithWarnings applies the metadata of the given snapshot, as created by
+// AdminOrg.ExportAllMetadata, to the entities of the receiver AdminOrg. Every URN of the snapshot is resolved to the
+// live entity, whose metadata is merged with the snapshot (ApplyModeMerge) or replaced by it (ApplyModeReplace).
+// Replacing affects only the domains that are applied, so SYSTEM entries are kept when they can't be modified.
+// Entities that no longer exist are skipped, as well as SYSTEM entries when the client doesn't have system
+// administrator privileges: these are returned as warnings, sorted alphabetically.
+// The entities are processed concurrently, with at most metadataExportConcurrency at the same time. A failure doesn't
+// stop the process, and all of them are returned in a single *MetadataMultiError indexed by URN.
+func (adminOrg *AdminOrg) ApplyMetadataSnapshotWithWarnings(snapshot *OrgMetadataSnapshot, mode ApplyMode) ([]string, error) {
+	if snapshot == nil {
+		return nil, fmt.Errorf("the metadata snapshot to apply can't be nil")
+	}
+	if mode != ApplyModeMerge && mode != ApplyModeReplace {
+		return nil, fmt.Errorf("invalid apply mode: %s", mode)
+	}
+
+	urns := make([]string, 0, len(snapshot.Entities))
+	for urn := range snapshot.Entities {
+		urns = append(urns, urn)
+	}
+	sort.Strings(urns)
+
+	multiError := newMetadataMultiError(fmt.Sprintf("applying metadata snapshot to Org '%s'", adminOrg.AdminOrg.Name))
+	var warnings []string
+	var mutex sync.Mutex
+	runMetadataWorkers(len(urns), metadataExportConcurrency, func(index int) {
+		entityWarnings, err := applyMetadataSnapshotEntity(adminOrg.client, urns[index], snapshot.Entities[urns[index]], mode)
+		mutex.Lock()
+		defer mutex.Unlock()
+		warnings = append(warnings, entityWarnings...)
+		multiError.add(urns[index], err)
+	})
+	sort.Strings(warnings)
+
+	return warnings, multiError.errorOrNil()
+}
+
+// ------------------------------------------------------------------------------------------------
+// GET metadata of the file records of a Catalog Item
+// ------------------------------------------------------------------------------------------------
+
+// GetFileRecordMetadata returns the metadata of the OVA/OVF or media file records referenced by the receiver
+// CatalogItem. VCD doesn't expose metadata for the file records themselves, they belong to the vApp Template or
+// Media that the Catalog Item references, so the metadata of that entity is returned instead. This is different
+// from CatalogItem.GetMetadata, which returns the metadata of the Catalog Item.
+// If the Catalog Item references another kind of entity, a *MetadataNotSupportedError is returned.
+func (catalogItem *CatalogItem) GetFileRecordMetadata() (*types.Metadata, error) {
+	href, err := catalogItem.getFileRecordMetadataHref()
+	if err != nil {
+		return nil, err
+	}
+	return getMetadata(catalogItem.client, href)
+}
+
+// GetFileRecordMetadataByKey returns the metadata of the OVA/OVF or media file records referenced by the receiver
+// CatalogItem, corresponding to the given key and domain. See GetFileRecordMetadata for details.
+func (catalogItem *CatalogItem) GetFileRecordMetadataByKey(key string, isSystem bool) (*types.MetadataValue, error) {
+	href, err := catalogItem.getFileRecordMetadataHref()
+	if err != nil {
+		return nil, err
+	}
+	return getMetadataByKey(catalogItem.client, href, key, isSystem)
+}
+
+// ------------------------------------------------------------------------------------------------
+// GET metadata of subscribed Catalogs
+// ------------------------------------------------------------------------------------------------
+
+// MetadataWithSubscription contains the metadata of a Catalog or Catalog Item, together with whether the Catalog is
+// subscribed to an external one
+type MetadataWithSubscription struct {
+	Metadata *types.Metadata
+	// IsSubscribed is true if the Catalog, or the Catalog of the Catalog Item, is subscribed to an external Catalog
+	IsSubscribed bool
+	// ReadOnly is true when the metadata is synchronized from the publisher, so it can't be modified. This is the case
+	// of the items of a subscribed Catalog, while the subscribed Catalog itself can still be tagged.
+	ReadOnly bool
+}
+
+// GetMetadataWithSubscription returns the metadata of the receiver Catalog and whether it is subscribed to an external
+// Catalog. The metadata of a subscribed Catalog is local, so it is never read-only.
+// Note: Checking the subscription requires Org administrator privileges.
+func (catalog *Catalog) GetMetadataWithSubscription() (*MetadataWithSubscription, error) {
+	isSubscribed, err := isSubscribedCatalog(catalog.client, catalog.Catalog.HREF)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := getMetadata(catalog.client, catalog.Catalog.HREF)
+	if err != nil {
+		return nil, err
+	}
+	return &MetadataWithSubscription{Metadata: metadata, IsSubscribed: isSubscribed}, nil
+}
+
+// GetMetadataWithSubscription returns the metadata of the receiver CatalogItem and whether its Catalog is subscribed
+// to an external Catalog. The metadata of the items of a subscribed Catalog is synchronized from the publisher, hence
+// it is read-only.
+// Note: Checking the subscription requires Org administrator privileges.
+func (catalogItem *CatalogItem) GetMetadataWithSubscription() (*MetadataWithSubscription, error) {
+	isSubscribed, err := catalogItem.isInSubscribedCatalog()
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := getMetadata(catalogItem.client, catalogItem.CatalogItem.HREF)
+	if err != nil {
+		return nil, err
+	}
+	return &MetadataWithSubscription{Metadata: metadata, IsSubscribed: isSubscribed, ReadOnly: isSubscribed}, nil
+}
+
+// ------------------------------------------------------------------------------------------------
+// CRUD metadata of vApp networks
+// ------------------------------------------------------------------------------------------------
+
+// GetVappNetworkMetadata returns the metadata of the vApp network with the given name or ID of the receiver VApp.
+// See withVappNetworkMetadataHref for details about how the vApp network HREF is resolved.
+func (vapp *VApp) GetVappNetworkMetadata(networkNameOrId string) (*types.Metadata, error) {
+	var metadata *types.Metadata
+	err := vapp.withVappNetworkMetadataHref(networkNameOrId, func(href string) error {
+		var err error
+		metadata, err = getMetadata(vapp.client, href)
+		return err
+	})
+	return metadata, err
+}
+
+// GetVappNetworkMetadataByKey returns the metadata of the vApp network with the given name or ID of the receiver
+// VApp, corresponding to the given key and domain.
+// See withVappNetworkMetadataHref for details about how the vApp network HREF is resolved.
+func (vapp *VApp) GetVappNetworkMetadataByKey(networkNameOrId, key string, isSystem bool) (*types.MetadataValue, error) {
+	var metadataValue *types.MetadataValue
+	err := vapp.withVappNetworkMetadataHref(networkNameOrId, func(href string) error {
+		var err error
+		metadataValue, err = getMetadataByKey(vapp.client, href, key, isSystem)
+		return err
+	})
+	return metadataValue, err
+}
+
+// AddVappNetworkMetadataEntryWithVisibility adds metadata to the vApp network with the given name or ID of the
+// receiver VApp and waits for the task to finish.
+// See withVappNetworkMetadataHref for details about how the vApp network HREF is resolved.
+func (vapp *VApp) AddVappNetworkMetadataEntryWithVisibility(networkNameOrId, key, value, typedValue, visibility string, isSystem bool) error {
+	return vapp.withVappNetworkMetadataHref(networkNameOrId, func(href string) error {
+		return addMetadataAndWait(vapp.client, href, key, value, typedValue, visibility, isSystem)
+	})
+}
+
+// MergeVappNetworkMetadataWithMetadataValues updates the metadata values that are already present in the vApp
+// network with the given name or ID of the receiver VApp and creates the ones not present, waiting for the task to
+// finish. See withVappNetworkMetadataHref for details about how the vApp network HREF is resolved.
+func (vapp *VApp) MergeVappNetworkMetadataWithMetadataValues(networkNameOrId string, metadata map[string]types.MetadataValue) error {
+	return vapp.withVappNetworkMetadataHref(networkNameOrId, func(href string) error {
+		return mergeMetadataAndWait(vapp.client, href, metadata)
+	})
+}
+
+// DeleteVappNetworkMetadataEntryWithDomain deletes the metadata associated to the input key from the vApp network
+// with the given name or ID of the receiver VApp and waits for the task to finish.
+// See withVappNetworkMetadataHref for details about how the vApp network HREF is resolved.
+func (vapp *VApp) DeleteVappNetworkMetadataEntryWithDomain(networkNameOrId, key string, isSystem bool) error {
+	return vapp.withVappNetworkMetadataHref(networkNameOrId, func(href string) error {
+		return deleteMetadataAndWait(vapp.client, href, key, isSystem)
+	})
+}
+
+// ------------------------------------------------------------------------------------------------
+// RENDER metadata with a template
+// ------------------------------------------------------------------------------------------------
+
+// MetadataTemplateOption modifies how RenderMetadataTemplate renders a template
+type MetadataTemplateOption func(*metadataTemplateSettings)
+
+// metadataTemplateSettings contains the settings that can be changed with a MetadataTemplateOption
+type metadataTemplateSettings struct {
+	missingKeyError bool
+}
+
+// WithMetadataTemplateMissingKeyError makes RenderMetadataTemplate fail when the template references a metadata key
+// that doesn't exist, instead of rendering it as an empty string
+func WithMetadataTemplateMissingKeyError() MetadataTemplateOption {
+	return func(settings *metadataTemplateSettings) {
+		settings.missingKeyError = true
+	}
+}
+
+// RenderMetadataTemplateByHref renders the given text/template with the metadata of the given resource reference.
+// See renderMetadataTemplate for details.
+func (vcdClient *VCDClient) RenderMetadataTemplateByHref(href, tmpl string, isSystem bool, options ...MetadataTemplateOption) (string, error) {
+	return renderMetadataTemplate(&vcdClient.Client, href, tmpl, isSystem, options...)
+}
+
+// RenderMetadataTemplate renders the given text/template with the receiver VM metadata.
+// See renderMetadataTemplate for details.
+func (vm *VM) RenderMetadataTemplate(tmpl string, isSystem bool, options ...MetadataTemplateOption) (string, error) {
+	return renderMetadataTemplate(vm.client, vm.VM.HREF, tmpl, isSystem, options...)
+}
+
+// ------------------------------------------------------------------------------------------------
+// ADD metadata async
+// ------------------------------------------------------------------------------------------------
+
+// AddMetadataEntryWithVisibilityByHrefAsync adds metadata to the given resource reference with the given key, value, type and visibility
+// and returns the task.
+func (vcdClient *VCDClient) AddMetadataEntryWithVisibilityByHrefAsync(href, key, value, typedValue, visibility string, isSystem bool) (Task, error) {
+	return addMetadata(&vcdClient.Client, href, key, value, typedValue, visibility, isSystem)
+}
+
+// AddMetadataEntryWithVisibilityAsync adds metadata to the given VM with the given key, value, type and visibility
+// // and returns the task.
+func (vm *VM) AddMetadataEntryWithVisibilityAsync(key, value, typedValue, visibility string, isSystem bool) (Task, error) {
+	return addMetadata(vm.client, vm.VM.HREF, key, value, typedValue, visibility, isSystem)
+}
+
+// AddMetadataEntryWithVisibilityAsync adds metadata to the given AdminVdc with the given key, value, type and visibility
+// and returns the task.
+func (adminVdc *AdminVdc) AddMetadataEntryWithVisibilityAsync(key, value, typedValue, visibility string, isSystem bool) (Task, error) {
+	return addMetadata(adminVdc.client, adminVdc.AdminVdc.HREF, key, value, typedValue, visibility, isSystem)
+}
+
+// AddMetadataEntryWithVisibilityAsync adds metadata to the given ProviderVdc with the given key, value, type and visibility
+// and returns the task.
+// Unlike tenant level entities, SYSTEM metadata can be added with types.MetadataReadWriteVisibility.
 // Note: Requires system administrator privileges.
-func (adminVdc *AdminVdc) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
-	return deleteMetadataAndWait(adminVdc.client, getAdminURL(adminVdc.AdminVdc.HREF), key, isSystem)
+func (providerVdc *ProviderVdc) AddMetadataEntryWithVisibilityAsync(key, value, typedValue, visibility string, isSystem bool) (Task, error) {
+	return addMetadataWithContext(context.Background(), providerVdc.client, providerVdc.ProviderVdc.HREF, key, value, typedValue, visibility, isSystem, providerVdc.client.IsSysAdmin)
+}
+
+// AddMetadataEntryWithVisibilityAsync adds metadata to the given VApp with the given key, value, type and visibility
+// and returns the task.
+func (vapp *VApp) AddMetadataEntryWithVisibilityAsync(key, value, typedValue, visibility string, isSystem bool) (Task, error) {
+	return addMetadata(vapp.client, vapp.VApp.HREF, key, value, typedValue, visibility, isSystem)
+}
+
+// AddMetadataEntryWithVisibilityAsync adds metadata to the given VAppTemplate with the given key, value, type and visibility
+// and returns the task.
+func (vAppTemplate *VAppTemplate) AddMetadataEntryWithVisibilityAsync(key, value, typedValue, visibility string, isSystem bool) (Task, error) {
+	return addMetadata(vAppTemplate.client, vAppTemplate.VAppTemplate.HREF, key, value, typedValue, visibility, isSystem)
+}
+
+// AddMetadataEntryWithVisibilityAsync adds metadata to the given MediaRecord with the given key, value, type and visibility
+// and returns the task.
+func (mediaRecord *MediaRecord) AddMetadataEntryWithVisibilityAsync(key, value, typedValue, visibility string, isSystem bool) (Task, error) {
+	return addMetadata(mediaRecord.client, mediaRecord.MediaRecord.HREF, key, value, typedValue, visibility, isSystem)
+}
+
+// AddMetadataEntryWithVisibilityAsync adds metadata to the given Media with the given key, value, type and visibility
+// and returns the task.
+func (media *Media) AddMetadataEntryWithVisibilityAsync(key, value, typedValue, visibility string, isSystem bool) (Task, error) {
+	return addMetadata(media.client, media.Media.HREF, key, value, typedValue, visibility, isSystem)
+}
+
+// AddMetadataEntryWithVisibilityAsync adds metadata to the given AdminCatalog with the given key, value, type and visibility
+// and returns the task.
+func (adminCatalog *AdminCatalog) AddMetadataEntryWithVisibilityAsync(key, value, typedValue, visibility string, isSystem bool) (Task, error) {
+	return addMetadata(adminCatalog.client, adminCatalog.AdminCatalog.HREF, key, value, typedValue, visibility, isSystem)
+}
+
+// AddMetadataEntryWithVisibilityAsync adds metadata to the given AdminOrg with the given key, value, type and visibility
+// and returns the task.
+func (adminOrg *AdminOrg) AddMetadataEntryWithVisibilityAsync(key, value, typedValue, visibility string, isSystem bool) (Task, error) {
+	return addMetadata(adminOrg.client, adminOrg.AdminOrg.HREF, key, value, typedValue, visibility, isSystem)
+}
+
+// AddMetadataEntryWithVisibilityAsync adds metadata to the given Disk with the given key, value, type and visibility
+// and returns the task.
+// NOTE: Only the XML API returns tasks, so it returns a *MetadataNotSupportedError when Client.OpenApiDiskMetadata
+// selects the OpenAPI metadata endpoint. Use AddMetadataEntryWithVisibility instead.
+func (disk *Disk) AddMetadataEntryWithVisibilityAsync(key, value, typedValue, visibility string, isSystem bool) (Task, error) {
+	href, err := disk.asyncMetadataHref()
+	if err != nil {
+		return Task{}, err
+	}
+	return addMetadata(disk.client, href, key, value, typedValue, visibility, isSystem)
+}
+
+// AddMetadataEntryWithVisibilityAsync adds metadata to the given OrgVDCNetwork with the given key, value, type and visibility
+// and returns the task.
+// Note: Org administrators can manage GENERAL metadata, while SYSTEM metadata requires system administrator
+// privileges. See OrgVDCNetwork.writeMetadata for details.
+func (orgVdcNetwork *OrgVDCNetwork) AddMetadataEntryWithVisibilityAsync(key, value, typedValue, visibility string, isSystem bool) (Task, error) {
+	var task Task
+	err := orgVdcNetwork.writeMetadata(isSystem, func(href string) error {
+		var err error
+		task, err = addMetadata(orgVdcNetwork.client, href, key, value, typedValue, visibility, isSystem)
+		return err
+	})
+	return task, err
+}
+
+// AddMetadataEntryWithVisibilityAsync adds metadata to the given Catalog Item with the given key, value, type and visibility
+// and returns the task.
+func (catalogItem *CatalogItem) AddMetadataEntryWithVisibilityAsync(key, value, typedValue, visibility string, isSystem bool) (Task, error) {
+	task, err := addMetadata(catalogItem.client, catalogItem.CatalogItem.HREF, key, value, typedValue, visibility, isSystem)
+	return task, catalogItem.subscribedMetadataError(err)
+}
+
+// ------------------------------------------------------------------------------------------------
+// GET and ADD metadata by ID
+// ------------------------------------------------------------------------------------------------
+
+// GetMetadataById returns the metadata of the entity with the given URN, like urn:vcloud:vm:<uuid>, without
+// retrieving the entity first. See metadataHrefFromId for the supported URNs.
+func (vcdClient *VCDClient) GetMetadataById(id string) (*types.Metadata, error) {
+	href, err := metadataHrefFromId(&vcdClient.Client, id, false)
+	if err != nil {
+		return nil, err
+	}
+	return getMetadata(&vcdClient.Client, href)
+}
+
+// AddMetadataEntryWithVisibilityById adds metadata to the entity with the given URN, like urn:vcloud:vm:<uuid>, with
+// the given key, value, type and visibility, and waits for the task to finish. See metadataHrefFromId for the supported
+// URNs.
+// Note: VDCs, Catalogs, Orgs and networks are modified with the admin endpoint, which requires Org administrator
+// privileges.
+func (vcdClient *VCDClient) AddMetadataEntryWithVisibilityById(id, key, value, typedValue, visibility string, isSystem bool) error {
+	href, err := metadataHrefFromId(&vcdClient.Client, id, true)
+	if err != nil {
+		return err
+	}
+	return addMetadataAndWait(&vcdClient.Client, href, key, value, typedValue, visibility, isSystem)
+}
+
+// ------------------------------------------------------------------------------------------------
+// ADD metadata from a MetadataValue
+// ------------------------------------------------------------------------------------------------
+
+// AddMetadataValueEntryByHref adds the given metadata value to the given resource reference with the given key, taking
+// the type, domain and visibility from the value itself, and waits for completion. A value without Domain is added to
+// the GENERAL domain with types.MetadataReadWriteVisibility.
+func (vcdClient *VCDClient) AddMetadataValueEntryByHref(href, key string, value *types.MetadataValue) error {
+	return addMetadataValueAndWait(&vcdClient.Client, href, key, value)
+}
+
+// AddMetadataValueEntry adds the given metadata value to the receiver VM with the given key, taking the type, domain
+// and visibility from the value itself, and waits for the task to finish. This allows copying a value read from
+// another entity as it is. A value without Domain is added to the GENERAL domain with types.MetadataReadWriteVisibility.
+func (vm *VM) AddMetadataValueEntry(key string, value *types.MetadataValue) error {
+	return addMetadataValueAndWait(vm.client, vm.VM.HREF, key, value)
+}
+
+// ------------------------------------------------------------------------------------------------
+// ADD metadata
+// ------------------------------------------------------------------------------------------------
+
+// AddMetadataEntryWithVisibilityByHref adds metadata to the given resource reference with the given key, value, type and visibility
+// and waits for completion.
+func (vcdClient *VCDClient) AddMetadataEntryWithVisibilityByHref(href, key, value, typedValue, visibility string, isSystem bool) error {
+	return addMetadataAndWait(&vcdClient.Client, href, key, value, typedValue, visibility, isSystem)
+}
+
+// AddMetadataEntryWithVisibility adds metadata to the receiver VM and waits for the task to finish.
+func (vm *VM) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	return addMetadataAndWait(vm.client, vm.VM.HREF, key, value, typedValue, visibility, isSystem)
+}
+
+// AddMetadataEntryWithVisibility adds metadata to the receiver AdminVdc and waits for the task to finish.
+func (adminVdc *AdminVdc) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	return addMetadataAndWait(adminVdc.client, adminVdc.AdminVdc.HREF, key, value, typedValue, visibility, isSystem)
+}
+
+// AddMetadataEntryWithVisibility adds metadata to the receiver ProviderVdc and waits for the task to finish.
+// Unlike tenant level entities, SYSTEM metadata can be added with types.MetadataReadWriteVisibility.
+// Note: Requires system administrator privileges.
+func (providerVdc *ProviderVdc) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	return addMetadataAndWaitWithContext(context.Background(), providerVdc.client, providerVdc.ProviderVdc.HREF, key, value, typedValue, visibility, isSystem, providerVdc.client.IsSysAdmin)
+}
+
+// AddMetadataEntryWithVisibility adds metadata to the receiver VApp and waits for the task to finish.
+func (vapp *VApp) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	return addMetadataAndWait(vapp.client, vapp.VApp.HREF, key, value, typedValue, visibility, isSystem)
+}
+
+// AddMetadataEntryWithVisibility adds metadata to the receiver VAppTemplate and waits for the task to finish.
+func (vAppTemplate *VAppTemplate) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	return addMetadataAndWait(vAppTemplate.client, vAppTemplate.VAppTemplate.HREF, key, value, typedValue, visibility, isSystem)
+}
+
+// AddMetadataEntryWithVisibility adds metadata to the receiver MediaRecord and waits for the task to finish.
+func (mediaRecord *MediaRecord) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	return addMetadataAndWait(mediaRecord.client, mediaRecord.MediaRecord.HREF, key, value, typedValue, visibility, isSystem)
+}
+
+// AddMetadataEntryWithVisibility adds metadata to the receiver Media and waits for the task to finish.
+func (media *Media) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	return addMetadataAndWait(media.client, media.Media.HREF, key, value, typedValue, visibility, isSystem)
+}
+
+// AddMetadataEntryWithVisibility adds metadata to the receiver AdminCatalog and waits for the task to finish.
+func (adminCatalog *AdminCatalog) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	return addMetadataAndWait(adminCatalog.client, adminCatalog.AdminCatalog.HREF, key, value, typedValue, visibility, isSystem)
+}
+
+// AddMetadataEntryWithVisibility adds metadata to the receiver AdminOrg and waits for the task to finish.
+func (adminOrg *AdminOrg) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	return addMetadataAndWait(adminOrg.client, adminOrg.AdminOrg.HREF, key, value, typedValue, visibility, isSystem)
+}
+
+// AddMetadataEntryWithVisibility adds metadata to the receiver Disk and waits for the task to finish.
+// NOTE: If Client.OpenApiDiskMetadata is set, the disk has an OpenAPI ID and the negotiated API version is 38.0+
+// (VCD 10.5+), the OpenAPI metadata endpoint is used, so it doesn't depend on the XML HREF of the disk.
+func (disk *Disk) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	opts := disk.metadataRequestOptions(metadataOperationAdd)
+	opts.key, opts.value, opts.typedValue, opts.visibility, opts.isSystem = key, value, typedValue, visibility, isSystem
+	_, err := metadataRequest(disk.client, opts)
+	return err
+}
+
+// AddMetadataEntryWithVisibility adds metadata to the receiver OrgVDCNetwork and waits for the task to finish.
+// Note: Org administrators can manage GENERAL metadata, while SYSTEM metadata requires system administrator
+// privileges. See OrgVDCNetwork.writeMetadata for details.
+func (orgVdcNetwork *OrgVDCNetwork) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	return orgVdcNetwork.writeMetadata(isSystem, func(href string) error {
+		return addMetadataAndWait(orgVdcNetwork.client, href, key, value, typedValue, visibility, isSystem)
+	})
+}
+
+// AddMetadataEntryWithVisibility adds metadata to the receiver Vdc and waits for the task to finish.
+// Note: The admin endpoint is used, hence it requires Org administrator privileges.
+func (vdc *Vdc) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	return addMetadataAndWait(vdc.client, getAdminURL(vdc.Vdc.HREF), key, value, typedValue, visibility, isSystem)
+}
+
+// AddMetadataEntryWithVisibility adds metadata to the receiver Catalog and waits for the task to finish.
+// Note: The admin endpoint is used, hence it requires Org administrator privileges.
+func (catalog *Catalog) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	return addMetadataAndWait(catalog.client, getAdminURL(catalog.Catalog.HREF), key, value, typedValue, visibility, isSystem)
+}
+
+// AddMetadataEntryWithVisibility adds metadata to the receiver Org and waits for the task to finish.
+// Note: The admin endpoint is used, hence it requires Org administrator privileges.
+func (org *Org) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	return addMetadataAndWait(org.client, getAdminURL(org.Org.HREF), key, value, typedValue, visibility, isSystem)
+}
+
+// AddMetadataEntryWithVisibility adds metadata to the receiver CatalogItem and waits for the task to finish.
+func (catalogItem *CatalogItem) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	return catalogItem.subscribedMetadataError(addMetadataAndWait(catalogItem.client, catalogItem.CatalogItem.HREF, key, value, typedValue, visibility, isSystem))
+}
+
+// AddMetadataEntryWithVisibility adds metadata to the receiver OpenApiOrgVdcNetwork and waits for the task to finish.
+// NOTE: If the network belongs to a VDC Group, the OpenAPI metadata endpoint is used, which requires VCD 10.5+.
+// Otherwise, the XML API is used.
+func (openApiOrgVdcNetwork *OpenApiOrgVdcNetwork) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	opts := openApiOrgVdcNetwork.metadataRequestOptions(metadataOperationAdd, true)
+	opts.key, opts.value, opts.typedValue, opts.visibility, opts.isSystem = key, value, typedValue, visibility, isSystem
+	_, err := metadataRequest(openApiOrgVdcNetwork.client, opts)
+	return err
+}
+
+// AddMetadataEntryWithVisibility adds metadata to the receiver NSX-T ALB Service Engine Group, like the tenant that
+// owns it for chargeback purposes.
+// NOTE: The OpenAPI metadata endpoint is used, which requires VCD 10.5+. A *MetadataNotSupportedError is returned
+// for older versions.
+// Note: Requires system administrator privileges.
+func (nsxtAlbServiceEngineGroup *NsxtAlbServiceEngineGroup) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	return addOpenApiMetadata(&nsxtAlbServiceEngineGroup.vcdClient.Client, types.OpenApiEndpointAlbServiceEngineGroupsMetadata, nsxtAlbServiceEngineGroup.NsxtAlbServiceEngineGroup.ID, nil, key, value, typedValue, visibility, isSystem)
+}
+
+// AddSubscriptionMetadataEntryWithVisibility is not supported, as VCD doesn't expose metadata for the subscription and
+// synchronization settings of a Catalog. It always returns a *MetadataNotSupportedError.
+// Use AdminCatalog.AddMetadataEntryWithVisibility to tag the subscribed Catalog itself.
+func (adminCatalog *AdminCatalog) AddSubscriptionMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	return catalogSubscriptionMetadataNotSupported()
+}
+
+// AddVmSizingMetadataEntryWithVisibility is not supported, as VCD doesn't expose metadata for the VM sizing configuration
+// (CPU, memory and their reservations) of a VDC Compute Policy. It always returns a *MetadataNotSupportedError.
+func (vdcComputePolicy *VdcComputePolicyV2) AddVmSizingMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	return vmSizingMetadataNotSupported()
+}
+
+// AddMetadataEntryWithVisibility is not supported, as VCD doesn't expose metadata for NSX-T Edge Gateway NAT rules.
+// It always returns a *MetadataNotSupportedError.
+func (nsxtNatRule *NsxtNatRule) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	return nsxtNatRuleMetadataNotSupported()
+}
+
+// AddMetadataEntryWithVisibility is not supported, as VCD doesn't expose metadata for NSX-T ALB Controllers.
+// It always returns a *MetadataNotSupportedError.
+func (nsxtAlbController *NsxtAlbController) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	return nsxtAlbControllerMetadataNotSupported()
+}
+
+// AddMetadataEntryWithVisibility is not supported, as VCD doesn't expose metadata for NSX-T ALB Clouds.
+// It always returns a *MetadataNotSupportedError.
+func (nsxtAlbCloud *NsxtAlbCloud) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	return nsxtAlbCloudMetadataNotSupported()
+}
+
+// AddRoleAssignmentMetadataEntryWithVisibility is not supported, as VCD doesn't expose metadata for the role assigned to an Org user.
+// It always returns a *MetadataNotSupportedError.
+func (orgUser *OrgUser) AddRoleAssignmentMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	return orgUserRoleAssignmentMetadataNotSupported()
+}
+
+// AddMetadataEntryWithVisibility is not supported, as VCD doesn't expose metadata for VM affinity rules.
+// It always returns a *MetadataNotSupportedError.
+func (vmAffinityRule *VmAffinityRule) AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	return vmAffinityRuleMetadataNotSupported()
+}
+
+// AddRoleMetadataEntryWithVisibility is not supported, as VCD doesn't expose metadata for roles.
+// It always returns a *MetadataNotSupportedError.
+func (role *Role) AddRoleMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	return roleMetadataNotSupported()
+}
+
+// AddGlobalRoleMetadataEntryWithVisibility is not supported, as VCD doesn't expose metadata for global roles.
+// It always returns a *MetadataNotSupportedError.
+func (globalRole *GlobalRole) AddGlobalRoleMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error {
+	return globalRoleMetadataNotSupported()
+}
+
+// AddStorageProfileMetadataEntryWithVisibility adds metadata to the storage profile with the given HREF and waits
+// for the task to finish.
+// The storage profile can be a VDC storage profile or a Provider VDC storage profile.
+// Note: Requires system administrator privileges for Provider VDC storage profiles.
+func (vcdClient *VCDClient) AddStorageProfileMetadataEntryWithVisibility(storageProfileHref, key, value, typedValue, visibility string, isSystem bool) error {
+	return addMetadataAndWait(&vcdClient.Client, storageProfileHref, key, value, typedValue, visibility, isSystem)
+}
+
+// AddNetworkPoolMetadataEntryWithVisibility is not supported, as VCD doesn't expose metadata for network pools.
+// It always returns a *MetadataNotSupportedError.
+func (vcdClient *VCDClient) AddNetworkPoolMetadataEntryWithVisibility(networkPoolHref, key, value, typedValue, visibility string, isSystem bool) error {
+	return networkPoolMetadataNotSupported()
+}
+
+// AddNsxtManagerMetadataEntryWithVisibility is not supported, as VCD doesn't expose metadata for NSX-T Managers.
+// It always returns a *MetadataNotSupportedError.
+func (vcdClient *VCDClient) AddNsxtManagerMetadataEntryWithVisibility(nsxtManagerHref, key, value, typedValue, visibility string, isSystem bool) error {
+	return nsxtManagerMetadataNotSupported()
+}
+
+// AddVAppSnapshotMetadataEntryWithVisibility is not supported, as VCD doesn't expose metadata for vApp snapshots.
+// It always returns a *MetadataNotSupportedError.
+func (vcdClient *VCDClient) AddVAppSnapshotMetadataEntryWithVisibility(snapshotHref, key, value, typedValue, visibility string, isSystem bool) error {
+	return vAppSnapshotMetadataNotSupported()
+}
+
+// AddUIPluginMetadataEntryWithVisibility is not supported, as VCD doesn't expose metadata for UI plugins.
+// It always returns a *MetadataNotSupportedError.
+func (vcdClient *VCDClient) AddUIPluginMetadataEntryWithVisibility(pluginId, key, value, typedValue, visibility string, isSystem bool) error {
+	return uiPluginMetadataNotSupported()
+}
+
+// ------------------------------------------------------------------------------------------------
+// ADD metadata with verification
+// ------------------------------------------------------------------------------------------------
+
+// SetMetadataEntryVerifiedByHref adds metadata to the given resource reference and reads it back to check that the stored
+// value matches the requested one. See setMetadataEntryVerified for details about attempts and delay.
+func (vcdClient *VCDClient) SetMetadataEntryVerifiedByHref(href, key, value, typedValue, visibility string, isSystem bool, attempts int, delay time.Duration) error {
+	return setMetadataEntryVerified(&vcdClient.Client, href, key, value, typedValue, visibility, isSystem, 2, attempts, delay)
+}
+
+// SetMetadataEntryVerified adds metadata to the receiver VM and reads it back to check that the stored value matches
+// the requested one. See setMetadataEntryVerified for details about attempts and delay.
+func (vm *VM) SetMetadataEntryVerified(key, value, typedValue, visibility string, isSystem bool, attempts int, delay time.Duration) error {
+	return setMetadataEntryVerified(vm.client, vm.VM.HREF, key, value, typedValue, visibility, isSystem, 2, attempts, delay)
+}
+
+// AddMetadataEntryWithVisibilityVerifiedByHref adds metadata to the given resource reference, waits for the task, and
+// reads the entry back up to 'retries' times, waiting metadataVerifyPollInterval between reads, until it is visible with
+// the requested value. Unlike SetMetadataEntryVerifiedByHref, the metadata is written only once.
+// See setMetadataEntryVerified for details.
+func (vcdClient *VCDClient) AddMetadataEntryWithVisibilityVerifiedByHref(href, key, value, typedValue, visibility string, isSystem bool, retries int) error {
+	return setMetadataEntryVerified(&vcdClient.Client, href, key, value, typedValue, visibility, isSystem, 1, retries, metadataVerifyPollInterval)
+}
+
+// AddMetadataEntryWithVisibilityVerified adds metadata to the receiver VM, waits for the task, and reads the entry back
+// up to 'retries' times, waiting metadataVerifyPollInterval between reads, until it is visible with the requested value.
+// Unlike SetMetadataEntryVerified, the metadata is written only once. See setMetadataEntryVerified for details.
+func (vm *VM) AddMetadataEntryWithVisibilityVerified(key, value, typedValue, visibility string, isSystem bool, retries int) error {
+	return setMetadataEntryVerified(vm.client, vm.VM.HREF, key, value, typedValue, visibility, isSystem, 1, retries, metadataVerifyPollInterval)
+}
+
+// ------------------------------------------------------------------------------------------------
+// ADD metadata only if changed
+// ------------------------------------------------------------------------------------------------
+
+// AddMetadataEntryWithVisibilityIfChangedByHref adds metadata to the given resource reference unless it already has
+// the same value, type and visibility, returning whether it was added. See addMetadataEntryIfChanged for details.
+func (vcdClient *VCDClient) AddMetadataEntryWithVisibilityIfChangedByHref(href, key, value, typedValue, visibility string, isSystem bool) (bool, error) {
+	return addMetadataEntryIfChanged(&vcdClient.Client, href, key, value, typedValue, visibility, isSystem)
+}
+
+// AddMetadataEntryWithVisibilityIfChanged adds metadata to the receiver VM unless it already has the same value, type
+// and visibility, returning whether it was added. See addMetadataEntryIfChanged for details.
+func (vm *VM) AddMetadataEntryWithVisibilityIfChanged(key, value, typedValue, visibility string, isSystem bool) (bool, error) {
+	return addMetadataEntryIfChanged(vm.client, vm.VM.HREF, key, value, typedValue, visibility, isSystem)
+}
+
+// ------------------------------------------------------------------------------------------------
+// ADD metadata if the current value matches (compare-and-set)
+// ------------------------------------------------------------------------------------------------
+
+// SetMetadataIfValueEqualsByHref adds metadata to the given resource reference only if its current value is the
+// expected one, returning whether it was added. See setMetadataIfValueEquals for details.
+func (vcdClient *VCDClient) SetMetadataIfValueEqualsByHref(href, key, expectedCurrent, newValue, typedValue, visibility string, isSystem bool) (bool, error) {
+	return setMetadataIfValueEquals(&vcdClient.Client, href, key, expectedCurrent, newValue, typedValue, visibility, isSystem)
+}
+
+// SetMetadataIfValueEquals adds metadata to the receiver VM only if its current value is the expected one, returning
+// whether it was added. See setMetadataIfValueEquals for details.
+func (vm *VM) SetMetadataIfValueEquals(key, expectedCurrent, newValue, typedValue, visibility string, isSystem bool) (bool, error) {
+	return setMetadataIfValueEquals(vm.client, vm.VM.HREF, key, expectedCurrent, newValue, typedValue, visibility, isSystem)
+}
+
+// ------------------------------------------------------------------------------------------------
+// INCREMENT numeric metadata
+// ------------------------------------------------------------------------------------------------
+
+// IncrementNumberMetadataByHref adds delta to the types.MetadataNumberValue metadata entry of the given resource
+// reference, and returns the new value. See incrementNumberMetadata for details.
+func (vcdClient *VCDClient) IncrementNumberMetadataByHref(href, key string, delta int64, isSystem bool) (int64, error) {
+	return incrementNumberMetadata(&vcdClient.Client, href, key, delta, isSystem)
+}
+
+// IncrementNumberMetadata adds delta to the types.MetadataNumberValue metadata entry of the receiver VM, and returns
+// the new value. See incrementNumberMetadata for details.
+func (vm *VM) IncrementNumberMetadata(key string, delta int64, isSystem bool) (int64, error) {
+	return incrementNumberMetadata(vm.client, vm.VM.HREF, key, delta, isSystem)
+}
+
+// ------------------------------------------------------------------------------------------------
+// ADD metadata with strongly-typed type and visibility
+// ------------------------------------------------------------------------------------------------
+
+// AddMetadataEntryTypedByHref adds metadata to the given resource reference and waits for the task to finish. It is
+// the same as AddMetadataEntryWithVisibilityByHref, but the type and visibility are checked at compile time.
+func (vcdClient *VCDClient) AddMetadataEntryTypedByHref(href, key, value string, metadataType types.MetadataType, visibility types.MetadataVisibility, isSystem bool) error {
+	return addMetadataEntryTyped(&vcdClient.Client, href, key, value, metadataType, visibility, isSystem)
+}
+
+// AddMetadataEntryTyped adds metadata to the receiver VM and waits for the task to finish. It is the same as
+// AddMetadataEntryWithVisibility, but the type and visibility are checked at compile time.
+func (vm *VM) AddMetadataEntryTyped(key, value string, metadataType types.MetadataType, visibility types.MetadataVisibility, isSystem bool) error {
+	return addMetadataEntryTyped(vm.client, vm.VM.HREF, key, value, metadataType, visibility, isSystem)
+}
+
+// ------------------------------------------------------------------------------------------------
+// ADD several metadata entries in one task
+// ------------------------------------------------------------------------------------------------
+
+// AddMetadataEntriesByHref adds all the given metadata entries to the given resource reference with a single request
+// and task. See addMetadataEntries for details.
+func (vcdClient *VCDClient) AddMetadataEntriesByHref(href string, entries []types.MetadataEntry) error {
+	return addMetadataEntries(&vcdClient.Client, href, entries)
+}
+
+// AddMetadataEntries adds all the given metadata entries to the receiver VM with a single request and task, instead of
+// one task per entry as AddMetadataEntryWithVisibility does. See addMetadataEntries for details.
+func (vm *VM) AddMetadataEntries(entries []types.MetadataEntry) error {
+	return addMetadataEntries(vm.client, vm.VM.HREF, entries)
+}
+
+// ------------------------------------------------------------------------------------------------
+// MERGE metadata async
+// ------------------------------------------------------------------------------------------------
+
+// MergeMetadataWithVisibilityByHrefAsync updates the metadata entries present in the referenced entity and creates the ones not present, then
+// returns the task.
+func (vcdClient *VCDClient) MergeMetadataWithVisibilityByHrefAsync(href string, metadata map[string]types.MetadataValue) (Task, error) {
+	return mergeAllMetadata(&vcdClient.Client, href, metadata)
+}
+
+// MergeMetadataWithMetadataValuesAsync merges VM metadata provided as a key-value map of type `typedValue` with the already present in VCD,
+// then returns the task.
+func (vm *VM) MergeMetadataWithMetadataValuesAsync(metadata map[string]types.MetadataValue) (Task, error) {
+	return mergeAllMetadata(vm.client, vm.VM.HREF, metadata)
+}
+
+// MergeMetadataWithMetadataValuesAsync merges AdminVdc metadata provided as a key-value map of type `typedValue` with the already present in VCD,
+// then waits for the task to complete.
+func (adminVdc *AdminVdc) MergeMetadataWithMetadataValuesAsync(metadata map[string]types.MetadataValue) (Task, error) {
+	return mergeAllMetadata(adminVdc.client, adminVdc.AdminVdc.HREF, metadata)
+}
+
+// MergeMetadataWithMetadataValuesAsync merges Provider VDC metadata provided as a key-value map of type `typedValue` with the already present in VCD,
+// then waits for the task to complete.
+// Note: Requires system administrator privileges.
+func (providerVdc *ProviderVdc) MergeMetadataWithMetadataValuesAsync(metadata map[string]types.MetadataValue) (Task, error) {
+	return mergeAllMetadata(providerVdc.client, providerVdc.ProviderVdc.HREF, metadata)
+}
+
+// MergeMetadataWithMetadataValuesAsync merges VApp metadata provided as a key-value map of type `typedValue` with the already present in VCD,
+// then waits for the task to complete.
+func (vapp *VApp) MergeMetadataWithMetadataValuesAsync(metadata map[string]types.MetadataValue) (Task, error) {
+	return mergeAllMetadata(vapp.client, vapp.VApp.HREF, metadata)
+}
+
+// MergeMetadataWithMetadataValuesAsync merges VAppTemplate metadata provided as a key-value map of type `typedValue` with the already present in VCD,
+// then waits for the task to complete.
+func (vAppTemplate *VAppTemplate) MergeMetadataWithMetadataValuesAsync(metadata map[string]types.MetadataValue) (Task, error) {
+	return mergeAllMetadata(vAppTemplate.client, vAppTemplate.VAppTemplate.HREF, metadata)
+}
+
+// MergeMetadataWithMetadataValuesAsync merges MediaRecord metadata provided as a key-value map of type `typedValue` with the already present in VCD,
+// then waits for the task to complete.
+func (mediaRecord *MediaRecord) MergeMetadataWithMetadataValuesAsync(metadata map[string]types.MetadataValue) (Task, error) {
+	return mergeAllMetadata(mediaRecord.client, mediaRecord.MediaRecord.HREF, metadata)
+}
+
+// MergeMetadataWithMetadataValuesAsync merges Media metadata provided as a key-value map of type `typedValue` with the already present in VCD,
+// then waits for the task to complete.
+func (media *Media) MergeMetadataWithMetadataValuesAsync(metadata map[string]types.MetadataValue) (Task, error) {
+	return mergeAllMetadata(media.client, media.Media.HREF, metadata)
+}
+
+// MergeMetadataWithMetadataValuesAsync merges AdminCatalog metadata provided as a key-value map of type `typedValue` with the already present in VCD,
+// then waits for the task to complete.
+func (adminCatalog *AdminCatalog) MergeMetadataWithMetadataValuesAsync(metadata map[string]types.MetadataValue) (Task, error) {
+	return mergeAllMetadata(adminCatalog.client, adminCatalog.AdminCatalog.HREF, metadata)
+}
+
+// MergeMetadataWithMetadataValuesAsync merges AdminOrg metadata provided as a key-value map of type `typedValue` with the already present in VCD,
+// then waits for the task to complete.
+func (adminOrg *AdminOrg) MergeMetadataWithMetadataValuesAsync(metadata map[string]types.MetadataValue) (Task, error) {
+	return mergeAllMetadata(adminOrg.client, adminOrg.AdminOrg.HREF, metadata)
+}
+
+// MergeMetadataWithMetadataValuesAsync merges Disk metadata provided as a key-value map of type `typedValue` with the already present in VCD,
+// then waits for the task to complete.
+// NOTE: Only the XML API returns tasks, so it returns a *MetadataNotSupportedError when Client.OpenApiDiskMetadata
+// selects the OpenAPI metadata endpoint. Use MergeMetadataWithMetadataValues instead.
+func (disk *Disk) MergeMetadataWithMetadataValuesAsync(metadata map[string]types.MetadataValue) (Task, error) {
+	href, err := disk.asyncMetadataHref()
+	if err != nil {
+		return Task{}, err
+	}
+	return mergeAllMetadata(disk.client, href, metadata)
+}
+
+// MergeMetadataWithMetadataValuesAsync merges OrgVDCNetwork metadata provided as a key-value map of type `typedValue` with the already present in VCD,
+// then waits for the task to complete.
+// Note: Org administrators can manage GENERAL metadata, while SYSTEM metadata requires system administrator
+// privileges. See OrgVDCNetwork.writeMetadata for details.
+func (orgVdcNetwork *OrgVDCNetwork) MergeMetadataWithMetadataValuesAsync(metadata map[string]types.MetadataValue) (Task, error) {
+	var task Task
+	err := orgVdcNetwork.writeMetadata(hasSystemMetadataValues(metadata), func(href string) error {
+		var err error
+		task, err = mergeAllMetadata(orgVdcNetwork.client, href, metadata)
+		return err
+	})
+	return task, err
+}
+
+// MergeMetadataWithMetadataValuesAsync merges CatalogItem metadata provided as a key-value map of type `typedValue` with the already present in VCD,
+// then waits for the task to complete.
+func (catalogItem *CatalogItem) MergeMetadataWithMetadataValuesAsync(metadata map[string]types.MetadataValue) (Task, error) {
+	task, err := mergeAllMetadata(catalogItem.client, catalogItem.CatalogItem.HREF, metadata)
+	return task, catalogItem.subscribedMetadataError(err)
+}
+
+// ------------------------------------------------------------------------------------------------
+// MERGE metadata
+// ------------------------------------------------------------------------------------------------
+
+// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver VM and creates the ones not present.
+// The input metadata map has a "metadata key"->"metadata value" relation.
+// This function waits until merge finishes.
+func (vm *VM) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	return mergeMetadataAndWait(vm.client, vm.VM.HREF, metadata)
+}
+
+// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver AdminVdc and creates the ones not present.
+// The input metadata map has a "metadata key"->"metadata value" relation.
+// This function waits until merge finishes.
+func (adminVdc *AdminVdc) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	return mergeMetadataAndWait(adminVdc.client, adminVdc.AdminVdc.HREF, metadata)
+}
+
+// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver ProviderVdc and creates the ones not present.
+// The input metadata map has a "metadata key"->"metadata value" relation.
+// This function waits until merge finishes.
+// Note: Requires system administrator privileges.
+func (providerVdc *ProviderVdc) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	return mergeMetadataAndWait(providerVdc.client, providerVdc.ProviderVdc.HREF, metadata)
+}
+
+// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver VApp and creates the ones not present.
+// The input metadata map has a "metadata key"->"metadata value" relation.
+// This function waits until merge finishes.
+func (vApp *VApp) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	return mergeMetadataAndWait(vApp.client, vApp.VApp.HREF, metadata)
+}
+
+// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver VAppTemplate and creates the ones not present.
+// The input metadata map has a "metadata key"->"metadata value" relation.
+// This function waits until merge finishes.
+func (vAppTemplate *VAppTemplate) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	return mergeMetadataAndWait(vAppTemplate.client, vAppTemplate.VAppTemplate.HREF, metadata)
+}
+
+// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver MediaRecord and creates the ones not present.
+// The input metadata map has a "metadata key"->"metadata value" relation.
+// This function waits until merge finishes.
+func (mediaRecord *MediaRecord) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	return mergeMetadataAndWait(mediaRecord.client, mediaRecord.MediaRecord.HREF, metadata)
+}
+
+// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver Media and creates the ones not present.
+// The input metadata map has a "metadata key"->"metadata value" relation.
+// This function waits until merge finishes.
+func (media *Media) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	return mergeMetadataAndWait(media.client, media.Media.HREF, metadata)
+}
+
+// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver AdminCatalog and creates the ones not present.
+// The input metadata map has a "metadata key"->"metadata value" relation.
+// This function waits until merge finishes.
+func (adminCatalog *AdminCatalog) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	return mergeMetadataAndWait(adminCatalog.client, adminCatalog.AdminCatalog.HREF, metadata)
+}
+
+// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver AdminOrg and creates the ones not present.
+// The input metadata map has a "metadata key"->"metadata value" relation.
+// This function waits until merge finishes.
+func (adminOrg *AdminOrg) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	return mergeMetadataAndWait(adminOrg.client, adminOrg.AdminOrg.HREF, metadata)
+}
+
+// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver Disk and creates the ones not present.
+// The input metadata map has a "metadata key"->"metadata value" relation.
+// This function waits until merge finishes.
+// NOTE: If Client.OpenApiDiskMetadata is set, the disk has an OpenAPI ID and the negotiated API version is 38.0+
+// (VCD 10.5+), the OpenAPI metadata endpoint is used, so it doesn't depend on the XML HREF of the disk.
+func (disk *Disk) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	opts := disk.metadataRequestOptions(metadataOperationMerge)
+	opts.metadata = metadata
+	_, err := metadataRequest(disk.client, opts)
+	return err
+}
+
+// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver OrgVDCNetwork and creates the ones not present.
+// The input metadata map has a "metadata key"->"metadata value" relation.
+// This function waits until merge finishes.
+// Note: Org administrators can manage GENERAL metadata, while SYSTEM metadata requires system administrator
+// privileges. See OrgVDCNetwork.writeMetadata for details.
+func (orgVdcNetwork *OrgVDCNetwork) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	return orgVdcNetwork.writeMetadata(hasSystemMetadataValues(metadata), func(href string) error {
+		return mergeMetadataAndWait(orgVdcNetwork.client, href, metadata)
+	})
+}
+
+// MergeMetadataWithMetadataValues merges Vdc metadata provided as a key-value map of type `typedValue` with the already present in VCD,
+// then waits for the task to complete.
+// Note: The admin endpoint is used, hence it requires Org administrator privileges.
+func (vdc *Vdc) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	return mergeMetadataAndWait(vdc.client, getAdminURL(vdc.Vdc.HREF), metadata)
+}
+
+// MergeMetadataWithMetadataValues merges Catalog metadata provided as a key-value map of type `typedValue` with the already present in VCD,
+// then waits for the task to complete.
+// Note: The admin endpoint is used, hence it requires Org administrator privileges.
+func (catalog *Catalog) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	return mergeMetadataAndWait(catalog.client, getAdminURL(catalog.Catalog.HREF), metadata)
+}
+
+// MergeMetadataWithMetadataValues merges Org metadata provided as a key-value map of type `typedValue` with the already present in VCD,
+// then waits for the task to complete.
+// Note: The admin endpoint is used, hence it requires Org administrator privileges.
+func (org *Org) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	return mergeMetadataAndWait(org.client, getAdminURL(org.Org.HREF), metadata)
+}
+
+// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver CatalogItem and creates the ones not present.
+// The input metadata map has a "metadata key"->"metadata value" relation.
+// This function waits until merge finishes.
+func (catalogItem *CatalogItem) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	return catalogItem.subscribedMetadataError(mergeMetadataAndWait(catalogItem.client, catalogItem.CatalogItem.HREF, metadata))
+}
+
+// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver OpenApiOrgVdcNetwork and creates the ones not present.
+// The input metadata map has a "metadata key"->"metadata value" relation.
+// This function waits until merge finishes.
+// NOTE: If the network belongs to a VDC Group, the OpenAPI metadata endpoint is used, which requires VCD 10.5+.
+// Otherwise, the XML API is used.
+func (openApiOrgVdcNetwork *OpenApiOrgVdcNetwork) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	opts := openApiOrgVdcNetwork.metadataRequestOptions(metadataOperationMerge, true)
+	opts.metadata = metadata
+	_, err := metadataRequest(openApiOrgVdcNetwork.client, opts)
+	return err
+}
+
+// MergeMetadataWithMetadataValues updates the metadata values that are already present in the receiver NSX-T ALB Service Engine Group and creates the ones not present.
+// The input metadata map has a "metadata key"->"metadata value" relation.
+// NOTE: The OpenAPI metadata endpoint is used, which requires VCD 10.5+. A *MetadataNotSupportedError is returned
+// for older versions.
+// Note: Requires system administrator privileges.
+func (nsxtAlbServiceEngineGroup *NsxtAlbServiceEngineGroup) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	return mergeOpenApiMetadata(&nsxtAlbServiceEngineGroup.vcdClient.Client, types.OpenApiEndpointAlbServiceEngineGroupsMetadata, nsxtAlbServiceEngineGroup.NsxtAlbServiceEngineGroup.ID, nil, metadata)
+}
+
+// MergeSubscriptionMetadataWithMetadataValues is not supported, as VCD doesn't expose metadata for the subscription and
+// synchronization settings of a Catalog. It always returns a *MetadataNotSupportedError.
+// Use AdminCatalog.MergeMetadataWithMetadataValues to tag the subscribed Catalog itself.
+func (adminCatalog *AdminCatalog) MergeSubscriptionMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	return catalogSubscriptionMetadataNotSupported()
+}
+
+// MergeVmSizingMetadataWithMetadataValues is not supported, as VCD doesn't expose metadata for the VM sizing configuration
+// (CPU, memory and their reservations) of a VDC Compute Policy. It always returns a *MetadataNotSupportedError.
+func (vdcComputePolicy *VdcComputePolicyV2) MergeVmSizingMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	return vmSizingMetadataNotSupported()
+}
+
+// MergeMetadataWithMetadataValues is not supported, as VCD doesn't expose metadata for NSX-T Edge Gateway NAT rules.
+// It always returns a *MetadataNotSupportedError.
+func (nsxtNatRule *NsxtNatRule) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	return nsxtNatRuleMetadataNotSupported()
+}
+
+// MergeMetadataWithMetadataValues is not supported, as VCD doesn't expose metadata for NSX-T ALB Controllers.
+// It always returns a *MetadataNotSupportedError.
+func (nsxtAlbController *NsxtAlbController) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	return nsxtAlbControllerMetadataNotSupported()
+}
+
+// MergeMetadataWithMetadataValues is not supported, as VCD doesn't expose metadata for NSX-T ALB Clouds.
+// It always returns a *MetadataNotSupportedError.
+func (nsxtAlbCloud *NsxtAlbCloud) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	return nsxtAlbCloudMetadataNotSupported()
+}
+
+// MergeRoleAssignmentMetadataWithMetadataValues is not supported, as VCD doesn't expose metadata for the role assigned to an Org user.
+// It always returns a *MetadataNotSupportedError.
+func (orgUser *OrgUser) MergeRoleAssignmentMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	return orgUserRoleAssignmentMetadataNotSupported()
+}
+
+// MergeMetadataWithMetadataValues is not supported, as VCD doesn't expose metadata for VM affinity rules.
+// It always returns a *MetadataNotSupportedError.
+func (vmAffinityRule *VmAffinityRule) MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	return vmAffinityRuleMetadataNotSupported()
+}
+
+// MergeRoleMetadataWithMetadataValues is not supported, as VCD doesn't expose metadata for roles.
+// It always returns a *MetadataNotSupportedError.
+func (role *Role) MergeRoleMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	return roleMetadataNotSupported()
+}
+
+// MergeGlobalRoleMetadataWithMetadataValues is not supported, as VCD doesn't expose metadata for global roles.
+// It always returns a *MetadataNotSupportedError.
+func (globalRole *GlobalRole) MergeGlobalRoleMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error {
+	return globalRoleMetadataNotSupported()
+}
+
+// MergeStorageProfileMetadataWithMetadataValues merges the given metadata with the metadata of the storage profile
+// with the given HREF, and waits for the task to finish.
+// The storage profile can be a VDC storage profile or a Provider VDC storage profile.
+// Note: Requires system administrator privileges for Provider VDC storage profiles.
+func (vcdClient *VCDClient) MergeStorageProfileMetadataWithMetadataValues(storageProfileHref string, metadata map[string]types.MetadataValue) error {
+	return mergeMetadataAndWait(&vcdClient.Client, storageProfileHref, metadata)
+}
+
+// MergeNetworkPoolMetadataWithMetadataValues is not supported, as VCD doesn't expose metadata for network pools.
+// It always returns a *MetadataNotSupportedError.
+func (vcdClient *VCDClient) MergeNetworkPoolMetadataWithMetadataValues(networkPoolHref string, metadata map[string]types.MetadataValue) error {
+	return networkPoolMetadataNotSupported()
+}
+
+// MergeNsxtManagerMetadataWithMetadataValues is not supported, as VCD doesn't expose metadata for NSX-T Managers.
+// It always returns a *MetadataNotSupportedError.
+func (vcdClient *VCDClient) MergeNsxtManagerMetadataWithMetadataValues(nsxtManagerHref string, metadata map[string]types.MetadataValue) error {
+	return nsxtManagerMetadataNotSupported()
+}
+
+// MergeVAppSnapshotMetadataWithMetadataValues is not supported, as VCD doesn't expose metadata for vApp snapshots.
+// It always returns a *MetadataNotSupportedError.
+func (vcdClient *VCDClient) MergeVAppSnapshotMetadataWithMetadataValues(snapshotHref string, metadata map[string]types.MetadataValue) error {
+	return vAppSnapshotMetadataNotSupported()
+}
+
+// MergeUIPluginMetadataWithMetadataValues is not supported, as VCD doesn't expose metadata for UI plugins.
+// It always returns a *MetadataNotSupportedError.
+func (vcdClient *VCDClient) MergeUIPluginMetadataWithMetadataValues(pluginId string, metadata map[string]types.MetadataValue) error {
+	return uiPluginMetadataNotSupported()
+}
+
+// ------------------------------------------------------------------------------------------------
+// BUILD metadata to MERGE
+// ------------------------------------------------------------------------------------------------
+
+// MetadataValueBuilder builds the map of metadata values that MergeMetadataWithMetadataValues and the rest of merge
+// methods expect, filling the namespaces, types and domains of every entry. Example:
+//
+//	metadata, err := NewMetadataValueBuilder().
+//		AddString("owner", "team-a", types.MetadataReadWriteVisibility, false).
+//		AddNumber("cost-center", 1234, types.MetadataReadOnlyVisibility, true).
+//		Build()
+//	if err != nil {
+//		return err
+//	}
+//	err = vm.MergeMetadataWithMetadataValues(metadata)
+//
+// Adding the same key twice keeps the last value, as the merge operation can only receive one value per key.
+type MetadataValueBuilder struct {
+	metadata map[string]types.MetadataValue
+	errors   map[string]error
+}
+
+// NewMetadataValueBuilder returns an empty MetadataValueBuilder
+func NewMetadataValueBuilder() *MetadataValueBuilder {
+	return &MetadataValueBuilder{
+		metadata: map[string]types.MetadataValue{},
+		errors:   map[string]error{},
+	}
+}
+
+// AddString adds a types.MetadataStringValue entry with the given visibility to the SYSTEM domain if isSystem=true,
+// or to the GENERAL domain otherwise
+func (builder *MetadataValueBuilder) AddString(key, value, visibility string, isSystem bool) *MetadataValueBuilder {
+	return builder.add(key, value, types.MetadataStringValue, visibility, isSystem)
+}
+
+// AddNumber adds a types.MetadataNumberValue entry with the given visibility to the SYSTEM domain if isSystem=true,
+// or to the GENERAL domain otherwise
+func (builder *MetadataValueBuilder) AddNumber(key string, value int64, visibility string, isSystem bool) *MetadataValueBuilder {
+	return builder.add(key, strconv.FormatInt(value, 10), types.MetadataNumberValue, visibility, isSystem)
+}
+
+// AddBool adds a types.MetadataBooleanValue entry with the given visibility to the SYSTEM domain if isSystem=true,
+// or to the GENERAL domain otherwise
+func (builder *MetadataValueBuilder) AddBool(key string, value bool, visibility string, isSystem bool) *MetadataValueBuilder {
+	return builder.add(key, strconv.FormatBool(value), types.MetadataBooleanValue, visibility, isSystem)
+}
+
+// AddDateTime adds a types.MetadataDateTimeValue entry, formatted with FormatMetadataDateTime, with the given
+// visibility to the SYSTEM domain if isSystem=true, or to the GENERAL domain otherwise
+func (builder *MetadataValueBuilder) AddDateTime(key string, value time.Time, visibility string, isSystem bool) *MetadataValueBuilder {
+	return builder.add(key, FormatMetadataDateTime(value), types.MetadataDateTimeValue, visibility, isSystem)
+}
+
+// Build returns the metadata values that were added to the builder, ready to be merged. It returns a
+// *MetadataMultiError, indexed by metadata key, if any entry has a visibility that is not allowed in its domain,
+// like types.MetadataReadWriteVisibility in SYSTEM domain.
+func (builder *MetadataValueBuilder) Build() (map[string]types.MetadataValue, error) {
+	if len(builder.errors) > 0 {
+		multiError := newMetadataMultiError("building metadata")
+		multiError.addAll(builder.errors)
+		return nil, multiError
+	}
+	metadata := make(map[string]types.MetadataValue, len(builder.metadata))
+	for key, value := range builder.metadata {
+		metadata[key] = value
+	}
+	return metadata, nil
+}
+
+// add stores the given entry in the builder, replacing any previous entry or error with the same key
+func (builder *MetadataValueBuilder) add(key, value, typedValue, visibility string, isSystem bool) *MetadataValueBuilder {
+	delete(builder.metadata, key)
+	delete(builder.errors, key)
+
+	metadataValue, err := newMetadataValue(value, typedValue, visibility, isSystem)
+	if err != nil {
+		builder.errors[key] = err
+		return builder
+	}
+	builder.metadata[key] = metadataValue
+	return builder
+}
+
+// NewStringMetadataValue returns a types.MetadataStringValue value with the given visibility in the SYSTEM domain if
+// isSystem=true, or in the GENERAL domain otherwise, ready to be used with MergeMetadataWithMetadataValues.
+// It returns an error if the visibility is not allowed in the domain, like types.MetadataReadWriteVisibility in SYSTEM
+// domain.
+func NewStringMetadataValue(value, visibility string, isSystem bool) (types.MetadataValue, error) {
+	return newMetadataValue(value, types.MetadataStringValue, visibility, isSystem)
+}
+
+// NewNumberMetadataValue returns a types.MetadataNumberValue value. See NewStringMetadataValue for details.
+func NewNumberMetadataValue(value int64, visibility string, isSystem bool) (types.MetadataValue, error) {
+	return newMetadataValue(strconv.FormatInt(value, 10), types.MetadataNumberValue, visibility, isSystem)
+}
+
+// NewBoolMetadataValue returns a types.MetadataBooleanValue value. See NewStringMetadataValue for details.
+func NewBoolMetadataValue(value bool, visibility string, isSystem bool) (types.MetadataValue, error) {
+	return newMetadataValue(strconv.FormatBool(value), types.MetadataBooleanValue, visibility, isSystem)
+}
+
+// NewDateTimeMetadataValue returns a types.MetadataDateTimeValue value, formatted with FormatMetadataDateTime. See
+// NewStringMetadataValue for details.
+func NewDateTimeMetadataValue(value time.Time, visibility string, isSystem bool) (types.MetadataValue, error) {
+	return newMetadataValue(FormatMetadataDateTime(value), types.MetadataDateTimeValue, visibility, isSystem)
+}
+
+// metadataDateTimeLayout is the layout of the types.MetadataDateTimeValue values written by the SDK, which is the one
+// that VCD uses when returning them: UTC with millisecond precision, such as "2023-03-14T15:09:26.000Z"
+const metadataDateTimeLayout = "2006-01-02T15:04:05.000Z"
+
+// FormatMetadataDateTime formats the given time as a types.MetadataDateTimeValue value that VCD accepts. The time is
+// converted to UTC, as VCD stores the instant without its time zone, and truncated to milliseconds, which is the
+// highest precision VCD keeps. Monotonic clock readings are dropped.
+func FormatMetadataDateTime(t time.Time) string {
+	return t.UTC().Truncate(time.Millisecond).Format(metadataDateTimeLayout)
+}
+
+// ParseMetadataDateTime parses a types.MetadataDateTimeValue value, as returned by VCD or FormatMetadataDateTime.
+// Any RFC3339 time is accepted, with or without fractional seconds, as well as time zone offsets without colon
+// such as "+0200". The returned time keeps the time zone of the value.
+func ParseMetadataDateTime(s string) (time.Time, error) {
+	dateTime, err := time.Parse(time.RFC3339Nano, s)
+	if err == nil {
+		return dateTime, nil
+	}
+	dateTime, offsetErr := time.Parse("2006-01-02T15:04:05.999999999Z0700", s)
+	if offsetErr == nil {
+		return dateTime, nil
+	}
+	return time.Time{}, fmt.Errorf("'%s' is not a valid metadata date time: %s", s, err)
+}
+
+// newMetadataValue returns a metadata value with its namespaces, type and domain filled, after checking that the
+// visibility is allowed in the domain
+func newMetadataValue(value, typedValue, visibility string, isSystem bool) (types.MetadataValue, error) {
+	err := validateMetadataVisibility(visibility, isSystem)
+	if err != nil {
+		return types.MetadataValue{}, err
+	}
+
+	domain := "GENERAL"
+	if isSystem {
+		domain = "SYSTEM"
+	}
+	return types.MetadataValue{
+		Xmlns: types.XMLNamespaceVCloud,
+		Xsi:   types.XMLNamespaceXSI,
+		TypedValue: &types.MetadataTypedValue{
+			XsiType: typedValue,
+			Value:   value,
+		},
+		Domain: &types.MetadataDomainTag{
+			Visibility: visibility,
+			Domain:     domain,
+		},
+	}, nil
+}
+
+// ------------------------------------------------------------------------------------------------
+// FILTER metadata
+// ------------------------------------------------------------------------------------------------
+
+// FilterMetadata returns a copy of the given metadata with only the entries for which the predicate returns true.
+// The input metadata is not modified, and the returned copy keeps its Xmlns, Xsi, HREF and Type. The predicates
+// returned by FilterByKeyPrefix and FilterByDomain can be used, for example:
+//
+//	owned := FilterMetadata(metadata, FilterByKeyPrefix("myapp."))
+func FilterMetadata(metadata *types.Metadata, predicate func(types.MetadataEntry) bool) *types.Metadata {
+	if metadata == nil {
+		return nil
+	}
+	filtered := &types.Metadata{
+		XMLName: metadata.XMLName,
+		Xmlns:   metadata.Xmlns,
+		HREF:    metadata.HREF,
+		Type:    metadata.Type,
+		Xsi:     metadata.Xsi,
+	}
+	for _, entry := range metadata.MetadataEntry {
+		if entry == nil || !predicate(*entry) {
+			continue
+		}
+		filtered.MetadataEntry = append(filtered.MetadataEntry, copyMetadataEntry(entry))
+	}
+	return filtered
+}
+
+// FilterByKeyPrefix returns a FilterMetadata predicate that selects the entries whose key starts with the given prefix
+func FilterByKeyPrefix(prefix string) func(types.MetadataEntry) bool {
+	return func(entry types.MetadataEntry) bool {
+		return strings.HasPrefix(entry.Key, prefix)
+	}
+}
+
+// FilterByDomain returns a FilterMetadata predicate that selects the entries of the SYSTEM domain if isSystem=true,
+// or of the GENERAL domain otherwise. Entries without Domain belong to the GENERAL domain.
+func FilterByDomain(isSystem bool) func(types.MetadataEntry) bool {
+	return func(entry types.MetadataEntry) bool {
+		return (effectiveMetadataDomain(entry.Domain).Domain == "SYSTEM") == isSystem
+	}
+}
+
+// ------------------------------------------------------------------------------------------------
+// JSON metadata
+// ------------------------------------------------------------------------------------------------
+
+// MetadataJSONEntry is the value of a metadata entry in the JSON object produced by MarshalJSONMetadata, which is
+// indexed by domain and key
+type MetadataJSONEntry struct {
+	Value      string `json:"value"`
+	Type       string `json:"type"`       // One of types.MetadataStringValue, types.MetadataNumberValue, types.MetadataDateTimeValue or types.MetadataBooleanValue
+	Domain     string `json:"domain"`     // GENERAL or SYSTEM
+	Visibility string `json:"visibility"` // One of types.MetadataReadWriteVisibility, types.MetadataReadOnlyVisibility or types.MetadataHiddenVisibility
+}
+
+// MarshalJSONMetadata converts the given metadata to a JSON object of domain -> key -> {value, type, domain,
+// visibility}, without the XML namespaces, links and references, like:
+//
+//	{"GENERAL": {"tier": {...}}, "SYSTEM": {"tier": {...}}}
+//
+// Entries are grouped by domain, as the same key can be present in both domains, and keys are kept as they are.
+// Entries without Domain are converted as GENERAL domain with types.MetadataReadWriteVisibility. Domains without
+// entries are omitted.
+func MarshalJSONMetadata(metadata *types.Metadata) ([]byte, error) {
+	domains := map[string]map[string]MetadataJSONEntry{}
+	if metadata != nil {
+		for _, entry := range metadata.MetadataEntry {
+			if entry == nil || entry.TypedValue == nil {
+				continue
+			}
+			domain := effectiveMetadataDomain(entry.Domain)
+			entries, found := domains[domain.Domain]
+			if !found {
+				entries = map[string]MetadataJSONEntry{}
+				domains[domain.Domain] = entries
+			}
+			if _, found = entries[entry.Key]; found {
+				return nil, fmt.Errorf("error converting metadata to JSON: key '%s' is present more than once in %s domain", entry.Key, domain.Domain)
+			}
+			entries[entry.Key] = MetadataJSONEntry{
+				Value:      entry.TypedValue.Value,
+				Type:       entry.TypedValue.XsiType,
+				Domain:     domain.Domain,
+				Visibility: domain.Visibility,
+			}
+		}
+	}
+	return json.Marshal(domains)
+}
+
+// UnmarshalJSONMetadata converts a JSON object produced by MarshalJSONMetadata back to metadata, with its entries sorted
+// by key, and GENERAL entries before SYSTEM ones for the same key. The domain is taken from the object that contains
+// the entry, and the domain field of the entry, if present, must match it. Each entry is validated as in addMetadata,
+// and GENERAL entries without visibility get types.MetadataReadWriteVisibility.
+func UnmarshalJSONMetadata(data []byte) (*types.Metadata, error) {
+	domains := map[string]map[string]MetadataJSONEntry{}
+	err := json.Unmarshal(data, &domains)
+	if err != nil {
+		return nil, fmt.Errorf("error converting JSON to metadata: %s", err)
+	}
+
+	metadata := &types.Metadata{
+		Xmlns: types.XMLNamespaceVCloud,
+		Xsi:   types.XMLNamespaceXSI,
+	}
+	for domainName, entries := range domains {
+		if domainName != "GENERAL" && domainName != "SYSTEM" {
+			return nil, &MetadataValidationError{Key: domainName, Reason: fmt.Sprintf("unknown domain '%s', expected GENERAL or SYSTEM", domainName), Err: ErrInvalidMetadataValue}
+		}
+		for key, entry := range entries {
+			if entry.Domain != "" && entry.Domain != domainName {
+				return nil, &MetadataValidationError{Key: key, Reason: fmt.Sprintf("domain '%s' doesn't match the enclosing domain '%s'", entry.Domain, domainName), Err: ErrInvalidMetadataValue}
+			}
+			domain := effectiveMetadataDomain(&types.MetadataDomainTag{Domain: domainName, Visibility: entry.Visibility})
+			err = validateMetadataEntry(key, entry.Value, entry.Type, domain.Visibility)
+			if err != nil {
+				return nil, err
+			}
+			metadata.MetadataEntry = append(metadata.MetadataEntry, &types.MetadataEntry{
+				Xmlns:      types.XMLNamespaceVCloud,
+				Xsi:        types.XMLNamespaceXSI,
+				Key:        key,
+				TypedValue: &types.MetadataTypedValue{XsiType: entry.Type, Value: entry.Value},
+				Domain:     &domain,
+			})
+		}
+	}
+	sort.Slice(metadata.MetadataEntry, func(i, j int) bool {
+		if metadata.MetadataEntry[i].Key != metadata.MetadataEntry[j].Key {
+			return metadata.MetadataEntry[i].Key < metadata.MetadataEntry[j].Key
+		}
+		return metadata.MetadataEntry[i].Domain.Domain < metadata.MetadataEntry[j].Domain.Domain
+	})
+	return metadata, nil
+}
+
+// ------------------------------------------------------------------------------------------------
+// PROPERTIES metadata
+// ------------------------------------------------------------------------------------------------
+
+// metadataPropertiesTypes maps the type annotations accepted by ParseMetadataProperties to metadata types
+var metadataPropertiesTypes = map[string]string{
+	"string":   types.MetadataStringValue,
+	"number":   types.MetadataNumberValue,
+	"datetime": types.MetadataDateTimeValue,
+	"bool":     types.MetadataBooleanValue,
+	"boolean":  types.MetadataBooleanValue,
+}
+
+// ParseMetadataProperties reads metadata from a .properties-style input, with one "key=value" entry per line, and
+// returns it as a map that can be passed to MergeMetadataWithMetadataValues. The format is:
+//   - Blank lines, and lines starting with '#' or '!', are skipped. Keys and values are trimmed.
+//   - The type of a value can be annotated after the key, like "replicas:number=3". The accepted annotations are
+//     "string", "number", "datetime", "bool" and "boolean". Entries without annotation get defaultType.
+//   - Keys that contain ':', '=' or '\' must escape them with a backslash, like "app\:tier=web" for the key
+//     "app:tier". Only an unescaped ':' starts a type annotation.
+//   - All entries get defaultVisibility, in SYSTEM domain when isSystem=true or in GENERAL domain otherwise.
+//
+// Entries are validated as in AddMetadataEntryWithVisibility. Malformed lines, invalid entries and duplicated keys
+// make it fail with an error that contains the line number.
+func ParseMetadataProperties(r io.Reader, defaultType, defaultVisibility string, isSystem bool) (map[string]types.MetadataValue, error) {
+	err := validateMetadataVisibility(defaultVisibility, isSystem)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing metadata properties: %s", err)
+	}
+	domain := "GENERAL"
+	if isSystem {
+		domain = "SYSTEM"
+	}
+
+	metadata := map[string]types.MetadataValue{}
+	lines := map[string]int{}
+	scanner := bufio.NewScanner(r)
+	// The longest valid line contains a key, a type annotation and a value of the maximum length allowed by VCD
+	scanner.Buffer(make([]byte, 0, 4096), 4*(maxMetadataKeyLength+maxMetadataValueLength)+64)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			continue
+		}
+
+		key, annotation, value, hasAnnotation, found := splitMetadataProperty(line)
+		if !found {
+			return nil, fmt.Errorf("error parsing metadata properties at line %d: missing '=' separator", lineNumber)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		typedValue := defaultType
+		if hasAnnotation {
+			annotation = strings.ToLower(strings.TrimSpace(annotation))
+			annotatedType, isKnown := metadataPropertiesTypes[annotation]
+			if !isKnown {
+				return nil, fmt.Errorf("error parsing metadata properties at line %d: unknown type annotation '%s'", lineNumber, annotation)
+			}
+			typedValue = annotatedType
+		}
+
+		if previousLine, isDuplicated := lines[key]; isDuplicated {
+			return nil, fmt.Errorf("error parsing metadata properties at line %d: key '%s' was already defined at line %d", lineNumber, key, previousLine)
+		}
+		err = validateMetadataEntry(key, value, typedValue, defaultVisibility)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing metadata properties at line %d: %s", lineNumber, err)
+		}
+		_, err = parseMetadataTypedValue(&types.MetadataTypedValue{XsiType: typedValue, Value: value})
+		if err != nil {
+			return nil, fmt.Errorf("error parsing metadata properties at line %d: %s", lineNumber, err)
+		}
+
+		lines[key] = lineNumber
+		metadata[key] = types.MetadataValue{
+			Xmlns:      types.XMLNamespaceVCloud,
+			Xsi:        types.XMLNamespaceXSI,
+			TypedValue: &types.MetadataTypedValue{XsiType: typedValue, Value: value},
+			Domain:     &types.MetadataDomainTag{Visibility: defaultVisibility, Domain: domain},
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading metadata properties after line %d: %s", lineNumber, err)
+	}
+	return metadata, nil
+}
+
+// splitMetadataProperty splits a line of ParseMetadataProperties input into the key, the type annotation and the
+// value. The key ends at the first unescaped '=', and the annotation starts after the last unescaped ':' of the key.
+// Backslash escapes are removed from the key. It returns found=false if the line has no unescaped '='.
+func splitMetadataProperty(line string) (key, annotation, value string, hasAnnotation, found bool) {
+	var keyBuilder strings.Builder
+	annotationStart := -1
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line):
+			i++
+			keyBuilder.WriteByte(line[i])
+		case line[i] == ':':
+			annotationStart = keyBuilder.Len()
+			keyBuilder.WriteByte(line[i])
+		case line[i] == '=':
+			key = keyBuilder.String()
+			if annotationStart >= 0 {
+				return key[:annotationStart], key[annotationStart+1:], line[i+1:], true, true
+			}
+			return key, "", line[i+1:], false, true
+		default:
+			keyBuilder.WriteByte(line[i])
+		}
+	}
+	return "", "", "", false, false
+}
+
+// ------------------------------------------------------------------------------------------------
+// STRUCT metadata
+// ------------------------------------------------------------------------------------------------
+
+// metadataStructTag is the struct tag read by StructToMetadata and MetadataToStruct
+const metadataStructTag = "vcdmeta"
+
+// StructToMetadata converts the given struct, or pointer to struct, to a map that can be passed to
+// MergeMetadataWithMetadataValues. Every field with a `vcdmeta:"key,type,visibility,system"` tag becomes an entry:
+//   - key is the metadata key, and it is required.
+//   - type is optional, and it is one of "string", "number", "datetime", "bool" and "boolean", or one of the metadata
+//     types like types.MetadataStringValue. It must match the field type: string fields are types.MetadataStringValue,
+//     int64 fields are types.MetadataNumberValue, bool fields are types.MetadataBooleanValue and time.Time fields are
+//     types.MetadataDateTimeValue, formatted with FormatMetadataDateTime. When it is omitted, it is taken from the
+//     field type.
+//   - visibility is optional, and it defaults to types.MetadataReadWriteVisibility in GENERAL domain and
+//     types.MetadataReadOnlyVisibility in SYSTEM domain.
+//   - system is optional, and it puts the entry in SYSTEM domain when it is the word "system".
+//
+// For example:
+//
+//	type VmLabels struct {
+//		Owner     string    `vcdmeta:"owner"`
+//		Replicas  int64     `vcdmeta:"replicas,number"`
+//		CreatedAt time.Time `vcdmeta:"createdAt,,READONLY,system"`
+//	}
+//
+// Fields without tag, or with the tag "-", are ignored. Unsupported field types, malformed tags, visibilities that are
+// not allowed in their domain and duplicated keys in the same domain make it fail.
+func StructToMetadata(v interface{}) (map[string]types.MetadataValue, error) {
+	structValue := reflect.ValueOf(v)
+	if structValue.Kind() == reflect.Ptr {
+		structValue = structValue.Elem()
+	}
+	if structValue.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("error converting struct to metadata: expected a struct or a pointer to struct, got %T", v)
+	}
+	fields, err := metadataStructFields(structValue.Type())
+	if err != nil {
+		return nil, fmt.Errorf("error converting struct to metadata: %s", err)
+	}
+
+	metadata := make(map[string]types.MetadataValue, len(fields))
+	for _, field := range fields {
+		fieldValue := structValue.Field(field.index)
+		var value string
+		switch field.typedValue {
+		case types.MetadataStringValue:
+			value = fieldValue.String()
+		case types.MetadataNumberValue:
+			value = strconv.FormatInt(fieldValue.Int(), 10)
+		case types.MetadataBooleanValue:
+			value = strconv.FormatBool(fieldValue.Bool())
+		case types.MetadataDateTimeValue:
+			value = FormatMetadataDateTime(fieldValue.Interface().(time.Time))
+		}
+		metadataValue, err := newMetadataValue(value, field.typedValue, field.visibility, field.isSystem)
+		if err != nil {
+			return nil, fmt.Errorf("error converting struct to metadata: field '%s': %s", field.name, err)
+		}
+		metadata[field.key] = metadataValue
+	}
+	return metadata, nil
+}
+
+// MetadataToStruct populates the fields of the struct pointed by v that have a `vcdmeta` tag with the values of the
+// given metadata, as described in StructToMetadata. Fields whose key is not present in their domain are left
+// untouched. An entry whose type doesn't match the field type, or whose value can't be parsed, makes it fail.
+func MetadataToStruct(metadata *types.Metadata, v interface{}) error {
+	pointerValue := reflect.ValueOf(v)
+	if pointerValue.Kind() != reflect.Ptr || pointerValue.IsNil() || pointerValue.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("error converting metadata to struct: expected a non-nil pointer to struct, got %T", v)
+	}
+	if metadata == nil {
+		return fmt.Errorf("error converting metadata to struct: metadata is nil")
+	}
+	structValue := pointerValue.Elem()
+	fields, err := metadataStructFields(structValue.Type())
+	if err != nil {
+		return fmt.Errorf("error converting metadata to struct: %s", err)
+	}
+
+	for _, field := range fields {
+		entry, found := metadata.GetByKey(field.key, field.isSystem)
+		if !found {
+			continue
+		}
+		if entry.TypedValue == nil || entry.TypedValue.XsiType != field.typedValue {
+			return fmt.Errorf("error converting metadata to struct: field '%s': metadata key '%s' is not a %s", field.name, field.key, field.typedValue)
+		}
+		value, err := parseMetadataTypedValue(entry.TypedValue)
+		if err != nil {
+			return fmt.Errorf("error converting metadata to struct: field '%s': %s", field.name, err)
+		}
+		fieldValue := structValue.Field(field.index)
+		fieldValue.Set(reflect.ValueOf(value).Convert(fieldValue.Type()))
+	}
+	return nil
+}
+
+// metadataStructField is a struct field with a valid `vcdmeta` tag
+type metadataStructField struct {
+	name       string
+	index      int
+	key        string
+	typedValue string
+	visibility string
+	isSystem   bool
+}
+
+// metadataStructFields returns the fields of the given struct type that have a `vcdmeta` tag, checking that their
+// tags are well-formed, their types are supported and their keys are unique in each domain
+func metadataStructFields(structType reflect.Type) ([]metadataStructField, error) {
+	var fields []metadataStructField
+	seen := map[string]string{}
+	for index := 0; index < structType.NumField(); index++ {
+		structField := structType.Field(index)
+		tag, hasTag := structField.Tag.Lookup(metadataStructTag)
+		if !hasTag || tag == "-" {
+			continue
+		}
+		if structField.PkgPath != "" {
+			return nil, fmt.Errorf("field '%s' is not exported", structField.Name)
+		}
+
+		fieldType, err := metadataTypeOfField(structField.Type)
+		if err != nil {
+			return nil, fmt.Errorf("field '%s': %s", structField.Name, err)
+		}
+		field, err := parseMetadataStructTag(tag, fieldType)
+		if err != nil {
+			return nil, fmt.Errorf("field '%s': malformed tag '%s': %s", structField.Name, tag, err)
+		}
+		field.name, field.index = structField.Name, index
+
+		domainKey := metadataDomainName(field.isSystem) + "/" + field.key
+		if previous, isDuplicated := seen[domainKey]; isDuplicated {
+			return nil, fmt.Errorf("field '%s': key '%s' is already used by field '%s' in the same domain", structField.Name, field.key, previous)
+		}
+		seen[domainKey] = structField.Name
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// metadataTypeOfField returns the metadata type that corresponds to the given struct field type
+func metadataTypeOfField(fieldType reflect.Type) (string, error) {
+	switch {
+	case fieldType == reflect.TypeOf(time.Time{}):
+		return types.MetadataDateTimeValue, nil
+	case fieldType.Kind() == reflect.String:
+		return types.MetadataStringValue, nil
+	case fieldType.Kind() == reflect.Int64:
+		return types.MetadataNumberValue, nil
+	case fieldType.Kind() == reflect.Bool:
+		return types.MetadataBooleanValue, nil
+	}
+	return "", fmt.Errorf("unsupported type %s, it must be string, int64, bool or time.Time", fieldType)
+}
+
+// parseMetadataStructTag parses a `vcdmeta:"key,type,visibility,system"` tag of a field whose type corresponds to the
+// given metadata type
+func parseMetadataStructTag(tag, fieldType string) (metadataStructField, error) {
+	parts := strings.Split(tag, ",")
+	if len(parts) > 4 {
+		return metadataStructField{}, fmt.Errorf("expected at most 4 comma-separated parts, got %d", len(parts))
+	}
+	for len(parts) < 4 {
+		parts = append(parts, "")
+	}
+	for index := range parts {
+		parts[index] = strings.TrimSpace(parts[index])
+	}
+
+	field := metadataStructField{key: parts[0], typedValue: fieldType}
+	if field.key == "" {
+		return metadataStructField{}, fmt.Errorf("the key is required")
+	}
+	switch parts[3] {
+	case "":
+	case "system":
+		field.isSystem = true
+	default:
+		return metadataStructField{}, fmt.Errorf("unknown domain flag '%s', it must be 'system' or empty", parts[3])
+	}
+
+	if parts[1] != "" {
+		typedValue, isKnown := metadataPropertiesTypes[strings.ToLower(parts[1])]
+		if !isKnown {
+			metadataType, err := types.ParseMetadataType(parts[1])
+			if err != nil {
+				return metadataStructField{}, err
+			}
+			typedValue = string(metadataType)
+		}
+		if typedValue != fieldType {
+			return metadataStructField{}, fmt.Errorf("type %s doesn't match the field type, which is %s", typedValue, fieldType)
+		}
+	}
+
+	field.visibility = types.MetadataReadWriteVisibility
+	if field.isSystem {
+		field.visibility = types.MetadataReadOnlyVisibility
+	}
+	if parts[2] != "" {
+		visibility, err := types.ParseMetadataVisibility(parts[2])
+		if err != nil {
+			return metadataStructField{}, err
+		}
+		field.visibility = string(visibility)
+	}
+	return field, nil
+}
+
+// ------------------------------------------------------------------------------------------------
+// GET metadata change history
+// ------------------------------------------------------------------------------------------------
+
+// Names of the VCD tasks that change metadata, as found in the task history
+const (
+	MetadataTaskUpdate = "metadataUpdate" // Entries were added or updated
+	MetadataTaskDelete = "metadataDelete" // An entry was deleted
+)
+
+// MetadataChangeRecord is a metadata change found in the task history of an entity.
+// VCD task records don't identify the changed keys, so Description is the only detail about the change. To track every
+// key changed by the SDK, use Client.OnMetadataChange.
+type MetadataChangeRecord struct {
+	Operation   string    // Name of the task, MetadataTaskUpdate or MetadataTaskDelete
+	Description string    // Human-readable description of the task, as reported by VCD
+	User        string    // Name of the user that started the task
+	Status      string    // Status of the task, such as "success" or "error"
+	StartDate   time.Time // When the change started
+	EndDate     time.Time // When the change finished. Zero if the task is still running
+	TaskHref    string    // HREF of the task
+}
+
+// GetMetadataHistory returns the metadata changes of the receiver VM found in the task history, sorted from the oldest
+// to the most recent. The history only contains the tasks that VCD still retains.
+func (vm *VM) GetMetadataHistory() ([]MetadataChangeRecord, error) {
+	return getMetadataHistory(vm.client, vm.VM.HREF)
+}
+
+// ------------------------------------------------------------------------------------------------
+// SYNC security tags and metadata
+// ------------------------------------------------------------------------------------------------
+
+// SyncSecurityTagsToMetadata mirrors the security tags of the receiver VM into GENERAL domain metadata: every tag is
+// stored with key prefix+tag and the tag as types.MetadataStringValue. The GENERAL entries whose key starts with
+// prefix but don't correspond to a tag anymore are deleted, and the rest of the metadata is not modified.
+// The prefix can't be empty, as every GENERAL entry would be considered as a mirrored tag.
+// Security tags require API v36.0 (VCD 10.3.0+).
+func (vm *VM) SyncSecurityTagsToMetadata(prefix string) error {
+	if prefix == "" {
+		return fmt.Errorf("the metadata key prefix for security tags can't be empty")
+	}
+	securityTags, err := vm.GetVMSecurityTags()
+	if err != nil {
+		return fmt.Errorf("error getting security tags of VM %s: %s", vm.VM.Name, err)
+	}
+	metadata, err := vm.GetMetadata()
+	if err != nil {
+		return fmt.Errorf("error getting metadata of VM %s: %s", vm.VM.Name, err)
+	}
+
+	desired := map[string]types.MetadataValue{}
+	for _, tag := range securityTags.Tags {
+		desired[prefix+tag] = types.MetadataValue{
+			Domain:     &types.MetadataDomainTag{Domain: "GENERAL", Visibility: types.MetadataReadWriteVisibility},
+			TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: tag},
+		}
+	}
+	toMerge, toDelete := DiffMetadata(securityTagsMetadata(metadata, prefix), desired)
+	if len(toMerge) > 0 {
+		err = vm.MergeMetadataWithMetadataValues(toMerge)
+		if err != nil {
+			return fmt.Errorf("error adding security tags to metadata of VM %s: %s", vm.VM.Name, err)
+		}
+	}
+	if len(toDelete) > 0 {
+		err = vm.DeleteMetadataEntriesWithDomain(toDelete, false)
+		if err != nil {
+			return fmt.Errorf("error removing security tags from metadata of VM %s: %s", vm.VM.Name, err)
+		}
+	}
+	return nil
+}
+
+// SyncMetadataToSecurityTags sets the security tags of the receiver VM to the ones mirrored in its GENERAL domain
+// metadata, that is, the keys that start with prefix, without it. This is the inverse of SyncSecurityTagsToMetadata:
+// the security tags of the VM that are not mirrored in metadata are removed. Tags are converted to lower-case, as VCD
+// does, and the security tags are not updated if they are already the same.
+// The prefix can't be empty, as every GENERAL entry would be considered as a mirrored tag.
+// Security tags require API v36.0 (VCD 10.3.0+).
+func (vm *VM) SyncMetadataToSecurityTags(prefix string) error {
+	if prefix == "" {
+		return fmt.Errorf("the metadata key prefix for security tags can't be empty")
+	}
+	metadata, err := vm.GetMetadata()
+	if err != nil {
+		return fmt.Errorf("error getting metadata of VM %s: %s", vm.VM.Name, err)
+	}
+	securityTags, err := vm.GetVMSecurityTags()
+	if err != nil {
+		return fmt.Errorf("error getting security tags of VM %s: %s", vm.VM.Name, err)
+	}
+
+	desiredTags := []string{}
+	for _, entry := range securityTagsMetadata(metadata, prefix).MetadataEntry {
+		tag := strings.ToLower(strings.TrimPrefix(entry.Key, prefix))
+		if tag != "" && !contains(tag, desiredTags) {
+			desiredTags = append(desiredTags, tag)
+		}
+	}
+	sort.Strings(desiredTags)
+
+	currentTags := []string{}
+	for _, tag := range securityTags.Tags {
+		currentTags = append(currentTags, strings.ToLower(tag))
+	}
+	sort.Strings(currentTags)
+	if reflect.DeepEqual(currentTags, desiredTags) {
+		return nil
+	}
+
+	_, err = vm.UpdateVMSecurityTags(&types.EntitySecurityTags{Tags: desiredTags})
+	if err != nil {
+		return fmt.Errorf("error updating security tags of VM %s: %s", vm.VM.Name, err)
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------
+// DIFF metadata
+// ------------------------------------------------------------------------------------------------
+
+// DiffMetadata computes the minimal set of changes to transform the current metadata into the desired one:
+//   - toMerge contains the desired entries whose value, type, domain or visibility differ from the current ones, or
+//     that don't exist yet. Values are compared in their canonical form, so "01" and "1" are the same number.
+//   - toDelete contains the keys, sorted alphabetically, that are present in the current metadata but absent from the
+//     desired one.
+//
+// The desired entries without Domain are considered as GENERAL domain with types.MetadataReadWriteVisibility.
+// As the desired metadata can only hold one entry per key, an entry of the current metadata is kept when its key is
+// desired in another domain. The result can be passed to MergeMetadataWithMetadataValues and
+// DeleteMetadataEntriesWithDomain.
+func DiffMetadata(current *types.Metadata, desired map[string]types.MetadataValue) (toMerge map[string]types.MetadataValue, toDelete []string) {
+	toMerge = map[string]types.MetadataValue{}
+	for key, desiredValue := range desired {
+		desiredValue := desiredValue
+		isSystem := effectiveMetadataDomain(desiredValue.Domain).Domain == "SYSTEM"
+		entry := findMetadataEntry(current, key, isSystem)
+		if entry != nil && metadataValuesMatch(&desiredValue, &types.MetadataValue{Domain: entry.Domain, TypedValue: entry.TypedValue}) {
+			continue
+		}
+		toMerge[key] = desiredValue
+	}
+
+	toDelete = []string{}
+	if current != nil {
+		seen := map[string]bool{}
+		for _, entry := range current.MetadataEntry {
+			if entry == nil || seen[entry.Key] {
+				continue
+			}
+			if _, isDesired := desired[entry.Key]; !isDesired {
+				toDelete = append(toDelete, entry.Key)
+				seen[entry.Key] = true
+			}
+		}
+	}
+	sort.Strings(toDelete)
+	return toMerge, toDelete
+}
+
+// ------------------------------------------------------------------------------------------------
+// REPLACE all metadata
+// ------------------------------------------------------------------------------------------------
+
+// ReplaceAllMetadataByHref makes the metadata of the given domain of the given resource reference match exactly the
+// given metadata. See replaceAllMetadata for details.
+func (vcdClient *VCDClient) ReplaceAllMetadataByHref(href string, metadata map[string]types.MetadataValue, isSystem bool) error {
+	return replaceAllMetadata(&vcdClient.Client, href, metadata, isSystem)
+}
+
+// ReplaceAllMetadata makes the metadata of the given domain of the receiver VM match exactly the given metadata.
+// See replaceAllMetadata for details.
+func (vm *VM) ReplaceAllMetadata(metadata map[string]types.MetadataValue, isSystem bool) error {
+	return replaceAllMetadata(vm.client, vm.VM.HREF, metadata, isSystem)
+}
+
+// ------------------------------------------------------------------------------------------------
+// PLAN metadata changes without applying them (dry-run)
+// ------------------------------------------------------------------------------------------------
+
+// MetadataValueChange is a metadata entry that would be updated, with its current and new values
+type MetadataValueChange struct {
+	Old types.MetadataValue
+	New types.MetadataValue
+}
+
+// MetadataChangePlan contains the changes that a metadata operation would perform, computed without modifying any
+// metadata
+type MetadataChangePlan struct {
+	ToAdd    map[string]types.MetadataValue // Entries that don't exist yet, by key
+	ToUpdate map[string]MetadataValueChange // Entries whose value, type, domain or visibility would change, by key
+	ToDelete []string                       // Keys that would be deleted, sorted alphabetically
+}
+
+// MergeMetadataWithVisibilityByHrefDryRun returns the changes that merging the given metadata into the given resource
+// reference would perform, without modifying it. See planMergeMetadata for details.
+func (vcdClient *VCDClient) MergeMetadataWithVisibilityByHrefDryRun(href string, metadata map[string]types.MetadataValue) (MetadataChangePlan, error) {
+	return planMergeMetadata(&vcdClient.Client, href, metadata)
+}
+
+// ReplaceAllMetadataByHrefDryRun returns the changes that ReplaceAllMetadataByHref would perform, without modifying the
+// metadata. See planReplaceAllMetadata for details.
+func (vcdClient *VCDClient) ReplaceAllMetadataByHrefDryRun(href string, metadata map[string]types.MetadataValue, isSystem bool) (MetadataChangePlan, error) {
+	return planReplaceAllMetadata(&vcdClient.Client, href, metadata, isSystem)
+}
+
+// MergeMetadataWithMetadataValuesDryRun returns the changes that VM.MergeMetadataWithMetadataValues would perform,
+// without modifying the metadata. See planMergeMetadata for details.
+func (vm *VM) MergeMetadataWithMetadataValuesDryRun(metadata map[string]types.MetadataValue) (MetadataChangePlan, error) {
+	return planMergeMetadata(vm.client, vm.VM.HREF, metadata)
+}
+
+// ReplaceAllMetadataDryRun returns the changes that VM.ReplaceAllMetadata would perform, without modifying the
+// metadata. See planReplaceAllMetadata for details.
+func (vm *VM) ReplaceAllMetadataDryRun(metadata map[string]types.MetadataValue, isSystem bool) (MetadataChangePlan, error) {
+	return planReplaceAllMetadata(vm.client, vm.VM.HREF, metadata, isSystem)
+}
+
+// ------------------------------------------------------------------------------------------------
+// MERGE metadata defaults
+// ------------------------------------------------------------------------------------------------
+
+// ApplyMetadataDefaultsByHref merges the given default metadata entries into the given resource reference, skipping the
+// ones whose key is already present. See applyMetadataDefaults for details.
+func (vcdClient *VCDClient) ApplyMetadataDefaultsByHref(href string, defaults map[string]types.MetadataValue, isSystem bool) ([]string, error) {
+	return applyMetadataDefaults(&vcdClient.Client, href, defaults, isSystem)
+}
+
+// ApplyMetadataDefaults merges the given default metadata entries into the receiver VM, skipping the ones whose key is
+// already present. See applyMetadataDefaults for details.
+func (vm *VM) ApplyMetadataDefaults(defaults map[string]types.MetadataValue, isSystem bool) ([]string, error) {
+	return applyMetadataDefaults(vm.client, vm.VM.HREF, defaults, isSystem)
+}
+
+// ------------------------------------------------------------------------------------------------
+// COPY metadata
+// ------------------------------------------------------------------------------------------------
+
+// CopySystemMetadata copies all the SYSTEM metadata entries of the source entity to the destination entity, keeping
+// their visibility (types.MetadataReadOnlyVisibility or types.MetadataHiddenVisibility). Entries in the GENERAL domain
+// are not copied.
+// Every entry is created separately, so a failure doesn't prevent copying the remaining ones. All the failures, like
+// the ones caused by lack of privileges, are returned in a *MetadataMultiError indexed by metadata key.
+// Note: Requires system administrator privileges, as SYSTEM metadata is only visible and writable for them.
+func CopySystemMetadata(src, dst MetadataCompatible) error {
+	metadata, err := src.GetMetadata()
+	if err != nil {
+		return fmt.Errorf("could not read the source metadata: %s", err)
+	}
+
+	multiError := newMetadataMultiError("copying SYSTEM metadata")
+	for _, entry := range metadata.MetadataEntry {
+		if !isMetadataEntryInDomain(entry, true) || entry.TypedValue == nil {
+			continue
+		}
+		err = dst.AddMetadataEntryWithVisibility(entry.Key, entry.TypedValue.Value, entry.TypedValue.XsiType, entry.Domain.Visibility, true)
+		multiError.add(entry.Key, err)
+	}
+	return multiError.errorOrNil()
+}
+
+// CopyMetadata copies the GENERAL metadata entries of the source entity to the destination entity, and also the
+// SYSTEM ones if includeSystem=true, merging them with the metadata already present in the destination. Entries of
+// each domain are merged with a single task.
+// SYSTEM entries are only copied when the destination client is a system administrator. Entries whose visibility
+// can't be set on the destination, like SYSTEM entries with types.MetadataReadWriteVisibility on tenant level
+// entities, are skipped without aborting the copy. Skipped entries and merge failures are returned in a
+// *MetadataMultiError, indexed by "<domain>/<key>" or by domain respectively.
+func CopyMetadata(src, dst MetadataCompatible, includeSystem bool) error {
+	metadata, err := src.GetMetadata()
+	if err != nil {
+		return fmt.Errorf("could not read the source metadata: %s", err)
+	}
+
+	dstClient := metadataEntityClient(dst)
+	// VCD only accepts SYSTEM metadata with types.MetadataReadWriteVisibility on Provider VDCs, see addMetadataWithContext
+	_, dstIsProviderVdc := dst.(*ProviderVdc)
+	multiError := newMetadataMultiError("copying metadata")
+	toMerge := map[string]map[string]types.MetadataValue{"GENERAL": {}, "SYSTEM": {}}
+	for _, entry := range metadata.MetadataEntry {
+		if entry == nil || entry.TypedValue == nil {
+			continue
+		}
+		domain := effectiveMetadataDomain(entry.Domain)
+		identifier := domain.Domain + "/" + entry.Key
+		if domain.Domain == "SYSTEM" {
+			if !includeSystem {
+				continue
+			}
+			if dstClient != nil && !dstClient.IsSysAdmin {
+				multiError.add(identifier, fmt.Errorf("skipped, SYSTEM metadata requires system administrator privileges"))
+				continue
+			}
+			if domain.Visibility == types.MetadataReadWriteVisibility && !dstIsProviderVdc {
+				multiError.add(identifier, fmt.Errorf("skipped, visibility %s is not allowed in SYSTEM domain of the destination", domain.Visibility))
+				continue
+			}
+		} else {
+			// GENERAL entries are always stored as types.MetadataReadWriteVisibility, see addMetadata
+			domain.Visibility = types.MetadataReadWriteVisibility
+		}
+		toMerge[domain.Domain][entry.Key] = types.MetadataValue{
+			TypedValue: &types.MetadataTypedValue{XsiType: entry.TypedValue.XsiType, Value: entry.TypedValue.Value},
+			Domain:     &types.MetadataDomainTag{Domain: domain.Domain, Visibility: domain.Visibility},
+		}
+	}
+
+	for _, domain := range []string{"GENERAL", "SYSTEM"} {
+		if len(toMerge[domain]) == 0 {
+			continue
+		}
+		err = dst.MergeMetadataWithMetadataValues(toMerge[domain])
+		multiError.add(domain, err)
+	}
+	return multiError.errorOrNil()
+}
+
+// ------------------------------------------------------------------------------------------------
+// REPLACE metadata in another domain
+// ------------------------------------------------------------------------------------------------
+
+// ReplaceMetadataEntryByHref moves the metadata entry with the given key of the given resource reference from its
+// current domain to the target domain, with a new value, type and visibility. See replaceMetadataEntry for details.
+func (vcdClient *VCDClient) ReplaceMetadataEntryByHref(href, key, newValue, newType, newVisibility string, targetSystem, currentSystem bool) error {
+	return replaceMetadataEntry(&vcdClient.Client, href, key, newValue, newType, newVisibility, targetSystem, currentSystem)
+}
+
+// ReplaceMetadataEntry moves the metadata entry with the given key of the receiver VM from its current domain to the
+// target domain, with a new value, type and visibility. See replaceMetadataEntry for details.
+func (vm *VM) ReplaceMetadataEntry(key, newValue, newType, newVisibility string, targetSystem, currentSystem bool) error {
+	return replaceMetadataEntry(vm.client, vm.VM.HREF, key, newValue, newType, newVisibility, targetSystem, currentSystem)
+}
+
+// ------------------------------------------------------------------------------------------------
+// RENAME a metadata key
+// ------------------------------------------------------------------------------------------------
+
+// RenameMetadataKeyByHref moves the metadata entry with the given old key of the given resource reference to the new
+// key, keeping its value, type, visibility and domain. See renameMetadataKey for details.
+func (vcdClient *VCDClient) RenameMetadataKeyByHref(href, oldKey, newKey string, isSystem, overwrite bool) error {
+	return renameMetadataKey(&vcdClient.Client, href, oldKey, newKey, isSystem, overwrite)
+}
+
+// RenameMetadataKey moves the metadata entry with the given old key of the receiver VM to the new key, keeping its
+// value, type, visibility and domain. It fails if the new key already exists. See renameMetadataKey for details.
+func (vm *VM) RenameMetadataKey(oldKey, newKey string, isSystem bool) error {
+	return renameMetadataKey(vm.client, vm.VM.HREF, oldKey, newKey, isSystem, false)
+}
+
+// RenameMetadataKeyWithOverwrite is the same as RenameMetadataKey, but if overwrite is true an existing entry with
+// the new key is replaced instead of failing.
+func (vm *VM) RenameMetadataKeyWithOverwrite(oldKey, newKey string, isSystem, overwrite bool) error {
+	return renameMetadataKey(vm.client, vm.VM.HREF, oldKey, newKey, isSystem, overwrite)
+}
+
+// ------------------------------------------------------------------------------------------------
+// NORMALIZE metadata created without domain
+// ------------------------------------------------------------------------------------------------
+
+// NormalizeMetadataDomainsByHref rewrites the metadata entries of the given resource reference in the GENERAL domain,
+// or in the SYSTEM domain when isSystem=true, that don't have a visibility, with the default visibility of the domain.
+// See normalizeMetadataDomains for details.
+func (vcdClient *VCDClient) NormalizeMetadataDomainsByHref(href string, isSystem bool) (int, error) {
+	return normalizeMetadataDomains(&vcdClient.Client, href, isSystem)
+}
+
+// NormalizeMetadataDomains rewrites the metadata entries of the receiver VM in the GENERAL domain, or in the SYSTEM
+// domain when isSystem=true, that don't have a visibility, with the default visibility of the domain, and returns how
+// many were rewritten. Entries without Domain are already GENERAL with types.MetadataReadWriteVisibility, so they are
+// left untouched. See normalizeMetadataDomains for details.
+func (vm *VM) NormalizeMetadataDomains(isSystem bool) (int, error) {
+	return normalizeMetadataDomains(vm.client, vm.VM.HREF, isSystem)
+}
+
+// ------------------------------------------------------------------------------------------------
+// UPDATE metadata with a function
+// ------------------------------------------------------------------------------------------------
+
+// MetadataUpdater computes the new value of a metadata entry given its current value, which is nil if the key doesn't
+// exist yet. It returns the new value and type, and whether the entry must be kept (keep=false deletes it).
+type MetadataUpdater func(key string, current *types.MetadataValue) (newValue string, newType string, keep bool)
+
+// UpdateMetadataValuesByHref applies the updater function to the given keys of the metadata of the given resource
+// reference. See updateMetadataValues for details.
+func (vcdClient *VCDClient) UpdateMetadataValuesByHref(href string, keys []string, updater MetadataUpdater, isSystem bool) error {
+	return updateMetadataValues(&vcdClient.Client, href, keys, updater, isSystem)
+}
+
+// UpdateMetadataValues applies the updater function to the given keys of the receiver VM metadata.
+// See updateMetadataValues for details.
+func (vm *VM) UpdateMetadataValues(keys []string, updater MetadataUpdater, isSystem bool) error {
+	return updateMetadataValues(vm.client, vm.VM.HREF, keys, updater, isSystem)
+}
+
+// ------------------------------------------------------------------------------------------------
+// ADD, MERGE and DELETE metadata returning the finished task
+// ------------------------------------------------------------------------------------------------
+
+// AddMetadataEntryWithVisibilityByHrefReturningTask adds metadata to the given resource reference, waits for the task
+// to finish and returns it. See waitMetadataTask for details.
+func (vcdClient *VCDClient) AddMetadataEntryWithVisibilityByHrefReturningTask(href, key, value, typedValue, visibility string, isSystem bool) (Task, error) {
+	return waitMetadataTask(addMetadata(&vcdClient.Client, href, key, value, typedValue, visibility, isSystem))
+}
+
+// MergeMetadataWithVisibilityByHrefReturningTask merges metadata provided as a key-value map of type `typedValue` with
+// the already present in VCD for the given resource reference, waits for the task to finish and returns it.
+// See waitMetadataTask for details.
+func (vcdClient *VCDClient) MergeMetadataWithVisibilityByHrefReturningTask(href string, metadata map[string]types.MetadataValue) (Task, error) {
+	return waitMetadataTask(mergeAllMetadata(&vcdClient.Client, href, metadata))
+}
+
+// DeleteMetadataEntryWithDomainByHrefReturningTask deletes metadata from the given resource reference, depending on
+// key provided as input, waits for the task to finish and returns it. See waitMetadataTask for details.
+func (vcdClient *VCDClient) DeleteMetadataEntryWithDomainByHrefReturningTask(href, key string, isSystem bool) (Task, error) {
+	return waitMetadataTask(deleteMetadata(&vcdClient.Client, href, key, isSystem))
+}
+
+// AddMetadataEntryWithVisibilityReturningTask adds metadata to the receiver VM, waits for the task to finish and
+// returns it, so its ID, owner and timestamps can be recorded. See waitMetadataTask for details.
+func (vm *VM) AddMetadataEntryWithVisibilityReturningTask(key, value, typedValue, visibility string, isSystem bool) (Task, error) {
+	return waitMetadataTask(addMetadata(vm.client, vm.VM.HREF, key, value, typedValue, visibility, isSystem))
+}
+
+// MergeMetadataWithMetadataValuesReturningTask merges VM metadata provided as a key-value map of type `typedValue` with
+// the already present in VCD, waits for the task to finish and returns it. See waitMetadataTask for details.
+func (vm *VM) MergeMetadataWithMetadataValuesReturningTask(metadata map[string]types.MetadataValue) (Task, error) {
+	return waitMetadataTask(mergeAllMetadata(vm.client, vm.VM.HREF, metadata))
+}
+
+// DeleteMetadataEntryWithDomainReturningTask deletes VM metadata associated to the input key, waits for the task to
+// finish and returns it. See waitMetadataTask for details.
+func (vm *VM) DeleteMetadataEntryWithDomainReturningTask(key string, isSystem bool) (Task, error) {
+	return waitMetadataTask(deleteMetadata(vm.client, vm.VM.HREF, key, isSystem))
+}
+
+// ------------------------------------------------------------------------------------------------
+// MERGE metadata returning the stored values
+// ------------------------------------------------------------------------------------------------
+
+// MergeMetadataWithVisibilityByHrefReturningValues merges metadata provided as a key-value map of type `typedValue`
+// with the already present in VCD for the given resource reference, and returns the values stored by VCD for the
+// merged keys. See mergeMetadataReturningValues for details.
+func (vcdClient *VCDClient) MergeMetadataWithVisibilityByHrefReturningValues(href string, metadata map[string]types.MetadataValue) (map[string]*types.MetadataValue, error) {
+	return mergeMetadataReturningValues(&vcdClient.Client, href, metadata)
+}
+
+// MergeMetadataWithMetadataValuesReturningValues merges VM metadata provided as a key-value map of type `typedValue`
+// with the already present in VCD, and returns the values stored by VCD for the merged keys, so the effective type and
+// visibility can be verified. See mergeMetadataReturningValues for details.
+func (vm *VM) MergeMetadataWithMetadataValuesReturningValues(metadata map[string]types.MetadataValue) (map[string]*types.MetadataValue, error) {
+	return mergeMetadataReturningValues(vm.client, vm.VM.HREF, metadata)
+}
+
+// ------------------------------------------------------------------------------------------------
+// CRUD metadata with context
+// ------------------------------------------------------------------------------------------------
+
+// The requests sent by the methods of this section, including the reads done before a change to call
+// Client.OnMetadataChange, also include the HTTP header values set in the context with ContextWithHttpHeaders, so
+// per-call headers like an X-Request-ID can be attached to them, and use the API version set in the context with
+// ContextWithApiVersion, if any.
+
+// GetMetadataByHrefCtx is the same as GetMetadataByHref, but the request is cancelled as soon as the given
+// context is done, returning ctx.Err().
+func (vcdClient *VCDClient) GetMetadataByHrefCtx(ctx context.Context, href string) (*types.Metadata, error) {
+	return getMetadataWithContext(ctx, &vcdClient.Client, href)
+}
+
+// GetMetadataByKeyAndHrefCtx is the same as GetMetadataByKeyAndHref, but the request is cancelled as soon as the
+// given context is done, returning ctx.Err().
+func (vcdClient *VCDClient) GetMetadataByKeyAndHrefCtx(ctx context.Context, href, key string, isSystem bool) (*types.MetadataValue, error) {
+	return getMetadataByKeyWithContext(ctx, &vcdClient.Client, href, key, isSystem)
+}
+
+// AddMetadataEntryWithVisibilityByHrefCtx is the same as AddMetadataEntryWithVisibilityByHref, but both the request
+// and the wait for the task are cancelled as soon as the given context is done, returning ctx.Err().
+// NOTE: Cancelling the context doesn't cancel the task in VCD, which may still complete.
+func (vcdClient *VCDClient) AddMetadataEntryWithVisibilityByHrefCtx(ctx context.Context, href, key, value, metadataType, visibility string, isSystem bool) error {
+	return addMetadataAndWaitWithContext(ctx, &vcdClient.Client, href, key, value, metadataType, visibility, isSystem, false)
+}
+
+// MergeMetadataWithVisibilityByHrefCtx is the same as MergeMetadataWithVisibilityByHref, but both the request
+// and the wait for the task are cancelled as soon as the given context is done, returning ctx.Err().
+// NOTE: Cancelling the context doesn't cancel the task in VCD, which may still complete.
+func (vcdClient *VCDClient) MergeMetadataWithVisibilityByHrefCtx(ctx context.Context, href string, metadata map[string]types.MetadataValue) error {
+	return mergeMetadataAndWaitWithContext(ctx, &vcdClient.Client, href, metadata)
+}
+
+// DeleteMetadataEntryWithDomainByHrefCtx is the same as DeleteMetadataEntryWithDomainByHref, but both the request
+// and the wait for the task are cancelled as soon as the given context is done, returning ctx.Err().
+// NOTE: Cancelling the context doesn't cancel the task in VCD, which may still complete.
+func (vcdClient *VCDClient) DeleteMetadataEntryWithDomainByHrefCtx(ctx context.Context, href, key string, isSystem bool) error {
+	return deleteMetadataAndWaitWithContext(ctx, &vcdClient.Client, href, key, isSystem)
+}
+
+// GetMetadataCtx is the same as VM.GetMetadata, but the request is cancelled as soon as the given context is done,
+// returning ctx.Err().
+func (vm *VM) GetMetadataCtx(ctx context.Context) (*types.Metadata, error) {
+	if vm.metadataCacheEnabled {
+		return vm.cachedMetadata(ctx)
+	}
+	return getMetadataWithContext(ctx, vm.client, vm.VM.HREF)
+}
+
+// GetSystemMetadataCtx is the same as VM.GetSystemMetadata, but the request is cancelled as soon as the given context
+// is done, returning ctx.Err().
+func (vm *VM) GetSystemMetadataCtx(ctx context.Context) (*types.Metadata, error) {
+	if vm.metadataCacheEnabled {
+		metadata, err := vm.cachedMetadata(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return FilterMetadata(metadata, FilterByDomain(true)), nil
+	}
+	return getSystemMetadataWithContext(ctx, vm.client, vm.VM.HREF)
+}
+
+// GetGeneralMetadataCtx is the same as VM.GetGeneralMetadata, but the request is cancelled as soon as the given context
+// is done, returning ctx.Err().
+func (vm *VM) GetGeneralMetadataCtx(ctx context.Context) (*types.Metadata, error) {
+	metadata, err := vm.GetMetadataCtx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return FilterMetadata(metadata, FilterByDomain(false)), nil
+}
+
+// GetMetadataByKeyCtx is the same as VM.GetMetadataByKey, but the request is cancelled as soon as the given context
+// is done, returning ctx.Err().
+func (vm *VM) GetMetadataByKeyCtx(ctx context.Context, key string, isSystem bool) (*types.MetadataValue, error) {
+	if vm.metadataCacheEnabled {
+		return vm.cachedMetadataByKey(ctx, key, isSystem)
+	}
+	return getMetadataByKeyWithContext(ctx, vm.client, vm.VM.HREF, key, isSystem)
+}
+
+// AddMetadataEntryWithVisibilityCtx is the same as VM.AddMetadataEntryWithVisibility, but both the request and the
+// wait for the task are cancelled as soon as the given context is done, returning ctx.Err().
+// NOTE: Cancelling the context doesn't cancel the task in VCD, which may still complete.
+func (vm *VM) AddMetadataEntryWithVisibilityCtx(ctx context.Context, key, value, metadataType, visibility string, isSystem bool) error {
+	return addMetadataAndWaitWithContext(ctx, vm.client, vm.VM.HREF, key, value, metadataType, visibility, isSystem, false)
+}
+
+// MergeMetadataWithMetadataValuesCtx is the same as VM.MergeMetadataWithMetadataValues, but both the request and the
+// wait for the task are cancelled as soon as the given context is done, returning ctx.Err().
+// NOTE: Cancelling the context doesn't cancel the task in VCD, which may still complete.
+func (vm *VM) MergeMetadataWithMetadataValuesCtx(ctx context.Context, metadata map[string]types.MetadataValue) error {
+	return mergeMetadataAndWaitWithContext(ctx, vm.client, vm.VM.HREF, metadata)
+}
+
+// DeleteMetadataEntryWithDomainCtx is the same as VM.DeleteMetadataEntryWithDomain, but both the request and the
+// wait for the task are cancelled as soon as the given context is done, returning ctx.Err().
+// NOTE: Cancelling the context doesn't cancel the task in VCD, which may still complete.
+func (vm *VM) DeleteMetadataEntryWithDomainCtx(ctx context.Context, key string, isSystem bool) error {
+	return deleteMetadataAndWaitWithContext(ctx, vm.client, vm.VM.HREF, key, isSystem)
+}
+
+// ------------------------------------------------------------------------------------------------
+// CRUD metadata with timeout
+// ------------------------------------------------------------------------------------------------
+
+// AddMetadataEntryWithVisibilityByHrefWithTimeout is the same as AddMetadataEntryWithVisibilityByHref, but it stops
+// waiting for the task after the given timeout, returning an error with the last known status and HREF of the task.
+// NOTE: Giving up doesn't cancel the task in VCD, which may still complete.
+func (vcdClient *VCDClient) AddMetadataEntryWithVisibilityByHrefWithTimeout(href, key, value, metadataType, visibility string, isSystem bool, timeout time.Duration) error {
+	return addMetadataAndWaitWithTimeout(&vcdClient.Client, href, key, value, metadataType, visibility, isSystem, timeout)
+}
+
+// MergeMetadataWithVisibilityByHrefWithTimeout is the same as MergeMetadataWithVisibilityByHref, but it stops waiting
+// for the task after the given timeout, returning an error with the last known status and HREF of the task.
+// NOTE: Giving up doesn't cancel the task in VCD, which may still complete.
+func (vcdClient *VCDClient) MergeMetadataWithVisibilityByHrefWithTimeout(href string, metadata map[string]types.MetadataValue, timeout time.Duration) error {
+	return mergeMetadataAndWaitWithTimeout(&vcdClient.Client, href, metadata, timeout)
+}
+
+// DeleteMetadataEntryWithDomainByHrefWithTimeout is the same as DeleteMetadataEntryWithDomainByHref, but it stops
+// waiting for the task after the given timeout, returning an error with the last known status and HREF of the task.
+// NOTE: Giving up doesn't cancel the task in VCD, which may still complete.
+func (vcdClient *VCDClient) DeleteMetadataEntryWithDomainByHrefWithTimeout(href, key string, isSystem bool, timeout time.Duration) error {
+	return deleteMetadataAndWaitWithTimeout(&vcdClient.Client, href, key, isSystem, timeout)
+}
+
+// AddMetadataEntryWithVisibilityWithTimeout is the same as VM.AddMetadataEntryWithVisibility, but it stops waiting
+// for the task after the given timeout, returning an error with the last known status and HREF of the task.
+// NOTE: Giving up doesn't cancel the task in VCD, which may still complete.
+func (vm *VM) AddMetadataEntryWithVisibilityWithTimeout(key, value, metadataType, visibility string, isSystem bool, timeout time.Duration) error {
+	return addMetadataAndWaitWithTimeout(vm.client, vm.VM.HREF, key, value, metadataType, visibility, isSystem, timeout)
+}
+
+// MergeMetadataWithMetadataValuesWithTimeout is the same as VM.MergeMetadataWithMetadataValues, but it stops waiting
+// for the task after the given timeout, returning an error with the last known status and HREF of the task.
+// NOTE: Giving up doesn't cancel the task in VCD, which may still complete.
+func (vm *VM) MergeMetadataWithMetadataValuesWithTimeout(metadata map[string]types.MetadataValue, timeout time.Duration) error {
+	return mergeMetadataAndWaitWithTimeout(vm.client, vm.VM.HREF, metadata, timeout)
+}
+
+// DeleteMetadataEntryWithDomainWithTimeout is the same as VM.DeleteMetadataEntryWithDomain, but it stops waiting for
+// the task after the given timeout, returning an error with the last known status and HREF of the task.
+// NOTE: Giving up doesn't cancel the task in VCD, which may still complete.
+func (vm *VM) DeleteMetadataEntryWithDomainWithTimeout(key string, isSystem bool, timeout time.Duration) error {
+	return deleteMetadataAndWaitWithTimeout(vm.client, vm.VM.HREF, key, isSystem, timeout)
+}
+
+// ------------------------------------------------------------------------------------------------
+// DELETE metadata async
+// ------------------------------------------------------------------------------------------------
+
+// DeleteMetadataEntryWithDomainByHrefAsync deletes metadata from the given resource reference, depending on key provided as input
+// and returns a task.
+func (vcdClient *VCDClient) DeleteMetadataEntryWithDomainByHrefAsync(href, key string, isSystem bool) (Task, error) {
+	return deleteMetadata(&vcdClient.Client, href, key, isSystem)
+}
+
+// DeleteMetadataEntryWithDomainAsync deletes VM metadata associated to the input key and returns the task.
+func (vm *VM) DeleteMetadataEntryWithDomainAsync(key string, isSystem bool) (Task, error) {
+	return deleteMetadata(vm.client, vm.VM.HREF, key, isSystem)
+}
+
+// DeleteMetadataEntryWithDomainAsync deletes AdminVdc metadata associated to the input key and returns the task.
+func (adminVdc *AdminVdc) DeleteMetadataEntryWithDomainAsync(key string, isSystem bool) (Task, error) {
+	return deleteMetadata(adminVdc.client, adminVdc.AdminVdc.HREF, key, isSystem)
+}
+
+// DeleteMetadataEntryWithDomainAsync deletes ProviderVdc metadata associated to the input key and returns the task.
+// Note: Requires system administrator privileges.
+func (providerVdc *ProviderVdc) DeleteMetadataEntryWithDomainAsync(key string, isSystem bool) (Task, error) {
+	return deleteMetadata(providerVdc.client, providerVdc.ProviderVdc.HREF, key, isSystem)
+}
+
+// DeleteMetadataEntryWithDomainAsync deletes VApp metadata associated to the input key and returns the task.
+func (vapp *VApp) DeleteMetadataEntryWithDomainAsync(key string, isSystem bool) (Task, error) {
+	return deleteMetadata(vapp.client, vapp.VApp.HREF, key, isSystem)
+}
+
+// DeleteMetadataEntryWithDomainAsync deletes VAppTemplate metadata associated to the input key and returns the task.
+func (vAppTemplate *VAppTemplate) DeleteMetadataEntryWithDomainAsync(key string, isSystem bool) (Task, error) {
+	return deleteMetadata(vAppTemplate.client, vAppTemplate.VAppTemplate.HREF, key, isSystem)
+}
+
+// DeleteMetadataEntryWithDomainAsync deletes MediaRecord metadata associated to the input key and returns the task.
+func (mediaRecord *MediaRecord) DeleteMetadataEntryWithDomainAsync(key string, isSystem bool) (Task, error) {
+	return deleteMetadata(mediaRecord.client, mediaRecord.MediaRecord.HREF, key, isSystem)
+}
+
+// DeleteMetadataEntryWithDomainAsync deletes Media metadata associated to the input key and returns the task.
+func (media *Media) DeleteMetadataEntryWithDomainAsync(key string, isSystem bool) (Task, error) {
+	return deleteMetadata(media.client, media.Media.HREF, key, isSystem)
+}
+
+// DeleteMetadataEntryWithDomainAsync deletes AdminCatalog metadata associated to the input key and returns the task.
+func (adminCatalog *AdminCatalog) DeleteMetadataEntryWithDomainAsync(key string, isSystem bool) (Task, error) {
+	return deleteMetadata(adminCatalog.client, adminCatalog.AdminCatalog.HREF, key, isSystem)
+}
+
+// DeleteMetadataEntryWithDomainAsync deletes AdminOrg metadata associated to the input key and returns the task.
+func (adminOrg *AdminOrg) DeleteMetadataEntryWithDomainAsync(key string, isSystem bool) (Task, error) {
+	return deleteMetadata(adminOrg.client, adminOrg.AdminOrg.HREF, key, isSystem)
+}
+
+// DeleteMetadataEntryWithDomainAsync deletes Disk metadata associated to the input key and returns the task.
+// NOTE: Only the XML API returns tasks, so it returns a *MetadataNotSupportedError when Client.OpenApiDiskMetadata
+// selects the OpenAPI metadata endpoint. Use DeleteMetadataEntryWithDomain instead.
+func (disk *Disk) DeleteMetadataEntryWithDomainAsync(key string, isSystem bool) (Task, error) {
+	href, err := disk.asyncMetadataHref()
+	if err != nil {
+		return Task{}, err
+	}
+	return deleteMetadata(disk.client, href, key, isSystem)
+}
+
+// DeleteMetadataEntryWithDomainAsync deletes OrgVDCNetwork metadata associated to the input key and returns the task.
+// Note: Org administrators can manage GENERAL metadata, while SYSTEM metadata requires system administrator
+// privileges. See OrgVDCNetwork.writeMetadata for details.
+func (orgVdcNetwork *OrgVDCNetwork) DeleteMetadataEntryWithDomainAsync(key string, isSystem bool) (Task, error) {
+	var task Task
+	err := orgVdcNetwork.writeMetadata(isSystem, func(href string) error {
+		var err error
+		task, err = deleteMetadata(orgVdcNetwork.client, href, key, isSystem)
+		return err
+	})
+	return task, err
+}
+
+// DeleteMetadataEntryWithDomainAsync deletes CatalogItem metadata associated to the input key and returns the task.
+func (catalogItem *CatalogItem) DeleteMetadataEntryWithDomainAsync(key string, isSystem bool) (Task, error) {
+	task, err := deleteMetadata(catalogItem.client, catalogItem.CatalogItem.HREF, key, isSystem)
+	return task, catalogItem.subscribedMetadataError(err)
+}
+
+// ------------------------------------------------------------------------------------------------
+// DELETE several metadata entries
+// ------------------------------------------------------------------------------------------------
+
+// metadataDeleteConcurrency is the maximum number of metadata deletion tasks that DeleteMetadataEntriesWithDomain
+// runs at the same time
+const metadataDeleteConcurrency = 5
+
+// DeleteMetadataEntriesWithDomainByHref deletes the metadata entries of the given resource reference associated to
+// the input keys and waits for all the tasks to finish. See deleteMetadataEntries for details.
+func (vcdClient *VCDClient) DeleteMetadataEntriesWithDomainByHref(href string, keys []string, isSystem bool) error {
+	return deleteMetadataEntries(&vcdClient.Client, href, keys, isSystem)
+}
+
+// DeleteMetadataEntriesWithDomain deletes VM metadata associated to the input keys and waits for all the tasks
+// to finish. See deleteMetadataEntries for details.
+func (vm *VM) DeleteMetadataEntriesWithDomain(keys []string, isSystem bool) error {
+	return deleteMetadataEntries(vm.client, vm.VM.HREF, keys, isSystem)
+}
+
+// DeleteMetadataEntriesWithDomain deletes AdminVdc metadata associated to the input keys and waits for all the tasks
+// to finish. See deleteMetadataEntries for details.
+// Note: Requires system administrator privileges.
+func (adminVdc *AdminVdc) DeleteMetadataEntriesWithDomain(keys []string, isSystem bool) error {
+	return deleteMetadataEntries(adminVdc.client, getAdminURL(adminVdc.AdminVdc.HREF), keys, isSystem)
+}
+
+// DeleteMetadataEntriesWithDomain deletes ProviderVdc metadata associated to the input keys and waits for all the tasks
+// to finish. See deleteMetadataEntries for details.
+// Note: Requires system administrator privileges.
+func (providerVdc *ProviderVdc) DeleteMetadataEntriesWithDomain(keys []string, isSystem bool) error {
+	return deleteMetadataEntries(providerVdc.client, providerVdc.ProviderVdc.HREF, keys, isSystem)
+}
+
+// DeleteMetadataEntriesWithDomain deletes VApp metadata associated to the input keys and waits for all the tasks
+// to finish. See deleteMetadataEntries for details.
+func (vApp *VApp) DeleteMetadataEntriesWithDomain(keys []string, isSystem bool) error {
+	return deleteMetadataEntries(vApp.client, vApp.VApp.HREF, keys, isSystem)
+}
+
+// DeleteMetadataEntriesWithDomain deletes VAppTemplate metadata associated to the input keys and waits for all the tasks
+// to finish. See deleteMetadataEntries for details.
+func (vAppTemplate *VAppTemplate) DeleteMetadataEntriesWithDomain(keys []string, isSystem bool) error {
+	return deleteMetadataEntries(vAppTemplate.client, vAppTemplate.VAppTemplate.HREF, keys, isSystem)
+}
+
+// DeleteMetadataEntriesWithDomain deletes MediaRecord metadata associated to the input keys and waits for all the tasks
+// to finish. See deleteMetadataEntries for details.
+func (mediaRecord *MediaRecord) DeleteMetadataEntriesWithDomain(keys []string, isSystem bool) error {
+	return deleteMetadataEntries(mediaRecord.client, mediaRecord.MediaRecord.HREF, keys, isSystem)
+}
+
+// DeleteMetadataEntriesWithDomain deletes Media metadata associated to the input keys and waits for all the tasks
+// to finish. See deleteMetadataEntries for details.
+func (media *Media) DeleteMetadataEntriesWithDomain(keys []string, isSystem bool) error {
+	return deleteMetadataEntries(media.client, media.Media.HREF, keys, isSystem)
+}
+
+// DeleteMetadataEntriesWithDomain deletes AdminCatalog metadata associated to the input keys and waits for all the tasks
+// to finish. See deleteMetadataEntries for details.
+func (adminCatalog *AdminCatalog) DeleteMetadataEntriesWithDomain(keys []string, isSystem bool) error {
+	return deleteMetadataEntries(adminCatalog.client, adminCatalog.AdminCatalog.HREF, keys, isSystem)
+}
+
+// DeleteMetadataEntriesWithDomain deletes AdminOrg metadata associated to the input keys and waits for all the tasks
+// to finish. See deleteMetadataEntries for details.
+func (adminOrg *AdminOrg) DeleteMetadataEntriesWithDomain(keys []string, isSystem bool) error {
+	return deleteMetadataEntries(adminOrg.client, adminOrg.AdminOrg.HREF, keys, isSystem)
+}
+
+// DeleteMetadataEntriesWithDomain deletes Disk metadata associated to the input keys and waits for all the tasks
+// to finish. See deleteMetadataEntries for details.
+// NOTE: Like DeleteMetadataEntryWithDomain, it uses the OpenAPI metadata endpoint when Client.OpenApiDiskMetadata is
+// set.
+func (disk *Disk) DeleteMetadataEntriesWithDomain(keys []string, isSystem bool) error {
+	errs := make([]error, len(keys))
+	runMetadataWorkers(len(keys), metadataDeleteConcurrency, func(index int) {
+		errs[index] = disk.DeleteMetadataEntryWithDomain(keys[index], isSystem)
+	})
+
+	multiError := newMetadataMultiError("deleting metadata")
+	for index, err := range errs {
+		multiError.add(keys[index], err)
+	}
+	return multiError.errorOrNil()
+}
+
+// DeleteMetadataEntriesWithDomain deletes OrgVDCNetwork metadata associated to the input keys and waits for all the tasks
+// to finish. See deleteMetadataEntries for details.
+// Note: Org administrators can manage GENERAL metadata, while SYSTEM metadata requires system administrator
+// privileges. See OrgVDCNetwork.writeMetadata for details.
+func (orgVdcNetwork *OrgVDCNetwork) DeleteMetadataEntriesWithDomain(keys []string, isSystem bool) error {
+	return orgVdcNetwork.writeMetadata(isSystem, func(href string) error {
+		return deleteMetadataEntries(orgVdcNetwork.client, href, keys, isSystem)
+	})
+}
+
+// DeleteMetadataEntriesWithDomain deletes Vdc metadata associated to the input keys and waits for all the tasks
+// to finish. See deleteMetadataEntries for details.
+// Note: The admin endpoint is used, hence it requires Org administrator privileges.
+func (vdc *Vdc) DeleteMetadataEntriesWithDomain(keys []string, isSystem bool) error {
+	return deleteMetadataEntries(vdc.client, getAdminURL(vdc.Vdc.HREF), keys, isSystem)
+}
+
+// DeleteMetadataEntriesWithDomain deletes Catalog metadata associated to the input keys and waits for all the tasks
+// to finish. See deleteMetadataEntries for details.
+// Note: The admin endpoint is used, hence it requires Org administrator privileges.
+func (catalog *Catalog) DeleteMetadataEntriesWithDomain(keys []string, isSystem bool) error {
+	return deleteMetadataEntries(catalog.client, getAdminURL(catalog.Catalog.HREF), keys, isSystem)
+}
+
+// DeleteMetadataEntriesWithDomain deletes Org metadata associated to the input keys and waits for all the tasks
+// to finish. See deleteMetadataEntries for details.
+// Note: The admin endpoint is used, hence it requires Org administrator privileges.
+func (org *Org) DeleteMetadataEntriesWithDomain(keys []string, isSystem bool) error {
+	return deleteMetadataEntries(org.client, getAdminURL(org.Org.HREF), keys, isSystem)
+}
+
+// DeleteMetadataEntriesWithDomain deletes CatalogItem metadata associated to the input keys and waits for all the tasks
+// to finish. See deleteMetadataEntries for details.
+func (catalogItem *CatalogItem) DeleteMetadataEntriesWithDomain(keys []string, isSystem bool) error {
+	return catalogItem.subscribedMetadataError(deleteMetadataEntries(catalogItem.client, catalogItem.CatalogItem.HREF, keys, isSystem))
+}
+
+// ------------------------------------------------------------------------------------------------
+// DELETE expired metadata
+// ------------------------------------------------------------------------------------------------
+
+// MetadataExpiryKeySuffix is appended to a metadata key to build the key of its companion entry, which stores the
+// expiry of the former. See MetadataExpiryKey
+const MetadataExpiryKeySuffix = ".expiresAt"
+
+// MetadataExpiryKey returns the key of the companion entry that stores the expiry of the metadata entry with the
+// given key, like "<key>.expiresAt". Its value must be encoded with EncodeMetadataExpiry.
+func MetadataExpiryKey(key string) string {
+	return key + MetadataExpiryKeySuffix
+}
+
+// EncodeMetadataExpiry returns the value of a companion expiry entry for the given expiry time, as a RFC 3339
+// timestamp in UTC, like "2023-01-31T15:04:05Z"
+func EncodeMetadataExpiry(expiresAt time.Time) string {
+	return expiresAt.UTC().Format(time.RFC3339)
+}
+
+// DecodeMetadataExpiry returns the expiry time stored in the value of a companion expiry entry, which must be a
+// RFC 3339 timestamp, as returned by EncodeMetadataExpiry
+func DecodeMetadataExpiry(value string) (time.Time, error) {
+	expiresAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid metadata expiry '%s', expected a RFC 3339 timestamp: %s", value, err)
+	}
+	return expiresAt, nil
+}
+
+// DeleteExpiredMetadataByHref deletes the metadata entries of the given resource reference whose companion expiry
+// entry is not after the given time. See deleteExpiredMetadata for details.
+func (vcdClient *VCDClient) DeleteExpiredMetadataByHref(href string, now time.Time, isSystem bool) ([]string, error) {
+	return deleteExpiredMetadata(&vcdClient.Client, href, now, isSystem)
+}
+
+// DeleteExpiredMetadata deletes the metadata entries of the receiver VM whose companion expiry entry is not after the
+// given time, and returns their keys. See deleteExpiredMetadata for details.
+func (vm *VM) DeleteExpiredMetadata(now time.Time, isSystem bool) ([]string, error) {
+	return deleteExpiredMetadata(vm.client, vm.VM.HREF, now, isSystem)
+}
+
+// ------------------------------------------------------------------------------------------------
+// DELETE metadata
+// ------------------------------------------------------------------------------------------------
+
+// DeleteMetadataEntryWithDomainByHref deletes metadata from the given resource reference, depending on key provided as input
+// and waits for the task to finish.
+func (vcdClient *VCDClient) DeleteMetadataEntryWithDomainByHref(href, key string, isSystem bool) error {
+	return deleteMetadataAndWait(&vcdClient.Client, href, key, isSystem)
+}
+
+// DeleteMetadataEntryWithDomain deletes VM metadata associated to the input key and waits for the task to finish.
+func (vm *VM) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
+	return deleteMetadataAndWait(vm.client, vm.VM.HREF, key, isSystem)
+}
+
+// DeleteMetadataEntryWithDomain deletes AdminVdc metadata associated to the input key and waits for the task to finish.
+// Note: Requires system administrator privileges.
+func (adminVdc *AdminVdc) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
+	return deleteMetadataAndWait(adminVdc.client, getAdminURL(adminVdc.AdminVdc.HREF), key, isSystem)
+}
+
+// DeleteMetadataEntryWithDomain deletes ProviderVdc metadata associated to the input key and waits for the task to finish.
+// Note: Requires system administrator privileges.
+func (providerVdc *ProviderVdc) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
+	return deleteMetadataAndWait(providerVdc.client, providerVdc.ProviderVdc.HREF, key, isSystem)
+}
+
+// DeleteMetadataEntryWithDomain deletes VApp metadata associated to the input key and waits for the task to finish.
+func (vApp *VApp) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
+	return deleteMetadataAndWait(vApp.client, vApp.VApp.HREF, key, isSystem)
+}
+
+// DeleteMetadataEntryWithDomain deletes VAppTemplate metadata associated to the input key and waits for the task to finish.
+func (vAppTemplate *VAppTemplate) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
+	return deleteMetadataAndWait(vAppTemplate.client, vAppTemplate.VAppTemplate.HREF, key, isSystem)
+}
+
+// DeleteMetadataEntryWithDomain deletes MediaRecord metadata associated to the input key and waits for the task to finish.
+func (mediaRecord *MediaRecord) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
+	return deleteMetadataAndWait(mediaRecord.client, mediaRecord.MediaRecord.HREF, key, isSystem)
+}
+
+// DeleteMetadataEntryWithDomain deletes Media metadata associated to the input key and waits for the task to finish.
+func (media *Media) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
+	return deleteMetadataAndWait(media.client, media.Media.HREF, key, isSystem)
+}
+
+// DeleteMetadataEntryWithDomain deletes AdminCatalog metadata associated to the input key and waits for the task to finish.
+func (adminCatalog *AdminCatalog) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
+	return deleteMetadataAndWait(adminCatalog.client, adminCatalog.AdminCatalog.HREF, key, isSystem)
+}
+
+// DeleteMetadataEntryWithDomain deletes AdminOrg metadata associated to the input key and waits for the task to finish.
+func (adminOrg *AdminOrg) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
+	return deleteMetadataAndWait(adminOrg.client, adminOrg.AdminOrg.HREF, key, isSystem)
+}
+
+// DeleteMetadataEntryWithDomain deletes Disk metadata associated to the input key and waits for the task to finish.
+// NOTE: If Client.OpenApiDiskMetadata is set, the disk has an OpenAPI ID and the negotiated API version is 38.0+
+// (VCD 10.5+), the OpenAPI metadata endpoint is used, so it doesn't depend on the XML HREF of the disk.
+func (disk *Disk) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
+	opts := disk.metadataRequestOptions(metadataOperationDelete)
+	opts.key, opts.isSystem = key, isSystem
+	_, err := metadataRequest(disk.client, opts)
+	return err
+}
+
+// DeleteMetadataEntryWithDomain deletes OrgVDCNetwork metadata associated to the input key and waits for the task to finish.
+// Note: Org administrators can manage GENERAL metadata, while SYSTEM metadata requires system administrator
+// privileges. See OrgVDCNetwork.writeMetadata for details.
+func (orgVdcNetwork *OrgVDCNetwork) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
+	return orgVdcNetwork.writeMetadata(isSystem, func(href string) error {
+		return deleteMetadataAndWait(orgVdcNetwork.client, href, key, isSystem)
+	})
+}
+
+// DeleteMetadataEntryWithDomain deletes Vdc metadata associated to the input key and waits for the task to finish.
+// Note: The admin endpoint is used, hence it requires Org administrator privileges.
+func (vdc *Vdc) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
+	return deleteMetadataAndWait(vdc.client, getAdminURL(vdc.Vdc.HREF), key, isSystem)
+}
+
+// DeleteMetadataEntryWithDomain deletes Catalog metadata associated to the input key and waits for the task to finish.
+// Note: The admin endpoint is used, hence it requires Org administrator privileges.
+func (catalog *Catalog) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
+	return deleteMetadataAndWait(catalog.client, getAdminURL(catalog.Catalog.HREF), key, isSystem)
+}
+
+// DeleteMetadataEntryWithDomain deletes Org metadata associated to the input key and waits for the task to finish.
+// Note: The admin endpoint is used, hence it requires Org administrator privileges.
+func (org *Org) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
+	return deleteMetadataAndWait(org.client, getAdminURL(org.Org.HREF), key, isSystem)
+}
+
+// DeleteMetadataEntryWithDomain deletes CatalogItem metadata associated to the input key and waits for the task to finish.
+func (catalogItem *CatalogItem) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
+	return catalogItem.subscribedMetadataError(deleteMetadataAndWait(catalogItem.client, catalogItem.CatalogItem.HREF, key, isSystem))
+}
+
+// DeleteMetadataEntryWithDomain deletes OpenApiOrgVdcNetwork metadata associated to the input key and waits for the task to finish.
+// NOTE: If the network belongs to a VDC Group, the OpenAPI metadata endpoint is used, which requires VCD 10.5+.
+// Otherwise, the XML API is used.
+func (openApiOrgVdcNetwork *OpenApiOrgVdcNetwork) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
+	opts := openApiOrgVdcNetwork.metadataRequestOptions(metadataOperationDelete, true)
+	opts.key, opts.isSystem = key, isSystem
+	_, err := metadataRequest(openApiOrgVdcNetwork.client, opts)
+	return err
+}
+
+// DeleteMetadataEntryWithDomain deletes NSX-T ALB Service Engine Group metadata associated to the input key.
+// NOTE: The OpenAPI metadata endpoint is used, which requires VCD 10.5+. A *MetadataNotSupportedError is returned
+// for older versions.
+// Note: Requires system administrator privileges.
+func (nsxtAlbServiceEngineGroup *NsxtAlbServiceEngineGroup) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
+	return deleteOpenApiMetadata(&nsxtAlbServiceEngineGroup.vcdClient.Client, types.OpenApiEndpointAlbServiceEngineGroupsMetadata, nsxtAlbServiceEngineGroup.NsxtAlbServiceEngineGroup.ID, nil, key, isSystem)
+}
+
+// DeleteSubscriptionMetadataEntryWithDomain is not supported, as VCD doesn't expose metadata for the subscription and
+// synchronization settings of a Catalog. It always returns a *MetadataNotSupportedError.
+// Use AdminCatalog.DeleteMetadataEntryWithDomain to remove metadata from the subscribed Catalog itself.
+func (adminCatalog *AdminCatalog) DeleteSubscriptionMetadataEntryWithDomain(key string, isSystem bool) error {
+	return catalogSubscriptionMetadataNotSupported()
+}
+
+// DeleteVmSizingMetadataEntryWithDomain is not supported, as VCD doesn't expose metadata for the VM sizing configuration
+// (CPU, memory and their reservations) of a VDC Compute Policy. It always returns a *MetadataNotSupportedError.
+func (vdcComputePolicy *VdcComputePolicyV2) DeleteVmSizingMetadataEntryWithDomain(key string, isSystem bool) error {
+	return vmSizingMetadataNotSupported()
+}
+
+// DeleteMetadataEntryWithDomain is not supported, as VCD doesn't expose metadata for NSX-T Edge Gateway NAT rules.
+// It always returns a *MetadataNotSupportedError.
+func (nsxtNatRule *NsxtNatRule) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
+	return nsxtNatRuleMetadataNotSupported()
+}
+
+// DeleteMetadataEntryWithDomain is not supported, as VCD doesn't expose metadata for NSX-T ALB Controllers.
+// It always returns a *MetadataNotSupportedError.
+func (nsxtAlbController *NsxtAlbController) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
+	return nsxtAlbControllerMetadataNotSupported()
+}
+
+// DeleteMetadataEntryWithDomain is not supported, as VCD doesn't expose metadata for NSX-T ALB Clouds.
+// It always returns a *MetadataNotSupportedError.
+func (nsxtAlbCloud *NsxtAlbCloud) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
+	return nsxtAlbCloudMetadataNotSupported()
+}
+
+// DeleteRoleAssignmentMetadataEntryWithDomain is not supported, as VCD doesn't expose metadata for the role assigned to an Org user.
+// It always returns a *MetadataNotSupportedError.
+func (orgUser *OrgUser) DeleteRoleAssignmentMetadataEntryWithDomain(key string, isSystem bool) error {
+	return orgUserRoleAssignmentMetadataNotSupported()
+}
+
+// DeleteMetadataEntryWithDomain is not supported, as VCD doesn't expose metadata for VM affinity rules.
+// It always returns a *MetadataNotSupportedError.
+func (vmAffinityRule *VmAffinityRule) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
+	return vmAffinityRuleMetadataNotSupported()
+}
+
+// DeleteRoleMetadataEntryWithDomain is not supported, as VCD doesn't expose metadata for roles.
+// It always returns a *MetadataNotSupportedError.
+func (role *Role) DeleteRoleMetadataEntryWithDomain(key string, isSystem bool) error {
+	return roleMetadataNotSupported()
+}
+
+// DeleteGlobalRoleMetadataEntryWithDomain is not supported, as VCD doesn't expose metadata for global roles.
+// It always returns a *MetadataNotSupportedError.
+func (globalRole *GlobalRole) DeleteGlobalRoleMetadataEntryWithDomain(key string, isSystem bool) error {
+	return globalRoleMetadataNotSupported()
+}
+
+// DeleteStorageProfileMetadataEntryWithDomain deletes the metadata of the storage profile with the given HREF,
+// corresponding to the given key and domain, and waits for the task to finish.
+// The storage profile can be a VDC storage profile or a Provider VDC storage profile.
+// Note: Requires system administrator privileges for Provider VDC storage profiles.
+func (vcdClient *VCDClient) DeleteStorageProfileMetadataEntryWithDomain(storageProfileHref, key string, isSystem bool) error {
+	return deleteMetadataAndWait(&vcdClient.Client, storageProfileHref, key, isSystem)
+}
+
+// DeleteNetworkPoolMetadataEntryWithDomain is not supported, as VCD doesn't expose metadata for network pools.
+// It always returns a *MetadataNotSupportedError.
+func (vcdClient *VCDClient) DeleteNetworkPoolMetadataEntryWithDomain(networkPoolHref, key string, isSystem bool) error {
+	return networkPoolMetadataNotSupported()
+}
+
+// DeleteNsxtManagerMetadataEntryWithDomain is not supported, as VCD doesn't expose metadata for NSX-T Managers.
+// It always returns a *MetadataNotSupportedError.
+func (vcdClient *VCDClient) DeleteNsxtManagerMetadataEntryWithDomain(nsxtManagerHref, key string, isSystem bool) error {
+	return nsxtManagerMetadataNotSupported()
+}
+
+// DeleteVAppSnapshotMetadataEntryWithDomain is not supported, as VCD doesn't expose metadata for vApp snapshots.
+// It always returns a *MetadataNotSupportedError.
+func (vcdClient *VCDClient) DeleteVAppSnapshotMetadataEntryWithDomain(snapshotHref, key string, isSystem bool) error {
+	return vAppSnapshotMetadataNotSupported()
+}
+
+// DeleteUIPluginMetadataEntryWithDomain is not supported, as VCD doesn't expose metadata for UI plugins.
+// It always returns a *MetadataNotSupportedError.
+func (vcdClient *VCDClient) DeleteUIPluginMetadataEntryWithDomain(pluginId, key string, isSystem bool) error {
+	return uiPluginMetadataNotSupported()
+}
+
+// ------------------------------------------------------------------------------------------------
+// Generic private functions
+// ------------------------------------------------------------------------------------------------
+
+const (
+	maxMetadataKeyLength   = 256   // Maximum length of a metadata key accepted by VCD
+	maxMetadataValueLength = 65535 // Maximum length of a metadata value accepted by VCD
+)
+
+// metadataVisibilityMinApiVersions contains the metadata visibilities accepted by the SDK, with the minimum API version
+// that supports each of them. An empty version means that any API version supports it. Visibilities introduced by newer
+// VCD versions must be registered here, so older VCDs get a *MetadataNotSupportedError instead of a VCD failure.
+var metadataVisibilityMinApiVersions = map[string]string{
+	types.MetadataReadOnlyVisibility:  "",
+	types.MetadataHiddenVisibility:    "",
+	types.MetadataReadWriteVisibility: "",
+}
+
+// getMetadata is a generic function to retrieve metadata from VCD
+func getMetadataByKey(client *Client, requestUri, key string, isSystem bool) (*types.MetadataValue, error) {
+	return getMetadataByKeyWithContext(context.Background(), client, requestUri, key, isSystem)
+}
+
+// getMetadataByKeyWithContext is the implementation of getMetadataByKey, with the request bound to the given context
+func getMetadataByKeyWithContext(ctx context.Context, client *Client, requestUri, key string, isSystem bool) (*types.MetadataValue, error) {
+	return getMetadataByKeyAndDomainWithContext(ctx, client, requestUri, key, metadataDomainName(isSystem))
+}
+
+// getMetadataByKeyAndDomainWithContext is the same as getMetadataByKeyWithContext, but the domain is given
+// explicitly and validated with validateMetadataDomainName
+func getMetadataByKeyAndDomainWithContext(ctx context.Context, client *Client, requestUri, key, domain string) (*types.MetadataValue, error) {
+	metadata := &types.MetadataValue{}
+	domain, err := validateMetadataDomainName(client, domain)
+	if err != nil {
+		return metadata, err
+	}
+
+	opLog := metadataOperationLog{operation: "get by key", href: requestUri, keys: []string{key}, domain: domain}
+	opLog.start()
+	metadata, err = executeGetMetadataByKeyWithContext(ctx, client, requestUri+xmlMetadataKeyDomainPath(key, domain), key)
+	opLog.end(nil, err)
+	return metadata, err
+}
+
+// executeGetMetadataByKeyWithContext sends the request of getMetadataByKeyAndDomainWithContext to the given metadata
+// entry HREF and decodes its response
+func executeGetMetadataByKeyWithContext(ctx context.Context, client *Client, href, key string) (*types.MetadataValue, error) {
+	metadata := &types.MetadataValue{}
+	resp, err := executeRequestCustomErrWithContext(ctx, href, map[string]string{}, http.MethodGet, types.MimeMetaData, nil, client, &types.Error{}, client.APIVersion)
+	if err != nil {
+		// A cancelled context is returned as is, so callers can identify it
+		if ctx.Err() != nil {
+			return metadata, ctx.Err()
+		}
+		if isMetadataKeyNotFound(err) {
+			return metadata, &MetadataKeyNotFoundError{Key: key, Err: err}
+		}
+		return metadata, categorizeMetadataRequestError(http.MethodGet, href, key, fmt.Errorf("error retrieving metadata by key %s: %s", key, err), err)
+	}
+
+	if err = decodeBody(types.BodyTypeXML, resp, metadata); err != nil {
+		return metadata, fmt.Errorf("error decoding response: %s", err)
+	}
+	if err = resp.Body.Close(); err != nil {
+		return metadata, fmt.Errorf("error closing response body: %s", err)
+	}
+	return metadata, nil
+}
+
+// xmlMetadataKeyPath returns the path, relative to the HREF of an entity, of the XML API metadata entry with the given
+// key, which lives under "/metadata/SYSTEM/" for the SYSTEM domain and under "/metadata/" for the GENERAL one.
+func xmlMetadataKeyPath(key string, isSystem bool) string {
+	return xmlMetadataKeyDomainPath(key, metadataDomainName(isSystem))
+}
+
+// xmlMetadataKeyDomainPath is the same as xmlMetadataKeyPath, but the domain is given explicitly. Any domain other
+// than GENERAL lives under "/metadata/<domain>/".
+func xmlMetadataKeyDomainPath(key, domain string) string {
+	if domain == "GENERAL" {
+		return "/metadata/" + key
+	}
+	return "/metadata/" + domain + "/" + key
+}
+
+// metadataDomainName returns the name of the SYSTEM domain (isSystem=true) or the GENERAL domain (isSystem=false)
+func metadataDomainName(isSystem bool) string {
+	if isSystem {
+		return "SYSTEM"
+	}
+	return "GENERAL"
+}
+
+// validateMetadataDomainName returns the given metadata domain in upper case if it is GENERAL or SYSTEM. Other
+// domains are returned as they are if Client.AllowUnknownMetadataDomains is true, or rejected otherwise. A domain
+// that is empty or would change the request path is always rejected.
+func validateMetadataDomainName(client *Client, domain string) (string, error) {
+	upperDomain := strings.ToUpper(domain)
+	if upperDomain == "GENERAL" || upperDomain == "SYSTEM" {
+		return upperDomain, nil
+	}
+	if domain == "" || strings.ContainsAny(domain, "/?#") {
+		return "", fmt.Errorf("invalid metadata domain '%s'", domain)
+	}
+	if client == nil || !client.AllowUnknownMetadataDomains {
+		return "", fmt.Errorf("unknown metadata domain '%s', expected GENERAL or SYSTEM", domain)
+	}
+	return domain, nil
+}
+
+// isMetadataKeyNotFound returns true if the given error is the VCD response to a request for a metadata key that
+// doesn't exist. VCD answers with a 404, or with a 403 whose message states that the entry doesn't exist. Any other
+// 403 is an authorization failure and is not considered as a missing key.
+func isMetadataKeyNotFound(err error) bool {
+	var vcdError *types.Error
+	if !errors.As(err, &vcdError) {
+		return false
+	}
+	switch vcdError.MajorErrorCode {
+	case http.StatusNotFound:
+		return true
+	case http.StatusForbidden:
+		message := strings.ToLower(vcdError.Message)
+		return strings.Contains(message, "does not exist") || strings.Contains(message, "not found")
+	}
+	return false
+}
+
+// getMetadataByKeyIfPresent retrieves the metadata value that corresponds to the given key and domain. It returns
+// (nil, false, nil) when the key doesn't exist and (value, true, nil) when it does, so the error is reserved for
+// genuine failures. It performs a single GET of the given key and doesn't fetch all the metadata of the entity.
+func getMetadataByKeyIfPresent(client *Client, requestUri, key string, isSystem bool) (*types.MetadataValue, bool, error) {
+	return getMetadataByKeyIfPresentWithContext(context.Background(), client, requestUri, key, isSystem)
+}
+
+// getMetadataByKeyIfPresentWithContext is the implementation of getMetadataByKeyIfPresent, with the request bound to
+// the given context
+func getMetadataByKeyIfPresentWithContext(ctx context.Context, client *Client, requestUri, key string, isSystem bool) (*types.MetadataValue, bool, error) {
+	metadataValue, err := getMetadataByKeyWithContext(ctx, client, requestUri, key, isSystem)
+	if err != nil {
+		if errors.Is(err, ErrorEntityNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return metadataValue, true, nil
+}
+
+// getMetadataByKeyWithDomain retrieves the given key from the SYSTEM and the GENERAL domains of the entity, returning
+// both values, with their visibility, in a MetadataKeyDomains. A domain where the key doesn't exist, or that the user
+// can't see, leaves its value as nil, while any other failure is returned as an error.
+func getMetadataByKeyWithDomain(client *Client, requestUri, key string) (*MetadataKeyDomains, error) {
+	keyDomains := &MetadataKeyDomains{Key: key}
+	for _, isSystem := range []bool{true, false} {
+		value, isPresent, err := getMetadataByKeyIfPresent(client, requestUri, key, isSystem)
+		if err != nil {
+			return nil, err
+		}
+		if !isPresent {
+			continue
+		}
+		if isSystem {
+			keyDomains.System = value
+		} else {
+			keyDomains.General = value
+		}
+	}
+	return keyDomains, nil
+}
+
+// waitForMetadataKey polls the metadata of an entity every pollInterval until the given key exists in the given domain
+// with the expected value, or until the timeout elapses. Values are compared in their canonical form, so "01" and "1"
+// are the same number. On timeout, the returned error includes the last observed value.
+// Polling stops as soon as the given context is done, returning ctx.Err().
+func waitForMetadataKey(ctx context.Context, client *Client, requestUri, key, expectedValue string, isSystem bool, timeout, pollInterval time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	lastSeen := "key not present"
+	for {
+		metadataValue, present, err := getMetadataByKeyIfPresentWithContext(ctx, client, requestUri, key, isSystem)
+		if err != nil {
+			return err
+		}
+		if present && metadataValue.TypedValue != nil {
+			expected := &types.MetadataTypedValue{XsiType: metadataValue.TypedValue.XsiType, Value: expectedValue}
+			if canonicalMetadataValue(metadataValue.TypedValue) == canonicalMetadataValue(expected) {
+				return nil
+			}
+			lastSeen = fmt.Sprintf("'%s'", metadataValue.TypedValue.Value)
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return fmt.Errorf("timeout after %s waiting for metadata key '%s' to have value '%s', last observed: %s",
+				timeout, key, expectedValue, lastSeen)
+		}
+		if pollInterval < wait {
+			wait = pollInterval
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// getTypedMetadataByKey retrieves the metadata value that corresponds to the given key and domain, and converts it to
+// the Go type that corresponds to its XsiType:
+// types.MetadataNumberValue is returned as int64, types.MetadataBooleanValue as bool,
+// types.MetadataDateTimeValue as time.Time and types.MetadataStringValue as string.
+// An error is returned if the stored value doesn't match its declared type.
+func getTypedMetadataByKey(client *Client, requestUri, key string, isSystem bool) (interface{}, error) {
+	metadataValue, err := getMetadataByKey(client, requestUri, key, isSystem)
+	if err != nil {
+		return nil, err
+	}
+	return typedMetadataValue(key, metadataValue)
+}
+
+// typedMetadataValue converts the given metadata value, which corresponds to the given key, to its Go type.
+// See parseMetadataTypedValue for details.
+func typedMetadataValue(key string, metadataValue *types.MetadataValue) (interface{}, error) {
+	if metadataValue == nil {
+		return nil, fmt.Errorf("metadata value with key '%s' is empty", key)
+	}
+	value, err := parseMetadataTypedValue(metadataValue.TypedValue)
+	if err != nil {
+		return nil, fmt.Errorf("error converting metadata value with key '%s': %s", key, err)
+	}
+	return value, nil
+}
+
+// getMetadata is a generic function to retrieve metadata from VCD
+func getMetadata(client *Client, requestUri string) (*types.Metadata, error) {
+	return getMetadataWithContext(context.Background(), client, requestUri)
+}
+
+// getMetadataWithContext is the implementation of getMetadata, with the request bound to the given context
+func getMetadataWithContext(ctx context.Context, client *Client, requestUri string) (*types.Metadata, error) {
+	opLog := metadataOperationLog{operation: "get", href: requestUri, domain: "GENERAL,SYSTEM"}
+	opLog.start()
+	metadata, err := executeGetMetadataWithContext(ctx, client, requestUri)
+	opLog.end(nil, err)
+	return metadata, err
+}
+
+// getSystemMetadata retrieves the SYSTEM domain metadata of the given resource reference from its SYSTEM domain path
+func getSystemMetadata(client *Client, requestUri string) (*types.Metadata, error) {
+	return getSystemMetadataWithContext(context.Background(), client, requestUri)
+}
+
+// getSystemMetadataWithContext is the implementation of getSystemMetadata, with the request bound to the given context
+func getSystemMetadataWithContext(ctx context.Context, client *Client, requestUri string) (*types.Metadata, error) {
+	opLog := metadataOperationLog{operation: "get", href: requestUri, domain: "SYSTEM"}
+	opLog.start()
+	metadata, err := executeGetMetadataPathWithContext(ctx, client, requestUri+"/metadata/SYSTEM/")
+	opLog.end(nil, err)
+	return metadata, err
+}
+
+// getGeneralMetadata retrieves the whole metadata of the given resource reference, as there is no GENERAL domain path,
+// and keeps only the GENERAL domain entries
+func getGeneralMetadata(client *Client, requestUri string) (*types.Metadata, error) {
+	metadata, err := getMetadata(client, requestUri)
+	if err != nil {
+		return nil, err
+	}
+	return FilterMetadata(metadata, FilterByDomain(false)), nil
+}
+
+// executeGetMetadataWithContext sends the request of getMetadataWithContext and decodes its response
+func executeGetMetadataWithContext(ctx context.Context, client *Client, requestUri string) (*types.Metadata, error) {
+	return executeGetMetadataPathWithContext(ctx, client, requestUri+"/metadata/")
+}
+
+// executeGetMetadataPathWithContext retrieves and decodes the metadata at the given metadata endpoint, such as
+// the base '/metadata/' path of an entity or the path of one of its domains
+func executeGetMetadataPathWithContext(ctx context.Context, client *Client, metadataUri string) (*types.Metadata, error) {
+	metadata := &types.Metadata{}
+
+	resp, err := executeRequestCustomErrWithContext(ctx, metadataUri, map[string]string{}, http.MethodGet, types.MimeMetaData, nil, client, &types.Error{}, client.APIVersion)
+	if err != nil {
+		// A cancelled context is returned as is, so callers can identify it
+		if ctx.Err() != nil {
+			return metadata, ctx.Err()
+		}
+		return metadata, categorizeMetadataRequestError(http.MethodGet, metadataUri, "", fmt.Errorf("error retrieving metadata: %s", err), err)
+	}
+	if client.MetadataStreamThresholdBytes <= 0 {
+		err = decodeBody(types.BodyTypeXML, resp, metadata)
+		if err != nil {
+			return metadata, fmt.Errorf("error decoding response: %s", err)
+		}
+	} else {
+		err = decodeMetadataBody(client, resp, metadata)
+		if err != nil {
+			return metadata, err
+		}
+	}
+	if err = resp.Body.Close(); err != nil {
+		return metadata, fmt.Errorf("error closing response body: %s", err)
+	}
+	return metadata, nil
+}
+
+// decodeMetadataBody decodes the given response into the given metadata. Responses bigger than
+// Client.MetadataStreamThresholdBytes, or of unknown size, are decoded while they are read so they are never held in
+// memory as a whole, and are not logged. Smaller responses are decoded with decodeBody, as any other response.
+func decodeMetadataBody(client *Client, resp *http.Response, metadata *types.Metadata) error {
+	if resp.ContentLength >= 0 && resp.ContentLength <= client.MetadataStreamThresholdBytes {
+		if err := decodeBody(types.BodyTypeXML, resp, metadata); err != nil {
+			return fmt.Errorf("error decoding response: %s", err)
+		}
+		return nil
+	}
+
+	util.Logger.Printf("[DEBUG - decodeMetadataBody] streaming metadata response of %d bytes from %s", resp.ContentLength, resp.Request.URL)
+	if err := xml.NewDecoder(resp.Body).Decode(metadata); err != nil && err != io.EOF {
+		return fmt.Errorf("error decoding response: %s", err)
+	}
+	return nil
+}
+
+// addMetadata adds metadata to an entity.
+// If the metadata entry is of the SYSTEM domain (isSystem=true), one can set different types of Visibility:
+// types.MetadataReadOnlyVisibility, types.MetadataHiddenVisibility but NOT types.MetadataReadWriteVisibility, which is
+// only accepted for Provider VDCs (see addMetadataWithContext).
+// If the metadata entry is of the GENERAL domain (isSystem=false), visibility is always types.MetadataReadWriteVisibility.
+// In terms of typedValues, that must be one of:
+// types.MetadataStringValue, types.MetadataNumberValue, types.MetadataDateTimeValue and types.MetadataBooleanValue.
+// Transient errors are retried as configured by Client.MetadataRetryCount and Client.MetadataRetryBackoff.
+func addMetadata(client *Client, requestUri, key, value, typedValue, visibility string, isSystem bool) (Task, error) {
+	return addMetadataWithContext(context.Background(), client, requestUri, key, value, typedValue, visibility, isSystem, false)
+}
+
+// addMetadataWithContext is the implementation of addMetadata, with the request bound to the given context.
+// allowSystemReadWrite must only be true for entities that accept SYSTEM metadata with
+// types.MetadataReadWriteVisibility, which VCD only allows for Provider VDCs when connected as system administrator.
+func addMetadataWithContext(ctx context.Context, client *Client, requestUri, key, value, typedValue, visibility string, isSystem, allowSystemReadWrite bool) (Task, error) {
+	err := validateMetadataEntry(key, value, typedValue, visibility)
+	if err != nil {
+		return Task{}, err
+	}
+	if isSystem {
+		err = checkProtectedSystemMetadataKey(client, key)
+		if err != nil {
+			return Task{}, err
+		}
+		err = validateMetadataVisibilityApiVersion(client, requestUri, visibility)
+		if err != nil {
+			return Task{}, err
+		}
+	}
+	if isSystem && visibility == types.MetadataReadWriteVisibility && !allowSystemReadWrite {
+		return Task{}, &MetadataValidationError{Key: key, Reason: "visibility READWRITE in SYSTEM domain is only allowed for Provider VDCs as system administrator", Err: ErrInvalidMetadataValue}
+	}
+
+	apiEndpoint := urlParseRequestURI(requestUri)
+	newMetadata := &types.MetadataValue{
+		Xmlns: types.XMLNamespaceVCloud,
+		Xsi:   types.XMLNamespaceXSI,
+		TypedValue: &types.MetadataTypedValue{
+			XsiType: typedValue,
+			Value:   value,
+		},
+		Domain: &types.MetadataDomainTag{
+			Visibility: visibility,
+			Domain:     "SYSTEM",
+		},
+	}
+
+	apiEndpoint.Path += xmlMetadataKeyPath(key, isSystem)
+	if !isSystem {
+		newMetadata.Domain.Domain = "GENERAL"
+		if visibility != types.MetadataReadWriteVisibility {
+			newMetadata.Domain.Visibility = types.MetadataReadWriteVisibility
+		}
+	}
+
+	domain := newMetadata.Domain.Visibility
+	opLog := metadataOperationLog{operation: "add", href: requestUri, keys: []string{key}, domain: newMetadata.Domain.Domain, values: map[string]string{key: value}}
+	opLog.start()
+	task, err := executeMetadataWriteRequest(ctx, client, requestUri, apiEndpoint.String(), key, http.MethodPut, types.MimeMetaDataValue, "error adding metadata: %s", newMetadata)
+	opLog.end(&task, err)
+
+	// Workaround for ugly error returned by VCD: "API Error: 500: [ <uuid> ] visibility"
+	if err != nil && strings.HasSuffix(err.Error(), "visibility") {
+		err = fmt.Errorf("error adding metadata with key %s: visibility cannot be %s when domain is %s: %s", key, visibility, domain, err)
+	}
+	return task, err
+}
+
+// executeMetadataWriteRequest sends a request that modifies the metadata of the entity with the given URI to the given
+// metadata endpoint of the entity, retrying it as configured by Client.MetadataRetryCount and
+// Client.MetadataRetryBackoff, and returns the task. Once the request is sent, the write is recorded with
+// recordMetadataWrite, so the metadata caches of the entity become stale. When VCD rejects the request, the error is
+// converted with categorizeMetadataRequestError, using the given key, which is empty when several entries are sent.
+func executeMetadataWriteRequest(ctx context.Context, client *Client, requestUri, endpoint, key, method, contentType, errorMessage string, payload interface{}) (Task, error) {
+	if !isMessageWithPlaceHolder(errorMessage) {
+		return Task{}, fmt.Errorf("error message has to include place holder for error")
+	}
+
+	resp, err := client.executeRequestWithRetry(ctx, client.MetadataRetryCount, client.MetadataRetryBackoff, endpoint, method, contentType, payload, client.APIVersion)
+	recordMetadataWrite(requestUri)
+	if err != nil {
+		// A cancelled context is returned as is, so callers can identify it
+		if ctx.Err() != nil {
+			return Task{}, ctx.Err()
+		}
+		return Task{}, categorizeMetadataRequestError(method, endpoint, key, fmt.Errorf(errorMessage, err), err)
+	}
+	return decodeTaskResponse(client, resp, errorMessage)
+}
+
+// addMetadataAndWait adds metadata to an entity and waits for the task completion.
+// The function supports passing a value that requires a typed value that must be one of:
+// types.MetadataStringValue, types.MetadataNumberValue, types.MetadataDateTimeValue and types.MetadataBooleanValue.
+// Visibility also needs to be one of: types.MetadataReadOnlyVisibility, types.MetadataHiddenVisibility or types.MetadataReadWriteVisibility
+func addMetadataAndWait(client *Client, requestUri, key, value, typedValue, visibility string, isSystem bool) error {
+	return addMetadataAndWaitWithContext(context.Background(), client, requestUri, key, value, typedValue, visibility, isSystem, false)
+}
+
+// addMetadataAndWaitWithContext is the implementation of addMetadataAndWait, which stops waiting for the task as soon
+// as the given context is cancelled. See addMetadataWithContext for allowSystemReadWrite.
+func addMetadataAndWaitWithContext(ctx context.Context, client *Client, requestUri, key, value, typedValue, visibility string, isSystem, allowSystemReadWrite bool) error {
+	oldValue := metadataValueBeforeChange(ctx, client, requestUri, key, isSystem)
+	task, err := addMetadataWithContext(ctx, client, requestUri, key, value, typedValue, visibility, isSystem, allowSystemReadWrite)
+	if err != nil {
+		return err
+	}
+
+	err = task.WaitTaskCompletionWithContext(ctx)
+	if err != nil {
+		return err
+	}
+	notifyMetadataChange(client, requestUri, key, MetadataChangeAdd, oldValue, storedMetadataValue(value, typedValue, visibility, isSystem))
+	return nil
+}
+
+// addMetadataValueAndWait adds the given metadata value with addMetadataAndWait, so it gets the same validation.
+// The value must have a TypedValue, and its Domain, if present, must be GENERAL or SYSTEM.
+func addMetadataValueAndWait(client *Client, requestUri, key string, value *types.MetadataValue) error {
+	if value == nil || value.TypedValue == nil {
+		return &MetadataValidationError{Key: key, Reason: "metadata value has no typed value", Err: ErrInvalidMetadataValue}
+	}
+	domain := effectiveMetadataDomain(value.Domain)
+	if domain.Domain != "GENERAL" && domain.Domain != "SYSTEM" {
+		return &MetadataValidationError{Key: key, Reason: fmt.Sprintf("unknown domain '%s'", domain.Domain), Err: ErrInvalidMetadataValue}
+	}
+	return addMetadataAndWait(client, requestUri, key, value.TypedValue.Value, value.TypedValue.XsiType, domain.Visibility, domain.Domain == "SYSTEM")
+}
+
+// addMetadataAndWaitWithTimeout is the same as addMetadataAndWait, but it stops waiting for the task after the
+// given timeout. See waitMetadataTaskWithTimeout for details.
+func addMetadataAndWaitWithTimeout(client *Client, requestUri, key, value, typedValue, visibility string, isSystem bool, timeout time.Duration) error {
+	err := validateMetadataTimeout(timeout)
+	if err != nil {
+		return err
+	}
+	oldValue := metadataValueBeforeChange(context.Background(), client, requestUri, key, isSystem)
+	task, err := addMetadata(client, requestUri, key, value, typedValue, visibility, isSystem)
+	if err != nil {
+		return err
+	}
+
+	err = waitMetadataTaskWithTimeout(task, timeout)
+	if err != nil {
+		return err
+	}
+	notifyMetadataChange(client, requestUri, key, MetadataChangeAdd, oldValue, storedMetadataValue(value, typedValue, visibility, isSystem))
+	return nil
+}
+
+// mergeMetadataAndWaitWithTimeout is the same as mergeMetadataAndWait, but it stops waiting for the task after the
+// given timeout. See waitMetadataTaskWithTimeout for details.
+func mergeMetadataAndWaitWithTimeout(client *Client, requestUri string, metadata map[string]types.MetadataValue, timeout time.Duration) error {
+	err := validateMetadataTimeout(timeout)
+	if err != nil {
+		return err
+	}
+	oldMetadata := metadataBeforeChange(context.Background(), client, requestUri)
+	task, err := mergeAllMetadata(client, requestUri, metadata)
+	if err != nil {
+		return err
+	}
+
+	err = waitMetadataTaskWithTimeout(task, timeout)
+	if err != nil {
+		return err
+	}
+	notifyMetadataMerge(client, requestUri, oldMetadata, metadata)
+	return nil
+}
+
+// deleteMetadataAndWaitWithTimeout is the same as deleteMetadataAndWait, but it stops waiting for the task after the
+// given timeout. See waitMetadataTaskWithTimeout for details.
+func deleteMetadataAndWaitWithTimeout(client *Client, requestUri string, key string, isSystem bool, timeout time.Duration) error {
+	err := validateMetadataTimeout(timeout)
+	if err != nil {
+		return err
+	}
+	oldValue := metadataValueBeforeChange(context.Background(), client, requestUri, key, isSystem)
+	task, err := deleteMetadata(client, requestUri, key, isSystem)
+	if err != nil {
+		return err
+	}
+
+	err = waitMetadataTaskWithTimeout(task, timeout)
+	if err != nil {
+		return err
+	}
+	notifyMetadataChange(client, requestUri, key, MetadataChangeDelete, oldValue, nil)
+	return nil
+}
+
+// waitMetadataTaskWithTimeout polls the given metadata task until it finishes or the timeout expires. In the latter
+// case, it returns an error that contains the last known status and the HREF of the task, so it can be followed up.
+// The timeout must have been checked with validateMetadataTimeout before sending the request that created the task.
+func waitMetadataTaskWithTimeout(task Task, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := task.WaitTaskCompletionWithContext(ctx)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		status, href := "unknown", "unknown"
+		if task.Task != nil {
+			status, href = task.Task.Status, task.Task.HREF
+		}
+		return fmt.Errorf("timeout of %s waiting for metadata task %s, last known status '%s'", timeout, href, status)
+	}
+	return err
+}
+
+// validateMetadataTimeout returns an error if the given timeout is lower or equal than zero, so the metadata functions
+// with timeout can reject it before sending any request.
+func validateMetadataTimeout(timeout time.Duration) error {
+	if timeout <= 0 {
+		return fmt.Errorf("invalid timeout %s waiting for metadata task: it must be greater than zero", timeout)
+	}
+	return nil
+}
+
+// waitMetadataTask waits for the given metadata task to finish, unless an error is given, and returns the task with its
+// final state, including ID, owner and timestamps. The task is also returned when it fails, so it can be inspected.
+func waitMetadataTask(task Task, err error) (Task, error) {
+	if err != nil {
+		return task, err
+	}
+	err = task.WaitTaskCompletion()
+	return task, err
+}
+
+// updateMetadataValues reads the current metadata of the given domain and calls the updater function for each of the
+// given keys, passing the current value (nil if the key is absent). Entries that must be kept are merged in a single
+// task, preserving their current visibility, and entries with keep=false are deleted.
+// New entries get types.MetadataReadWriteVisibility in GENERAL domain and types.MetadataReadOnlyVisibility in SYSTEM domain.
+// NOTE: This is a read-modify-write operation and it is not atomic: metadata modified by someone else between the read
+// and the write will be overwritten. Use SetMetadataEntryVerified to verify critical writes.
+func updateMetadataValues(client *Client, requestUri string, keys []string, updater MetadataUpdater, isSystem bool) error {
+	if updater == nil {
+		return fmt.Errorf("metadata updater function cannot be nil")
+	}
+	metadata, err := getMetadata(client, requestUri)
+	if err != nil {
+		return err
+	}
+
+	current := map[string]*types.MetadataValue{}
+	for _, entry := range metadata.MetadataEntry {
+		if isMetadataEntryInDomain(entry, isSystem) {
+			current[entry.Key] = &types.MetadataValue{Domain: entry.Domain, TypedValue: entry.TypedValue}
+		}
+	}
+
+	domain := "GENERAL"
+	defaultVisibility := types.MetadataReadWriteVisibility
+	if isSystem {
+		domain = "SYSTEM"
+		defaultVisibility = types.MetadataReadOnlyVisibility
+	}
+
+	toMerge := map[string]types.MetadataValue{}
+	var toDelete []string
+	for _, key := range keys {
+		currentValue := current[key]
+		newValue, newType, keep := updater(key, currentValue)
+		if !keep {
+			if currentValue != nil {
+				toDelete = append(toDelete, key)
+			}
+			continue
+		}
+		visibility := defaultVisibility
+		if currentValue != nil && currentValue.Domain != nil && currentValue.Domain.Visibility != "" {
+			visibility = currentValue.Domain.Visibility
+		}
+		toMerge[key] = types.MetadataValue{
+			Domain:     &types.MetadataDomainTag{Domain: domain, Visibility: visibility},
+			TypedValue: &types.MetadataTypedValue{XsiType: newType, Value: newValue},
+		}
+	}
+
+	if len(toMerge) > 0 {
+		err = mergeMetadataAndWait(client, requestUri, toMerge)
+		if err != nil {
+			return err
+		}
+	}
+
+	return deleteMetadataEntries(client, requestUri, toDelete, isSystem)
+}
+
+// deleteMetadataEntries deletes the metadata entries associated to the given keys from an entity referenced by its
+// URI, waiting for all the tasks to finish. Deletions run concurrently, with at most metadataDeleteConcurrency
+// simultaneous tasks. A failed deletion doesn't stop the rest, and all the failures are returned in a single
+// *MetadataMultiError indexed by metadata key.
+func deleteMetadataEntries(client *Client, requestUri string, keys []string, isSystem bool) error {
+	errs := make([]error, len(keys))
+	runMetadataWorkers(len(keys), metadataDeleteConcurrency, func(index int) {
+		errs[index] = deleteMetadataAndWait(client, requestUri, keys[index], isSystem)
+	})
+
+	multiError := newMetadataMultiError("deleting metadata")
+	for index, err := range errs {
+		multiError.add(keys[index], err)
+	}
+	return multiError.errorOrNil()
+}
+
+// normalizeMetadataDomains rewrites the metadata entries of an entity referenced by its URI that belong to the GENERAL
+// domain when isSystem=false, or to the SYSTEM domain when isSystem=true, but whose Domain doesn't state a visibility,
+// with the default visibility of that domain: types.MetadataReadWriteVisibility for GENERAL and
+// types.MetadataReadOnlyVisibility for SYSTEM. All the entries are rewritten in a single merge task.
+// The domain of every entry is evaluated with effectiveMetadataDomain, so the entries without Domain, which VCD
+// returns for GENERAL entries with types.MetadataReadWriteVisibility, are already normalized and are never rewritten.
+// Entries are never moved between domains. It returns how many entries were rewritten, and it is idempotent: once all
+// the entries have a visibility, no request other than the initial GET is sent.
+func normalizeMetadataDomains(client *Client, requestUri string, isSystem bool) (int, error) {
+	metadata, err := getMetadata(client, requestUri)
+	if err != nil {
+		return 0, err
+	}
+
+	domain := types.MetadataDomainTag{Domain: "GENERAL", Visibility: types.MetadataReadWriteVisibility}
+	if isSystem {
+		domain = types.MetadataDomainTag{Domain: "SYSTEM", Visibility: types.MetadataReadOnlyVisibility}
+	}
+	toMerge := map[string]types.MetadataValue{}
+	for _, entry := range metadata.MetadataEntry {
+		if entry == nil || entry.TypedValue == nil {
+			continue
+		}
+		entryDomain := effectiveMetadataDomain(entry.Domain)
+		if entryDomain.Domain != domain.Domain || entryDomain.Visibility != "" {
+			continue
+		}
+		toMerge[entry.Key] = types.MetadataValue{
+			TypedValue: &types.MetadataTypedValue{XsiType: entry.TypedValue.XsiType, Value: entry.TypedValue.Value},
+			Domain:     &types.MetadataDomainTag{Domain: domain.Domain, Visibility: domain.Visibility},
+		}
+	}
+	if len(toMerge) == 0 {
+		return 0, nil
+	}
+
+	err = mergeMetadataAndWait(client, requestUri, toMerge)
+	if err != nil {
+		return 0, err
+	}
+	return len(toMerge), nil
+}
+
+// mergeMetadataReturningValues merges the given metadata in an entity referenced by its URI, as mergeMetadataAndWait
+// does, and once the task has finished it retrieves all the metadata of the entity with a single request, returning the
+// stored values of the merged keys, indexed by key. Every key is looked up in the domain of its given value, GENERAL
+// when missing. A key that is not present after the merge returns an error, alongside the values that were found.
+func mergeMetadataReturningValues(client *Client, requestUri string, metadata map[string]types.MetadataValue) (map[string]*types.MetadataValue, error) {
+	err := mergeMetadataAndWait(client, requestUri, metadata)
+	if err != nil {
+		return nil, err
+	}
+	storedMetadata, err := getMetadata(client, requestUri)
+	if err != nil {
+		return nil, fmt.Errorf("metadata merged, but it couldn't be retrieved afterwards: %s", err)
+	}
+
+	values := make(map[string]*types.MetadataValue, len(metadata))
+	var missingKeys []string
+	for key, value := range metadata {
+		entry, found := storedMetadata.GetByKey(key, effectiveMetadataDomain(value.Domain).Domain == "SYSTEM")
+		if !found {
+			missingKeys = append(missingKeys, key)
+			continue
+		}
+		values[key] = &types.MetadataValue{
+			Xmlns:      types.XMLNamespaceVCloud,
+			Xsi:        types.XMLNamespaceXSI,
+			Domain:     entry.Domain,
+			TypedValue: entry.TypedValue,
+		}
+	}
+	if len(missingKeys) > 0 {
+		sort.Strings(missingKeys)
+		return values, fmt.Errorf("metadata merged, but the keys %v are not present afterwards", missingKeys)
+	}
+	return values, nil
+}
+
+// deleteExpiredMetadata deletes the metadata entries of the SYSTEM domain (isSystem=true) or the GENERAL domain
+// (isSystem=false) of an entity referenced by its URI whose companion expiry entry, with the key returned by
+// MetadataExpiryKey, is not after the given time. Both the entry and its companion are deleted, and a companion
+// without entry is deleted as well. The keys of the removed entries are returned, sorted alphabetically.
+// Companions with an invalid expiry are left untouched. Those, and the failed deletions, are returned in a single
+// *MetadataMultiError indexed by metadata key, alongside the keys that were removed.
+func deleteExpiredMetadata(client *Client, requestUri string, now time.Time, isSystem bool) ([]string, error) {
+	metadata, err := getMetadata(client, requestUri)
+	if err != nil {
+		return nil, err
+	}
+
+	multiError := newMetadataMultiError("deleting expired metadata")
+	present := map[string]bool{}
+	var expired []string
+	for _, entry := range metadata.MetadataEntry {
+		if !isMetadataEntryInDomain(entry, isSystem) {
+			continue
+		}
+		present[entry.Key] = true
+		key := strings.TrimSuffix(entry.Key, MetadataExpiryKeySuffix)
+		if key == entry.Key || key == "" || entry.TypedValue == nil {
+			continue
+		}
+		expiresAt, err := DecodeMetadataExpiry(entry.TypedValue.Value)
+		if err != nil {
+			multiError.add(entry.Key, err)
+			continue
+		}
+		if !expiresAt.After(now) {
+			expired = append(expired, key)
+		}
+	}
+	sort.Strings(expired)
+
+	var toDelete []string
+	for _, key := range expired {
+		if present[key] {
+			toDelete = append(toDelete, key)
+		}
+		toDelete = append(toDelete, MetadataExpiryKey(key))
+	}
+	err = deleteMetadataEntries(client, requestUri, toDelete, isSystem)
+	if err != nil {
+		deleteErrors, ok := err.(*MetadataMultiError)
+		if !ok {
+			return nil, err
+		}
+		multiError.addAll(deleteErrors.Errors)
+	}
+
+	removed := []string{}
+	for _, key := range expired {
+		if multiError.Errors[key] == nil && multiError.Errors[MetadataExpiryKey(key)] == nil {
+			removed = append(removed, key)
+		}
+	}
+	return removed, multiError.errorOrNil()
+}
+
+// replaceAllMetadata makes the metadata entries of the SYSTEM domain (isSystem=true) or the GENERAL domain
+// (isSystem=false) match exactly the given metadata: entries that differ or don't exist are merged in a single task,
+// and keys that are not in the given metadata are deleted. Entries of the other domain are never modified.
+// Entries without Domain get types.MetadataReadWriteVisibility in GENERAL domain and
+// types.MetadataReadOnlyVisibility in SYSTEM domain. Entries of the other domain are rejected before sending any
+// request.
+// Deletions are performed even if the merge fails, and all the failures are returned in a single *MetadataMultiError
+// indexed by metadata key.
+func replaceAllMetadata(client *Client, requestUri string, metadata map[string]types.MetadataValue, isSystem bool) error {
+	_, toMerge, toDelete, err := diffAllMetadata(client, requestUri, metadata, isSystem)
+	if err != nil {
+		return err
+	}
+
+	multiError := newMetadataMultiError("replacing metadata")
+	if len(toMerge) > 0 {
+		err = mergeMetadataAndWait(client, requestUri, toMerge)
+		if err != nil {
+			for key := range toMerge {
+				multiError.add(key, err)
+			}
+		}
+	}
+
+	err = deleteMetadataEntries(client, requestUri, toDelete, isSystem)
+	if err != nil {
+		deleteErrors, ok := err.(*MetadataMultiError)
+		if !ok {
+			return err
+		}
+		multiError.addAll(deleteErrors.Errors)
+	}
+
+	return multiError.errorOrNil()
+}
+
+// diffAllMetadata reads the metadata of the given domain of an entity and computes the changes needed to make it
+// match exactly the given metadata, as described in replaceAllMetadata. It returns the current metadata of the
+// domain, the entries to merge and the keys to delete. Only one GET request is sent.
+func diffAllMetadata(client *Client, requestUri string, metadata map[string]types.MetadataValue, isSystem bool) (*types.Metadata, map[string]types.MetadataValue, []string, error) {
+	desired := map[string]types.MetadataValue{}
+	for key, value := range metadata {
+		if value.Domain == nil || value.Domain.Domain == "" {
+			value.Domain = &types.MetadataDomainTag{Domain: "GENERAL", Visibility: types.MetadataReadWriteVisibility}
+			if isSystem {
+				value.Domain = &types.MetadataDomainTag{Domain: "SYSTEM", Visibility: types.MetadataReadOnlyVisibility}
+			}
+		}
+		if (value.Domain.Domain == "SYSTEM") != isSystem {
+			return nil, nil, nil, fmt.Errorf("metadata entry with key '%s' belongs to %s domain, which is not the one being replaced", key, value.Domain.Domain)
+		}
+		desired[key] = value
+	}
+
+	currentMetadata, err := getMetadata(client, requestUri)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	current := &types.Metadata{}
+	for _, entry := range currentMetadata.MetadataEntry {
+		if isMetadataEntryInDomain(entry, isSystem) {
+			current.MetadataEntry = append(current.MetadataEntry, entry)
+		}
+	}
+
+	toMerge, toDelete := DiffMetadata(current, desired)
+	return current, toMerge, toDelete, nil
+}
+
+// planReplaceAllMetadata returns the changes that replaceAllMetadata would perform, without modifying any metadata.
+// Only one GET request is sent.
+func planReplaceAllMetadata(client *Client, requestUri string, metadata map[string]types.MetadataValue, isSystem bool) (MetadataChangePlan, error) {
+	current, toMerge, toDelete, err := diffAllMetadata(client, requestUri, metadata, isSystem)
+	if err != nil {
+		return MetadataChangePlan{}, err
+	}
+	return newMetadataChangePlan(current, toMerge, toDelete), nil
+}
+
+// planMergeMetadata returns the changes that mergeAllMetadata would perform, without modifying any metadata. The
+// entries are validated as in mergeAllMetadata, and the ones that already have the same value, type, domain and
+// visibility are left out of the plan. Only one GET request is sent.
+func planMergeMetadata(client *Client, requestUri string, metadata map[string]types.MetadataValue) (MetadataChangePlan, error) {
+	err := validateMetadataValues(metadata)
+	if err != nil {
+		return MetadataChangePlan{}, err
+	}
+	current, err := getMetadata(client, requestUri)
+	if err != nil {
+		return MetadataChangePlan{}, err
+	}
+	toMerge, _ := DiffMetadata(current, metadata)
+	return newMetadataChangePlan(current, toMerge, nil), nil
+}
+
+// newMetadataChangePlan classifies the entries to merge into the current metadata as additions or updates, and
+// returns them in a MetadataChangePlan together with the keys to delete
+func newMetadataChangePlan(current *types.Metadata, toMerge map[string]types.MetadataValue, toDelete []string) MetadataChangePlan {
+	plan := MetadataChangePlan{
+		ToAdd:    map[string]types.MetadataValue{},
+		ToUpdate: map[string]MetadataValueChange{},
+		ToDelete: toDelete,
+	}
+	for key, value := range toMerge {
+		isSystem := effectiveMetadataDomain(value.Domain).Domain == "SYSTEM"
+		entry := findMetadataEntry(current, key, isSystem)
+		if entry == nil {
+			plan.ToAdd[key] = value
+			continue
+		}
+		plan.ToUpdate[key] = MetadataValueChange{
+			Old: types.MetadataValue{Domain: entry.Domain, TypedValue: entry.TypedValue},
+			New: value,
+		}
+	}
+	return plan
+}
+
+// replaceMetadataEntry moves the metadata entry with the given key from the domain given by currentSystem to the domain
+// given by targetSystem, setting the new value, type and visibility. The visibility is validated against the target
+// domain before sending any request.
+// The entry is created in the target domain before deleting it from the current one, so it is never lost. If the
+// deletion fails, the target domain is rolled back to its previous state. If the rollback fails too, both errors
+// are returned.
+// If both domains are the same, the entry is just overwritten.
+func replaceMetadataEntry(client *Client, requestUri, key, newValue, newType, newVisibility string, targetSystem, currentSystem bool) error {
+	err := validateMetadataVisibility(newVisibility, targetSystem)
+	if err != nil {
+		return fmt.Errorf("error replacing metadata with key '%s': %s", key, err)
+	}
+
+	metadata, err := getMetadata(client, requestUri)
+	if err != nil {
+		return err
+	}
+	if findMetadataEntry(metadata, key, currentSystem) == nil {
+		return fmt.Errorf("%s: metadata entry with key '%s' not found", ErrorEntityNotFound, key)
+	}
+	if targetSystem == currentSystem {
+		return addMetadataAndWait(client, requestUri, key, newValue, newType, newVisibility, targetSystem)
+	}
+	previousTarget := findMetadataEntry(metadata, key, targetSystem)
+
+	err = addMetadataAndWait(client, requestUri, key, newValue, newType, newVisibility, targetSystem)
+	if err != nil {
+		return fmt.Errorf("error creating metadata with key '%s' in the target domain: %s", key, err)
+	}
+
+	err = deleteMetadataAndWait(client, requestUri, key, currentSystem)
+	if err == nil {
+		return nil
+	}
+
+	var rollbackErr error
+	if previousTarget != nil && previousTarget.TypedValue != nil {
+		visibility := effectiveMetadataDomain(previousTarget.Domain).Visibility
+		rollbackErr = addMetadataAndWait(client, requestUri, key, previousTarget.TypedValue.Value, previousTarget.TypedValue.XsiType, visibility, targetSystem)
+	} else {
+		rollbackErr = deleteMetadataAndWait(client, requestUri, key, targetSystem)
+	}
+	if rollbackErr != nil {
+		return fmt.Errorf("error deleting metadata with key '%s' from the current domain: %s. Rollback of the target domain failed: %s", key, err, rollbackErr)
+	}
+	return fmt.Errorf("error deleting metadata with key '%s' from the current domain, the change was rolled back: %s", key, err)
+}
+
+// countMetadata returns the number of metadata entries of an entity in the domain given by isSystem.
+// VCD doesn't provide an endpoint that returns only the number of entries, so all the metadata is retrieved with a
+// single request and counted.
+func countMetadata(client *Client, requestUri string, isSystem bool) (int, error) {
+	metadata, err := getMetadata(client, requestUri)
+	if err != nil {
+		return 0, err
+	}
+	return len(metadata.Keys(isSystem)), nil
+}
+
+// renameMetadataKey moves the metadata entry with the given old key to the new key, both in the domain given by
+// isSystem, preserving its value, type and visibility. VCD can't rename keys, so the entry is created with the new key
+// before deleting the old one, waiting for both tasks, so it is never lost.
+// If the new key already exists, an error is returned unless overwrite is true. If the deletion of the old key fails,
+// the new key is rolled back to its previous state. If the rollback fails too, both errors are returned.
+func renameMetadataKey(client *Client, requestUri, oldKey, newKey string, isSystem, overwrite bool) error {
+	if oldKey == newKey {
+		return fmt.Errorf("error renaming metadata key '%s': the new key is the same as the old one", oldKey)
+	}
+
+	current, err := getMetadataByKey(client, requestUri, oldKey, isSystem)
+	if err != nil {
+		return err
+	}
+	if current.TypedValue == nil {
+		return fmt.Errorf("error renaming metadata key '%s': the entry has no value", oldKey)
+	}
+	visibility := effectiveMetadataDomain(current.Domain).Visibility
+	err = validateMetadataEntry(newKey, current.TypedValue.Value, current.TypedValue.XsiType, visibility)
+	if err != nil {
+		return fmt.Errorf("error renaming metadata key '%s': %s", oldKey, err)
+	}
+
+	previousNew, isPresent, err := getMetadataByKeyIfPresent(client, requestUri, newKey, isSystem)
+	if err != nil {
+		return err
+	}
+	if isPresent && !overwrite {
+		return fmt.Errorf("error renaming metadata key '%s': key '%s' already exists", oldKey, newKey)
+	}
+
+	err = addMetadataAndWait(client, requestUri, newKey, current.TypedValue.Value, current.TypedValue.XsiType, visibility, isSystem)
+	if err != nil {
+		return fmt.Errorf("error creating metadata with key '%s': %s", newKey, err)
+	}
+
+	err = deleteMetadataAndWait(client, requestUri, oldKey, isSystem)
+	if err == nil {
+		return nil
+	}
+
+	var rollbackErr error
+	if isPresent && previousNew.TypedValue != nil {
+		previousVisibility := effectiveMetadataDomain(previousNew.Domain).Visibility
+		rollbackErr = addMetadataAndWait(client, requestUri, newKey, previousNew.TypedValue.Value, previousNew.TypedValue.XsiType, previousVisibility, isSystem)
+	} else {
+		rollbackErr = deleteMetadataAndWait(client, requestUri, newKey, isSystem)
+	}
+	if rollbackErr != nil {
+		return fmt.Errorf("error deleting metadata with key '%s': %s. Rollback of key '%s' failed: %s", oldKey, err, newKey, rollbackErr)
+	}
+	return fmt.Errorf("error deleting metadata with key '%s', the rename was rolled back: %s", oldKey, err)
+}
+
+// applyMetadataDefaults reads the metadata of the given resource and merges, in a single task, only the default entries
+// whose key is not present yet, so values set by other users are never overwritten. The returned slice contains the
+// keys of the applied defaults, sorted alphabetically.
+// Every default entry is checked and created in its own domain. Entries without a Domain use the domain given by
+// isSystem, with types.MetadataReadWriteVisibility in GENERAL domain and types.MetadataReadOnlyVisibility in
+// SYSTEM domain.
+func applyMetadataDefaults(client *Client, requestUri string, defaults map[string]types.MetadataValue, isSystem bool) ([]string, error) {
+	metadata, err := getMetadata(client, requestUri)
+	if err != nil {
+		return nil, err
+	}
+
+	toMerge := map[string]types.MetadataValue{}
+	for key, value := range defaults {
+		domain := &types.MetadataDomainTag{Domain: "GENERAL", Visibility: types.MetadataReadWriteVisibility}
+		if isSystem {
+			domain = &types.MetadataDomainTag{Domain: "SYSTEM", Visibility: types.MetadataReadOnlyVisibility}
+		}
+		if value.Domain != nil && value.Domain.Domain != "" {
+			domain = value.Domain
+		}
+		entryIsSystem := domain.Domain == "SYSTEM"
+
+		if findMetadataEntry(metadata, key, entryIsSystem) != nil {
+			continue
+		}
+		toMerge[key] = types.MetadataValue{Domain: domain, TypedValue: value.TypedValue}
+	}
+
+	if len(toMerge) == 0 {
+		return []string{}, nil
+	}
+	err = mergeMetadataAndWait(client, requestUri, toMerge)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make([]string, 0, len(toMerge))
+	for key := range toMerge {
+		applied = append(applied, key)
+	}
+	sort.Strings(applied)
+	return applied, nil
+}
+
+// setMetadataEntryVerified adds metadata to an entity and waits for the task completion, then reads the entry back
+// up to 'attempts' times, waiting 'delay' between reads, until the stored value, type and visibility match the requested
+// ones. Values are compared in their canonical form, so "01" and "1" are the same number.
+// If the entry doesn't match after all the reads, the metadata is written again, up to 'maxWrites' times in total, and
+// verified again, returning an error if it still doesn't converge. This is useful for VCD cells where writes are not
+// immediately visible.
+func setMetadataEntryVerified(client *Client, requestUri, key, value, typedValue, visibility string, isSystem bool, maxWrites, attempts int, delay time.Duration) error {
+	if maxWrites < 1 {
+		maxWrites = 1
+	}
+	if attempts < 1 {
+		attempts = 1
+	}
+	expected := storedMetadataValue(value, typedValue, visibility, isSystem)
+
+	var lastSeen string
+	for write := 1; write <= maxWrites; write++ {
+		err := addMetadataAndWait(client, requestUri, key, value, typedValue, visibility, isSystem)
+		if err != nil {
+			return err
+		}
+		for attempt := 1; attempt <= attempts; attempt++ {
+			stored, err := getMetadataByKey(client, requestUri, key, isSystem)
+			if err == nil {
+				if metadataValuesMatch(expected, stored) {
+					return nil
+				}
+				lastSeen = canonicalMetadataValue(stored.TypedValue)
+			} else {
+				lastSeen = err.Error()
+			}
+			if attempt < attempts {
+				time.Sleep(delay)
+			}
+		}
+	}
+	return fmt.Errorf("metadata entry with key '%s' did not converge to value '%s' after %d writes and %d reads each, last seen: %s",
+		key, value, maxWrites, attempts, lastSeen)
+}
+
+// metadataVerifyPollInterval is the time that AddMetadataEntryWithVisibilityVerified waits between reads of the added
+// entry
+const metadataVerifyPollInterval = 500 * time.Millisecond
+
+// copyMetadata returns a copy of the given metadata that doesn't share its links or entries, so it can be modified
+// without altering the original
+func copyMetadata(metadata *types.Metadata) *types.Metadata {
+	metadataCopy := FilterMetadata(metadata, func(types.MetadataEntry) bool { return true })
+	if metadataCopy != nil && metadata.Link != nil {
+		metadataCopy.Link = append([]*types.Link(nil), metadata.Link...)
+	}
+	return metadataCopy
+}
+
+// copyMetadataEntry returns a copy of the given metadata entry that doesn't share its domain, typed value or links
+func copyMetadataEntry(entry *types.MetadataEntry) *types.MetadataEntry {
+	entryCopy := *entry
+	if entry.Domain != nil {
+		domain := *entry.Domain
+		entryCopy.Domain = &domain
+	}
+	if entry.TypedValue != nil {
+		typedValue := *entry.TypedValue
+		entryCopy.TypedValue = &typedValue
+	}
+	if entry.Link != nil {
+		entryCopy.Link = append([]*types.Link(nil), entry.Link...)
+	}
+	return &entryCopy
+}
+
+// storedMetadataValue returns the metadata value that VCD stores when adding the given value, type and visibility with
+// addMetadata in the given domain
+func storedMetadataValue(value, typedValue, visibility string, isSystem bool) *types.MetadataValue {
+	stored := &types.MetadataValue{
+		TypedValue: &types.MetadataTypedValue{XsiType: typedValue, Value: value},
+		Domain:     &types.MetadataDomainTag{Visibility: visibility, Domain: "SYSTEM"},
+	}
+	if !isSystem {
+		// GENERAL entries are always stored as types.MetadataReadWriteVisibility, see addMetadata
+		stored.Domain = &types.MetadataDomainTag{Visibility: types.MetadataReadWriteVisibility, Domain: "GENERAL"}
+	}
+	return stored
+}
+
+// addMetadataEntryTyped adds metadata to an entity and waits for the task completion with addMetadataAndWait, which
+// validates the type and visibility with types.MetadataType.IsValid and types.MetadataVisibility.IsValid. They can
+// only be invalid when they are converted from strings without types.ParseMetadataType or types.ParseMetadataVisibility.
+func addMetadataEntryTyped(client *Client, requestUri, key, value string, metadataType types.MetadataType, visibility types.MetadataVisibility, isSystem bool) error {
+	return addMetadataAndWait(client, requestUri, key, value, string(metadataType), string(visibility), isSystem)
+}
+
+// addMetadataEntryIfChanged adds metadata to an entity and waits for the task completion, unless the entry already
+// exists with the same value, type and visibility. Values are compared in their canonical form, so "01" and "1" are the
+// same number. It returns whether the entry was added, so no task is created when there is nothing to change.
+func addMetadataEntryIfChanged(client *Client, requestUri, key, value, typedValue, visibility string, isSystem bool) (bool, error) {
+	current, present, err := getMetadataByKeyIfPresent(client, requestUri, key, isSystem)
+	if err != nil {
+		return false, err
+	}
+	if present && metadataValuesMatch(storedMetadataValue(value, typedValue, visibility, isSystem), current) {
+		return false, nil
+	}
+
+	err = addMetadataAndWait(client, requestUri, key, value, typedValue, visibility, isSystem)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// addMetadataEntityEntryIfChanged is the same as addMetadataEntryIfChanged, but it works with any metadata compatible
+// entity, including the ones that use the OpenAPI metadata endpoint
+func addMetadataEntityEntryIfChanged(entity MetadataCompatible, key, value, typedValue, visibility string, isSystem bool) (bool, error) {
+	current, err := entity.GetMetadataByKey(key, isSystem)
+	if err != nil && !errors.Is(err, ErrorEntityNotFound) {
+		return false, err
+	}
+	if err == nil && metadataValuesMatch(storedMetadataValue(value, typedValue, visibility, isSystem), current) {
+		return false, nil
+	}
+
+	err = entity.AddMetadataEntryWithVisibility(key, value, typedValue, visibility, isSystem)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// setMetadataIfValueEquals is a compare-and-set of a metadata entry: it reads the current value of the given key and
+// only adds the new value, waiting for the task, if the current one is expectedCurrent. An empty expectedCurrent
+// means that the key must not exist. The current value is compared in its canonical form, using its own type.
+// It returns false without an error when the precondition fails, so callers can re-read the value and retry.
+// NOTE: VCD has no conditional update, so this is not atomic: another writer can still change the entry between the
+// read and the write. It only narrows the race window, acting as a lightweight optimistic lock.
+func setMetadataIfValueEquals(client *Client, requestUri, key, expectedCurrent, newValue, typedValue, visibility string, isSystem bool) (bool, error) {
+	err := validateMetadataEntry(key, newValue, typedValue, visibility)
+	if err != nil {
+		return false, err
+	}
+
+	current, present, err := getMetadataByKeyIfPresent(client, requestUri, key, isSystem)
+	if err != nil {
+		return false, err
+	}
+	if !present || current.TypedValue == nil {
+		if expectedCurrent != "" {
+			return false, nil
+		}
+	} else {
+		expected := &types.MetadataTypedValue{XsiType: current.TypedValue.XsiType, Value: expectedCurrent}
+		if canonicalMetadataValue(expected) != canonicalMetadataValue(current.TypedValue) {
+			return false, nil
+		}
+	}
+
+	err = addMetadataAndWait(client, requestUri, key, newValue, typedValue, visibility, isSystem)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// metadataIncrementMaxAttempts is the number of times that incrementNumberMetadata tries to write the new value
+// before giving up because of concurrent modifications
+const metadataIncrementMaxAttempts = 5
+
+// incrementNumberMetadata reads the current types.MetadataNumberValue of the given key, adds delta to it and writes it
+// back with setMetadataIfValueEquals, keeping the visibility of the entry, and returns the new value. A key that
+// doesn't exist is created with delta as value, with the default visibility of its domain.
+// When the value was changed by another writer between the read and the write, it reads it again and retries, up to
+// metadataIncrementMaxAttempts times.
+// NOTE: The atomicity is best-effort, as it relies on compare-and-set retries: VCD has no server-side increment, so a
+// concurrent writer can still change the entry right before the new value is written.
+func incrementNumberMetadata(client *Client, requestUri, key string, delta int64, isSystem bool) (int64, error) {
+	for attempt := 1; attempt <= metadataIncrementMaxAttempts; attempt++ {
+		current, present, err := getMetadataByKeyIfPresent(client, requestUri, key, isSystem)
+		if err != nil {
+			return 0, fmt.Errorf("error reading metadata key '%s' to increment it: %s", key, err)
+		}
+
+		var base int64
+		expectedCurrent := ""
+		visibility := types.MetadataReadWriteVisibility
+		if isSystem {
+			visibility = types.MetadataReadOnlyVisibility
+		}
+		if present && current.TypedValue != nil {
+			if current.TypedValue.XsiType != types.MetadataNumberValue {
+				return 0, fmt.Errorf("metadata key '%s' can't be incremented, as it is a %s", key, current.TypedValue.XsiType)
+			}
+			base, err = strconv.ParseInt(current.TypedValue.Value, 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("metadata key '%s' can't be incremented, as its value '%s' is not a valid %s: %s",
+					key, current.TypedValue.Value, types.MetadataNumberValue, err)
+			}
+			expectedCurrent = current.TypedValue.Value
+			if current.Domain != nil && current.Domain.Visibility != "" {
+				visibility = current.Domain.Visibility
+			}
+		}
+		if (delta > 0 && base > math.MaxInt64-delta) || (delta < 0 && base < math.MinInt64-delta) {
+			return 0, fmt.Errorf("incrementing metadata key '%s' with value %d by %d overflows", key, base, delta)
+		}
+
+		newValue := base + delta
+		set, err := setMetadataIfValueEquals(client, requestUri, key, expectedCurrent, strconv.FormatInt(newValue, 10),
+			types.MetadataNumberValue, visibility, isSystem)
+		if err != nil {
+			return 0, fmt.Errorf("error incrementing metadata key '%s': %s", key, err)
+		}
+		if set {
+			return newValue, nil
+		}
+		util.Logger.Printf("[DEBUG] metadata key '%s' was modified concurrently during the increment (attempt %d of %d)",
+			key, attempt, metadataIncrementMaxAttempts)
+	}
+	return 0, fmt.Errorf("could not increment metadata key '%s' after %d attempts, as it was modified concurrently",
+		key, metadataIncrementMaxAttempts)
+}
+
+// checkProtectedSystemMetadataKey returns a *MetadataValidationError that wraps ErrProtectedMetadataKey if the given
+// SYSTEM domain key matches any of the patterns of Client.ProtectSystemMetadataKeys. A malformed pattern is an error
+// too, so that a typo doesn't leave the keys unprotected.
+func checkProtectedSystemMetadataKey(client *Client, key string) error {
+	for _, pattern := range client.ProtectSystemMetadataKeys {
+		matched, err := path.Match(pattern, key)
+		if err != nil {
+			return &MetadataValidationError{Key: key, Reason: fmt.Sprintf("invalid protection pattern '%s': %s", pattern, err), Err: ErrProtectedMetadataKey}
+		}
+		if matched {
+			return &MetadataValidationError{Key: key, Reason: fmt.Sprintf("SYSTEM domain key is protected by pattern '%s'", pattern), Err: ErrProtectedMetadataKey}
+		}
+	}
+	return nil
+}
+
+// validateNewEntityMetadata runs, before a vApp or a VM is created, the same checks that adding the given metadata to
+// it would run afterwards: the entries must be valid, SYSTEM keys must not be protected by
+// Client.ProtectSystemMetadataKeys, and SYSTEM entries can't have types.MetadataReadWriteVisibility, which VCD only
+// allows for Provider VDCs. This way, metadata that would be rejected doesn't leave an untagged entity behind.
+func validateNewEntityMetadata(client *Client, metadata map[string]types.MetadataValue) error {
+	err := validateMetadataValues(metadata)
+	if err != nil {
+		return err
+	}
+	err = checkProtectedSystemMetadataValues(client, metadata)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		domain := metadata[key].Domain
+		if domain != nil && domain.Domain == "SYSTEM" && domain.Visibility == types.MetadataReadWriteVisibility {
+			return &MetadataValidationError{Key: key, Reason: "visibility READWRITE in SYSTEM domain is only allowed for Provider VDCs as system administrator", Err: ErrInvalidMetadataValue}
+		}
+	}
+	return nil
+}
+
+// checkProtectedSystemMetadataValues runs checkProtectedSystemMetadataKey for every SYSTEM domain entry of the given
+// metadata, in key order, returning the first error
+func checkProtectedSystemMetadataValues(client *Client, metadata map[string]types.MetadataValue) error {
+	if len(client.ProtectSystemMetadataKeys) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		if value.Domain != nil && value.Domain.Domain == "SYSTEM" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		err := checkProtectedSystemMetadataKey(client, key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// metadataValuesMatch returns true if both metadata values have the same type, canonical value, domain and visibility.
+// A missing Domain is considered as GENERAL domain with types.MetadataReadWriteVisibility, as VCD omits it in that case.
+func metadataValuesMatch(expected, actual *types.MetadataValue) bool {
+	if expected == nil || actual == nil || expected.TypedValue == nil || actual.TypedValue == nil {
+		return expected == actual
+	}
+	if expected.TypedValue.XsiType != actual.TypedValue.XsiType ||
+		canonicalMetadataValue(expected.TypedValue) != canonicalMetadataValue(actual.TypedValue) {
+		return false
+	}
+	return effectiveMetadataDomain(expected.Domain) == effectiveMetadataDomain(actual.Domain)
+}
+
+// effectiveMetadataDomain returns the domain tag as VCD applies it, where a missing tag means GENERAL domain with
+// types.MetadataReadWriteVisibility
+func effectiveMetadataDomain(domain *types.MetadataDomainTag) types.MetadataDomainTag {
+	if domain == nil || domain.Domain == "" {
+		visibility := types.MetadataReadWriteVisibility
+		if domain != nil && domain.Visibility != "" {
+			visibility = domain.Visibility
+		}
+		return types.MetadataDomainTag{Domain: "GENERAL", Visibility: visibility}
+	}
+	return *domain
+}
+
+// canonicalMetadataValue returns the given typed value in a normalized string form, so that values that are written
+// differently but are semantically equal can be compared. For example, "01" and "1" for types.MetadataNumberValue,
+// "TRUE" and "true" for types.MetadataBooleanValue or the same instant in different time zones for
+// types.MetadataDateTimeValue. Values that can't be parsed are returned untouched.
+func canonicalMetadataValue(typedValue *types.MetadataTypedValue) string {
+	if typedValue == nil {
+		return ""
+	}
+	parsedValue, err := parseMetadataTypedValue(typedValue)
+	if err != nil {
+		return typedValue.Value
+	}
+	switch v := parsedValue.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	default:
+		return typedValue.Value
+	}
+}
+
+// mergeAllMetadata updates the metadata values that are already present in VCD and creates the ones not present.
+// The input metadata map has a "metadata key"->"metadata value" relation.
+// If the operation is successful, it returns the created task.
+// Transient errors are retried as configured by Client.MetadataRetryCount and Client.MetadataRetryBackoff.
+func mergeAllMetadata(client *Client, requestUri string, metadata map[string]types.MetadataValue) (Task, error) {
+	return mergeAllMetadataWithContext(context.Background(), client, requestUri, metadata)
+}
+
+// mergeAllMetadataWithContext is the implementation of mergeAllMetadata, with the request bound to the given context
+func mergeAllMetadataWithContext(ctx context.Context, client *Client, requestUri string, metadata map[string]types.MetadataValue) (Task, error) {
+	err := validateMetadataValues(metadata)
+	if err != nil {
+		return Task{}, err
+	}
+	err = checkProtectedSystemMetadataValues(client, metadata)
+	if err != nil {
+		return Task{}, err
+	}
+
+	var metadataToMerge []*types.MetadataEntry
+	for key, value := range metadata {
+		metadataToMerge = append(metadataToMerge, &types.MetadataEntry{
+			Xmlns:      types.XMLNamespaceVCloud,
+			Xsi:        types.XMLNamespaceXSI,
+			Key:        key,
+			TypedValue: value.TypedValue,
+			Domain:     value.Domain,
+		})
+	}
+
+	newMetadata := &types.Metadata{
+		Xmlns:         types.XMLNamespaceVCloud,
+		Xsi:           types.XMLNamespaceXSI,
+		MetadataEntry: metadataToMerge,
+	}
+
+	apiEndpoint := urlParseRequestURI(requestUri)
+	apiEndpoint.Path += "/metadata"
+
+	opLog := newMergeMetadataOperationLog(requestUri, metadata)
+	opLog.start()
+	task, err := executeMetadataWriteRequest(ctx, client, requestUri, apiEndpoint.String(), "", http.MethodPost, types.MimeMetaData, "error adding metadata: %s", newMetadata)
+	opLog.end(&task, err)
+	return task, err
+}
+
+// addMetadataEntries adds all the given metadata entries to an entity through the merge endpoint, so only one request
+// is sent and only one task is waited for. Each entry specifies its key, typed value and, optionally, its domain and
+// visibility. Entries without Domain are added to the GENERAL domain, which is always stored with
+// types.MetadataReadWriteVisibility, as in addMetadata.
+// All the entries are validated as in addMetadata before sending the request, and the invalid ones are returned as a
+// *MetadataMultiError. If the task fails, its error is returned with all the keys of the request.
+func addMetadataEntries(client *Client, requestUri string, entries []types.MetadataEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	multiError := newMetadataMultiError("validating metadata entries")
+	metadataToMerge := make([]*types.MetadataEntry, 0, len(entries))
+	keys := make([]string, 0, len(entries))
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		domain := effectiveMetadataDomain(entry.Domain)
+		if domain.Domain == "GENERAL" {
+			domain.Visibility = types.MetadataReadWriteVisibility
+		}
+		identifier := domain.Domain + "/" + entry.Key
+		if seen[identifier] {
+			multiError.add(identifier, fmt.Errorf("duplicated metadata key '%s' in domain %s", entry.Key, domain.Domain))
+			continue
+		}
+		seen[identifier] = true
+		if entry.TypedValue == nil {
+			multiError.add(identifier, &MetadataValidationError{Key: entry.Key, Reason: "metadata value is empty", Err: ErrInvalidMetadataValue})
+			continue
+		}
+		err := validateMetadataEntry(entry.Key, entry.TypedValue.Value, entry.TypedValue.XsiType, domain.Visibility)
+		if err == nil && domain.Domain == "SYSTEM" {
+			err = checkProtectedSystemMetadataKey(client, entry.Key)
+		}
+		if err != nil {
+			multiError.add(identifier, err)
+			continue
+		}
+
+		metadataToMerge = append(metadataToMerge, &types.MetadataEntry{
+			Xmlns:      types.XMLNamespaceVCloud,
+			Xsi:        types.XMLNamespaceXSI,
+			Key:        entry.Key,
+			TypedValue: &types.MetadataTypedValue{XsiType: entry.TypedValue.XsiType, Value: entry.TypedValue.Value},
+			Domain:     &types.MetadataDomainTag{Domain: domain.Domain, Visibility: domain.Visibility},
+		})
+		keys = append(keys, identifier)
+	}
+	if len(multiError.Errors) > 0 {
+		return multiError
+	}
+
+	newMetadata := &types.Metadata{
+		Xmlns:         types.XMLNamespaceVCloud,
+		Xsi:           types.XMLNamespaceXSI,
+		MetadataEntry: metadataToMerge,
+	}
+
+	apiEndpoint := urlParseRequestURI(requestUri)
+	apiEndpoint.Path += "/metadata"
+
+	oldMetadata := metadataBeforeChange(context.Background(), client, requestUri)
+	task, err := executeMetadataWriteRequest(context.Background(), client, requestUri, apiEndpoint.String(), "", http.MethodPost, types.MimeMetaData, "error adding metadata entries: %s", newMetadata)
+	if err != nil {
+		return err
+	}
+	err = task.WaitTaskCompletion()
+	if err != nil {
+		sort.Strings(keys)
+		return fmt.Errorf("error adding metadata entries [%s]: %s", strings.Join(keys, ", "), err)
+	}
+	for _, entry := range metadataToMerge {
+		isSystem := entry.Domain != nil && entry.Domain.Domain == "SYSTEM"
+		var oldValue *types.MetadataValue
+		if oldMetadata != nil {
+			if oldEntry := findMetadataEntry(oldMetadata, entry.Key, isSystem); oldEntry != nil {
+				oldValue = &types.MetadataValue{Domain: oldEntry.Domain, TypedValue: oldEntry.TypedValue}
+			}
+		}
+		notifyMetadataChange(client, requestUri, entry.Key, MetadataChangeAdd, oldValue, &types.MetadataValue{Domain: entry.Domain, TypedValue: entry.TypedValue})
+	}
+	return nil
+}
+
+// mergeAllMetadata updates the metadata values that are already present in VCD and creates the ones not present.
+// The input metadata map has a "metadata key"->"metadata value" relation.
+// This function waits until merge finishes.
+func mergeMetadataAndWait(client *Client, requestUri string, metadata map[string]types.MetadataValue) error {
+	return mergeMetadataAndWaitWithContext(context.Background(), client, requestUri, metadata)
+}
+
+// mergeMetadataAndWaitWithContext is the implementation of mergeMetadataAndWait, which stops waiting for the task as
+// soon as the given context is cancelled
+func mergeMetadataAndWaitWithContext(ctx context.Context, client *Client, requestUri string, metadata map[string]types.MetadataValue) error {
+	oldMetadata := metadataBeforeChange(ctx, client, requestUri)
+	task, err := mergeAllMetadataWithContext(ctx, client, requestUri, metadata)
+	if err != nil {
+		return err
+	}
+
+	err = task.WaitTaskCompletionWithContext(ctx)
+	if err != nil {
+		return err
+	}
+	notifyMetadataMerge(client, requestUri, oldMetadata, metadata)
+	return nil
+}
+
+// deleteMetadata deletes metadata associated to the input key from an entity referenced by its URI, then returns the
+// task.
+// Transient errors are retried as configured by Client.MetadataRetryCount and Client.MetadataRetryBackoff.
+func deleteMetadata(client *Client, requestUri string, key string, isSystem bool) (Task, error) {
+	return deleteMetadataWithContext(context.Background(), client, requestUri, key, isSystem)
+}
+
+// deleteMetadataWithContext is the implementation of deleteMetadata, with the request bound to the given context
+func deleteMetadataWithContext(ctx context.Context, client *Client, requestUri string, key string, isSystem bool) (Task, error) {
+	if isSystem {
+		err := checkProtectedSystemMetadataKey(client, key)
+		if err != nil {
+			return Task{}, err
+		}
+	}
+	apiEndpoint := urlParseRequestURI(requestUri)
+	apiEndpoint.Path += xmlMetadataKeyPath(key, isSystem)
+
+	opLog := metadataOperationLog{operation: "delete", href: requestUri, keys: []string{key}, domain: metadataDomainName(isSystem)}
+	opLog.start()
+	task, err := executeMetadataWriteRequest(ctx, client, requestUri, apiEndpoint.String(), key, http.MethodDelete, "", "error deleting metadata: %s", nil)
+	opLog.end(&task, err)
+	return task, err
+}
+
+// deleteMetadata deletes metadata associated to the input key from an entity referenced by its URI.
+func deleteMetadataAndWait(client *Client, requestUri string, key string, isSystem bool) error {
+	return deleteMetadataAndWaitWithContext(context.Background(), client, requestUri, key, isSystem)
+}
+
+// deleteMetadataAndWaitWithContext is the implementation of deleteMetadataAndWait, which stops waiting for the task as
+// soon as the given context is cancelled
+func deleteMetadataAndWaitWithContext(ctx context.Context, client *Client, requestUri string, key string, isSystem bool) error {
+	oldValue := metadataValueBeforeChange(ctx, client, requestUri, key, isSystem)
+	task, err := deleteMetadataWithContext(ctx, client, requestUri, key, isSystem)
+	if err != nil {
+		return err
+	}
+
+	err = task.WaitTaskCompletionWithContext(ctx)
+	if err != nil {
+		return err
+	}
+	notifyMetadataChange(client, requestUri, key, MetadataChangeDelete, oldValue, nil)
+	return nil
+}
+
+// getMetadataHistory queries the metadata tasks of the entity with the given HREF and converts them to
+// MetadataChangeRecord, sorted by start date. The task names are grouped in the filter, as ';' binds tighter than ','
+// in query filters, and the object of every record is checked too, so tasks of other entities are never returned.
+func getMetadataHistory(client *Client, href string) ([]MetadataChangeRecord, error) {
+	if href == "" {
+		return nil, fmt.Errorf("cannot get metadata history of an entity without HREF")
+	}
+	taskType := types.QtTask
+	if client.IsSysAdmin {
+		taskType = types.QtAdminTask
+	}
+	notEncodedParams := map[string]string{
+		"type":   taskType,
+		"filter": fmt.Sprintf("object==%s;(name==%s,name==%s)", href, MetadataTaskUpdate, MetadataTaskDelete),
+	}
+	results, err := client.cumulativeQuery(taskType, nil, notEncodedParams)
+	if err != nil {
+		return nil, fmt.Errorf("error getting metadata history of %s: %s", href, err)
+	}
+	tasks := results.Results.TaskRecord
+	if client.IsSysAdmin {
+		tasks = results.Results.AdminTaskRecord
+	}
+
+	records := make([]MetadataChangeRecord, 0, len(tasks))
+	for _, task := range tasks {
+		if task == nil || task.Object != href || (task.Name != MetadataTaskUpdate && task.Name != MetadataTaskDelete) {
+			continue
+		}
+		record := MetadataChangeRecord{
+			Operation:   task.Name,
+			Description: task.OperationFull,
+			User:        task.OwnerName,
+			Status:      task.Status,
+			TaskHref:    task.HREF,
+		}
+		record.StartDate, err = parseTaskRecordDate(task.StartDate)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing start date of task %s: %s", task.HREF, err)
+		}
+		record.EndDate, err = parseTaskRecordDate(task.EndDate)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing end date of task %s: %s", task.HREF, err)
+		}
+		records = append(records, record)
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].StartDate.Before(records[j].StartDate)
+	})
+	return records, nil
+}
+
+// securityTagsMetadata returns the GENERAL domain entries of the given metadata whose key starts with prefix, which
+// are the ones that mirror security tags
+func securityTagsMetadata(metadata *types.Metadata, prefix string) *types.Metadata {
+	result := &types.Metadata{}
+	if metadata == nil {
+		return result
+	}
+	for _, entry := range metadata.MetadataEntry {
+		if entry == nil || !strings.HasPrefix(entry.Key, prefix) || effectiveMetadataDomain(entry.Domain).Domain != "GENERAL" {
+			continue
+		}
+		result.MetadataEntry = append(result.MetadataEntry, entry)
+	}
+	return result
+}
+
+// parseTaskRecordDate parses a date of a task query record. An empty date is returned as zero time.
+func parseTaskRecordDate(date string) (time.Time, error) {
+	if date == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, date)
+}
+
+// Operations reported to Client.OnMetadataChange
+const (
+	MetadataChangeAdd    = "add"    // A single entry was added or updated
+	MetadataChangeMerge  = "merge"  // An entry was added or updated as part of a merge of several entries
+	MetadataChangeDelete = "delete" // An entry was deleted
+)
+
+// metadataValueBeforeChange returns the current value of the given key, to be passed to Client.OnMetadataChange.
+// It returns nil without doing any request when the hook is not set, and nil when the value can't be retrieved, as
+// the hook must not make the operation fail. The request is bound to the given context, as the operation itself.
+func metadataValueBeforeChange(ctx context.Context, client *Client, requestUri, key string, isSystem bool) *types.MetadataValue {
+	if client.OnMetadataChange == nil {
+		return nil
+	}
+	value, _, err := getMetadataByKeyIfPresentWithContext(ctx, client, requestUri, key, isSystem)
+	if err != nil {
+		util.Logger.Printf("[DEBUG] could not retrieve metadata '%s' of '%s' before changing it: %s", key, requestUri, err)
+		return nil
+	}
+	return value
+}
+
+// metadataBeforeChange returns all the current metadata of the entity, to be passed to Client.OnMetadataChange. As
+// metadataValueBeforeChange, it returns nil when the hook is not set or the metadata can't be retrieved.
+func metadataBeforeChange(ctx context.Context, client *Client, requestUri string) *types.Metadata {
+	if client.OnMetadataChange == nil {
+		return nil
+	}
+	metadata, err := getMetadataWithContext(ctx, client, requestUri)
+	if err != nil {
+		util.Logger.Printf("[DEBUG] could not retrieve metadata of '%s' before changing it: %s", requestUri, err)
+		return nil
+	}
+	return metadata
+}
+
+// notifyMetadataChange is called once a metadata change has finished. It records the write of the entity with
+// recordMetadataWrite and calls Client.OnMetadataChange, if it is set
+func notifyMetadataChange(client *Client, entity, key, op string, oldValue, newValue *types.MetadataValue) {
+	recordMetadataWrite(entity)
+	if client.OnMetadataChange != nil {
+		client.OnMetadataChange(entity, key, op, oldValue, newValue)
+	}
+}
+
+// notifyMetadataMerge is called once a metadata merge has finished. It records the write of the entity with
+// recordMetadataWrite and calls Client.OnMetadataChange, if it is set, once per merged key, sorted by key. The old
+// values are taken from the given metadata, that was retrieved before merging.
+func notifyMetadataMerge(client *Client, entity string, oldMetadata *types.Metadata, merged map[string]types.MetadataValue) {
+	recordMetadataWrite(entity)
+	if client.OnMetadataChange == nil {
+		return
+	}
+	keys := make([]string, 0, len(merged))
+	for key := range merged {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		newValue := merged[key]
+		isSystem := newValue.Domain != nil && newValue.Domain.Domain == "SYSTEM"
+		var oldValue *types.MetadataValue
+		if oldMetadata != nil {
+			if entry := findMetadataEntry(oldMetadata, key, isSystem); entry != nil {
+				oldValue = &types.MetadataValue{Domain: entry.Domain, TypedValue: entry.TypedValue}
+			}
+		}
+		client.OnMetadataChange(entity, key, MetadataChangeMerge, oldValue, &newValue)
+	}
+}
+
+// getXmlMetadataHref returns the XML API HREF used to manage the metadata of the receiver OpenApiOrgVdcNetwork.
+// If isAdmin is true, the admin HREF is returned, which is required to modify metadata.
+// Networks that belong to a VDC Group are not reachable through this HREF, the OpenAPI metadata endpoint must be
+// used for them instead.
+func (openApiOrgVdcNetwork *OpenApiOrgVdcNetwork) getXmlMetadataHref(isAdmin bool) string {
+	path := "network"
+	if isAdmin {
+		path = "admin/network"
+	}
+	return fmt.Sprintf("%s/%s/%s", openApiOrgVdcNetwork.client.VCDHREF.String(), path, extractUuid(openApiOrgVdcNetwork.OpenApiOrgVdcNetwork.ID))
+}
+
+// WithTenantMetadataHref returns a copy of the receiver OrgVDCNetwork whose methods that modify metadata use the plain
+// network HREF instead of the admin one also for system administrators, as Org administrators always do. The receiver
+// is not modified, so the choice only applies to the calls made with the returned copy, which shares the network
+// definition and the client with the receiver.
+// Reading metadata and modifying the GENERAL domain work with the tenant HREF, while modifying the SYSTEM domain always
+// requires system administrator privileges. Without it, system administrators use the admin HREF.
+func (orgVdcNetwork *OrgVDCNetwork) WithTenantMetadataHref() *OrgVDCNetwork {
+	tenantOrgVdcNetwork := *orgVdcNetwork
+	tenantOrgVdcNetwork.tenantMetadataHref = true
+	return &tenantOrgVdcNetwork
+}
+
+// writeMetadata calls the given function to modify the metadata of the receiver OrgVDCNetwork, with the HREF that
+// suits the role of the caller:
+//   - System administrators use the admin HREF, as they always did, unless the receiver was obtained with
+//     OrgVDCNetwork.WithTenantMetadataHref, in which case they use the tenant HREF.
+//   - Anybody else uses the tenant HREF, which lets Org administrators manage the GENERAL metadata of their networks.
+//     As SYSTEM metadata can only be modified by system administrators, a *MetadataPermissionError that requires
+//     system administrator is returned without sending any request when isSystem=true.
+//
+// When VCD rejects the request of a user that is not system administrator because of missing rights, the returned
+// *MetadataPermissionError names the network instead of the metadata HREF.
+func (orgVdcNetwork *OrgVDCNetwork) writeMetadata(isSystem bool, write func(href string) error) error {
+	entity := fmt.Sprintf("Org VDC network '%s'", orgVdcNetwork.OrgVDCNetwork.Name)
+	href := getAdminURL(orgVdcNetwork.OrgVDCNetwork.HREF)
+	if !orgVdcNetwork.client.IsSysAdmin && isSystem {
+		return &MetadataPermissionError{Entity: entity, RequiresSystemAdmin: true}
+	}
+	if !orgVdcNetwork.client.IsSysAdmin || orgVdcNetwork.tenantMetadataHref {
+		href = strings.Replace(orgVdcNetwork.OrgVDCNetwork.HREF, "/api/admin/", "/api/", 1)
+	}
+
+	err := write(href)
+	var permissionError *MetadataPermissionError
+	if err != nil && !orgVdcNetwork.client.IsSysAdmin && errors.As(err, &permissionError) {
+		return &MetadataPermissionError{Entity: entity, Err: permissionError.Err}
+	}
+	return err
+}
+
+// metadataRequestOptions returns the options to perform the given metadata operation on the receiver
+// OpenApiOrgVdcNetwork with metadataRequest. Networks that belong to a VDC Group use the OpenAPI metadata endpoint,
+// while the rest use the XML API, with the admin HREF when isAdmin=true.
+func (openApiOrgVdcNetwork *OpenApiOrgVdcNetwork) metadataRequestOptions(operation metadataOperation, isAdmin bool) metadataRequestOptions {
+	if openApiOrgVdcNetwork.isInVdcGroup() {
+		return metadataRequestOptions{
+			transport: metadataTransportOpenApi,
+			operation: operation,
+			endpoint:  types.OpenApiEndpointOrgVdcNetworksMetadata,
+			entityId:  openApiOrgVdcNetwork.OpenApiOrgVdcNetwork.ID,
+		}
+	}
+	return metadataRequestOptions{
+		transport: metadataTransportXml,
+		operation: operation,
+		href:      openApiOrgVdcNetwork.getXmlMetadataHref(isAdmin),
+	}
+}
+
+// metadataRequestOptions returns the options to perform the given metadata operation on the receiver
+// ExternalNetworkV2 with metadataRequest. The OpenAPI metadata endpoint is used when VCD supports it (API 38.0+,
+// VCD 10.5+), and the XML API, with the admin HREF of the external network, otherwise.
+func (extNet *ExternalNetworkV2) metadataRequestOptions(operation metadataOperation) metadataRequestOptions {
+	var id string
+	if extNet.ExternalNetwork != nil {
+		id = extNet.ExternalNetwork.ID
+	}
+	if extNet.client == nil {
+		return metadataRequestOptions{transport: metadataTransportOpenApi, operation: operation, entityId: id}
+	}
+	_, err := extNet.client.getOpenApiHighestElevatedVersion(types.OpenApiPathVersion1_0_0 + types.OpenApiEndpointExternalNetworksMetadata)
+	if err == nil {
+		return metadataRequestOptions{
+			transport: metadataTransportOpenApi,
+			operation: operation,
+			endpoint:  types.OpenApiEndpointExternalNetworksMetadata,
+			entityId:  id,
+		}
+	}
+	var href string
+	if id != "" {
+		href = fmt.Sprintf("%s/admin/extension/externalnet/%s", extNet.client.VCDHREF.String(), extractUuid(id))
+	}
+	return metadataRequestOptions{
+		transport: metadataTransportXml,
+		operation: operation,
+		href:      href,
+	}
+}
+
+// metadataRequestOptions returns the options to perform the given metadata operation on the receiver Disk with
+// metadataRequest. The XML API is used by default. When Client.OpenApiDiskMetadata is set, disks with an OpenAPI ID
+// use the OpenAPI metadata endpoint if the negotiated API version supports it.
+func (disk *Disk) metadataRequestOptions(operation metadataOperation) metadataRequestOptions {
+	endpoint := types.OpenApiPathVersion1_0_0 + types.OpenApiEndpointDisksMetadata
+	if disk.client.OpenApiDiskMetadata && strings.HasPrefix(disk.Disk.Id, "urn:vcloud:disk:") &&
+		disk.client.APIClientVersionIs(">= "+endpointMinApiVersions[endpoint]) {
+		return metadataRequestOptions{
+			transport: metadataTransportOpenApi,
+			operation: operation,
+			endpoint:  types.OpenApiEndpointDisksMetadata,
+			entityId:  disk.Disk.Id,
+		}
+	}
+	return metadataRequestOptions{
+		transport: metadataTransportXml,
+		operation: operation,
+		href:      disk.Disk.HREF,
+	}
+}
+
+// asyncMetadataHref returns the XML HREF used by the asynchronous metadata methods of the receiver Disk, which need the
+// task returned by the XML API. It returns a *MetadataNotSupportedError when metadataRequestOptions selects the OpenAPI
+// metadata endpoint, as it doesn't return tasks.
+func (disk *Disk) asyncMetadataHref() (string, error) {
+	opts := disk.metadataRequestOptions(metadataOperationAdd)
+	if opts.transport != metadataTransportXml {
+		return "", &MetadataNotSupportedError{
+			Entity: "Disk",
+			Reason: "asynchronous operations require the XML API, as the OpenAPI metadata endpoint doesn't return tasks",
+		}
+	}
+	return opts.href, nil
+}
+
+// withVappNetworkMetadataHref runs the given metadata operation with the HREF of the vApp network with the given name
+// or ID, as cached in the network configuration of the receiver VApp. The HREF of a vApp network changes when it is
+// reconfigured, so if the operation fails with a 404, the VApp is refreshed and the operation is retried once with
+// the new HREF of the network with the same name.
+func (vapp *VApp) withVappNetworkMetadataHref(networkNameOrId string, operation func(href string) error) error {
+	networkConfig, err := vapp.getVappNetworkConfig(networkNameOrId)
+	if err != nil {
+		return err
+	}
+	err = operation(networkConfig.Link.HREF)
+	var notFoundError *MetadataKeyNotFoundError
+	if err == nil || (errors.As(err, &notFoundError) && notFoundError.Key != "") || !strings.Contains(err.Error(), fmt.Sprintf("API Error: %d:", http.StatusNotFound)) {
+		return err
+	}
+
+	staleHref := networkConfig.Link.HREF
+	refreshErr := vapp.Refresh()
+	if refreshErr != nil {
+		return err
+	}
+	networkConfig, refreshErr = vapp.getVappNetworkConfig(networkConfig.NetworkName)
+	if refreshErr != nil || networkConfig.Link.HREF == staleHref {
+		return err
+	}
+	return operation(networkConfig.Link.HREF)
+}
+
+// getVappNetworkConfig returns the network configuration of the receiver VApp that corresponds to the vApp network
+// with the given name or ID, as it was retrieved with the VApp. It returns an error containing ErrorEntityNotFound if
+// there is no such network.
+func (vapp *VApp) getVappNetworkConfig(networkNameOrId string) (*types.VAppNetworkConfiguration, error) {
+	if vapp.VApp == nil || vapp.VApp.NetworkConfigSection == nil {
+		return nil, fmt.Errorf("%s: vApp network '%s'", ErrorEntityNotFound, networkNameOrId)
+	}
+	for i, networkConfig := range vapp.VApp.NetworkConfigSection.NetworkConfig {
+		if networkConfig.NetworkName == types.NoneNetwork || networkConfig.Link == nil {
+			continue
+		}
+		if networkConfig.NetworkName == networkNameOrId || equalIds(networkNameOrId, networkConfig.ID, networkConfig.Link.HREF) {
+			return &vapp.VApp.NetworkConfigSection.NetworkConfig[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%s: vApp network '%s'", ErrorEntityNotFound, networkNameOrId)
+}
+
+// isInSubscribedCatalog returns true if the Catalog of the receiver CatalogItem, referenced by its "up" link, is
+// subscribed to an external Catalog
+func (catalogItem *CatalogItem) isInSubscribedCatalog() (bool, error) {
+	catalogLink := catalogItem.CatalogItem.Link.ForType(types.MimeCatalog, types.RelUp)
+	if catalogLink == nil {
+		catalogLink = catalogItem.CatalogItem.Link.ForType(types.MimeAdminCatalog, types.RelUp)
+	}
+	if catalogLink == nil {
+		return false, fmt.Errorf("catalog item '%s' doesn't reference its catalog", catalogItem.CatalogItem.Name)
+	}
+	return isSubscribedCatalog(catalogItem.client, catalogLink.HREF)
+}
+
+// subscribedMetadataError converts the given error, returned when modifying the metadata of the receiver CatalogItem,
+// to a *MetadataNotSupportedError when the CatalogItem belongs to a subscribed Catalog, as VCD rejects the change
+// with a BAD_REQUEST error that doesn't explain the reason. Any other error, such as server or authorization errors,
+// is returned unchanged. The subscription is only checked when VCD rejects the change.
+func (catalogItem *CatalogItem) subscribedMetadataError(err error) error {
+	var vcdError *types.Error
+	if err == nil || !errors.As(err, &vcdError) || vcdError.MajorErrorCode != http.StatusBadRequest {
+		return err
+	}
+	isSubscribed, subscriptionErr := catalogItem.isInSubscribedCatalog()
+	if subscriptionErr != nil || !isSubscribed {
+		return err
+	}
+	return &MetadataNotSupportedError{
+		Entity: fmt.Sprintf("Catalog Item '%s'", catalogItem.CatalogItem.Name),
+		Reason: fmt.Sprintf("its catalog is subscribed to an external catalog, so its metadata is synchronized from the publisher and is read-only: %s", err),
+	}
+}
+
+// isSubscribedCatalog returns true if the Catalog with the given HREF is subscribed to an external Catalog, which is
+// only reported in the admin view of the Catalog
+func isSubscribedCatalog(client *Client, catalogHref string) (bool, error) {
+	adminCatalog := &types.AdminCatalog{}
+	_, err := client.ExecuteRequest(getAdminURL(catalogHref), http.MethodGet, types.MimeAdminCatalog, "error retrieving catalog: %s", nil, adminCatalog)
+	if err != nil {
+		return false, err
+	}
+	subscription := adminCatalog.ExternalCatalogSubscription
+	return subscription != nil && subscription.SubscribeToExternalFeeds, nil
+}
+
+// getFileRecordMetadataHref returns the HREF of the vApp Template or Media referenced by the receiver CatalogItem,
+// which holds the metadata of its file records
+func (catalogItem *CatalogItem) getFileRecordMetadataHref() (string, error) {
+	if catalogItem.CatalogItem == nil || catalogItem.CatalogItem.Entity == nil || catalogItem.CatalogItem.Entity.HREF == "" {
+		return "", fmt.Errorf("catalog item doesn't reference any entity")
+	}
+	switch catalogItem.CatalogItem.Entity.Type {
+	case types.MimeVAppTemplate, types.MimeMediaItem:
+		return catalogItem.CatalogItem.Entity.HREF, nil
+	default:
+		return "", catalogItemFileRecordMetadataNotSupported(catalogItem.CatalogItem.Entity.Type)
+	}
+}
+
+// metadataHrefFromId returns the HREF used to manage the metadata of the entity with the given URN. The supported
+// URN namespaces are vm, vapp, vdc, catalog, catalogitem, org, disk, media and network. If isAdmin is true, the admin HREF is
+// returned for the entities that require it to modify metadata, that is, VDCs, Catalogs, Orgs and networks.
+// An unsupported namespace returns a *MetadataNotSupportedError.
+func metadataHrefFromId(client *Client, id string, isAdmin bool) (string, error) {
+	if !isUrn(id) || !strings.HasPrefix(id, "urn:vcloud:") {
+		return "", fmt.Errorf("'%s' is not a valid URN", id)
+	}
+	namespace := strings.Split(id, ":")[2]
+	uuid := extractUuid(id)
+
+	var path string
+	needsAdmin := false
+	switch namespace {
+	case "vm":
+		path = "/vApp/vm-" + uuid
+	case "vapp":
+		path = "/vApp/vapp-" + uuid
+	case "disk":
+		path = "/disk/" + uuid
+	case "media":
+		path = "/media/" + uuid
+	case "catalogitem":
+		path = "/catalogItem/" + uuid
+	case "vdc", "catalog", "org", "network":
+		path = "/" + namespace + "/" + uuid
+		needsAdmin = true
+	default:
+		return "", &MetadataNotSupportedError{
+			Entity: fmt.Sprintf("URN namespace '%s'", namespace),
+			Reason: "only vm, vapp, vdc, catalog, catalogitem, org, disk, media and network URNs can be resolved to retrieve metadata",
+		}
+	}
+
+	href := client.VCDHREF.String() + path
+	if isAdmin && needsAdmin {
+		href = getAdminURL(href)
+	}
+	return href, nil
+}
+
+// applyMetadataSnapshotEntity applies the metadata of the given snapshot entity to the live entity with the given
+// URN, as described in AdminOrg.ApplyMetadataSnapshotWithWarnings. It returns the warnings about the entity and the
+// entries that were skipped.
+func applyMetadataSnapshotEntity(client *Client, urn string, entity *OrgMetadataSnapshotEntity, mode ApplyMode) ([]string, error) {
+	href, err := metadataHrefFromId(client, urn, true)
+	if err != nil {
+		return nil, err
+	}
+	_, err = getMetadata(client, href)
+	if err != nil {
+		if strings.Contains(err.Error(), fmt.Sprintf("API Error: %d:", http.StatusNotFound)) {
+			return []string{fmt.Sprintf("entity '%s' no longer exists, skipped", urn)}, nil
+		}
+		return nil, err
+	}
+
+	var warnings []string
+	toApply := map[string]map[string]types.MetadataValue{"GENERAL": {}, "SYSTEM": {}}
+	if entity != nil && entity.Metadata != nil {
+		for _, entry := range entity.Metadata.MetadataEntry {
+			if entry == nil || entry.TypedValue == nil {
+				continue
+			}
+			domain := effectiveMetadataDomain(entry.Domain)
+			if domain.Domain == "SYSTEM" && !client.IsSysAdmin {
+				warnings = append(warnings, fmt.Sprintf("SYSTEM entry '%s' of entity '%s' skipped, it requires system administrator privileges", entry.Key, urn))
+				continue
+			}
+			if domain.Domain != "SYSTEM" {
+				// GENERAL entries are always stored as types.MetadataReadWriteVisibility, see addMetadata
+				domain.Visibility = types.MetadataReadWriteVisibility
+			}
+			toApply[domain.Domain][entry.Key] = types.MetadataValue{
+				TypedValue: &types.MetadataTypedValue{XsiType: entry.TypedValue.XsiType, Value: entry.TypedValue.Value},
+				Domain:     &types.MetadataDomainTag{Domain: domain.Domain, Visibility: domain.Visibility},
+			}
+		}
+	}
+
+	if mode == ApplyModeReplace {
+		err = replaceAllMetadata(client, href, toApply["GENERAL"], false)
+		if err == nil && client.IsSysAdmin {
+			err = replaceAllMetadata(client, href, toApply["SYSTEM"], true)
+		}
+		return warnings, err
+	}
+
+	toMerge := toApply["GENERAL"]
+	for key, value := range toApply["SYSTEM"] {
+		if _, found := toMerge[key]; found {
+			// The same key can exist in both domains, so the SYSTEM entry is merged separately
+			err = mergeMetadataAndWait(client, href, map[string]types.MetadataValue{key: value})
+			if err != nil {
+				return warnings, err
+			}
+			continue
+		}
+		toMerge[key] = value
+	}
+	if len(toMerge) == 0 {
+		return warnings, nil
+	}
+	return warnings, mergeMetadataAndWait(client, href, toMerge)
 }
 
-// DeleteMetadataEntryWithDomain deletes ProviderVdc metadata associated to the input key and waits for the task to finish.
-// Note: Requires system administrator privileges.
-func (providerVdc *ProviderVdc) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
-	return deleteMetadataAndWait(providerVdc.client, providerVdc.ProviderVdc.HREF, key, isSystem)
+// metadataUrnFromHref returns the URN of the entity with the given HREF, like urn:vcloud:vm:<uuid>. It is the inverse
+// of metadataHrefFromId, and supports the same entities, both with tenant and admin HREFs.
+func metadataUrnFromHref(href string) (string, error) {
+	uuid := extractUuid(href)
+	if uuid == "" {
+		return "", fmt.Errorf("HREF '%s' doesn't contain a UUID", href)
+	}
+	namespace := metadataEntityTypeFromHref(href)
+	if namespace == "" {
+		return "", fmt.Errorf("HREF '%s' doesn't belong to an entity with metadata", href)
+	}
+	return fmt.Sprintf("urn:vcloud:%s:%s", namespace, uuid), nil
 }
 
-// DeleteMetadataEntryWithDomain deletes VApp metadata associated to the input key and waits for the task to finish.
-func (vApp *VApp) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
-	return deleteMetadataAndWait(vApp.client, vApp.VApp.HREF, key, isSystem)
+// metadataEntityTypeFromHref returns the URN namespace of the entity with the given HREF, such as vm or catalog, or
+// an empty string if the HREF doesn't belong to any of the entities supported by metadataUrnFromHref
+func metadataEntityTypeFromHref(href string) string {
+	// More specific paths must be checked first, as '/catalog/' would match '/catalogItem/' otherwise
+	for _, pattern := range []struct{ path, namespace string }{
+		{"/vApp/vm-", "vm"},
+		{"/vApp/vapp-", "vapp"},
+		{"/catalogItem/", "catalogitem"},
+		{"/catalog/", "catalog"},
+		{"/vdc/", "vdc"},
+		{"/media/", "media"},
+		{"/disk/", "disk"},
+		{"/network/", "network"},
+		{"/org/", "org"},
+	} {
+		if strings.Contains(href, pattern.path) {
+			return pattern.namespace
+		}
+	}
+	return ""
 }
 
-// DeleteMetadataEntryWithDomain deletes VAppTemplate metadata associated to the input key and waits for the task to finish.
-func (vAppTemplate *VAppTemplate) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
-	return deleteMetadataAndWait(vAppTemplate.client, vAppTemplate.VAppTemplate.HREF, key, isSystem)
+// metadataOperationLog contains the context of a metadata operation that is logged when it starts and when it ends,
+// as long as logging is enabled with util.EnableLogging
+type metadataOperationLog struct {
+	operation string            // The operation, such as "get" or "add"
+	href      string            // The HREF of the entity, or its URN for OpenAPI metadata
+	keys      []string          // The keys involved in the operation, if any
+	domain    string            // The domain, or domains separated by commas, of the keys
+	values    map[string]string // The values being sent, indexed by key, which are redacted by redactMetadataValue
 }
 
-// DeleteMetadataEntryWithDomain deletes MediaRecord metadata associated to the input key and waits for the task to finish.
-func (mediaRecord *MediaRecord) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
-	return deleteMetadataAndWait(mediaRecord.client, mediaRecord.MediaRecord.HREF, key, isSystem)
+// newMergeMetadataOperationLog returns the metadataOperationLog of a merge of the given metadata into the entity with
+// the given HREF or URN, with its keys sorted and the domains involved
+func newMergeMetadataOperationLog(href string, metadata map[string]types.MetadataValue) metadataOperationLog {
+	opLog := metadataOperationLog{operation: "merge", href: href, values: map[string]string{}}
+	domains := map[string]bool{}
+	for key, value := range metadata {
+		opLog.keys = append(opLog.keys, key)
+		domains[effectiveMetadataDomain(value.Domain).Domain] = true
+		if value.TypedValue != nil {
+			opLog.values[key] = value.TypedValue.Value
+		}
+	}
+	sort.Strings(opLog.keys)
+	var domainNames []string
+	for _, domain := range []string{"GENERAL", "SYSTEM"} {
+		if domains[domain] {
+			domainNames = append(domainNames, domain)
+		}
+	}
+	opLog.domain = strings.Join(domainNames, ",")
+	return opLog
 }
 
-// DeleteMetadataEntryWithDomain deletes Media metadata associated to the input key and waits for the task to finish.
-func (media *Media) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
-	return deleteMetadataAndWait(media.client, media.Media.HREF, key, isSystem)
+// start logs the beginning of the metadata operation, with its entity, keys, domain and redacted values
+func (opLog metadataOperationLog) start() {
+	if !util.EnableLogging {
+		return
+	}
+	values := make([]string, 0, len(opLog.values))
+	for _, key := range opLog.keys {
+		if value, ok := opLog.values[key]; ok {
+			values = append(values, key+"="+redactMetadataValue(value))
+		}
+	}
+	util.Logger.Printf("[DEBUG] metadata operation=%s phase=start entity=%s entityType=%s keys=%v domain=%s values=%v",
+		opLog.operation, opLog.href, opLog.entityType(), opLog.keys, opLog.domain, values)
 }
 
-// DeleteMetadataEntryWithDomain deletes AdminCatalog metadata associated to the input key and waits for the task to finish.
-func (adminCatalog *AdminCatalog) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
-	return deleteMetadataAndWait(adminCatalog.client, adminCatalog.AdminCatalog.HREF, key, isSystem)
+// end logs the result of the metadata operation, with the ID of the task it started, if any, and its error
+func (opLog metadataOperationLog) end(task *Task, err error) {
+	if !util.EnableLogging {
+		return
+	}
+	taskId := ""
+	if task != nil && task.Task != nil {
+		taskId = task.Task.ID
+	}
+	util.Logger.Printf("[DEBUG] metadata operation=%s phase=end entity=%s entityType=%s keys=%v domain=%s task=%s error=%v",
+		opLog.operation, opLog.href, opLog.entityType(), opLog.keys, opLog.domain, taskId, err)
 }
 
-// DeleteMetadataEntryWithDomain deletes AdminOrg metadata associated to the input key and waits for the task to finish.
-func (adminOrg *AdminOrg) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
-	return deleteMetadataAndWait(adminOrg.client, adminOrg.AdminOrg.HREF, key, isSystem)
+// entityType returns the type of the entity of the metadata operation, taken from its HREF or URN, or "unknown"
+func (opLog metadataOperationLog) entityType() string {
+	entityType := metadataEntityTypeFromHref(opLog.href)
+	if urnParts := strings.Split(opLog.href, ":"); entityType == "" && len(urnParts) > 3 && strings.HasPrefix(opLog.href, "urn:vcloud:") {
+		entityType = urnParts[2]
+	}
+	if entityType == "" {
+		return "unknown"
+	}
+	return entityType
 }
 
-// DeleteMetadataEntryWithDomain deletes Disk metadata associated to the input key and waits for the task to finish.
-func (disk *Disk) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
-	return deleteMetadataAndWait(disk.client, disk.Disk.HREF, key, isSystem)
+// redactMetadataValue returns the given metadata value as it should be logged: only its length, so secrets that are
+// stored as metadata are not leaked into the logs, unless the logging of sensitive data is enabled with
+// util.LogPasswords
+func redactMetadataValue(value string) string {
+	if util.LogPasswords {
+		return value
+	}
+	return fmt.Sprintf("<redacted %d characters>", utf8.RuneCountInString(value))
 }
 
-// DeleteMetadataEntryWithDomain deletes OrgVDCNetwork metadata associated to the input key and waits for the task to finish.
-// Note: Requires system administrator privileges.
-func (orgVdcNetwork *OrgVDCNetwork) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
-	return deleteMetadataAndWait(orgVdcNetwork.client, getAdminURL(orgVdcNetwork.OrgVDCNetwork.HREF), key, isSystem)
+// discoverMetadataEntities returns all the entities of the receiver AdminOrg that can have metadata, indexed by URN,
+// walking its VDCs, vApps and Catalogs. Containers that can't be retrieved are still returned, so their own metadata
+// can be exported, and the errors retrieving them are returned indexed by URN.
+func (adminOrg *AdminOrg) discoverMetadataEntities() (map[string]*OrgMetadataSnapshotEntity, map[string]error) {
+	entities := map[string]*OrgMetadataSnapshotEntity{}
+	discoveryErrors := map[string]error{}
+	var mutex sync.Mutex
+
+	// add registers the entity with the given HREF and returns its URN, or an empty string if it was already
+	// registered or its URN can't be computed
+	add := func(href, name string) string {
+		urn, err := metadataUrnFromHref(href)
+		mutex.Lock()
+		defer mutex.Unlock()
+		if err != nil {
+			discoveryErrors[href] = err
+			return ""
+		}
+		if _, exists := entities[urn]; exists {
+			return ""
+		}
+		entities[urn] = &OrgMetadataSnapshotEntity{Name: name, Type: strings.Split(urn, ":")[2], Href: href}
+		return urn
+	}
+	addError := func(urn string, err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		discoveryErrors[urn] = err
+	}
+
+	add(adminOrg.AdminOrg.HREF, adminOrg.AdminOrg.Name)
+
+	var vdcRefs, catalogRefs []*types.Reference
+	if adminOrg.AdminOrg.Vdcs != nil {
+		vdcRefs = adminOrg.AdminOrg.Vdcs.Vdcs
+	}
+	if adminOrg.AdminOrg.Catalogs != nil {
+		catalogRefs = adminOrg.AdminOrg.Catalogs.Catalog
+	}
+
+	var vAppRefs []*types.ResourceReference
+	runMetadataWorkers(len(vdcRefs), metadataExportConcurrency, func(index int) {
+		urn := add(vdcRefs[index].HREF, vdcRefs[index].Name)
+		if urn == "" {
+			return
+		}
+		vdc := &types.Vdc{}
+		_, err := adminOrg.client.ExecuteRequest(vdcRefs[index].HREF, http.MethodGet, "", "error retrieving VDC: %s", nil, vdc)
+		if err != nil {
+			addError(urn, err)
+			return
+		}
+		for _, resourceEntities := range vdc.ResourceEntities {
+			for _, resource := range resourceEntities.ResourceEntity {
+				switch resource.Type {
+				case types.MimeVApp:
+					if add(resource.HREF, resource.Name) != "" {
+						mutex.Lock()
+						vAppRefs = append(vAppRefs, resource)
+						mutex.Unlock()
+					}
+				case types.MimeMediaItem, types.MimeDisk:
+					add(resource.HREF, resource.Name)
+				}
+			}
+		}
+		for _, availableNetworks := range vdc.AvailableNetworks {
+			for _, network := range availableNetworks.Network {
+				add(network.HREF, network.Name)
+			}
+		}
+	})
+
+	runMetadataWorkers(len(vAppRefs), metadataExportConcurrency, func(index int) {
+		vApp := &types.VApp{}
+		_, err := adminOrg.client.ExecuteRequest(vAppRefs[index].HREF, http.MethodGet, "", "error retrieving vApp: %s", nil, vApp)
+		if err != nil {
+			urn, _ := metadataUrnFromHref(vAppRefs[index].HREF)
+			addError(urn, err)
+			return
+		}
+		if vApp.Children != nil {
+			for _, vm := range vApp.Children.VM {
+				add(vm.HREF, vm.Name)
+			}
+		}
+	})
+
+	runMetadataWorkers(len(catalogRefs), metadataExportConcurrency, func(index int) {
+		urn := add(catalogRefs[index].HREF, catalogRefs[index].Name)
+		if urn == "" {
+			return
+		}
+		catalog := &types.Catalog{}
+		_, err := adminOrg.client.ExecuteRequest(catalogRefs[index].HREF, http.MethodGet, "", "error retrieving catalog: %s", nil, catalog)
+		if err != nil {
+			addError(urn, err)
+			return
+		}
+		for _, catalogItems := range catalog.CatalogItems {
+			for _, catalogItem := range catalogItems.CatalogItem {
+				add(catalogItem.HREF, catalogItem.Name)
+			}
+		}
+	})
+
+	return entities, discoveryErrors
 }
 
-// DeleteMetadataEntryWithDomain deletes CatalogItem metadata associated to the input key and waits for the task to finish.
-func (catalogItem *CatalogItem) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
-	return deleteMetadataAndWait(catalogItem.client, catalogItem.CatalogItem.HREF, key, isSystem)
+// isInVdcGroup returns true if the receiver OpenApiOrgVdcNetwork belongs to a VDC Group, in which case its metadata
+// can only be managed with the OpenAPI metadata endpoint.
+func (openApiOrgVdcNetwork *OpenApiOrgVdcNetwork) isInVdcGroup() bool {
+	ownerRef := openApiOrgVdcNetwork.OpenApiOrgVdcNetwork.OwnerRef
+	return ownerRef != nil && OwnerIsVdcGroup(ownerRef.ID)
 }
 
-// DeleteMetadataEntryWithDomain deletes OpenApiOrgVdcNetwork metadata associated to the input key and waits for the task to finish.
-// Note: It doesn't delete metadata from networks that belong to a VDC Group.
-// TODO: This function is currently using XML API underneath as OpenAPI metadata is still not supported.
-func (openApiOrgVdcNetwork *OpenApiOrgVdcNetwork) DeleteMetadataEntryWithDomain(key string, isSystem bool) error {
-	href := fmt.Sprintf("%s/admin/network/%s", openApiOrgVdcNetwork.client.VCDHREF.String(), extractUuid(openApiOrgVdcNetwork.OpenApiOrgVdcNetwork.ID))
-	task, err := deleteMetadata(openApiOrgVdcNetwork.client, href, key, isSystem)
+// getMetadataAsMap retrieves the metadata of an entity referenced by its URI and returns the entries of the SYSTEM
+// domain (isSystem=true) or the GENERAL domain (isSystem=false) as a map of key to raw value.
+// See metadataAsMap for details.
+func getMetadataAsMap(client *Client, requestUri string, isSystem bool) (map[string]string, error) {
+	metadata, err := getMetadata(client, requestUri)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return task.WaitTaskCompletion()
+	return metadataAsMap(metadata, isSystem), nil
 }
 
-// ------------------------------------------------------------------------------------------------
-// Generic private functions
-// ------------------------------------------------------------------------------------------------
+// metadataAsMap flattens the entries of the given metadata that belong to the SYSTEM domain (isSystem=true) or to the
+// GENERAL domain (isSystem=false) into a map of key to raw value. Entries of the other domain are ignored, so a key
+// that exists in both domains always gets the value of the requested one.
+func metadataAsMap(metadata *types.Metadata, isSystem bool) map[string]string {
+	values := map[string]string{}
+	if metadata == nil {
+		return values
+	}
+	for _, entry := range metadata.MetadataEntry {
+		if !isMetadataEntryInDomain(entry, isSystem) || entry.TypedValue == nil {
+			continue
+		}
+		values[entry.Key] = entry.TypedValue.Value
+	}
+	return values
+}
 
-// getMetadata is a generic function to retrieve metadata from VCD
-func getMetadataByKey(client *Client, requestUri, key string, isSystem bool) (*types.MetadataValue, error) {
-	metadata := &types.MetadataValue{}
-	href := requestUri + "/metadata/"
+// renderMetadataTemplate executes the given text/template with the metadata values of the given domain, which are
+// passed as the map returned by getMetadataAsMap, and returns the rendered text. Example:
+//
+//	{{.owner}} ({{index . "cost-center"}})
+//
+// Keys that are not valid Go identifiers, like the ones containing dashes, must be accessed with 'index'.
+// Keys that don't exist render as an empty string, unless WithMetadataTemplateMissingKeyError is given, in which case
+// an error is returned. The template is parsed before retrieving the metadata, so syntax errors don't cost a request.
+func renderMetadataTemplate(client *Client, requestUri, tmpl string, isSystem bool, options ...MetadataTemplateOption) (string, error) {
+	settings := &metadataTemplateSettings{}
+	for _, option := range options {
+		option(settings)
+	}
 
-	if isSystem {
-		href += "SYSTEM/"
+	missingKey := "missingkey=zero"
+	if settings.missingKeyError {
+		missingKey = "missingkey=error"
+	}
+	parsedTemplate, err := template.New("metadata").Option(missingKey).Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("error parsing metadata template: %s", err)
 	}
 
-	_, err := client.ExecuteRequest(href+key, http.MethodGet, types.MimeMetaData, "error retrieving metadata by key "+key+": %s", nil, metadata)
-	return metadata, err
+	values, err := getMetadataAsMap(client, requestUri, isSystem)
+	if err != nil {
+		return "", err
+	}
+
+	var rendered strings.Builder
+	err = parsedTemplate.Execute(&rendered, values)
+	if err != nil {
+		return "", fmt.Errorf("error rendering metadata template: %s", err)
+	}
+	return rendered.String(), nil
 }
 
-// getMetadata is a generic function to retrieve metadata from VCD
-func getMetadata(client *Client, requestUri string) (*types.Metadata, error) {
-	metadata := &types.Metadata{}
+// getMetadataStrict retrieves the metadata entries that belong to the given domain and validates that all their values
+// can be parsed according to their XsiType. All the malformed values are reported in a single *MetadataValueParseError.
+func getMetadataStrict(client *Client, requestUri string, isSystem bool) (*types.Metadata, error) {
+	metadata, err := getMetadata(client, requestUri)
+	if err != nil {
+		return nil, err
+	}
 
-	_, err := client.ExecuteRequest(requestUri+"/metadata/", http.MethodGet, types.MimeMetaData, "error retrieving metadata: %s", nil, metadata)
-	return metadata, err
+	result := &types.Metadata{
+		Xmlns: metadata.Xmlns,
+		Xsi:   metadata.Xsi,
+		HREF:  metadata.HREF,
+		Type:  metadata.Type,
+		Link:  metadata.Link,
+	}
+	parseErrors := map[string]error{}
+	for _, entry := range metadata.MetadataEntry {
+		if !isMetadataEntryInDomain(entry, isSystem) {
+			continue
+		}
+		result.MetadataEntry = append(result.MetadataEntry, entry)
+		_, err = parseMetadataTypedValue(entry.TypedValue)
+		if err != nil {
+			parseErrors[entry.Key] = err
+		}
+	}
+
+	if len(parseErrors) > 0 {
+		return result, &MetadataValueParseError{Errors: parseErrors}
+	}
+	return result, nil
 }
 
-// addMetadata adds metadata to an entity.
-// If the metadata entry is of the SYSTEM domain (isSystem=true), one can set different types of Visibility:
-// types.MetadataReadOnlyVisibility, types.MetadataHiddenVisibility but NOT types.MetadataReadWriteVisibility.
-// If the metadata entry is of the GENERAL domain (isSystem=false), visibility is always types.MetadataReadWriteVisibility.
-// In terms of typedValues, that must be one of:
-// types.MetadataStringValue, types.MetadataNumberValue, types.MetadataDateTimeValue and types.MetadataBooleanValue.
-func addMetadata(client *Client, requestUri, key, value, typedValue, visibility string, isSystem bool) (Task, error) {
-	apiEndpoint := urlParseRequestURI(requestUri)
-	newMetadata := &types.MetadataValue{
-		Xmlns: types.XMLNamespaceVCloud,
-		Xsi:   types.XMLNamespaceXSI,
-		TypedValue: &types.MetadataTypedValue{
-			XsiType: typedValue,
-			Value:   value,
-		},
-		Domain: &types.MetadataDomainTag{
-			Visibility: visibility,
-			Domain:     "SYSTEM",
-		},
+// metadataValueOrAbsent returns the value of the given key in the given domain, or MetadataAbsentBucket if the
+// metadata doesn't contain such entry.
+func metadataValueOrAbsent(metadata *types.Metadata, key string, isSystem bool) string {
+	entry := findMetadataEntry(metadata, key, isSystem)
+	if entry == nil || entry.TypedValue == nil {
+		return MetadataAbsentBucket
 	}
+	return entry.TypedValue.Value
+}
 
-	if isSystem {
-		apiEndpoint.Path += "/metadata/SYSTEM/" + key
-	} else {
-		apiEndpoint.Path += "/metadata/" + key
-		newMetadata.Domain.Domain = "GENERAL"
-		if visibility != types.MetadataReadWriteVisibility {
-			newMetadata.Domain.Visibility = types.MetadataReadWriteVisibility
-		}
+// findMetadataEntry returns the entry of the given metadata that corresponds to the given key and domain, or nil if
+// there is no such entry.
+func findMetadataEntry(metadata *types.Metadata, key string, isSystem bool) *types.MetadataEntry {
+	entry, _ := metadata.GetByKey(key, isSystem)
+	return entry
+}
+
+// validateMetadataEntry checks, before sending any request to VCD, that the given metadata entry is valid:
+//   - The key is not empty and is not longer than maxMetadataKeyLength characters.
+//   - The value is not longer than maxMetadataValueLength characters.
+//   - The type is one of types.MetadataStringValue, types.MetadataNumberValue, types.MetadataDateTimeValue and
+//     types.MetadataBooleanValue.
+//   - The visibility, if not empty, is one of types.MetadataReadOnlyVisibility, types.MetadataHiddenVisibility and
+//     types.MetadataReadWriteVisibility.
+//
+// It returns a *MetadataValidationError that wraps ErrInvalidMetadataKey or ErrInvalidMetadataValue otherwise.
+func validateMetadataEntry(key, value, typedValue, visibility string) error {
+	switch {
+	case key == "":
+		return &MetadataValidationError{Key: key, Reason: "key is empty", Err: ErrInvalidMetadataKey}
+	case utf8.RuneCountInString(key) > maxMetadataKeyLength:
+		return &MetadataValidationError{Key: key, Reason: fmt.Sprintf("key is longer than %d characters", maxMetadataKeyLength), Err: ErrInvalidMetadataKey}
+	case utf8.RuneCountInString(value) > maxMetadataValueLength:
+		return &MetadataValidationError{Key: key, Reason: fmt.Sprintf("value is longer than %d characters", maxMetadataValueLength), Err: ErrInvalidMetadataValue}
 	}
 
-	domain := newMetadata.Domain.Visibility
-	task, err := client.ExecuteTaskRequest(apiEndpoint.String(), http.MethodPut, types.MimeMetaDataValue, "error adding metadata: %s", newMetadata)
+	if !types.MetadataType(typedValue).IsValid() {
+		return &MetadataValidationError{Key: key, Reason: fmt.Sprintf("unknown metadata type '%s'", typedValue), Err: ErrInvalidMetadataValue}
+	}
+	if _, isKnown := metadataVisibilityMinApiVersions[visibility]; visibility != "" && !isKnown {
+		return &MetadataValidationError{Key: key, Reason: fmt.Sprintf("unknown visibility '%s'", visibility), Err: ErrInvalidMetadataValue}
+	}
+	return nil
+}
 
-	// Workaround for ugly error returned by VCD: "API Error: 500: [ <uuid> ] visibility"
-	if err != nil && strings.HasSuffix(err.Error(), "visibility") {
-		err = fmt.Errorf("error adding metadata with key %s: visibility cannot be %s when domain is %s: %s", key, visibility, domain, err)
+// validateMetadataVisibilityApiVersion checks that the negotiated API version of the client supports the given
+// visibility, as registered in metadataVisibilityMinApiVersions. Otherwise, it returns a *MetadataNotSupportedError
+// with the minimum API version required by the visibility.
+func validateMetadataVisibilityApiVersion(client *Client, requestUri, visibility string) error {
+	minimumApiVersion := metadataVisibilityMinApiVersions[visibility]
+	if minimumApiVersion == "" || client.APIClientVersionIs(">= "+minimumApiVersion) {
+		return nil
+	}
+	return &MetadataNotSupportedError{
+		Entity:            fmt.Sprintf("entity '%s'", requestUri),
+		Reason:            fmt.Sprintf("visibility '%s' is not available in API version %s", visibility, client.APIVersion),
+		MinimumApiVersion: minimumApiVersion,
 	}
-	return task, err
 }
 
-// addMetadataAndWait adds metadata to an entity and waits for the task completion.
-// The function supports passing a value that requires a typed value that must be one of:
-// types.MetadataStringValue, types.MetadataNumberValue, types.MetadataDateTimeValue and types.MetadataBooleanValue.
-// Visibility also needs to be one of: types.MetadataReadOnlyVisibility, types.MetadataHiddenVisibility or types.MetadataReadWriteVisibility
-func addMetadataAndWait(client *Client, requestUri, key, value, typedValue, visibility string, isSystem bool) error {
-	task, err := addMetadata(client, requestUri, key, value, typedValue, visibility, isSystem)
-	if err != nil {
-		return err
+// validateMetadataValues validates all the given metadata values with validateMetadataEntry, in key order, and returns
+// the first error found. Values without TypedValue are rejected.
+func validateMetadataValues(metadata map[string]types.MetadataValue) error {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := metadata[key]
+		if value.TypedValue == nil {
+			return &MetadataValidationError{Key: key, Reason: "metadata value is empty", Err: ErrInvalidMetadataValue}
+		}
+		visibility := ""
+		if value.Domain != nil {
+			visibility = value.Domain.Visibility
+		}
+		err := validateMetadataEntry(key, value.TypedValue.Value, value.TypedValue.XsiType, visibility)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	return task.WaitTaskCompletion()
+// hasSystemMetadataValues returns true if any of the given metadata values belongs to the SYSTEM domain
+func hasSystemMetadataValues(metadata map[string]types.MetadataValue) bool {
+	for _, value := range metadata {
+		if value.Domain != nil && value.Domain.Domain == "SYSTEM" {
+			return true
+		}
+	}
+	return false
 }
 
-// mergeAllMetadata updates the metadata values that are already present in VCD and creates the ones not present.
-// The input metadata map has a "metadata key"->"metadata value" relation.
-// If the operation is successful, it returns the created task.
-func mergeAllMetadata(client *Client, requestUri string, metadata map[string]types.MetadataValue) (Task, error) {
-	var metadataToMerge []*types.MetadataEntry
-	for key, value := range metadata {
-		metadataToMerge = append(metadataToMerge, &types.MetadataEntry{
-			Xmlns:      types.XMLNamespaceVCloud,
-			Xsi:        types.XMLNamespaceXSI,
-			Key:        key,
-			TypedValue: value.TypedValue,
-			Domain:     value.Domain,
-		})
+// validateMetadataVisibility checks that the given visibility is allowed in the given domain: SYSTEM domain accepts
+// any visibility registered in metadataVisibilityMinApiVersions but types.MetadataReadWriteVisibility, while GENERAL
+// domain only accepts types.MetadataReadWriteVisibility.
+func validateMetadataVisibility(visibility string, isSystem bool) error {
+	_, isKnown := metadataVisibilityMinApiVersions[visibility]
+	switch {
+	case isSystem && isKnown && visibility != types.MetadataReadWriteVisibility:
+		return nil
+	case !isSystem && visibility == types.MetadataReadWriteVisibility:
+		return nil
+	}
+	domain := "GENERAL"
+	if isSystem {
+		domain = "SYSTEM"
 	}
+	return fmt.Errorf("visibility '%s' is not allowed in %s domain", visibility, domain)
+}
 
-	newMetadata := &types.Metadata{
-		Xmlns:         types.XMLNamespaceVCloud,
-		Xsi:           types.XMLNamespaceXSI,
-		MetadataEntry: metadataToMerge,
+// metadataEntityIdentifier returns the HREF of the given metadata compatible entity, to identify it in errors.
+// If the entity type is unknown or its HREF is empty, its type and position in the input are used instead.
+func metadataEntityIdentifier(entity MetadataCompatible, index int) string {
+	href := ""
+	switch typedEntity := entity.(type) {
+	case *VM:
+		href = typedEntity.VM.HREF
+	case *VApp:
+		href = typedEntity.VApp.HREF
+	case *VAppTemplate:
+		href = typedEntity.VAppTemplate.HREF
+	case *Vdc:
+		href = typedEntity.Vdc.HREF
+	case *AdminVdc:
+		href = typedEntity.AdminVdc.HREF
+	case *ProviderVdc:
+		href = typedEntity.ProviderVdc.HREF
+	case *MediaRecord:
+		href = typedEntity.MediaRecord.HREF
+	case *Media:
+		href = typedEntity.Media.HREF
+	case *Catalog:
+		href = typedEntity.Catalog.HREF
+	case *AdminCatalog:
+		href = typedEntity.AdminCatalog.HREF
+	case *Org:
+		href = typedEntity.Org.HREF
+	case *AdminOrg:
+		href = typedEntity.AdminOrg.HREF
+	case *Disk:
+		href = typedEntity.Disk.HREF
+	case *OrgVDCNetwork:
+		href = typedEntity.OrgVDCNetwork.HREF
+	case *CatalogItem:
+		href = typedEntity.CatalogItem.HREF
+	case *OpenApiOrgVdcNetwork:
+		href = typedEntity.OpenApiOrgVdcNetwork.ID
+	case *NsxtEdgeGateway:
+		href = typedEntity.EdgeGateway.ID
+	case *VdcGroup:
+		href = typedEntity.VdcGroup.Id
+	case *ExternalNetworkV2:
+		href = typedEntity.ExternalNetwork.ID
+	case *Certificate:
+		href = typedEntity.CertificateLibrary.Id
+	case *VdcComputePolicyV2:
+		href = typedEntity.VdcComputePolicyV2.ID
+	case *NsxtAlbServiceEngineGroup:
+		href = typedEntity.NsxtAlbServiceEngineGroup.ID
 	}
+	if href == "" {
+		return fmt.Sprintf("%T #%d", entity, index)
+	}
+	return href
+}
 
-	apiEndpoint := urlParseRequestURI(requestUri)
-	apiEndpoint.Path += "/metadata"
+// metadataEntityClient returns the client of the given metadata compatible entity, or nil if its type is unknown
+func metadataEntityClient(entity MetadataCompatible) *Client {
+	switch typedEntity := entity.(type) {
+	case *VM:
+		return typedEntity.client
+	case *VApp:
+		return typedEntity.client
+	case *VAppTemplate:
+		return typedEntity.client
+	case *Vdc:
+		return typedEntity.client
+	case *AdminVdc:
+		return typedEntity.client
+	case *ProviderVdc:
+		return typedEntity.client
+	case *MediaRecord:
+		return typedEntity.client
+	case *Media:
+		return typedEntity.client
+	case *Catalog:
+		return typedEntity.client
+	case *AdminCatalog:
+		return typedEntity.client
+	case *Org:
+		return typedEntity.client
+	case *AdminOrg:
+		return typedEntity.client
+	case *Disk:
+		return typedEntity.client
+	case *OrgVDCNetwork:
+		return typedEntity.client
+	case *CatalogItem:
+		return typedEntity.client
+	case *OpenApiOrgVdcNetwork:
+		return typedEntity.client
+	case *NsxtEdgeGateway:
+		return typedEntity.client
+	case *VdcGroup:
+		return typedEntity.client
+	case *ExternalNetworkV2:
+		return typedEntity.client
+	case *Certificate:
+		return typedEntity.client
+	case *VdcComputePolicyV2:
+		return typedEntity.client
+	case *NsxtAlbServiceEngineGroup:
+		return &typedEntity.vcdClient.Client
+	}
+	return nil
+}
 
-	return client.ExecuteTaskRequest(apiEndpoint.String(), http.MethodPost, types.MimeMetaData, "error adding metadata: %s", newMetadata)
+// runMetadataWorkers calls the work function once for every index from 0 to count-1, running at most 'concurrency'
+// calls at the same time, and returns when all of them have finished. A concurrency lower than 1 is treated as 1.
+func runMetadataWorkers(count, concurrency int, work func(index int)) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	semaphore := make(chan struct{}, concurrency)
+	var waitGroup sync.WaitGroup
+	for index := 0; index < count; index++ {
+		waitGroup.Add(1)
+		semaphore <- struct{}{}
+		go func(index int) {
+			defer func() {
+				<-semaphore
+				waitGroup.Done()
+			}()
+			work(index)
+		}(index)
+	}
+	waitGroup.Wait()
 }
 
-// mergeAllMetadata updates the metadata values that are already present in VCD and creates the ones not present.
-// The input metadata map has a "metadata key"->"metadata value" relation.
-// This function waits until merge finishes.
-func mergeMetadataAndWait(client *Client, requestUri string, metadata map[string]types.MetadataValue) error {
-	task, err := mergeAllMetadata(client, requestUri, metadata)
-	if err != nil {
-		return err
+// isMetadataEntryInDomain returns true if the given metadata entry belongs to the SYSTEM domain when isSystem=true,
+// or to the GENERAL domain otherwise. Entries without a Domain tag belong to the GENERAL domain, as VCD omits it
+// for GENERAL entries with types.MetadataReadWriteVisibility.
+func isMetadataEntryInDomain(entry *types.MetadataEntry, isSystem bool) bool {
+	if entry == nil {
+		return false
+	}
+	entryIsSystem := entry.Domain != nil && entry.Domain.Domain == "SYSTEM"
+	return entryIsSystem == isSystem
+}
+
+// parseMetadataTypedValue converts the string value of the given metadata typed value to the Go type that corresponds
+// to its XsiType:
+// types.MetadataNumberValue is returned as int64, types.MetadataBooleanValue as bool,
+// types.MetadataDateTimeValue as time.Time and types.MetadataStringValue as string.
+// An error is returned if the value doesn't match its declared type.
+func parseMetadataTypedValue(typedValue *types.MetadataTypedValue) (interface{}, error) {
+	if typedValue == nil {
+		return nil, fmt.Errorf("metadata typed value is empty")
 	}
 
-	return task.WaitTaskCompletion()
+	switch typedValue.XsiType {
+	case types.MetadataNumberValue:
+		number, err := strconv.ParseInt(typedValue.Value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("value '%s' is not a valid %s: %s", typedValue.Value, typedValue.XsiType, err)
+		}
+		return number, nil
+	case types.MetadataBooleanValue:
+		boolean, err := strconv.ParseBool(typedValue.Value)
+		if err != nil {
+			return nil, fmt.Errorf("value '%s' is not a valid %s: %s", typedValue.Value, typedValue.XsiType, err)
+		}
+		return boolean, nil
+	case types.MetadataDateTimeValue:
+		dateTime, err := ParseMetadataDateTime(typedValue.Value)
+		if err != nil {
+			return nil, fmt.Errorf("value '%s' is not a valid %s: %s", typedValue.Value, typedValue.XsiType, err)
+		}
+		return dateTime, nil
+	case types.MetadataStringValue:
+		return typedValue.Value, nil
+	default:
+		return nil, fmt.Errorf("unknown metadata type '%s'", typedValue.XsiType)
+	}
 }
 
-// deleteMetadata deletes metadata associated to the input key from an entity referenced by its URI, then returns the
-// task.
-func deleteMetadata(client *Client, requestUri string, key string, isSystem bool) (Task, error) {
-	apiEndpoint := urlParseRequestURI(requestUri)
-	if isSystem {
-		apiEndpoint.Path += "/metadata/SYSTEM/" + key
-	} else {
-		apiEndpoint.Path += "/metadata/" + key
+// ------------------------------------------------------------------------------------------------
+// Generic metadata request dispatcher
+// ------------------------------------------------------------------------------------------------
+
+// metadataTransport identifies the API used to manage the metadata of an entity
+type metadataTransport int
+
+const (
+	// metadataTransportXml manages metadata with the XML API, using the HREF of the entity
+	metadataTransportXml metadataTransport = iota
+	// metadataTransportOpenApi manages metadata with the OpenAPI, using an OpenAPI metadata endpoint and the entity ID
+	metadataTransportOpenApi
+)
+
+// String returns a human readable name of the transport
+func (transport metadataTransport) String() string {
+	switch transport {
+	case metadataTransportXml:
+		return "XML"
+	case metadataTransportOpenApi:
+		return "OpenAPI"
 	}
+	return fmt.Sprintf("unknown(%d)", int(transport))
+}
+
+// metadataOperation identifies the metadata operation performed by metadataRequest
+type metadataOperation int
+
+const (
+	metadataOperationGet      metadataOperation = iota // Retrieves all the metadata of the entity
+	metadataOperationGetByKey                          // Retrieves the entry with the given key and domain
+	metadataOperationAdd                               // Creates or updates the entry with the given key and domain
+	metadataOperationMerge                             // Creates or updates all the given entries
+	metadataOperationDelete                            // Deletes the entry with the given key and domain
+)
 
-	return client.ExecuteTaskRequest(apiEndpoint.String(), http.MethodDelete, "", "error deleting metadata: %s", nil)
+// String returns a human readable name of the operation
+func (operation metadataOperation) String() string {
+	switch operation {
+	case metadataOperationGet:
+		return "get"
+	case metadataOperationGetByKey:
+		return "get by key"
+	case metadataOperationAdd:
+		return "add"
+	case metadataOperationMerge:
+		return "merge"
+	case metadataOperationDelete:
+		return "delete"
+	}
+	return fmt.Sprintf("unknown(%d)", int(operation))
 }
 
-// deleteMetadata deletes metadata associated to the input key from an entity referenced by its URI.
-func deleteMetadataAndWait(client *Client, requestUri string, key string, isSystem bool) error {
-	task, err := deleteMetadata(client, requestUri, key, isSystem)
+// metadataRequestOptions describes a single metadata operation, independently of the transport used to perform it
+type metadataRequestOptions struct {
+	transport metadataTransport
+	operation metadataOperation
+
+	// href is the HREF of the entity, used by metadataTransportXml
+	href string
+	// endpoint is one of the OpenAPI metadata endpoints, like types.OpenApiEndpointOrgVdcNetworksMetadata, and
+	// entityId is the ID of the entity. Both are used by metadataTransportOpenApi
+	endpoint string
+	entityId string
+
+	// key and isSystem select the entry for metadataOperationGetByKey, metadataOperationAdd and
+	// metadataOperationDelete
+	key      string
+	isSystem bool
+
+	// value, typedValue and visibility are the contents of the entry for metadataOperationAdd
+	value      string
+	typedValue string
+	visibility string
+
+	// metadata contains the entries for metadataOperationMerge
+	metadata map[string]types.MetadataValue
+}
+
+// metadataResponse contains the result of metadataRequest. Only metadataOperationGet fills metadata and only
+// metadataOperationGetByKey fills value.
+type metadataResponse struct {
+	metadata *types.Metadata
+	value    *types.MetadataValue
+}
+
+// metadataRequest performs the metadata operation described by the given options with the requested transport, so
+// callers get the same behaviour from both APIs:
+//   - Operations that modify metadata wait for the XML API task to finish, as OpenAPI doesn't return tasks.
+//   - A missing key is always reported as a *MetadataKeyNotFoundError.
+//   - Any other error mentions the operation, the transport and the entity.
+//
+// It's only used by the receivers whose metadata can be managed with either transport, OpenApiOrgVdcNetwork, Disk and
+// ExternalNetworkV2.
+// The rest of the receivers only have XML metadata and call the XML helpers, like getMetadata, directly.
+func metadataRequest(client *Client, opts metadataRequestOptions) (*metadataResponse, error) {
+	return metadataRequestWithContext(context.Background(), client, opts)
+}
+
+// metadataRequestWithContext is the implementation of metadataRequest, with the requests of either transport bound to
+// the given context
+func metadataRequestWithContext(ctx context.Context, client *Client, opts metadataRequestOptions) (*metadataResponse, error) {
+	entity := opts.href
+	if opts.transport == metadataTransportOpenApi {
+		entity = opts.entityId
+	}
+	if client == nil {
+		return nil, fmt.Errorf("a client is required to manage the %s metadata of '%s'", opts.transport, entity)
+	}
+
+	var response *metadataResponse
+	var err error
+	switch opts.transport {
+	case metadataTransportXml:
+		if opts.href == "" {
+			return nil, fmt.Errorf("the entity HREF is required to manage its XML metadata")
+		}
+		response, err = xmlMetadataRequest(ctx, client, opts)
+	case metadataTransportOpenApi:
+		response, err = openApiMetadataRequest(ctx, client, opts)
+	default:
+		return nil, fmt.Errorf("unknown metadata transport %s", opts.transport)
+	}
 	if err != nil {
-		return err
+		return nil, normalizeMetadataRequestError(opts, entity, err)
+	}
+	return response, nil
+}
+
+// xmlMetadataRequest performs the given metadata operation with the XML API
+func xmlMetadataRequest(ctx context.Context, client *Client, opts metadataRequestOptions) (*metadataResponse, error) {
+	switch opts.operation {
+	case metadataOperationGet:
+		metadata, err := getMetadataWithContext(ctx, client, opts.href)
+		if err != nil {
+			return nil, err
+		}
+		return &metadataResponse{metadata: metadata}, nil
+	case metadataOperationGetByKey:
+		value, err := getMetadataByKeyWithContext(ctx, client, opts.href, opts.key, opts.isSystem)
+		if err != nil {
+			return nil, err
+		}
+		return &metadataResponse{value: value}, nil
+	case metadataOperationAdd:
+		return &metadataResponse{}, addMetadataAndWaitWithContext(ctx, client, opts.href, opts.key, opts.value, opts.typedValue, opts.visibility, opts.isSystem, false)
+	case metadataOperationMerge:
+		return &metadataResponse{}, mergeMetadataAndWaitWithContext(ctx, client, opts.href, opts.metadata)
+	case metadataOperationDelete:
+		return &metadataResponse{}, deleteMetadataAndWaitWithContext(ctx, client, opts.href, opts.key, opts.isSystem)
+	}
+	return nil, fmt.Errorf("unknown metadata operation %s", opts.operation)
+}
+
+// openApiMetadataRequest performs the given metadata operation with the OpenAPI
+func openApiMetadataRequest(ctx context.Context, client *Client, opts metadataRequestOptions) (*metadataResponse, error) {
+	switch opts.operation {
+	case metadataOperationGet:
+		metadata, err := getOpenApiMetadataWithContext(ctx, client, opts.endpoint, opts.entityId, nil)
+		if err != nil {
+			return nil, err
+		}
+		return &metadataResponse{metadata: metadata}, nil
+	case metadataOperationGetByKey:
+		value, err := getOpenApiMetadataByKeyWithContext(ctx, client, opts.endpoint, opts.entityId, nil, opts.key, opts.isSystem)
+		if err != nil {
+			return nil, err
+		}
+		return &metadataResponse{value: value}, nil
+	case metadataOperationAdd:
+		// The XML API validates the entry before sending it, so the same checks are done here
+		err := validateMetadataEntry(opts.key, opts.value, opts.typedValue, opts.visibility)
+		if err != nil {
+			return nil, err
+		}
+		return &metadataResponse{}, addOpenApiMetadataWithContext(ctx, client, opts.endpoint, opts.entityId, nil, opts.key, opts.value, opts.typedValue, opts.visibility, opts.isSystem)
+	case metadataOperationMerge:
+		err := validateMetadataValues(opts.metadata)
+		if err != nil {
+			return nil, err
+		}
+		return &metadataResponse{}, mergeOpenApiMetadataWithContext(ctx, client, opts.endpoint, opts.entityId, nil, opts.metadata)
+	case metadataOperationDelete:
+		return &metadataResponse{}, deleteOpenApiMetadataWithContext(ctx, client, opts.endpoint, opts.entityId, nil, opts.key, opts.isSystem)
+	}
+	return nil, fmt.Errorf("unknown metadata operation %s", opts.operation)
+}
+
+// normalizeMetadataRequestError converts the error returned by any transport to the form documented in
+// metadataRequest. Typed metadata errors, like *MetadataValidationError or *MetadataPermissionError, are returned
+// as they are, so callers can still inspect them.
+func normalizeMetadataRequestError(opts metadataRequestOptions, entity string, err error) error {
+	var notFoundError *MetadataKeyNotFoundError
+	if errors.As(err, &notFoundError) {
+		return notFoundError
+	}
+	if opts.operation == metadataOperationGetByKey || opts.operation == metadataOperationDelete {
+		if isMetadataKeyNotFound(err) || ContainsNotFound(err) {
+			return &MetadataKeyNotFoundError{Key: opts.key, Err: err}
+		}
 	}
 
-	return task.WaitTaskCompletion()
+	var validationError *MetadataValidationError
+	var notSupportedError *MetadataNotSupportedError
+	var permissionError *MetadataPermissionError
+	var multiError *MetadataMultiError
+	if errors.As(err, &validationError) || errors.As(err, &notSupportedError) || errors.As(err, &permissionError) || errors.As(err, &multiError) {
+		return err
+	}
+	return fmt.Errorf("error performing %s metadata operation '%s' on '%s': %s", opts.transport, opts.operation, entity, err)
 }
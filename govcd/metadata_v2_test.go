@@ -277,15 +277,6 @@ func (vcd *TestVCD) TestCatalogItemMetadata(check *C) {
 	testMetadataCRUDActions(catalogItem, check, nil)
 }
 
-// metadataCompatible allows centralizing and generalizing the tests for metadata compatible resources.
-type metadataCompatible interface {
-	GetMetadata() (*types.Metadata, error)
-	GetMetadataByKey(key string, isSystem bool) (*types.MetadataValue, error)
-	AddMetadataEntryWithVisibility(key, value, typedValue, visibility string, isSystem bool) error
-	MergeMetadataWithMetadataValues(metadata map[string]types.MetadataValue) error
-	DeleteMetadataEntryWithDomain(key string, isSystem bool) error
-}
-
 type metadataTest struct {
 	Key                   string
 	Value                 string
@@ -298,9 +289,9 @@ type metadataTest struct {
 
 // testMetadataCRUDActions performs a complete test of all use cases that metadata can have, for a metadata compatible resource.
 // The function parameter extraReadStep performs an extra read step that can be passed as a function. Useful to perform a test
-// on "admin+not admin" resource combinations, where the "not admin" only has a GetMetadata function.
-// For example, AdminOrg and Org, where Org only has GetMetadata.
-func testMetadataCRUDActions(resource metadataCompatible, check *C, extraReadStep func(testCase metadataTest)) {
+// on "admin+not admin" resource combinations, to check that the metadata is also visible from the "not admin" one.
+// For example, AdminOrg and Org.
+func testMetadataCRUDActions(resource MetadataCompatible, check *C, extraReadStep func(testCase metadataTest)) {
 	// Check how much metadata exists
 	metadata, err := resource.GetMetadata()
 	check.Assert(err, IsNil)
@@ -396,6 +387,11 @@ func testMetadataCRUDActions(resource metadataCompatible, check *C, extraReadSte
 		},
 	}
 
+	// Provider VDCs accept types.MetadataReadWriteVisibility with isSystem=true for system administrators
+	if _, isProviderVdc := resource.(*ProviderVdc); isProviderVdc {
+		testCases[len(testCases)-1].ExpectErrorOnFirstAdd = false
+	}
+
 	for _, testCase := range testCases {
 
 		err = resource.AddMetadataEntryWithVisibility(testCase.Key, testCase.Value, testCase.Type, testCase.Visibility, testCase.IsSystem)
@@ -0,0 +1,5741 @@
+//go:build unit || ALL
+// +build unit ALL
+
+/*
+ * Copyright 2023 VMware, Inc.  All rights reserved.  Licensed under the Apache v2 License.
+ */
+
+package govcd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vmware/go-vcloud-director/v2/types/v56"
+	"github.com/vmware/go-vcloud-director/v2/util"
+)
+
+// Test_parseMetadataTypedValue checks that metadata values are converted to the Go type that corresponds to their XsiType
+func Test_parseMetadataTypedValue(t *testing.T) {
+	tests := []struct {
+		name       string
+		typedValue *types.MetadataTypedValue
+		want       interface{}
+		wantErr    bool
+	}{
+		{"string", &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "abc"}, "abc", false},
+		{"number", &types.MetadataTypedValue{XsiType: types.MetadataNumberValue, Value: "42"}, int64(42), false},
+		{"negative number", &types.MetadataTypedValue{XsiType: types.MetadataNumberValue, Value: "-7"}, int64(-7), false},
+		{"malformed number", &types.MetadataTypedValue{XsiType: types.MetadataNumberValue, Value: "abc"}, nil, true},
+		{"boolean", &types.MetadataTypedValue{XsiType: types.MetadataBooleanValue, Value: "true"}, true, false},
+		{"malformed boolean", &types.MetadataTypedValue{XsiType: types.MetadataBooleanValue, Value: "notABool"}, nil, true},
+		{"date", &types.MetadataTypedValue{XsiType: types.MetadataDateTimeValue, Value: "2022-10-05T13:44:00.000Z"},
+			time.Date(2022, 10, 5, 13, 44, 0, 0, time.UTC), false},
+		{"malformed date", &types.MetadataTypedValue{XsiType: types.MetadataDateTimeValue, Value: "notADate"}, nil, true},
+		{"unknown type", &types.MetadataTypedValue{XsiType: "MetadataFooValue", Value: "abc"}, nil, true},
+		{"nil value", nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMetadataTypedValue(tt.typedValue)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMetadataTypedValue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if wantTime, isTime := tt.want.(time.Time); isTime {
+				if gotTime, ok := got.(time.Time); !ok || !gotTime.Equal(wantTime) {
+					t.Errorf("parseMetadataTypedValue() = %v, want %v", got, tt.want)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("parseMetadataTypedValue() = %v (%T), want %v (%T)", got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+// Test_MetadataValueParseError checks that the error message lists all the malformed keys in order
+func Test_MetadataValueParseError(t *testing.T) {
+	_, numberErr := parseMetadataTypedValue(&types.MetadataTypedValue{XsiType: types.MetadataNumberValue, Value: "abc"})
+	_, dateErr := parseMetadataTypedValue(&types.MetadataTypedValue{XsiType: types.MetadataDateTimeValue, Value: "abc"})
+	parseError := &MetadataValueParseError{Errors: map[string]error{
+		"zKey": numberErr,
+		"aKey": dateErr,
+	}}
+
+	message := parseError.Error()
+	if !strings.HasPrefix(message, "found 2 metadata entries with malformed values") {
+		t.Errorf("unexpected error message: %s", message)
+	}
+	if strings.Index(message, "key 'aKey'") > strings.Index(message, "key 'zKey'") {
+		t.Errorf("expected keys to be sorted in error message: %s", message)
+	}
+}
+
+// notSupportedMetadataOperations contains the five metadata operations of an entity that doesn't support metadata
+type notSupportedMetadataOperations struct {
+	get      func() error
+	getByKey func() error
+	add      func() error
+	merge    func() error
+	delete   func() error
+}
+
+// metadataCompatibleOperations returns the metadata operations of the given MetadataCompatible entity
+func metadataCompatibleOperations(entity MetadataCompatible) notSupportedMetadataOperations {
+	return notSupportedMetadataOperations{
+		get: func() error {
+			_, err := entity.GetMetadata()
+			return err
+		},
+		getByKey: func() error {
+			_, err := entity.GetMetadataByKey("key", false)
+			return err
+		},
+		add: func() error {
+			return entity.AddMetadataEntryWithVisibility("key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+		},
+		merge: func() error {
+			return entity.MergeMetadataWithMetadataValues(map[string]types.MetadataValue{})
+		},
+		delete: func() error {
+			return entity.DeleteMetadataEntryWithDomain("key", false)
+		},
+	}
+}
+
+// Test_MetadataNotSupported checks that the metadata operations of the entities that don't support metadata return a
+// *MetadataNotSupportedError without sending any request to VCD
+func Test_MetadataNotSupported(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	vcdClient := &VCDClient{Client: *mockServer.client}
+
+	adminCatalog := NewAdminCatalog(mockServer.client)
+	policy := &VdcComputePolicyV2{VdcComputePolicyV2: &types.VdcComputePolicyV2{}, client: mockServer.client}
+	orgUser := NewUser(mockServer.client, &AdminOrg{AdminOrg: &types.AdminOrg{}})
+	orgUser.User = &types.User{Href: mockServer.URL + "/api/admin/user/1", Role: &types.Reference{Name: "Organization Administrator"}}
+	affinityRule := NewVmAffinityRule(mockServer.client)
+	affinityRule.VmAffinityRule = &types.VmAffinityRule{HREF: mockServer.URL + "/api/vdc/1/vmAffinityRules/1", Name: "rule"}
+	networkPoolHref := mockServer.URL + "/api/admin/extension/networkPool/1"
+	nsxtManagerHref := mockServer.URL + "/api/admin/extension/nsxtManagers/1"
+	snapshotHref := mockServer.URL + "/api/vApp/vapp-1/snapshotSection"
+	pluginId := "urn:vcloud:uiPlugin:1"
+	role := &Role{Role: &types.Role{ID: "urn:vcloud:role:1"}, client: mockServer.client}
+	globalRole := &GlobalRole{GlobalRole: &types.GlobalRole{Id: "urn:vcloud:globalRole:1"}, client: mockServer.client}
+
+	tests := []struct {
+		name       string
+		operations notSupportedMetadataOperations
+	}{
+		{
+			name: "CatalogSubscription",
+			operations: notSupportedMetadataOperations{
+				get: func() error {
+					_, err := adminCatalog.GetSubscriptionMetadata()
+					return err
+				},
+				getByKey: func() error {
+					_, err := adminCatalog.GetSubscriptionMetadataByKey("key", false)
+					return err
+				},
+				add: func() error {
+					return adminCatalog.AddSubscriptionMetadataEntryWithVisibility("key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+				},
+				merge: func() error {
+					return adminCatalog.MergeSubscriptionMetadataWithMetadataValues(map[string]types.MetadataValue{})
+				},
+				delete: func() error {
+					return adminCatalog.DeleteSubscriptionMetadataEntryWithDomain("key", false)
+				},
+			},
+		},
+		{
+			name: "VmSizing",
+			operations: notSupportedMetadataOperations{
+				get: func() error {
+					_, err := policy.GetVmSizingMetadata()
+					return err
+				},
+				getByKey: func() error {
+					_, err := policy.GetVmSizingMetadataByKey("key", false)
+					return err
+				},
+				add: func() error {
+					return policy.AddVmSizingMetadataEntryWithVisibility("key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+				},
+				merge: func() error {
+					return policy.MergeVmSizingMetadataWithMetadataValues(map[string]types.MetadataValue{})
+				},
+				delete: func() error {
+					return policy.DeleteVmSizingMetadataEntryWithDomain("key", false)
+				},
+			},
+		},
+		{
+			name: "OrgUserRoleAssignment",
+			operations: notSupportedMetadataOperations{
+				get: func() error {
+					_, err := orgUser.GetRoleAssignmentMetadata()
+					return err
+				},
+				getByKey: func() error {
+					_, err := orgUser.GetRoleAssignmentMetadataByKey("key", false)
+					return err
+				},
+				add: func() error {
+					return orgUser.AddRoleAssignmentMetadataEntryWithVisibility("key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+				},
+				merge: func() error {
+					return orgUser.MergeRoleAssignmentMetadataWithMetadataValues(map[string]types.MetadataValue{})
+				},
+				delete: func() error {
+					return orgUser.DeleteRoleAssignmentMetadataEntryWithDomain("key", false)
+				},
+			},
+		},
+		{
+			name: "NsxtNatRule",
+			operations: metadataCompatibleOperations(&NsxtNatRule{
+				NsxtNatRule:   &types.NsxtNatRule{ID: "urn:vcloud:natRule:1"},
+				client:        mockServer.client,
+				edgeGatewayId: "urn:vcloud:gateway:1",
+			}),
+		},
+		{
+			name: "NsxtAlbController",
+			operations: metadataCompatibleOperations(&NsxtAlbController{
+				NsxtAlbController: &types.NsxtAlbController{ID: "urn:vcloud:loadBalancerController:1"},
+				vcdClient:         vcdClient,
+			}),
+		},
+		{
+			name: "NsxtAlbCloud",
+			operations: metadataCompatibleOperations(&NsxtAlbCloud{
+				NsxtAlbCloud: &types.NsxtAlbCloud{ID: "urn:vcloud:loadBalancerCloud:1"},
+				vcdClient:    vcdClient,
+			}),
+		},
+		{name: "VmAffinityRule", operations: metadataCompatibleOperations(affinityRule)},
+		{
+			name: "NetworkPool",
+			operations: notSupportedMetadataOperations{
+				get: func() error {
+					_, err := vcdClient.GetNetworkPoolMetadata(networkPoolHref)
+					return err
+				},
+				getByKey: func() error {
+					_, err := vcdClient.GetNetworkPoolMetadataByKey(networkPoolHref, "key", false)
+					return err
+				},
+				add: func() error {
+					return vcdClient.AddNetworkPoolMetadataEntryWithVisibility(networkPoolHref, "key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+				},
+				merge: func() error {
+					return vcdClient.MergeNetworkPoolMetadataWithMetadataValues(networkPoolHref, map[string]types.MetadataValue{})
+				},
+				delete: func() error {
+					return vcdClient.DeleteNetworkPoolMetadataEntryWithDomain(networkPoolHref, "key", false)
+				},
+			},
+		},
+		{
+			name: "NsxtManager",
+			operations: notSupportedMetadataOperations{
+				get: func() error {
+					_, err := vcdClient.GetNsxtManagerMetadata(nsxtManagerHref)
+					return err
+				},
+				getByKey: func() error {
+					_, err := vcdClient.GetNsxtManagerMetadataByKey(nsxtManagerHref, "key", false)
+					return err
+				},
+				add: func() error {
+					return vcdClient.AddNsxtManagerMetadataEntryWithVisibility(nsxtManagerHref, "key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+				},
+				merge: func() error {
+					return vcdClient.MergeNsxtManagerMetadataWithMetadataValues(nsxtManagerHref, map[string]types.MetadataValue{})
+				},
+				delete: func() error {
+					return vcdClient.DeleteNsxtManagerMetadataEntryWithDomain(nsxtManagerHref, "key", false)
+				},
+			},
+		},
+		{
+			name: "VAppSnapshot",
+			operations: notSupportedMetadataOperations{
+				get: func() error {
+					_, err := vcdClient.GetVAppSnapshotMetadata(snapshotHref)
+					return err
+				},
+				getByKey: func() error {
+					_, err := vcdClient.GetVAppSnapshotMetadataByKey(snapshotHref, "key", false)
+					return err
+				},
+				add: func() error {
+					return vcdClient.AddVAppSnapshotMetadataEntryWithVisibility(snapshotHref, "key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+				},
+				merge: func() error {
+					return vcdClient.MergeVAppSnapshotMetadataWithMetadataValues(snapshotHref, map[string]types.MetadataValue{})
+				},
+				delete: func() error {
+					return vcdClient.DeleteVAppSnapshotMetadataEntryWithDomain(snapshotHref, "key", false)
+				},
+			},
+		},
+		{
+			name: "UIPlugin",
+			operations: notSupportedMetadataOperations{
+				get: func() error {
+					_, err := vcdClient.GetUIPluginMetadata(pluginId)
+					return err
+				},
+				getByKey: func() error {
+					_, err := vcdClient.GetUIPluginMetadataByKey(pluginId, "key", false)
+					return err
+				},
+				add: func() error {
+					return vcdClient.AddUIPluginMetadataEntryWithVisibility(pluginId, "key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+				},
+				merge: func() error {
+					return vcdClient.MergeUIPluginMetadataWithMetadataValues(pluginId, map[string]types.MetadataValue{})
+				},
+				delete: func() error {
+					return vcdClient.DeleteUIPluginMetadataEntryWithDomain(pluginId, "key", false)
+				},
+			},
+		},
+		{
+			name: "Role",
+			operations: notSupportedMetadataOperations{
+				get: func() error {
+					_, err := role.GetRoleMetadata()
+					return err
+				},
+				getByKey: func() error {
+					_, err := role.GetRoleMetadataByKey("key", false)
+					return err
+				},
+				add: func() error {
+					return role.AddRoleMetadataEntryWithVisibility("key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+				},
+				merge: func() error {
+					return role.MergeRoleMetadataWithMetadataValues(map[string]types.MetadataValue{})
+				},
+				delete: func() error {
+					return role.DeleteRoleMetadataEntryWithDomain("key", false)
+				},
+			},
+		},
+		{
+			name: "GlobalRole",
+			operations: notSupportedMetadataOperations{
+				get: func() error {
+					_, err := globalRole.GetGlobalRoleMetadata()
+					return err
+				},
+				getByKey: func() error {
+					_, err := globalRole.GetGlobalRoleMetadataByKey("key", false)
+					return err
+				},
+				add: func() error {
+					return globalRole.AddGlobalRoleMetadataEntryWithVisibility("key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+				},
+				merge: func() error {
+					return globalRole.MergeGlobalRoleMetadataWithMetadataValues(map[string]types.MetadataValue{})
+				},
+				delete: func() error {
+					return globalRole.DeleteGlobalRoleMetadataEntryWithDomain("key", false)
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for operation, run := range map[string]func() error{
+				"get":      tt.operations.get,
+				"getByKey": tt.operations.getByKey,
+				"add":      tt.operations.add,
+				"merge":    tt.operations.merge,
+				"delete":   tt.operations.delete,
+			} {
+				err := run()
+				if _, ok := err.(*MetadataNotSupportedError); !ok {
+					t.Errorf("%s: expected a *MetadataNotSupportedError, got %T: %v", operation, err, err)
+				}
+			}
+		})
+	}
+
+	if requests := mockServer.recordedRequests(); requests != "" {
+		t.Errorf("expected no requests to be sent, got:\n%s", requests)
+	}
+}
+
+// assertMetadataNotSupported fails the test if the given error is not a *MetadataNotSupportedError
+func assertMetadataNotSupported(t *testing.T, err error) {
+	t.Helper()
+	if _, ok := err.(*MetadataNotSupportedError); !ok {
+		t.Errorf("expected a *MetadataNotSupportedError, got %T: %v", err, err)
+	}
+}
+
+// Test_metadataValuesMatch checks that metadata values are compared in their canonical form
+func Test_metadataValuesMatch(t *testing.T) {
+	newValue := func(xsiType, value string, domain *types.MetadataDomainTag) *types.MetadataValue {
+		return &types.MetadataValue{TypedValue: &types.MetadataTypedValue{XsiType: xsiType, Value: value}, Domain: domain}
+	}
+	general := &types.MetadataDomainTag{Domain: "GENERAL", Visibility: types.MetadataReadWriteVisibility}
+	system := &types.MetadataDomainTag{Domain: "SYSTEM", Visibility: types.MetadataReadOnlyVisibility}
+
+	tests := []struct {
+		name     string
+		expected *types.MetadataValue
+		actual   *types.MetadataValue
+		want     bool
+	}{
+		{"same string", newValue(types.MetadataStringValue, "a", general), newValue(types.MetadataStringValue, "a", general), true},
+		{"different string", newValue(types.MetadataStringValue, "a", general), newValue(types.MetadataStringValue, "b", general), false},
+		{"missing domain is general", newValue(types.MetadataStringValue, "a", general), newValue(types.MetadataStringValue, "a", nil), true},
+		{"different domain", newValue(types.MetadataStringValue, "a", general), newValue(types.MetadataStringValue, "a", system), false},
+		{"canonical number", newValue(types.MetadataNumberValue, "01", general), newValue(types.MetadataNumberValue, "1", nil), true},
+		{"canonical boolean", newValue(types.MetadataBooleanValue, "TRUE", system), newValue(types.MetadataBooleanValue, "true", system), true},
+		{"canonical date", newValue(types.MetadataDateTimeValue, "2022-10-05T15:44:00+02:00", general),
+			newValue(types.MetadataDateTimeValue, "2022-10-05T13:44:00.000Z", general), true},
+		{"different type", newValue(types.MetadataStringValue, "1", general), newValue(types.MetadataNumberValue, "1", general), false},
+		{"nil actual", newValue(types.MetadataStringValue, "a", general), nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := metadataValuesMatch(tt.expected, tt.actual); got != tt.want {
+				t.Errorf("metadataValuesMatch() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Test_OpenApiOrgVdcNetworkMetadataRequests checks the requests sent to manage metadata of an Org VDC network that
+// belongs to a regular VDC
+func Test_OpenApiOrgVdcNetworkMetadataRequests(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+
+	network := &OpenApiOrgVdcNetwork{
+		OpenApiOrgVdcNetwork: &types.OpenApiOrgVdcNetwork{
+			ID:       "urn:vcloud:network:1b8a1e2c-4e2a-4c7b-9a8f-0c7d6e5f4a3b",
+			Name:     "vdc-network",
+			OwnerRef: &types.OpenApiReference{ID: "urn:vcloud:vdc:2c9b2f3d-5f3b-4d8c-8b9a-1d8e7f6a5b4c", Name: "vdc"},
+		},
+		client: mockServer.client,
+	}
+
+	err := network.AddMetadataEntryWithVisibility("key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	if err != nil {
+		t.Fatalf("error adding metadata: %s", err)
+	}
+	err = network.DeleteMetadataEntryWithDomain("key", false)
+	if err != nil {
+		t.Fatalf("error deleting metadata: %s", err)
+	}
+
+	actual := mockServer.recordedRequests()
+	expected := goldenString(t, "REQ_metadata", actual, false)
+	if actual != expected {
+		t.Errorf("unexpected requests:\n%s\nexpected:\n%s", actual, expected)
+	}
+}
+
+// Test_OpenApiOrgVdcNetworkInVdcGroupMetadata checks the OpenAPI requests sent to manage metadata of an Org VDC network
+// that belongs to a VDC Group
+func Test_OpenApiOrgVdcNetworkInVdcGroupMetadata(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.setMaxSupportedVersion("38.0")
+	mockServer.openApiResponse = `[
+  {"id": "urn:vcloud:metadata:1", "keyValue": {"domain": "TENANT", "key": "existing", "value": {"value": "old", "type": "StringEntry"}}},
+  {"id": "urn:vcloud:metadata:2", "readOnly": true, "keyValue": {"domain": "PROVIDER", "key": "cost", "value": {"value": 1500000, "type": "NumberEntry"}}}
+]`
+
+	network := &OpenApiOrgVdcNetwork{
+		OpenApiOrgVdcNetwork: &types.OpenApiOrgVdcNetwork{
+			ID:       "urn:vcloud:network:1b8a1e2c-4e2a-4c7b-9a8f-0c7d6e5f4a3b",
+			Name:     "vdc-group-network",
+			OwnerRef: &types.OpenApiReference{ID: "urn:vcloud:vdcGroup:3d0c3a4e-6a4c-4e9d-9c0b-2e9f8a7b6c5d", Name: "vdc-group"},
+		},
+		client: mockServer.client,
+	}
+
+	metadata, err := network.GetMetadata()
+	if err != nil {
+		t.Fatalf("error retrieving metadata: %s", err)
+	}
+	if len(metadata.MetadataEntry) != 2 {
+		t.Fatalf("expected 2 metadata entries, got %d", len(metadata.MetadataEntry))
+	}
+	value, err := network.GetMetadataByKey("cost", true)
+	if err != nil {
+		t.Fatalf("error retrieving metadata by key: %s", err)
+	}
+	if value.TypedValue.XsiType != types.MetadataNumberValue || value.TypedValue.Value != "1500000" ||
+		value.Domain.Domain != "SYSTEM" || value.Domain.Visibility != types.MetadataReadOnlyVisibility {
+		t.Errorf("unexpected converted metadata value: %+v %+v", value.TypedValue, value.Domain)
+	}
+	_, err = network.GetMetadataByKey("cost", false)
+	if !ContainsNotFound(err) {
+		t.Errorf("expected a not found error for key 'cost' in GENERAL domain, got: %v", err)
+	}
+
+	mockServer.requests = nil
+	err = network.AddMetadataEntryWithVisibility("existing", "new", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	if err != nil {
+		t.Fatalf("error adding metadata: %s", err)
+	}
+	err = network.MergeMetadataWithMetadataValues(map[string]types.MetadataValue{
+		"enabled": {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataBooleanValue, Value: "true"}},
+	})
+	if err != nil {
+		t.Fatalf("error merging metadata: %s", err)
+	}
+	err = network.DeleteMetadataEntryWithDomain("cost", true)
+	if err != nil {
+		t.Fatalf("error deleting metadata: %s", err)
+	}
+
+	actual := mockServer.recordedRequests()
+	expected := goldenString(t, "REQ_openapi_metadata", actual, false)
+	if actual != expected {
+		t.Errorf("unexpected requests:\n%s\nexpected:\n%s", actual, expected)
+	}
+}
+
+// Test_OpenApiOrgVdcNetworkInVdcGroupMetadataNotSupported checks that metadata operations on Org VDC networks that
+// belong to a VDC Group return a MetadataNotSupportedError if VCD doesn't support the OpenAPI metadata endpoint
+func Test_OpenApiOrgVdcNetworkInVdcGroupMetadataNotSupported(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.setMaxSupportedVersion("37.2")
+
+	network := &OpenApiOrgVdcNetwork{
+		OpenApiOrgVdcNetwork: &types.OpenApiOrgVdcNetwork{
+			ID:       "urn:vcloud:network:1b8a1e2c-4e2a-4c7b-9a8f-0c7d6e5f4a3b",
+			Name:     "vdc-group-network",
+			OwnerRef: &types.OpenApiReference{ID: "urn:vcloud:vdcGroup:3d0c3a4e-6a4c-4e9d-9c0b-2e9f8a7b6c5d", Name: "vdc-group"},
+		},
+		client: mockServer.client,
+	}
+
+	_, err := network.GetMetadata()
+	assertMetadataNotSupported(t, err)
+	_, err = network.GetMetadataByKey("key", false)
+	assertMetadataNotSupported(t, err)
+	err = network.AddMetadataEntryWithVisibility("key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	assertMetadataNotSupported(t, err)
+	err = network.MergeMetadataWithMetadataValues(map[string]types.MetadataValue{})
+	assertMetadataNotSupported(t, err)
+	err = network.DeleteMetadataEntryWithDomain("key", false)
+	assertMetadataNotSupported(t, err)
+	if err.(*MetadataNotSupportedError).MinimumApiVersion != "38.0" {
+		t.Errorf("expected minimum API version 38.0, got: %s", err)
+	}
+
+	if requests := mockServer.recordedRequests(); requests != "" {
+		t.Errorf("expected no requests to be sent, got:\n%s", requests)
+	}
+}
+
+// metadataMockServer is a minimal VCD mock that records the metadata requests it receives. GET requests are answered
+// with metadataResponse, task polling requests with a task in taskStatus, and any other request with a running task.
+// OpenAPI GET requests are answered with a single page containing openApiResponse, and any other OpenAPI request
+// succeeds synchronously. Requests listed in failingRequests, as "METHOD PATH", fail with failureStatus and
+// failureMessage, being an HTTP 500 error by default. Requests listed in responses, as "METHOD PATH", are answered with
+// the given body instead.
+type metadataMockServer struct {
+	*httptest.Server
+	client           *Client
+	metadataResponse string
+	responses        map[string]string
+	openApiResponse  string
+	taskStatus       string
+	failingRequests  []string
+	failureStatus    int
+	failureMessage   string
+	requests         []string
+	mutex            sync.Mutex
+}
+
+// newMetadataMockServer starts a metadataMockServer and returns it together with a Client that points to it
+func newMetadataMockServer(t *testing.T) *metadataMockServer {
+	mockServer := &metadataMockServer{
+		metadataResponse: `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5"></Metadata>`,
+		openApiResponse:  `[]`,
+		taskStatus:       "success",
+		failureStatus:    http.StatusInternalServerError,
+		failureMessage:   "mock failure",
+	}
+	mockServer.Server = httptest.NewServer(http.HandlerFunc(mockServer.handler))
+
+	vcdHref, err := url.ParseRequestURI(mockServer.URL + "/api")
+	if err != nil {
+		t.Fatalf("error parsing mock server URL: %s", err)
+	}
+	mockServer.client = &Client{
+		APIVersion: "37.0",
+		VCDHREF:    *vcdHref,
+		Http:       http.Client{},
+	}
+	return mockServer
+}
+
+func (mockServer *metadataMockServer) handler(w http.ResponseWriter, r *http.Request) {
+	taskTemplate := `<Task xmlns="http://www.vmware.com/vcloud/v1.5" href="%s/api/task/1" name="task" status="%s"></Task>`
+	if strings.HasPrefix(r.URL.Path, "/api/task/") {
+		_, _ = fmt.Fprintf(w, taskTemplate, mockServer.URL, mockServer.taskStatus)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	mockServer.mutex.Lock()
+	mockServer.requests = append(mockServer.requests, fmt.Sprintf("%s %s\n%s", r.Method, r.URL.Path, body))
+	mockServer.mutex.Unlock()
+
+	for _, failingRequest := range mockServer.failingRequests {
+		if failingRequest == r.Method+" "+r.URL.Path {
+			w.WriteHeader(mockServer.failureStatus)
+			_, _ = fmt.Fprintf(w, `<Error xmlns="http://www.vmware.com/vcloud/v1.5" majorErrorCode="%d" message="%s"></Error>`, mockServer.failureStatus, mockServer.failureMessage)
+			return
+		}
+	}
+
+	if response, ok := mockServer.responses[r.Method+" "+r.URL.Path]; ok {
+		_, _ = w.Write([]byte(response))
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/cloudapi/") {
+		w.Header().Set("Content-Type", types.JSONMime)
+		switch r.Method {
+		case http.MethodGet:
+			_, _ = fmt.Fprintf(w, `{"resultTotal": 1, "pageCount": 1, "page": 1, "pageSize": 128, "values": %s}`, mockServer.openApiResponse)
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write(body)
+		}
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		_, _ = w.Write([]byte(mockServer.metadataResponse))
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	_, _ = fmt.Fprintf(w, taskTemplate, mockServer.URL, "running")
+}
+
+// setMaxSupportedVersion makes the client believe that the maximum API version supported by VCD is the given one
+func (mockServer *metadataMockServer) setMaxSupportedVersion(maxVersion string) {
+	mockServer.client.supportedVersions = SupportedVersions{VersionInfos: VersionInfos{{Version: maxVersion}}}
+}
+
+// recordedRequests returns all the requests received by the mock server, excluding task polling, one per block
+func (mockServer *metadataMockServer) recordedRequests() string {
+	mockServer.mutex.Lock()
+	defer mockServer.mutex.Unlock()
+	return strings.Join(mockServer.requests, "\n")
+}
+
+// Test_GetFullMetadataTree checks that the metadata of a vApp and all its VMs is retrieved, indexed by VM name, and
+// that failures and duplicate VM names are aggregated per child
+func Test_GetFullMetadataTree(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+
+	vapp := NewVApp(mockServer.client)
+	vapp.VApp = &types.VApp{
+		Name: "vapp",
+		HREF: mockServer.URL + "/api/vApp/vapp-1",
+		Children: &types.VAppChildren{VM: []*types.Vm{
+			{Name: "web", HREF: mockServer.URL + "/api/vApp/vm-1"},
+			{Name: "db", HREF: mockServer.URL + "/api/vApp/vm-2"},
+			{Name: "db", HREF: mockServer.URL + "/api/vApp/vm-3"},
+			{Name: "broken", HREF: "not-a-valid-href"},
+		}},
+	}
+
+	tree, err := vapp.GetFullMetadataTree(2)
+	multiError, ok := err.(*MetadataMultiError)
+	if !ok {
+		t.Fatalf("expected a *MetadataMultiError, got %T: %v", err, err)
+	}
+	if len(multiError.Errors) != 2 || multiError.Errors["broken"] == nil || multiError.Errors["db"] == nil {
+		t.Errorf("expected errors for VMs 'broken' and 'db', got: %s", multiError)
+	}
+	if !strings.Contains(multiError.Errors["db"].Error(), "found 2 VMs with the same name") {
+		t.Errorf("expected a duplicate name error for VM 'db', got: %s", multiError.Errors["db"])
+	}
+	if tree.VApp == nil {
+		t.Errorf("expected vApp metadata to be retrieved")
+	}
+	if len(tree.VMs) != 1 || tree.VMs["web"] == nil {
+		t.Errorf("expected metadata only for VM 'web', got: %v", tree.VMs)
+	}
+	if requests := mockServer.recordedRequests(); strings.Contains(requests, "vm-2") || strings.Contains(requests, "vm-3") {
+		t.Errorf("expected no requests for the VMs with duplicate names, got:\n%s", requests)
+	}
+}
+
+// Test_AggregateMetadataKeys checks that the metadata keys of the VMs, vApps and networks of a VDC are counted once per
+// entity, that all the pages of the queries are retrieved and that failures don't stop the aggregation
+func Test_AggregateMetadataKeys(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	recordsTemplate := `<QueryResultRecords xmlns="http://www.vmware.com/vcloud/v1.5" total="%d" pageSize="2" page="%s">%s</QueryResultRecords>`
+	entryTemplate := `<MetadataEntry><Domain visibility="%s">%s</Domain><Key>%s</Key><TypedValue xsi:type="MetadataStringValue"><Value>v</Value></TypedValue></MetadataEntry>`
+	metadataByPath := map[string][]string{
+		"/api/vApp/vm-1/metadata/":     {fmt.Sprintf(entryTemplate, "READWRITE", "GENERAL", "owner"), fmt.Sprintf(entryTemplate, "READWRITE", "GENERAL", "env")},
+		"/api/vApp/vm-2/metadata/":     {fmt.Sprintf(entryTemplate, "READWRITE", "GENERAL", "owner")},
+		"/api/vApp/vm-3/metadata/":     nil,
+		"/api/network/net-1/metadata/": {fmt.Sprintf(entryTemplate, "READWRITE", "GENERAL", "owner"), fmt.Sprintf(entryTemplate, "READONLY", "SYSTEM", "owner")},
+	}
+	mockServer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mockServer.mutex.Lock()
+		mockServer.requests = append(mockServer.requests, r.Method+" "+r.URL.Path+"?"+r.URL.Query().Get("type")+r.URL.Query().Get("page"))
+		mockServer.mutex.Unlock()
+		if r.URL.Path == "/api/query" {
+			page := r.URL.Query().Get("page")
+			records := ""
+			total := 1
+			switch r.URL.Query().Get("type") {
+			case types.QtVm:
+				total = 3
+				if page == "2" {
+					records = fmt.Sprintf(`<VMRecord href="%s/api/vApp/vm-3"/>`, mockServer.URL)
+				} else {
+					page = "1"
+					records = fmt.Sprintf(`<VMRecord href="%s/api/vApp/vm-1"/><VMRecord href="%s/api/vApp/vm-2"/>`, mockServer.URL, mockServer.URL)
+				}
+			case types.QtVapp:
+				records = fmt.Sprintf(`<VAppRecord href="%s/api/vApp/vapp-1"/>`, mockServer.URL)
+			case types.QtOrgVdcNetwork:
+				records = fmt.Sprintf(`<OrgVdcNetworkRecord href="%s/api/network/net-1"/>`, mockServer.URL)
+			}
+			if page == "" {
+				page = "1"
+			}
+			_, _ = fmt.Fprintf(w, recordsTemplate, total, page, records)
+			return
+		}
+		entries, found := metadataByPath[r.URL.Path]
+		if !found {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = fmt.Fprint(w, `<Error xmlns="http://www.vmware.com/vcloud/v1.5" majorErrorCode="500" message="mock failure"></Error>`)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">%s</Metadata>`,
+			strings.Join(entries, ""))
+	})
+
+	vdc := NewVdc(mockServer.client)
+	vdc.Vdc = &types.Vdc{Name: "vdc", ID: "urn:vcloud:vdc:1", HREF: mockServer.URL + "/api/vdc/1"}
+
+	keyUsage, err := vdc.AggregateMetadataKeys()
+	multiError, ok := err.(*MetadataMultiError)
+	if !ok {
+		t.Fatalf("expected a *MetadataMultiError, got %T: %v", err, err)
+	}
+	if len(multiError.Errors) != 1 || multiError.Errors[mockServer.URL+"/api/vApp/vapp-1"] == nil {
+		t.Errorf("expected a single error for the vApp, got: %s", multiError)
+	}
+	expected := map[string]int{"owner": 3, "env": 1}
+	if !reflect.DeepEqual(keyUsage, expected) {
+		t.Errorf("expected key usage %v, got %v", expected, keyUsage)
+	}
+	if requests := mockServer.recordedRequests(); !strings.Contains(requests, "GET /api/query?vm2") {
+		t.Errorf("expected the second page of VMs to be retrieved, got:\n%s", requests)
+	}
+}
+
+// Test_UpdateMetadataValues checks that the values computed by the updater are merged or deleted
+func Test_UpdateMetadataValues(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.metadataResponse = `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <MetadataEntry><Key>counter</Key><TypedValue xsi:type="MetadataNumberValue"><Value>41</Value></TypedValue></MetadataEntry>
+  <MetadataEntry><Key>obsolete</Key><TypedValue xsi:type="MetadataStringValue"><Value>old</Value></TypedValue></MetadataEntry>
+</Metadata>`
+
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+
+	var seen []string
+	err := vm.UpdateMetadataValues([]string{"counter", "obsolete", "missing"}, func(key string, current *types.MetadataValue) (string, string, bool) {
+		seen = append(seen, fmt.Sprintf("%s=%v", key, current != nil))
+		switch key {
+		case "counter":
+			return "42", types.MetadataNumberValue, true
+		default:
+			return "", "", false
+		}
+	}, false)
+	if err != nil {
+		t.Fatalf("error updating metadata: %s", err)
+	}
+
+	if strings.Join(seen, ",") != "counter=true,obsolete=true,missing=false" {
+		t.Errorf("unexpected updater calls: %v", seen)
+	}
+	requests := mockServer.recordedRequests()
+	if !strings.Contains(requests, "POST /api/vApp/vm-1/metadata\n") || !strings.Contains(requests, "<Value>42</Value>") {
+		t.Errorf("expected a merge with the new counter value, got:\n%s", requests)
+	}
+	if !strings.Contains(requests, "DELETE /api/vApp/vm-1/metadata/obsolete") || strings.Contains(requests, "missing") {
+		t.Errorf("expected only 'obsolete' to be deleted, got:\n%s", requests)
+	}
+}
+
+// Test_SummarizeMetadataKey checks that metadata values are counted per value, with missing keys in the absent
+// bucket and read errors aggregated separately
+func Test_SummarizeMetadataKey(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.metadataResponse = `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <MetadataEntry><Key>environment</Key><TypedValue xsi:type="MetadataStringValue"><Value>production</Value></TypedValue></MetadataEntry>
+</Metadata>`
+
+	var entities []MetadataCompatible
+	for _, href := range []string{mockServer.URL + "/api/vApp/vm-1", mockServer.URL + "/api/vApp/vm-2", "not-a-valid-href"} {
+		vm := NewVM(mockServer.client)
+		vm.VM = &types.Vm{HREF: href}
+		entities = append(entities, vm)
+	}
+
+	histogram, err := SummarizeMetadataKey(entities, "environment", false, 2)
+	multiError, ok := err.(*MetadataMultiError)
+	if !ok {
+		t.Fatalf("expected a *MetadataMultiError, got %T: %v", err, err)
+	}
+	if len(multiError.Errors) != 1 || multiError.Errors["not-a-valid-href"] == nil {
+		t.Errorf("expected a single error for the invalid HREF, got: %s", multiError)
+	}
+	if len(histogram) != 1 || histogram["production"] != 2 {
+		t.Errorf("expected 2 entities with value 'production', got: %v", histogram)
+	}
+
+	histogram, err = SummarizeMetadataKey(entities[:2], "environment", true, 1)
+	if err != nil {
+		t.Fatalf("error summarizing metadata: %s", err)
+	}
+	if len(histogram) != 1 || histogram[MetadataAbsentBucket] != 2 {
+		t.Errorf("expected 2 entities without the key in SYSTEM domain, got: %v", histogram)
+	}
+}
+
+// Test_ApplyMetadataDefaults checks that only the defaults missing in their own domain are merged
+func Test_ApplyMetadataDefaults(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.metadataResponse = `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <MetadataEntry><Key>owner</Key><TypedValue xsi:type="MetadataStringValue"><Value>alice</Value></TypedValue></MetadataEntry>
+  <MetadataEntry><Domain visibility="READONLY">SYSTEM</Domain><Key>tier</Key><TypedValue xsi:type="MetadataStringValue"><Value>gold</Value></TypedValue></MetadataEntry>
+</Metadata>`
+
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+
+	applied, err := vm.ApplyMetadataDefaults(map[string]types.MetadataValue{
+		"owner": {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "nobody"}},
+		"tier":  {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "bronze"}},
+		"cost": {
+			Domain:     &types.MetadataDomainTag{Domain: "SYSTEM", Visibility: types.MetadataHiddenVisibility},
+			TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataNumberValue, Value: "10"},
+		},
+	}, false)
+	if err != nil {
+		t.Fatalf("error applying metadata defaults: %s", err)
+	}
+	if strings.Join(applied, ",") != "cost,tier" {
+		t.Errorf("expected defaults 'cost' and 'tier' to be applied, got: %v", applied)
+	}
+	requests := mockServer.recordedRequests()
+	if strings.Contains(requests, "nobody") || !strings.Contains(requests, "bronze") || !strings.Contains(requests, `visibility="PRIVATE">SYSTEM`) {
+		t.Errorf("unexpected merge request:\n%s", requests)
+	}
+
+	mockServer.requests = nil
+	applied, err = vm.ApplyMetadataDefaults(map[string]types.MetadataValue{
+		"owner": {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "nobody"}},
+	}, false)
+	if err != nil {
+		t.Fatalf("error applying metadata defaults: %s", err)
+	}
+	if len(applied) != 0 || mockServer.recordedRequests() != "GET /api/vApp/vm-1/metadata/\n" {
+		t.Errorf("expected no defaults to be applied, got %v and requests:\n%s", applied, mockServer.recordedRequests())
+	}
+}
+
+// Test_MetadataCompatibleTenantEntities checks that the metadata of Vdc, Catalog and Org is modified through their
+// admin endpoints
+func Test_MetadataCompatibleTenantEntities(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+
+	vdc := NewVdc(mockServer.client)
+	vdc.Vdc = &types.Vdc{HREF: mockServer.URL + "/api/vdc/1"}
+	catalog := NewCatalog(mockServer.client)
+	catalog.Catalog = &types.Catalog{HREF: mockServer.URL + "/api/catalog/1"}
+	org := NewOrg(mockServer.client)
+	org.Org = &types.Org{HREF: mockServer.URL + "/api/org/1"}
+
+	for _, entity := range []MetadataCompatible{vdc, catalog, org} {
+		err := entity.AddMetadataEntryWithVisibility("key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+		if err != nil {
+			t.Fatalf("error adding metadata to %T: %s", entity, err)
+		}
+		err = entity.MergeMetadataWithMetadataValues(map[string]types.MetadataValue{})
+		if err != nil {
+			t.Fatalf("error merging metadata into %T: %s", entity, err)
+		}
+		err = entity.DeleteMetadataEntryWithDomain("key", false)
+		if err != nil {
+			t.Fatalf("error deleting metadata from %T: %s", entity, err)
+		}
+	}
+
+	for _, path := range []string{"/api/admin/vdc/1/metadata", "/api/admin/catalog/1/metadata", "/api/admin/org/1/metadata"} {
+		for _, method := range []string{"PUT " + path + "/key\n", "POST " + path + "\n", "DELETE " + path + "/key\n"} {
+			if !strings.Contains(mockServer.recordedRequests(), method) {
+				t.Errorf("expected request '%s', got:\n%s", strings.TrimSpace(method), mockServer.recordedRequests())
+			}
+		}
+	}
+}
+
+// Test_CopySystemMetadata checks that only SYSTEM entries are copied, keeping their visibility, and that failures
+// are reported per key
+func Test_CopySystemMetadata(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.metadataResponse = `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <MetadataEntry><Key>general</Key><TypedValue xsi:type="MetadataStringValue"><Value>value</Value></TypedValue></MetadataEntry>
+  <MetadataEntry><Domain visibility="READONLY">SYSTEM</Domain><Key>readonly</Key><TypedValue xsi:type="MetadataStringValue"><Value>value</Value></TypedValue></MetadataEntry>
+  <MetadataEntry><Domain visibility="PRIVATE">SYSTEM</Domain><Key>hidden</Key><TypedValue xsi:type="MetadataNumberValue"><Value>1</Value></TypedValue></MetadataEntry>
+</Metadata>`
+
+	src := NewVM(mockServer.client)
+	src.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+	dst := NewVM(mockServer.client)
+	dst.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-2"}
+
+	err := CopySystemMetadata(src, dst)
+	if err != nil {
+		t.Fatalf("error copying SYSTEM metadata: %s", err)
+	}
+	requests := mockServer.recordedRequests()
+	if strings.Contains(requests, "/api/vApp/vm-2/metadata/general") ||
+		!strings.Contains(requests, "PUT /api/vApp/vm-2/metadata/SYSTEM/readonly") ||
+		!strings.Contains(requests, "PUT /api/vApp/vm-2/metadata/SYSTEM/hidden") ||
+		!strings.Contains(requests, `visibility="PRIVATE"`) {
+		t.Errorf("expected only SYSTEM entries to be copied, got:\n%s", requests)
+	}
+
+	dst.VM.HREF = "http://127.0.0.1:0/api/vApp/vm-2"
+	err = CopySystemMetadata(src, dst)
+	multiError, ok := err.(*MetadataMultiError)
+	if !ok {
+		t.Fatalf("expected a *MetadataMultiError, got %T: %v", err, err)
+	}
+	if len(multiError.Errors) != 2 || multiError.Errors["readonly"] == nil || multiError.Errors["hidden"] == nil {
+		t.Errorf("expected errors for 'readonly' and 'hidden', got: %s", multiError)
+	}
+}
+
+// Test_ReplaceMetadataEntry checks that an entry is moved to another domain, validating the target visibility and
+// rolling back the target domain when the deletion fails
+func Test_ReplaceMetadataEntry(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.metadataResponse = `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <MetadataEntry><Key>tier</Key><TypedValue xsi:type="MetadataStringValue"><Value>gold</Value></TypedValue></MetadataEntry>
+</Metadata>`
+
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+
+	err := vm.ReplaceMetadataEntry("tier", "platinum", types.MetadataStringValue, types.MetadataReadWriteVisibility, true, false)
+	if err == nil || mockServer.recordedRequests() != "" {
+		t.Fatalf("expected a visibility error without requests, got: %v\n%s", err, mockServer.recordedRequests())
+	}
+
+	err = vm.ReplaceMetadataEntry("tier", "platinum", types.MetadataStringValue, types.MetadataReadOnlyVisibility, true, false)
+	if err != nil {
+		t.Fatalf("error replacing metadata: %s", err)
+	}
+	requests := mockServer.recordedRequests()
+	putIndex := strings.Index(requests, "PUT /api/vApp/vm-1/metadata/SYSTEM/tier")
+	deleteIndex := strings.Index(requests, "DELETE /api/vApp/vm-1/metadata/tier")
+	if putIndex < 0 || deleteIndex < putIndex {
+		t.Errorf("expected the SYSTEM entry to be created before deleting the GENERAL one, got:\n%s", requests)
+	}
+
+	mockServer.requests = nil
+	mockServer.failingRequests = []string{"DELETE /api/vApp/vm-1/metadata/tier"}
+	err = vm.ReplaceMetadataEntry("tier", "platinum", types.MetadataStringValue, types.MetadataReadOnlyVisibility, true, false)
+	if err == nil || !strings.Contains(err.Error(), "rolled back") {
+		t.Errorf("expected a rolled back error, got: %v", err)
+	}
+	if !strings.Contains(mockServer.recordedRequests(), "DELETE /api/vApp/vm-1/metadata/SYSTEM/tier") {
+		t.Errorf("expected the SYSTEM entry to be rolled back, got:\n%s", mockServer.recordedRequests())
+	}
+
+	// A previous target entry without TypedValue can't be restored, so the rollback deletes it
+	mockServer.metadataResponse = `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <MetadataEntry><Key>tier</Key><TypedValue xsi:type="MetadataStringValue"><Value>gold</Value></TypedValue></MetadataEntry>
+  <MetadataEntry><Domain visibility="READONLY">SYSTEM</Domain><Key>tier</Key></MetadataEntry>
+</Metadata>`
+	mockServer.requests = nil
+	err = vm.ReplaceMetadataEntry("tier", "platinum", types.MetadataStringValue, types.MetadataReadOnlyVisibility, true, false)
+	if err == nil || !strings.Contains(err.Error(), "rolled back") {
+		t.Errorf("expected a rolled back error, got: %v", err)
+	}
+	if !strings.Contains(mockServer.recordedRequests(), "DELETE /api/vApp/vm-1/metadata/SYSTEM/tier") {
+		t.Errorf("expected the SYSTEM entry without value to be rolled back, got:\n%s", mockServer.recordedRequests())
+	}
+}
+
+// Test_MetadataWithContext checks that the context-aware metadata functions stop waiting for a running task as soon as
+// the context is done, and that a cancelled context prevents any request
+func Test_MetadataWithContext(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.taskStatus = "running"
+
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	startTime := time.Now()
+	err := vm.AddMetadataEntryWithVisibilityCtx(ctx, "key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected %s, got: %v", context.DeadlineExceeded, err)
+	}
+	if elapsed := time.Since(startTime); elapsed > 2*time.Second {
+		t.Errorf("expected the wait to stop when the context expired, but it took %s", elapsed)
+	}
+	if !strings.Contains(mockServer.recordedRequests(), "PUT /api/vApp/vm-1/metadata/key") {
+		t.Errorf("expected the metadata entry to be sent, got:\n%s", mockServer.recordedRequests())
+	}
+
+	mockServer.requests = nil
+	cancelledCtx, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	err = vm.DeleteMetadataEntryWithDomainCtx(cancelledCtx, "key", false)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %s, got: %v", context.Canceled, err)
+	}
+	_, err = vm.GetMetadataCtx(cancelledCtx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %s, got: %v", context.Canceled, err)
+	}
+	if mockServer.recordedRequests() != "" {
+		t.Errorf("expected no requests with a cancelled context, got:\n%s", mockServer.recordedRequests())
+	}
+}
+
+// Test_MetadataDateTime checks that metadata date times are formatted in UTC with millisecond precision, whatever the
+// time zone and precision of the given time, and that the values returned by VCD are parsed back
+func Test_MetadataDateTime(t *testing.T) {
+	kathmandu := time.FixedZone("NPT", 5*3600+45*60)
+	newYork := time.FixedZone("EST", -5*3600)
+	formatTests := []struct {
+		name     string
+		value    time.Time
+		expected string
+	}{
+		{"UTC", time.Date(2023, 3, 14, 15, 9, 26, 0, time.UTC), "2023-03-14T15:09:26.000Z"},
+		{"PositiveOffset", time.Date(2023, 3, 14, 15, 9, 26, 0, kathmandu), "2023-03-14T09:24:26.000Z"},
+		{"NegativeOffsetCrossingDay", time.Date(2023, 12, 31, 22, 0, 0, 0, newYork), "2024-01-01T03:00:00.000Z"},
+		{"Milliseconds", time.Date(2023, 3, 14, 15, 9, 26, 123000000, time.UTC), "2023-03-14T15:09:26.123Z"},
+		{"Nanoseconds", time.Date(2023, 3, 14, 15, 9, 26, 123999999, time.UTC), "2023-03-14T15:09:26.123Z"},
+		{"MonotonicClock", time.Now(), ""},
+	}
+	for _, tt := range formatTests {
+		t.Run(tt.name, func(t *testing.T) {
+			formatted := FormatMetadataDateTime(tt.value)
+			if tt.expected != "" && formatted != tt.expected {
+				t.Errorf("expected '%s', got '%s'", tt.expected, formatted)
+			}
+			parsed, err := ParseMetadataDateTime(formatted)
+			if err != nil {
+				t.Fatalf("error parsing '%s': %s", formatted, err)
+			}
+			if !parsed.Equal(tt.value.Truncate(time.Millisecond)) {
+				t.Errorf("expected '%s' to be parsed as %s, got %s", formatted, tt.value.Truncate(time.Millisecond), parsed)
+			}
+		})
+	}
+
+	parseTests := []struct {
+		value    string
+		expected time.Time
+	}{
+		{"2023-03-14T15:09:26Z", time.Date(2023, 3, 14, 15, 9, 26, 0, time.UTC)},
+		{"2023-03-14T15:09:26.5Z", time.Date(2023, 3, 14, 15, 9, 26, 500000000, time.UTC)},
+		{"2023-03-14T17:09:26.000+02:00", time.Date(2023, 3, 14, 15, 9, 26, 0, time.UTC)},
+		{"2023-03-14T17:09:26.000+0200", time.Date(2023, 3, 14, 15, 9, 26, 0, time.UTC)},
+		{"2023-03-14T15:09:26.123456789Z", time.Date(2023, 3, 14, 15, 9, 26, 123456789, time.UTC)},
+	}
+	for _, tt := range parseTests {
+		parsed, err := ParseMetadataDateTime(tt.value)
+		if err != nil {
+			t.Errorf("error parsing '%s': %s", tt.value, err)
+			continue
+		}
+		if !parsed.Equal(tt.expected) {
+			t.Errorf("expected '%s' to be parsed as %s, got %s", tt.value, tt.expected, parsed)
+		}
+	}
+
+	for _, invalid := range []string{"", "2023-03-14", "2023-03-14 15:09:26Z", "14/03/2023"} {
+		_, err := ParseMetadataDateTime(invalid)
+		if err == nil {
+			t.Errorf("expected an error parsing '%s'", invalid)
+		}
+	}
+}
+
+// Test_MetadataValueBuilder checks that the builder fills every metadata value and rejects visibilities that are not
+// allowed in their domain
+func Test_MetadataValueBuilder(t *testing.T) {
+	dateTime := time.Date(2023, 3, 14, 15, 9, 26, 0, time.UTC)
+	metadata, err := NewMetadataValueBuilder().
+		AddString("owner", "team-a", types.MetadataReadWriteVisibility, false).
+		AddNumber("cost-center", 1234, types.MetadataReadOnlyVisibility, true).
+		AddBool("critical", true, types.MetadataHiddenVisibility, true).
+		AddDateTime("expires", dateTime, types.MetadataReadWriteVisibility, false).
+		Build()
+	if err != nil {
+		t.Fatalf("error building metadata: %s", err)
+	}
+
+	expected := map[string][4]string{
+		"owner":       {types.MetadataStringValue, "team-a", "GENERAL", types.MetadataReadWriteVisibility},
+		"cost-center": {types.MetadataNumberValue, "1234", "SYSTEM", types.MetadataReadOnlyVisibility},
+		"critical":    {types.MetadataBooleanValue, "true", "SYSTEM", types.MetadataHiddenVisibility},
+		"expires":     {types.MetadataDateTimeValue, "2023-03-14T15:09:26.000Z", "GENERAL", types.MetadataReadWriteVisibility},
+	}
+	if len(metadata) != len(expected) {
+		t.Fatalf("expected %d metadata values, got %d", len(expected), len(metadata))
+	}
+	for key, want := range expected {
+		value := metadata[key]
+		if value.Xmlns != types.XMLNamespaceVCloud || value.Xsi != types.XMLNamespaceXSI || value.TypedValue == nil || value.Domain == nil {
+			t.Errorf("metadata value '%s' is not complete: %#v", key, value)
+			continue
+		}
+		got := [4]string{value.TypedValue.XsiType, value.TypedValue.Value, value.Domain.Domain, value.Domain.Visibility}
+		if got != want {
+			t.Errorf("metadata value '%s': expected %v, got %v", key, want, got)
+		}
+	}
+
+	_, err = NewMetadataValueBuilder().
+		AddString("owner", "team-a", types.MetadataReadWriteVisibility, false).
+		AddString("secret", "s3cr3t", types.MetadataReadWriteVisibility, true).
+		AddString("tier", "gold", types.MetadataHiddenVisibility, false).
+		Build()
+	multiError, ok := err.(*MetadataMultiError)
+	if !ok {
+		t.Fatalf("expected a *MetadataMultiError, got: %v", err)
+	}
+	if len(multiError.Errors) != 2 || multiError.Errors["secret"] == nil || multiError.Errors["tier"] == nil {
+		t.Errorf("expected errors for 'secret' and 'tier', got: %s", err)
+	}
+
+	_, err = NewMetadataValueBuilder().
+		AddString("secret", "s3cr3t", types.MetadataReadWriteVisibility, true).
+		AddString("secret", "s3cr3t", types.MetadataReadOnlyVisibility, true).
+		Build()
+	if err != nil {
+		t.Errorf("expected the last value of a key to replace its error, got: %s", err)
+	}
+}
+
+// Test_NewMetadataValue checks that the metadata value constructors return complete values, matching the ones of
+// MetadataValueBuilder, and reject visibilities that are not allowed in their domain
+func Test_NewMetadataValue(t *testing.T) {
+	dateTime := time.Date(2023, 3, 14, 15, 9, 26, 0, time.UTC)
+	built, err := NewMetadataValueBuilder().
+		AddString("owner", "team-a", types.MetadataReadWriteVisibility, false).
+		AddNumber("cost-center", 1234, types.MetadataReadOnlyVisibility, true).
+		AddBool("critical", true, types.MetadataHiddenVisibility, true).
+		AddDateTime("expires", dateTime, types.MetadataReadWriteVisibility, false).
+		Build()
+	if err != nil {
+		t.Fatalf("error building metadata: %s", err)
+	}
+
+	constructed := map[string]func() (types.MetadataValue, error){
+		"owner": func() (types.MetadataValue, error) {
+			return NewStringMetadataValue("team-a", types.MetadataReadWriteVisibility, false)
+		},
+		"cost-center": func() (types.MetadataValue, error) {
+			return NewNumberMetadataValue(1234, types.MetadataReadOnlyVisibility, true)
+		},
+		"critical": func() (types.MetadataValue, error) {
+			return NewBoolMetadataValue(true, types.MetadataHiddenVisibility, true)
+		},
+		"expires": func() (types.MetadataValue, error) {
+			return NewDateTimeMetadataValue(dateTime, types.MetadataReadWriteVisibility, false)
+		},
+	}
+	for key, constructor := range constructed {
+		value, err := constructor()
+		if err != nil {
+			t.Errorf("error creating metadata value '%s': %s", key, err)
+			continue
+		}
+		if !reflect.DeepEqual(value, built[key]) {
+			t.Errorf("metadata value '%s': expected %#v, got %#v", key, built[key], value)
+		}
+	}
+
+	_, err = NewStringMetadataValue("s3cr3t", types.MetadataReadWriteVisibility, true)
+	if err == nil {
+		t.Errorf("expected an error with a READWRITE visibility in SYSTEM domain")
+	}
+	_, err = NewNumberMetadataValue(1, types.MetadataHiddenVisibility, false)
+	if err == nil {
+		t.Errorf("expected an error with a PRIVATE visibility in GENERAL domain")
+	}
+}
+
+// Test_StructToMetadata checks that StructToMetadata and MetadataToStruct convert the fields with a vcdmeta tag
+// back and forth, and that they reject unsupported fields and malformed tags
+func Test_StructToMetadata(t *testing.T) {
+	type environment string
+	type labels struct {
+		Owner       string      `vcdmeta:"owner"`
+		Environment environment `vcdmeta:"env,string,READWRITE"`
+		Replicas    int64       `vcdmeta:"replicas,number"`
+		Critical    bool        `vcdmeta:"critical,MetadataBooleanValue,PRIVATE,system"`
+		CreatedAt   time.Time   `vcdmeta:"createdAt,,,system"`
+		Ignored     string      `vcdmeta:"-"`
+		Untagged    string
+	}
+	createdAt := time.Date(2023, 3, 14, 15, 9, 26, 0, time.UTC)
+	original := labels{
+		Owner:       "team-a",
+		Environment: "production",
+		Replicas:    3,
+		Critical:    true,
+		CreatedAt:   createdAt,
+		Ignored:     "ignored",
+		Untagged:    "untagged",
+	}
+
+	metadata, err := StructToMetadata(&original)
+	if err != nil {
+		t.Fatalf("error converting struct to metadata: %s", err)
+	}
+	expected, err := NewMetadataValueBuilder().
+		AddString("owner", "team-a", types.MetadataReadWriteVisibility, false).
+		AddString("env", "production", types.MetadataReadWriteVisibility, false).
+		AddNumber("replicas", 3, types.MetadataReadWriteVisibility, false).
+		AddBool("critical", true, types.MetadataHiddenVisibility, true).
+		AddDateTime("createdAt", createdAt, types.MetadataReadOnlyVisibility, true).
+		Build()
+	if err != nil {
+		t.Fatalf("error building metadata: %s", err)
+	}
+	if !reflect.DeepEqual(metadata, expected) {
+		t.Errorf("expected metadata %#v, got %#v", expected, metadata)
+	}
+
+	fetched := &types.Metadata{}
+	for key, value := range metadata {
+		fetched.MetadataEntry = append(fetched.MetadataEntry, &types.MetadataEntry{
+			Key:        key,
+			Domain:     value.Domain,
+			TypedValue: value.TypedValue,
+		})
+	}
+	restored := labels{Untagged: "kept"}
+	err = MetadataToStruct(fetched, &restored)
+	if err != nil {
+		t.Fatalf("error converting metadata to struct: %s", err)
+	}
+	original.Ignored, original.Untagged = "", "kept"
+	if !reflect.DeepEqual(restored, original) {
+		t.Errorf("expected struct %#v, got %#v", original, restored)
+	}
+
+	mismatched := &types.Metadata{MetadataEntry: []*types.MetadataEntry{
+		{Key: "replicas", TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "three"}},
+	}}
+	if err = MetadataToStruct(mismatched, &restored); err == nil {
+		t.Errorf("expected an error with a metadata type that doesn't match the field type")
+	}
+	if err = MetadataToStruct(fetched, restored); err == nil {
+		t.Errorf("expected an error converting metadata to a struct that is not a pointer")
+	}
+
+	invalidStructs := map[string]interface{}{
+		"unsupported type": struct {
+			Count int `vcdmeta:"count"`
+		}{},
+		"missing key": struct {
+			Owner string `vcdmeta:",string"`
+		}{},
+		"mismatched type": struct {
+			Owner string `vcdmeta:"owner,number"`
+		}{},
+		"unknown type": struct {
+			Owner string `vcdmeta:"owner,text"`
+		}{},
+		"unknown visibility": struct {
+			Owner string `vcdmeta:"owner,,PUBLIC"`
+		}{},
+		"unknown domain": struct {
+			Owner string `vcdmeta:"owner,,,general"`
+		}{},
+		"too many parts": struct {
+			Owner string `vcdmeta:"owner,,,system,extra"`
+		}{},
+		"readwrite in system domain": struct {
+			Owner string `vcdmeta:"owner,,READWRITE,system"`
+		}{},
+		"duplicated key": struct {
+			Owner  string `vcdmeta:"owner"`
+			Owner2 string `vcdmeta:"owner"`
+		}{},
+		"unexported field": struct {
+			owner string `vcdmeta:"owner"`
+		}{},
+		"not a struct": "owner",
+	}
+	for name, invalid := range invalidStructs {
+		if _, err = StructToMetadata(invalid); err == nil {
+			t.Errorf("%s: expected an error converting struct to metadata", name)
+		}
+	}
+}
+
+// Test_CatalogItemFileRecordMetadata checks that the metadata of the file records of a Catalog Item is retrieved from
+// the referenced vApp Template or Media, and that other entities are not supported
+func Test_CatalogItemFileRecordMetadata(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+
+	catalogItem := NewCatalogItem(mockServer.client)
+	catalogItem.CatalogItem.HREF = mockServer.URL + "/api/catalogItem/item-1"
+	for _, entity := range []types.Entity{
+		{HREF: mockServer.URL + "/api/vAppTemplate/vappTemplate-1", Type: types.MimeVAppTemplate},
+		{HREF: mockServer.URL + "/api/media/media-1", Type: types.MimeMediaItem},
+	} {
+		mockServer.requests = nil
+		catalogItem.CatalogItem.Entity = &types.Entity{HREF: entity.HREF, Type: entity.Type}
+		mockServer.metadataResponse = `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5"></Metadata>`
+		_, err := catalogItem.GetFileRecordMetadata()
+		if err != nil {
+			t.Fatalf("error retrieving file record metadata: %s", err)
+		}
+		mockServer.metadataResponse = `<MetadataValue xmlns="http://www.vmware.com/vcloud/v1.5"></MetadataValue>`
+		_, err = catalogItem.GetFileRecordMetadataByKey("key", true)
+		if err != nil {
+			t.Fatalf("error retrieving file record metadata by key: %s", err)
+		}
+		path := strings.TrimPrefix(entity.HREF, mockServer.URL)
+		expected := fmt.Sprintf("GET %s/metadata/\n\nGET %s/metadata/SYSTEM/key\n", path, path)
+		if mockServer.recordedRequests() != expected {
+			t.Errorf("expected requests:\n%s\ngot:\n%s", expected, mockServer.recordedRequests())
+		}
+	}
+
+	mockServer.requests = nil
+	catalogItem.CatalogItem.Entity = &types.Entity{HREF: mockServer.URL + "/api/vApp/vapp-1", Type: types.MimeVApp}
+	_, err := catalogItem.GetFileRecordMetadata()
+	assertMetadataNotSupported(t, err)
+	_, err = catalogItem.GetFileRecordMetadataByKey("key", false)
+	assertMetadataNotSupported(t, err)
+	if mockServer.recordedRequests() != "" {
+		t.Errorf("expected no requests for unsupported entities, got:\n%s", mockServer.recordedRequests())
+	}
+}
+
+// Test_GetTypedMetadataByKey checks that metadata values are returned with the Go type that corresponds to their
+// XsiType, and that malformed values are reported
+func Test_GetTypedMetadataByKey(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+	valueTemplate := `<MetadataValue xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"><TypedValue xsi:type="%s"><Value>%s</Value></TypedValue></MetadataValue>`
+
+	tests := []struct {
+		xsiType  string
+		value    string
+		expected interface{}
+	}{
+		{types.MetadataStringValue, "gold", "gold"},
+		{types.MetadataNumberValue, "42", int64(42)},
+		{types.MetadataBooleanValue, "true", true},
+		{types.MetadataDateTimeValue, "2023-03-14T15:09:26Z", time.Date(2023, 3, 14, 15, 9, 26, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		mockServer.metadataResponse = fmt.Sprintf(valueTemplate, test.xsiType, test.value)
+		value, err := vm.GetTypedMetadataByKey("key", false)
+		if err != nil {
+			t.Errorf("error retrieving typed metadata of type %s: %s", test.xsiType, err)
+			continue
+		}
+		if value != test.expected {
+			t.Errorf("expected %#v for type %s, got %#v", test.expected, test.xsiType, value)
+		}
+	}
+
+	mockServer.metadataResponse = fmt.Sprintf(valueTemplate, types.MetadataNumberValue, "forty-two")
+	_, err := vm.GetTypedMetadataByKey("key", false)
+	if err == nil || !strings.Contains(err.Error(), "'key'") {
+		t.Errorf("expected an error mentioning the malformed key, got: %v", err)
+	}
+}
+
+// Test_RenderMetadataTemplate checks that templates are rendered with the metadata of the requested domain, and that
+// missing keys are rendered empty or fail depending on the options
+func Test_RenderMetadataTemplate(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.metadataResponse = `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <MetadataEntry><Key>owner</Key><TypedValue xsi:type="MetadataStringValue"><Value>team-a</Value></TypedValue></MetadataEntry>
+  <MetadataEntry><Key>cost-center</Key><TypedValue xsi:type="MetadataNumberValue"><Value>1234</Value></TypedValue></MetadataEntry>
+  <MetadataEntry><Domain visibility="READONLY">SYSTEM</Domain><Key>owner</Key><TypedValue xsi:type="MetadataStringValue"><Value>provider</Value></TypedValue></MetadataEntry>
+</Metadata>`
+
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+
+	rendered, err := vm.RenderMetadataTemplate(`{{.owner}} ({{index . "cost-center"}}){{.missing}}`, false)
+	if err != nil {
+		t.Fatalf("error rendering metadata template: %s", err)
+	}
+	if rendered != "team-a (1234)" {
+		t.Errorf("expected 'team-a (1234)', got '%s'", rendered)
+	}
+
+	rendered, err = vm.RenderMetadataTemplate(`{{.owner}}`, true)
+	if err != nil {
+		t.Fatalf("error rendering SYSTEM metadata template: %s", err)
+	}
+	if rendered != "provider" {
+		t.Errorf("expected 'provider', got '%s'", rendered)
+	}
+
+	_, err = vm.RenderMetadataTemplate(`{{.owner}}{{.missing}}`, false, WithMetadataTemplateMissingKeyError())
+	if err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("expected an error about the missing key, got: %v", err)
+	}
+
+	mockServer.requests = nil
+	_, err = vm.RenderMetadataTemplate(`{{.owner`, false)
+	if err == nil || mockServer.recordedRequests() != "" {
+		t.Errorf("expected a parsing error without requests, got: %v\n%s", err, mockServer.recordedRequests())
+	}
+}
+
+// Test_DeleteMetadataEntriesWithDomain checks that all the given keys are deleted, and that failures don't stop the
+// rest of deletions and are reported by key
+func Test_DeleteMetadataEntriesWithDomain(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.failingRequests = []string{"DELETE /api/vApp/vm-1/metadata/SYSTEM/key-3"}
+
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+
+	keys := []string{"key-1", "key-2", "key-3", "key-4", "key-5", "key-6", "key-7"}
+	err := vm.DeleteMetadataEntriesWithDomain(keys, true)
+	multiError, ok := err.(*MetadataMultiError)
+	if !ok {
+		t.Fatalf("expected a *MetadataMultiError, got: %v", err)
+	}
+	if len(multiError.Errors) != 1 || multiError.Errors["key-3"] == nil {
+		t.Errorf("expected a single error for 'key-3', got: %s", err)
+	}
+
+	requests := mockServer.recordedRequests()
+	for _, key := range keys {
+		if !strings.Contains(requests, "DELETE /api/vApp/vm-1/metadata/SYSTEM/"+key+"\n") {
+			t.Errorf("expected key '%s' to be deleted, got:\n%s", key, requests)
+		}
+	}
+
+	mockServer.requests = nil
+	err = vm.DeleteMetadataEntriesWithDomain([]string{"key-1"}, false)
+	if err != nil {
+		t.Errorf("error deleting metadata entries: %s", err)
+	}
+	err = vm.DeleteMetadataEntriesWithDomain(nil, false)
+	if err != nil {
+		t.Errorf("error deleting no metadata entries: %s", err)
+	}
+	if mockServer.recordedRequests() != "DELETE /api/vApp/vm-1/metadata/key-1\n" {
+		t.Errorf("expected a single GENERAL deletion, got:\n%s", mockServer.recordedRequests())
+	}
+}
+
+// Test_DiffMetadata checks that only the entries that differ are merged and only the undesired keys are deleted
+func Test_DiffMetadata(t *testing.T) {
+	current := &types.Metadata{MetadataEntry: []*types.MetadataEntry{
+		{Key: "same", TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataNumberValue, Value: "01"}},
+		{Key: "changed-value", TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "old"}},
+		{Key: "changed-type", TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "true"}},
+		{Key: "changed-visibility", Domain: &types.MetadataDomainTag{Domain: "SYSTEM", Visibility: types.MetadataReadOnlyVisibility},
+			TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "value"}},
+		{Key: "changed-domain", TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "value"}},
+		{Key: "undesired", TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "value"}},
+		{Key: "another-undesired", Domain: &types.MetadataDomainTag{Domain: "SYSTEM", Visibility: types.MetadataHiddenVisibility},
+			TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "value"}},
+	}}
+	system := func(visibility string) *types.MetadataDomainTag {
+		return &types.MetadataDomainTag{Domain: "SYSTEM", Visibility: visibility}
+	}
+	desired := map[string]types.MetadataValue{
+		"same":               {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataNumberValue, Value: "1"}},
+		"changed-value":      {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "new"}},
+		"changed-type":       {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataBooleanValue, Value: "true"}},
+		"changed-visibility": {Domain: system(types.MetadataHiddenVisibility), TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "value"}},
+		"changed-domain":     {Domain: system(types.MetadataReadOnlyVisibility), TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "value"}},
+		"new":                {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "value"}},
+	}
+
+	toMerge, toDelete := DiffMetadata(current, desired)
+	expectedMerge := []string{"changed-domain", "changed-type", "changed-value", "changed-visibility", "new"}
+	if len(toMerge) != len(expectedMerge) {
+		t.Errorf("expected %d entries to merge, got %d: %v", len(expectedMerge), len(toMerge), toMerge)
+	}
+	for _, key := range expectedMerge {
+		if _, ok := toMerge[key]; !ok {
+			t.Errorf("expected '%s' to be merged", key)
+		}
+	}
+	if strings.Join(toDelete, ",") != "another-undesired,undesired" {
+		t.Errorf("expected 'another-undesired,undesired' to be deleted, got: %v", toDelete)
+	}
+
+	toMerge, toDelete = DiffMetadata(nil, nil)
+	if len(toMerge) != 0 || len(toDelete) != 0 {
+		t.Errorf("expected no changes for empty metadata, got: %v, %v", toMerge, toDelete)
+	}
+}
+
+// Test_ReplaceAllMetadata checks that only the entries of the replaced domain are merged and deleted, and that the
+// deletions are performed even if the merge fails
+func Test_ReplaceAllMetadata(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.metadataResponse = `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <MetadataEntry><Key>keep</Key><TypedValue xsi:type="MetadataStringValue"><Value>value</Value></TypedValue></MetadataEntry>
+  <MetadataEntry><Key>stale</Key><TypedValue xsi:type="MetadataStringValue"><Value>value</Value></TypedValue></MetadataEntry>
+  <MetadataEntry><Domain visibility="READONLY">SYSTEM</Domain><Key>system-stale</Key><TypedValue xsi:type="MetadataStringValue"><Value>value</Value></TypedValue></MetadataEntry>
+</Metadata>`
+
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+
+	err := vm.ReplaceAllMetadata(map[string]types.MetadataValue{
+		"keep": {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "value"}},
+		"new": {Domain: &types.MetadataDomainTag{Domain: "SYSTEM", Visibility: types.MetadataReadOnlyVisibility},
+			TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "value"}},
+	}, false)
+	if err == nil || mockServer.recordedRequests() != "" {
+		t.Fatalf("expected an error for a SYSTEM entry without requests, got: %v\n%s", err, mockServer.recordedRequests())
+	}
+
+	err = vm.ReplaceAllMetadata(map[string]types.MetadataValue{
+		"keep": {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "value"}},
+		"new":  {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "value"}},
+	}, false)
+	if err != nil {
+		t.Fatalf("error replacing metadata: %s", err)
+	}
+	requests := mockServer.recordedRequests()
+	if !strings.Contains(requests, "POST /api/vApp/vm-1/metadata\n") || !strings.Contains(requests, "<Key>new</Key>") ||
+		strings.Contains(requests, "<Key>keep</Key>") {
+		t.Errorf("expected only the new entry to be merged, got:\n%s", requests)
+	}
+	if !strings.Contains(requests, "DELETE /api/vApp/vm-1/metadata/stale\n") || strings.Contains(requests, "system-stale") {
+		t.Errorf("expected only the stale GENERAL entry to be deleted, got:\n%s", requests)
+	}
+
+	mockServer.requests = nil
+	mockServer.failingRequests = []string{"POST /api/vApp/vm-1/metadata"}
+	err = vm.ReplaceAllMetadata(map[string]types.MetadataValue{
+		"new": {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "value"}},
+	}, false)
+	multiError, ok := err.(*MetadataMultiError)
+	if !ok || multiError.Errors["new"] == nil {
+		t.Fatalf("expected a *MetadataMultiError for key 'new', got: %v", err)
+	}
+	if !strings.Contains(mockServer.recordedRequests(), "DELETE /api/vApp/vm-1/metadata/keep\n") {
+		t.Errorf("expected deletions after a failed merge, got:\n%s", mockServer.recordedRequests())
+	}
+}
+
+// Test_MetadataRetry checks that metadata requests are retried only for 5xx errors and only when retries are enabled,
+// and that the final error contains the number of attempts and the text of the original error
+func Test_MetadataRetry(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.failingRequests = []string{"PUT /api/vApp/vm-1/metadata/key", "DELETE /api/vApp/vm-1/metadata/key"}
+
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+
+	countRequests := func(request string) int {
+		return strings.Count(mockServer.recordedRequests(), request+"\n")
+	}
+
+	err := vm.AddMetadataEntryWithVisibility("key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	if err == nil || strings.Contains(err.Error(), "attempts") || countRequests("PUT /api/vApp/vm-1/metadata/key") != 1 {
+		t.Errorf("expected a single failed request without retries, got: %v\n%s", err, mockServer.recordedRequests())
+	}
+
+	mockServer.requests = nil
+	mockServer.client.MetadataRetryCount = 2
+	mockServer.client.MetadataRetryBackoff = time.Millisecond
+	mockServer.failureStatus = http.StatusServiceUnavailable
+	err = vm.DeleteMetadataEntryWithDomain("key", false)
+	if err == nil || !strings.Contains(err.Error(), "after 3 attempts") || !strings.HasSuffix(err.Error(), "mock failure") {
+		t.Errorf("expected the original error after 3 attempts, got: %v", err)
+	}
+	if countRequests("DELETE /api/vApp/vm-1/metadata/key") != 3 {
+		t.Errorf("expected 3 requests, got:\n%s", mockServer.recordedRequests())
+	}
+
+	// The visibility error returned by VCD must still be recognised after the retries
+	mockServer.requests = nil
+	mockServer.failureMessage = "[ 7c2ed3c6-4d3b-4cc9-a7a8-0a8b4b2d58a1 ] visibility"
+	err = vm.AddMetadataEntryWithVisibility("key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	if err == nil || !strings.Contains(err.Error(), "visibility cannot be READWRITE") {
+		t.Errorf("expected a visibility error after the retries, got: %v", err)
+	}
+	if countRequests("PUT /api/vApp/vm-1/metadata/key") != 3 {
+		t.Errorf("expected 3 requests, got:\n%s", mockServer.recordedRequests())
+	}
+
+	mockServer.requests = nil
+	mockServer.failureMessage = "mock failure"
+	mockServer.failureStatus = http.StatusBadRequest
+	err = vm.AddMetadataEntryWithVisibility("key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	if err == nil || strings.Contains(err.Error(), "attempts") || countRequests("PUT /api/vApp/vm-1/metadata/key") != 1 {
+		t.Errorf("expected a single failed request for a 4xx error, got: %v\n%s", err, mockServer.recordedRequests())
+	}
+
+	// A 500 error followed by a success makes the request succeed on the second attempt
+	failures := 1
+	mockServer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mockServer.mutex.Lock()
+		mockServer.failingRequests = nil
+		if failures > 0 && r.Method == http.MethodPut {
+			mockServer.failingRequests = []string{r.Method + " " + r.URL.Path}
+			failures--
+		}
+		mockServer.mutex.Unlock()
+		mockServer.handler(w, r)
+	})
+	mockServer.requests = nil
+	mockServer.failureStatus = http.StatusInternalServerError
+	err = vm.AddMetadataEntryWithVisibility("key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	if err != nil {
+		t.Errorf("expected the request to succeed after a 500 error, got: %s", err)
+	}
+	if countRequests("PUT /api/vApp/vm-1/metadata/key") != 2 {
+		t.Errorf("expected 2 requests, got:\n%s", mockServer.recordedRequests())
+	}
+}
+
+// Test_GetMetadataAsMap checks that only the metadata of the requested domain is returned, even if a key exists in
+// both domains
+func Test_GetMetadataAsMap(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.metadataResponse = `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <MetadataEntry><Key>owner</Key><TypedValue xsi:type="MetadataStringValue"><Value>team-a</Value></TypedValue></MetadataEntry>
+  <MetadataEntry><Key>cost-center</Key><TypedValue xsi:type="MetadataNumberValue"><Value>1234</Value></TypedValue></MetadataEntry>
+  <MetadataEntry><Domain visibility="READONLY">SYSTEM</Domain><Key>owner</Key><TypedValue xsi:type="MetadataStringValue"><Value>provider</Value></TypedValue></MetadataEntry>
+</Metadata>`
+
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+
+	for isSystem, expected := range map[bool]map[string]string{
+		false: {"owner": "team-a", "cost-center": "1234"},
+		true:  {"owner": "provider"},
+	} {
+		values, err := vm.GetMetadataAsMap(isSystem)
+		if err != nil {
+			t.Fatalf("error retrieving metadata as map: %s", err)
+		}
+		if fmt.Sprint(values) != fmt.Sprint(expected) {
+			t.Errorf("expected %v for isSystem=%t, got %v", expected, isSystem, values)
+		}
+	}
+}
+
+// Test_ValidateMetadataEntry checks that invalid metadata entries are rejected before sending any request
+func Test_ValidateMetadataEntry(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+
+	tests := []struct {
+		key, value, typedValue, visibility string
+		expected                           error
+	}{
+		{"", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, ErrInvalidMetadataKey},
+		{strings.Repeat("k", 257), "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, ErrInvalidMetadataKey},
+		{"key", strings.Repeat("v", 65536), types.MetadataStringValue, types.MetadataReadWriteVisibility, ErrInvalidMetadataValue},
+		{"key", "value", "MetadataIntegerValue", types.MetadataReadWriteVisibility, ErrInvalidMetadataValue},
+		{"key", "value", types.MetadataStringValue, "PUBLIC", ErrInvalidMetadataValue},
+	}
+	for _, test := range tests {
+		err := vm.AddMetadataEntryWithVisibility(test.key, test.value, test.typedValue, test.visibility, false)
+		if !errors.Is(err, test.expected) {
+			t.Errorf("expected %s for key of length %d, got: %v", test.expected, len(test.key), err)
+		}
+		err = vm.MergeMetadataWithMetadataValues(map[string]types.MetadataValue{
+			test.key: {
+				Domain:     &types.MetadataDomainTag{Domain: "GENERAL", Visibility: test.visibility},
+				TypedValue: &types.MetadataTypedValue{XsiType: test.typedValue, Value: test.value},
+			},
+		})
+		if !errors.Is(err, test.expected) {
+			t.Errorf("expected %s when merging key of length %d, got: %v", test.expected, len(test.key), err)
+		}
+	}
+	if mockServer.recordedRequests() != "" {
+		t.Errorf("expected no requests for invalid metadata, got:\n%s", mockServer.recordedRequests())
+	}
+
+	err := validateMetadataEntry(strings.Repeat("k", 256), strings.Repeat("v", 65535), types.MetadataStringValue, "")
+	if err != nil {
+		t.Errorf("expected metadata at the length limits to be valid, got: %s", err)
+	}
+}
+
+// Test_MetadataById checks that entity URNs are resolved to the HREFs used to manage their metadata
+func Test_MetadataById(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	vcdClient := &VCDClient{Client: *mockServer.client}
+
+	uuid := "8f2e6b9c-3a1d-4e5f-9b7a-0c1d2e3f4a5b"
+	tests := map[string][2]string{
+		"vm":          {"/api/vApp/vm-" + uuid, "/api/vApp/vm-" + uuid},
+		"vapp":        {"/api/vApp/vapp-" + uuid, "/api/vApp/vapp-" + uuid},
+		"vdc":         {"/api/vdc/" + uuid, "/api/admin/vdc/" + uuid},
+		"catalog":     {"/api/catalog/" + uuid, "/api/admin/catalog/" + uuid},
+		"org":         {"/api/org/" + uuid, "/api/admin/org/" + uuid},
+		"disk":        {"/api/disk/" + uuid, "/api/disk/" + uuid},
+		"media":       {"/api/media/" + uuid, "/api/media/" + uuid},
+		"network":     {"/api/network/" + uuid, "/api/admin/network/" + uuid},
+		"catalogitem": {"/api/catalogItem/" + uuid, "/api/catalogItem/" + uuid},
+	}
+	for namespace, paths := range tests {
+		mockServer.requests = nil
+		id := "urn:vcloud:" + namespace + ":" + uuid
+		_, err := vcdClient.GetMetadataById(id)
+		if err != nil {
+			t.Fatalf("error retrieving metadata of %s: %s", id, err)
+		}
+		err = vcdClient.AddMetadataEntryWithVisibilityById(id, "key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+		if err != nil {
+			t.Fatalf("error adding metadata to %s: %s", id, err)
+		}
+		requests := mockServer.recordedRequests()
+		if !strings.HasPrefix(requests, "GET "+paths[0]+"/metadata/\n") || !strings.Contains(requests, "PUT "+paths[1]+"/metadata/key\n") {
+			t.Errorf("unexpected requests for %s:\n%s", id, requests)
+		}
+	}
+
+	mockServer.requests = nil
+	_, err := vcdClient.GetMetadataById("urn:vcloud:nsxtmanager:" + uuid)
+	assertMetadataNotSupported(t, err)
+	_, err = vcdClient.GetMetadataById("vm-" + uuid)
+	if err == nil {
+		t.Errorf("expected an error for an invalid URN")
+	}
+	if mockServer.recordedRequests() != "" {
+		t.Errorf("expected no requests for unsupported URNs, got:\n%s", mockServer.recordedRequests())
+	}
+}
+
+// Test_NsxtEdgeGatewayMetadata checks that the metadata of NSX-T Edge Gateways is managed with the OpenAPI metadata
+// endpoint, which is not supported before VCD 10.5
+func Test_NsxtEdgeGatewayMetadata(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.setMaxSupportedVersion("38.0")
+	mockServer.openApiResponse = `[
+  {"id": "urn:vcloud:metadata:1", "keyValue": {"domain": "TENANT", "key": "existing", "value": {"value": "old", "type": "StringEntry"}}}
+]`
+
+	egw := &NsxtEdgeGateway{
+		EdgeGateway: &types.OpenAPIEdgeGateway{ID: "urn:vcloud:gateway:5e1b2c3d-4f5a-4b6c-8d7e-9f0a1b2c3d4e", Name: "edge-gateway"},
+		client:      mockServer.client,
+	}
+	egw.initOpenApiMetadata()
+	endpoint := "/cloudapi/1.0.0/edgeGateways/urn:vcloud:gateway:5e1b2c3d-4f5a-4b6c-8d7e-9f0a1b2c3d4e/metadata/"
+
+	value, err := egw.GetMetadataByKey("existing", false)
+	if err != nil {
+		t.Fatalf("error retrieving metadata by key: %s", err)
+	}
+	if value.TypedValue.Value != "old" {
+		t.Errorf("expected value 'old', got: %s", value.TypedValue.Value)
+	}
+	err = egw.AddMetadataEntryWithVisibility("existing", "new", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	if err != nil {
+		t.Fatalf("error adding metadata: %s", err)
+	}
+	err = egw.DeleteMetadataEntryWithDomain("existing", false)
+	if err != nil {
+		t.Fatalf("error deleting metadata: %s", err)
+	}
+	requests := mockServer.recordedRequests()
+	for _, expected := range []string{"GET " + endpoint, "PUT " + endpoint + "urn:vcloud:metadata:1", "DELETE " + endpoint + "urn:vcloud:metadata:1"} {
+		if !strings.Contains(requests, expected+"\n") {
+			t.Errorf("expected request '%s', got:\n%s", expected, requests)
+		}
+	}
+
+	mockServer.requests = nil
+	mockServer.setMaxSupportedVersion("37.2")
+	_, err = egw.GetMetadata()
+	assertMetadataNotSupported(t, err)
+	err = egw.MergeMetadataWithMetadataValues(map[string]types.MetadataValue{})
+	assertMetadataNotSupported(t, err)
+	if requests := mockServer.recordedRequests(); requests != "" {
+		t.Errorf("expected no requests to be sent, got:\n%s", requests)
+	}
+}
+
+// Test_VdcGroupMetadata checks that the metadata of VDC Groups is managed with the OpenAPI metadata endpoint, converting
+// the entries from and to the XML API types
+func Test_VdcGroupMetadata(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.setMaxSupportedVersion("38.0")
+	mockServer.openApiResponse = `[
+  {"id": "urn:vcloud:metadata:1", "keyValue": {"domain": "TENANT", "key": "owner", "value": {"value": "team-a", "type": "StringEntry"}}},
+  {"id": "urn:vcloud:metadata:2", "readOnly": true, "keyValue": {"domain": "PROVIDER", "key": "tier", "value": {"value": "gold", "type": "StringEntry"}}}
+]`
+
+	vdcGroup := &VdcGroup{
+		VdcGroup: &types.VdcGroup{Id: "urn:vcloud:vdcGroup:3d0c3a4e-6a4c-4e9d-9c0b-2e9f8a7b6c5d", Name: "vdc-group"},
+		client:   mockServer.client,
+	}
+	vdcGroup.initOpenApiMetadata()
+	endpoint := "/cloudapi/1.0.0/vdcGroups/urn:vcloud:vdcGroup:3d0c3a4e-6a4c-4e9d-9c0b-2e9f8a7b6c5d/metadata/"
+
+	values, err := vdcGroup.GetMetadataAsMap(true)
+	if err != nil {
+		t.Fatalf("error retrieving metadata: %s", err)
+	}
+	if fmt.Sprint(values) != "map[tier:gold]" {
+		t.Errorf("expected only the SYSTEM entry, got: %v", values)
+	}
+
+	mockServer.requests = nil
+	err = vdcGroup.MergeMetadataWithMetadataValues(map[string]types.MetadataValue{
+		"owner": {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "team-b"}},
+		"count": {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataNumberValue, Value: "3"}},
+	})
+	if err != nil {
+		t.Fatalf("error merging metadata: %s", err)
+	}
+	err = vdcGroup.DeleteMetadataEntryWithDomain("tier", true)
+	if err != nil {
+		t.Fatalf("error deleting metadata: %s", err)
+	}
+	requests := mockServer.recordedRequests()
+	for _, expected := range []string{
+		"POST " + endpoint + "\n",
+		`"value": 3,`,
+		"PUT " + endpoint + "urn:vcloud:metadata:1\n",
+		"DELETE " + endpoint + "urn:vcloud:metadata:2\n",
+	} {
+		if !strings.Contains(requests, expected) {
+			t.Errorf("expected request '%s', got:\n%s", expected, requests)
+		}
+	}
+
+	mockServer.requests = nil
+	mockServer.setMaxSupportedVersion("37.2")
+	err = vdcGroup.AddMetadataEntryWithVisibility("key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	assertMetadataNotSupported(t, err)
+	if requests := mockServer.recordedRequests(); requests != "" {
+		t.Errorf("expected no requests to be sent, got:\n%s", requests)
+	}
+}
+
+// Test_GetMetadataByKeyNotFound checks that a missing metadata key is reported as ErrorEntityNotFound, while
+// authorization failures are not
+func Test_GetMetadataByKeyNotFound(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	vm := NewVM(mockServer.client)
+	vm.VM.HREF = mockServer.URL + "/api/vApp/vm-1"
+	mockServer.failingRequests = []string{"GET /api/vApp/vm-1/metadata/missing"}
+
+	tests := []struct {
+		name           string
+		status         int
+		message        string
+		expectNotFound bool
+	}{
+		{name: "NotFound", status: http.StatusNotFound, message: "mock failure", expectNotFound: true},
+		{name: "ForbiddenMissingEntry", status: http.StatusForbidden, message: "The metadata entry missing does not exist", expectNotFound: true},
+		{name: "ForbiddenNoRights", status: http.StatusForbidden, message: "Either you need some or all of the following rights", expectNotFound: false},
+		{name: "ServerError", status: http.StatusInternalServerError, message: "mock failure", expectNotFound: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockServer.failureStatus = tt.status
+			mockServer.failureMessage = tt.message
+
+			_, err := vm.GetMetadataByKey("missing", false)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if errors.Is(err, ErrorEntityNotFound) != tt.expectNotFound {
+				t.Errorf("expected errors.Is(err, ErrorEntityNotFound) to be %t, got error: %s", tt.expectNotFound, err)
+			}
+			if ContainsNotFound(err) != tt.expectNotFound {
+				t.Errorf("expected ContainsNotFound(err) to be %t, got error: %s", tt.expectNotFound, err)
+			}
+			if !tt.expectNotFound {
+				return
+			}
+			var vcdError *types.Error
+			if !errors.As(err, &vcdError) {
+				t.Fatalf("expected the original *types.Error to be wrapped, got: %s", err)
+			}
+			if vcdError.MajorErrorCode != tt.status {
+				t.Errorf("expected major error code %d, got %d", tt.status, vcdError.MajorErrorCode)
+			}
+		})
+	}
+}
+
+// Test_GetMetadataByKeyIfPresent checks that a missing key is reported as not present without an error, while any
+// other failure is returned as an error
+func Test_GetMetadataByKeyIfPresent(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	vm := NewVM(mockServer.client)
+	vm.VM.HREF = mockServer.URL + "/api/vApp/vm-1"
+	mockServer.metadataResponse = `<MetadataValue xmlns="http://www.vmware.com/vcloud/v1.5"><TypedValue xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="MetadataStringValue"><Value>web</Value></TypedValue></MetadataValue>`
+
+	value, present, err := vm.GetMetadataByKeyIfPresent("tier", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !present || value == nil || value.TypedValue == nil || value.TypedValue.Value != "web" {
+		t.Errorf("expected present value 'web', got present=%t value=%v", present, value)
+	}
+
+	mockServer.failingRequests = []string{"GET /api/vApp/vm-1/metadata/missing"}
+	mockServer.failureStatus = http.StatusNotFound
+	value, present, err = vm.GetMetadataByKeyIfPresent("missing", false)
+	if err != nil || present || value != nil {
+		t.Errorf("expected (nil, false, nil) for a missing key, got (%v, %t, %v)", value, present, err)
+	}
+
+	mockServer.failureStatus = http.StatusForbidden
+	_, present, err = vm.GetMetadataByKeyIfPresent("missing", false)
+	if err == nil || present {
+		t.Errorf("expected an error for an authorization failure, got present=%t err=%v", present, err)
+	}
+
+	requests := mockServer.recordedRequests()
+	if strings.Count(requests, "GET /api/vApp/vm-1/metadata/") != 3 || strings.Contains(requests, "GET /api/vApp/vm-1/metadata\n") {
+		t.Errorf("expected a single GET by key per call, got:\n%s", requests)
+	}
+}
+
+// Test_AddMetadataEntries checks that several metadata entries are added with a single merge request, that invalid
+// entries are rejected before sending it, and that a failed task is reported with all the keys
+func Test_AddMetadataEntries(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	vm := NewVM(mockServer.client)
+	vm.VM.HREF = mockServer.URL + "/api/vApp/vm-1"
+
+	entries := []types.MetadataEntry{
+		{Key: "tier", TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "web"}},
+		{Key: "replicas", TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataNumberValue, Value: "3"},
+			Domain: &types.MetadataDomainTag{Domain: "GENERAL", Visibility: types.MetadataReadOnlyVisibility}},
+		{Key: "tier", TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "db"},
+			Domain: &types.MetadataDomainTag{Domain: "SYSTEM", Visibility: types.MetadataHiddenVisibility}},
+	}
+	err := vm.AddMetadataEntries(entries)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	requests := mockServer.recordedRequests()
+	if strings.Count(requests, "POST /api/vApp/vm-1/metadata\n") != 1 || strings.Contains(requests, "PUT ") {
+		t.Fatalf("expected a single merge request, got:\n%s", requests)
+	}
+	// GENERAL entries are always stored with READWRITE visibility
+	expectedDomains := map[string]int{
+		`<Domain visibility="READWRITE">GENERAL</Domain>`: 2,
+		`<Domain visibility="PRIVATE">SYSTEM</Domain>`:    1,
+		`<Key>tier</Key>`:       2,
+		`visibility="READONLY"`: 0,
+	}
+	for expected, count := range expectedDomains {
+		if strings.Count(requests, expected) != count {
+			t.Errorf("expected request body to contain %s %d times, got:\n%s", expected, count, requests)
+		}
+	}
+
+	mockServer.requests = nil
+	err = vm.AddMetadataEntries([]types.MetadataEntry{
+		{Key: "", TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "web"}},
+		{Key: "tier", TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "web"}},
+		{Key: "tier", TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "db"}},
+		{Key: "empty"},
+	})
+	var multiError *MetadataMultiError
+	if !errors.As(err, &multiError) || len(multiError.Errors) != 3 {
+		t.Fatalf("expected 3 validation errors, got: %v", err)
+	}
+	if !errors.Is(multiError.Errors["GENERAL/"], ErrInvalidMetadataKey) {
+		t.Errorf("expected ErrInvalidMetadataKey for the empty key, got: %s", multiError.Errors["GENERAL/"])
+	}
+	if requests := mockServer.recordedRequests(); requests != "" {
+		t.Errorf("expected no requests to be sent, got:\n%s", requests)
+	}
+
+	mockServer.taskStatus = "error"
+	err = vm.AddMetadataEntries(entries[:2])
+	if err == nil || !strings.Contains(err.Error(), "[GENERAL/replicas, GENERAL/tier]") {
+		t.Errorf("expected task error with all the keys, got: %v", err)
+	}
+}
+
+// Test_GetMetadataStreamed checks that setting Client.MetadataStreamThresholdBytes doesn't change the decoded metadata,
+// either for responses above the threshold or of unknown size
+func Test_GetMetadataStreamed(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	vm := NewVM(mockServer.client)
+	vm.VM.HREF = mockServer.URL + "/api/vApp/vm-1"
+
+	var entries strings.Builder
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&entries, `<MetadataEntry><Key>key-%d</Key><TypedValue xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="MetadataStringValue"><Value>value-%d</Value></TypedValue></MetadataEntry>`, i, i)
+	}
+	mockServer.metadataResponse = `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5">` + entries.String() + `</Metadata>`
+
+	buffered, err := vm.GetMetadata()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, threshold := range []int64{1, int64(len(mockServer.metadataResponse))} {
+		mockServer.client.MetadataStreamThresholdBytes = threshold
+		metadata, err := vm.GetMetadata()
+		if err != nil {
+			t.Fatalf("[threshold %d] unexpected error: %s", threshold, err)
+		}
+		if !reflect.DeepEqual(buffered, metadata) {
+			t.Errorf("[threshold %d] expected the same metadata as the buffered response", threshold)
+		}
+		if len(metadata.MetadataEntry) != 200 || metadata.MetadataEntry[199].TypedValue.Value != "value-199" {
+			t.Errorf("[threshold %d] expected 200 metadata entries, got %d", threshold, len(metadata.MetadataEntry))
+		}
+	}
+
+	mockServer.failingRequests = []string{"GET /api/vApp/vm-1/metadata/"}
+	_, err = vm.GetMetadata()
+	if err == nil || !strings.Contains(err.Error(), "error retrieving metadata") {
+		t.Errorf("expected an error retrieving metadata, got: %v", err)
+	}
+}
+
+// Test_AddMetadataEntryWithVisibilityIfChanged checks that the entry is only added when its value, type or visibility
+// differ from the stored ones, or when it doesn't exist
+func Test_AddMetadataEntryWithVisibilityIfChanged(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	vm := NewVM(mockServer.client)
+	vm.VM.HREF = mockServer.URL + "/api/vApp/vm-1"
+	mockServer.metadataResponse = `<MetadataValue xmlns="http://www.vmware.com/vcloud/v1.5"><Domain visibility="READONLY">SYSTEM</Domain><TypedValue xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="MetadataNumberValue"><Value>3</Value></TypedValue></MetadataValue>`
+
+	tests := []struct {
+		name          string
+		value         string
+		typedValue    string
+		visibility    string
+		expectChanged bool
+	}{
+		{name: "Unchanged", value: "3", typedValue: types.MetadataNumberValue, visibility: types.MetadataReadOnlyVisibility, expectChanged: false},
+		{name: "UnchangedCanonical", value: "03", typedValue: types.MetadataNumberValue, visibility: types.MetadataReadOnlyVisibility, expectChanged: false},
+		{name: "ValueChanged", value: "4", typedValue: types.MetadataNumberValue, visibility: types.MetadataReadOnlyVisibility, expectChanged: true},
+		{name: "TypeChanged", value: "3", typedValue: types.MetadataStringValue, visibility: types.MetadataReadOnlyVisibility, expectChanged: true},
+		{name: "VisibilityChanged", value: "3", typedValue: types.MetadataNumberValue, visibility: types.MetadataHiddenVisibility, expectChanged: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockServer.requests = nil
+			changed, err := vm.AddMetadataEntryWithVisibilityIfChanged("replicas", tt.value, tt.typedValue, tt.visibility, true)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if changed != tt.expectChanged {
+				t.Errorf("expected changed to be %t, got %t", tt.expectChanged, changed)
+			}
+			sentPut := strings.Contains(mockServer.recordedRequests(), "PUT /api/vApp/vm-1/metadata/SYSTEM/replicas")
+			if sentPut != tt.expectChanged {
+				t.Errorf("expected PUT to be sent: %t, got requests:\n%s", tt.expectChanged, mockServer.recordedRequests())
+			}
+		})
+	}
+
+	mockServer.requests = nil
+	mockServer.failingRequests = []string{"GET /api/vApp/vm-1/metadata/missing"}
+	mockServer.failureStatus = http.StatusNotFound
+	changed, err := vm.AddMetadataEntryWithVisibilityIfChanged("missing", "web", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	if err != nil || !changed {
+		t.Errorf("expected a missing entry to be added, got changed=%t err=%v", changed, err)
+	}
+	if !strings.Contains(mockServer.recordedRequests(), "PUT /api/vApp/vm-1/metadata/missing") {
+		t.Errorf("expected a PUT for the missing entry, got:\n%s", mockServer.recordedRequests())
+	}
+}
+
+// Test_AddSystemReadWriteMetadata checks that SYSTEM metadata with READWRITE visibility is only sent for Provider VDCs
+// when the client is a system administrator
+func Test_AddSystemReadWriteMetadata(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	providerVdc := &ProviderVdc{
+		ProviderVdc: &types.ProviderVdc{HREF: mockServer.URL + "/api/admin/providervdc/pvdc-1"},
+		client:      mockServer.client,
+	}
+	vm := NewVM(mockServer.client)
+	vm.VM.HREF = mockServer.URL + "/api/vApp/vm-1"
+
+	err := providerVdc.AddMetadataEntryWithVisibility("cost-center", "cc-1", types.MetadataStringValue, types.MetadataReadWriteVisibility, true)
+	if !errors.Is(err, ErrInvalidMetadataValue) || mockServer.recordedRequests() != "" {
+		t.Fatalf("expected a validation error without requests for a tenant client, got: %v\n%s", err, mockServer.recordedRequests())
+	}
+
+	mockServer.client.IsSysAdmin = true
+	err = vm.AddMetadataEntryWithVisibility("cost-center", "cc-1", types.MetadataStringValue, types.MetadataReadWriteVisibility, true)
+	if !errors.Is(err, ErrInvalidMetadataValue) || mockServer.recordedRequests() != "" {
+		t.Fatalf("expected a validation error without requests for a VM, got: %v\n%s", err, mockServer.recordedRequests())
+	}
+
+	// The HREF alone doesn't allow it: only the ProviderVdc methods do
+	vcdClient := &VCDClient{Client: *mockServer.client}
+	err = vcdClient.AddMetadataEntryWithVisibilityByHref(providerVdc.ProviderVdc.HREF, "cost-center", "cc-1", types.MetadataStringValue, types.MetadataReadWriteVisibility, true)
+	if !errors.Is(err, ErrInvalidMetadataValue) || mockServer.recordedRequests() != "" {
+		t.Fatalf("expected a validation error without requests for a Provider VDC HREF, got: %v\n%s", err, mockServer.recordedRequests())
+	}
+
+	err = providerVdc.AddMetadataEntryWithVisibility("cost-center", "cc-1", types.MetadataStringValue, types.MetadataReadWriteVisibility, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	requests := mockServer.recordedRequests()
+	if !strings.Contains(requests, "PUT /api/admin/providervdc/pvdc-1/metadata/SYSTEM/cost-center") || !strings.Contains(requests, `<Domain visibility="READWRITE">SYSTEM</Domain>`) {
+		t.Errorf("expected SYSTEM metadata with READWRITE visibility to be sent, got:\n%s", requests)
+	}
+}
+
+// Test_FilterMetadata checks that FilterMetadata returns a filtered copy without modifying the input metadata
+func Test_FilterMetadata(t *testing.T) {
+	metadata := &types.Metadata{
+		Xmlns: types.XMLNamespaceVCloud,
+		Xsi:   types.XMLNamespaceXSI,
+		HREF:  "https://vcd.example.com/api/vApp/vm-1/metadata",
+		MetadataEntry: []*types.MetadataEntry{
+			{Key: "myapp.owner", TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "team-a"}},
+			{Key: "myapp.tier", TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "web"},
+				Domain: &types.MetadataDomainTag{Domain: "SYSTEM", Visibility: types.MetadataReadOnlyVisibility}},
+			{Key: "other.owner", TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "team-b"},
+				Domain: &types.MetadataDomainTag{Domain: "GENERAL", Visibility: types.MetadataReadWriteVisibility}},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		predicate    func(types.MetadataEntry) bool
+		expectedKeys []string
+	}{
+		{name: "KeyPrefix", predicate: FilterByKeyPrefix("myapp."), expectedKeys: []string{"myapp.owner", "myapp.tier"}},
+		{name: "SystemDomain", predicate: FilterByDomain(true), expectedKeys: []string{"myapp.tier"}},
+		{name: "GeneralDomain", predicate: FilterByDomain(false), expectedKeys: []string{"myapp.owner", "other.owner"}},
+		{name: "None", predicate: FilterByKeyPrefix("none."), expectedKeys: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filtered := FilterMetadata(metadata, tt.predicate)
+			if filtered.Xmlns != metadata.Xmlns || filtered.Xsi != metadata.Xsi || filtered.HREF != metadata.HREF {
+				t.Errorf("expected Xmlns, Xsi and HREF to be preserved, got %+v", filtered)
+			}
+			var keys []string
+			for _, entry := range filtered.MetadataEntry {
+				keys = append(keys, entry.Key)
+			}
+			if !reflect.DeepEqual(keys, tt.expectedKeys) {
+				t.Errorf("expected keys %v, got %v", tt.expectedKeys, keys)
+			}
+		})
+	}
+
+	filtered := FilterMetadata(metadata, FilterByKeyPrefix("myapp."))
+	filtered.MetadataEntry[1].TypedValue.Value = "db"
+	filtered.MetadataEntry[1].Domain.Visibility = types.MetadataHiddenVisibility
+	if len(metadata.MetadataEntry) != 3 || metadata.MetadataEntry[1].TypedValue.Value != "web" ||
+		metadata.MetadataEntry[1].Domain.Visibility != types.MetadataReadOnlyVisibility {
+		t.Errorf("expected the input metadata not to be modified")
+	}
+	if FilterMetadata(nil, FilterByDomain(true)) != nil {
+		t.Errorf("expected nil metadata to be filtered as nil")
+	}
+}
+
+// Test_WaitForMetadataKey checks that waiting for a metadata key succeeds when it has the expected value, and that
+// timeouts and cancelled contexts are reported
+func Test_WaitForMetadataKey(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	vm := NewVM(mockServer.client)
+	vm.VM.HREF = mockServer.URL + "/api/vApp/vm-1"
+	mockServer.metadataResponse = `<MetadataValue xmlns="http://www.vmware.com/vcloud/v1.5"><TypedValue xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="MetadataNumberValue"><Value>03</Value></TypedValue></MetadataValue>`
+
+	err := vm.WaitForMetadataKey("replicas", "3", false, time.Second, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	err = vm.WaitForMetadataKey("replicas", "4", false, 50*time.Millisecond, 10*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "timeout") || !strings.Contains(err.Error(), "last observed: '03'") {
+		t.Errorf("expected a timeout error with the last observed value, got: %v", err)
+	}
+	if requests := strings.Count(mockServer.recordedRequests(), "GET /api/vApp/vm-1/metadata/replicas"); requests < 3 {
+		t.Errorf("expected the metadata key to be polled several times, got %d requests", requests)
+	}
+
+	mockServer.failingRequests = []string{"GET /api/vApp/vm-1/metadata/missing"}
+	mockServer.failureStatus = http.StatusNotFound
+	err = vm.WaitForMetadataKey("missing", "web", false, 30*time.Millisecond, 10*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "last observed: key not present") {
+		t.Errorf("expected a timeout error for a missing key, got: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(30*time.Millisecond, cancel)
+	err = vm.WaitForMetadataKeyCtx(ctx, "missing", "web", false, time.Minute, 10*time.Millisecond)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+
+	mockServer.failureStatus = http.StatusInternalServerError
+	err = vm.WaitForMetadataKey("missing", "web", false, time.Minute, 10*time.Millisecond)
+	if err == nil || strings.Contains(err.Error(), "timeout") {
+		t.Errorf("expected the request error to be returned without waiting, got: %v", err)
+	}
+}
+
+// Test_JSONMetadata checks that metadata is converted to JSON and back without losing types, domains or visibilities
+func Test_JSONMetadata(t *testing.T) {
+	metadata := &types.Metadata{
+		Xmlns: types.XMLNamespaceVCloud,
+		Xsi:   types.XMLNamespaceXSI,
+	}
+	metadataTypes := []string{types.MetadataStringValue, types.MetadataNumberValue, types.MetadataDateTimeValue, types.MetadataBooleanValue}
+	metadataValues := []string{"web", "3", "2022-10-16T10:00:00Z", "true"}
+	domains := []types.MetadataDomainTag{
+		{Domain: "GENERAL", Visibility: types.MetadataReadWriteVisibility},
+		{Domain: "SYSTEM", Visibility: types.MetadataReadOnlyVisibility},
+		{Domain: "SYSTEM", Visibility: types.MetadataHiddenVisibility},
+	}
+	for i, metadataType := range metadataTypes {
+		for j, domain := range domains {
+			domain := domain
+			metadata.MetadataEntry = append(metadata.MetadataEntry, &types.MetadataEntry{
+				Xmlns:      types.XMLNamespaceVCloud,
+				Xsi:        types.XMLNamespaceXSI,
+				Key:        fmt.Sprintf("key-%d-%d", i, j),
+				TypedValue: &types.MetadataTypedValue{XsiType: metadataType, Value: metadataValues[i]},
+				Domain:     &domain,
+			})
+		}
+	}
+
+	data, err := MarshalJSONMetadata(metadata)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if strings.Contains(string(data), types.XMLNamespaceVCloud) {
+		t.Errorf("expected JSON without XML namespaces, got: %s", data)
+	}
+	if !strings.Contains(string(data), `"key-1-2":{"value":"3","type":"MetadataNumberValue","domain":"SYSTEM","visibility":"PRIVATE"}`) {
+		t.Errorf("unexpected JSON: %s", data)
+	}
+
+	roundTrip, err := UnmarshalJSONMetadata(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(metadata, roundTrip) {
+		data, _ = xml.Marshal(roundTrip)
+		t.Errorf("expected the round trip to be lossless, got: %s", data)
+	}
+
+	// The same key in both domains is kept, as entries are grouped by domain
+	metadata.MetadataEntry = []*types.MetadataEntry{
+		{
+			Xmlns:      types.XMLNamespaceVCloud,
+			Xsi:        types.XMLNamespaceXSI,
+			Key:        "tier",
+			TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "web"},
+			Domain:     &types.MetadataDomainTag{Domain: "GENERAL", Visibility: types.MetadataReadWriteVisibility},
+		},
+		{
+			Xmlns:      types.XMLNamespaceVCloud,
+			Xsi:        types.XMLNamespaceXSI,
+			Key:        "tier",
+			TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "db"},
+			Domain:     &types.MetadataDomainTag{Domain: "SYSTEM", Visibility: types.MetadataReadOnlyVisibility},
+		},
+	}
+	data, err = MarshalJSONMetadata(metadata)
+	if err != nil {
+		t.Fatalf("unexpected error for a key present in both domains: %s", err)
+	}
+	expectedJson := `{"GENERAL":{"tier":{"value":"web","type":"MetadataStringValue","domain":"GENERAL","visibility":"READWRITE"}},` +
+		`"SYSTEM":{"tier":{"value":"db","type":"MetadataStringValue","domain":"SYSTEM","visibility":"READONLY"}}}`
+	if string(data) != expectedJson {
+		t.Errorf("expected JSON %s, got: %s", expectedJson, data)
+	}
+	roundTrip, err = UnmarshalJSONMetadata(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(metadata, roundTrip) {
+		data, _ = xml.Marshal(roundTrip)
+		t.Errorf("expected both domains to be kept, got: %s", data)
+	}
+
+	_, err = UnmarshalJSONMetadata([]byte(`{"tier":{"value":{"value":"web","type":"MetadataStringValue"}}}`))
+	if !errors.Is(err, ErrInvalidMetadataValue) {
+		t.Errorf("expected ErrInvalidMetadataValue for an unknown domain, got: %v", err)
+	}
+	_, err = UnmarshalJSONMetadata([]byte(`{"GENERAL":{"tier":{"value":"web","type":"MetadataStringValue","domain":"SYSTEM"}}}`))
+	if !errors.Is(err, ErrInvalidMetadataValue) {
+		t.Errorf("expected ErrInvalidMetadataValue for a mismatched domain, got: %v", err)
+	}
+	_, err = UnmarshalJSONMetadata([]byte(`{"GENERAL":{"tier":{"value":"web","type":"MetadataTextValue"}}}`))
+	if !errors.Is(err, ErrInvalidMetadataValue) {
+		t.Errorf("expected ErrInvalidMetadataValue for an unknown type, got: %v", err)
+	}
+}
+
+// Test_CopyMetadata checks that GENERAL metadata is copied with a single merge, and that SYSTEM metadata is only copied
+// when requested and allowed in the destination
+func Test_CopyMetadata(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.metadataResponse = `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <MetadataEntry><Key>general</Key><TypedValue xsi:type="MetadataStringValue"><Value>value</Value></TypedValue></MetadataEntry>
+  <MetadataEntry><Domain visibility="PRIVATE">SYSTEM</Domain><Key>hidden</Key><TypedValue xsi:type="MetadataNumberValue"><Value>1</Value></TypedValue></MetadataEntry>
+  <MetadataEntry><Domain visibility="READWRITE">SYSTEM</Domain><Key>writable</Key><TypedValue xsi:type="MetadataStringValue"><Value>value</Value></TypedValue></MetadataEntry>
+</Metadata>`
+
+	src := NewVM(mockServer.client)
+	src.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+	dst := NewVM(mockServer.client)
+	dst.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-2"}
+
+	err := CopyMetadata(src, dst, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	requests := mockServer.recordedRequests()
+	if strings.Count(requests, "POST /api/vApp/vm-2/metadata\n") != 1 || !strings.Contains(requests, "<Key>general</Key>") ||
+		strings.Contains(requests, "<Key>hidden</Key>") {
+		t.Errorf("expected only GENERAL entries to be merged, got:\n%s", requests)
+	}
+
+	mockServer.requests = nil
+	err = CopyMetadata(src, dst, true)
+	var multiError *MetadataMultiError
+	if !errors.As(err, &multiError) || len(multiError.Errors) != 2 || multiError.Errors["SYSTEM/hidden"] == nil || multiError.Errors["SYSTEM/writable"] == nil {
+		t.Fatalf("expected SYSTEM entries to be skipped for a tenant client, got: %v", err)
+	}
+	if strings.Count(mockServer.recordedRequests(), "POST /api/vApp/vm-2/metadata\n") != 1 {
+		t.Errorf("expected GENERAL entries to be copied anyway, got:\n%s", mockServer.recordedRequests())
+	}
+
+	mockServer.requests = nil
+	mockServer.client.IsSysAdmin = true
+	err = CopyMetadata(src, dst, true)
+	if !errors.As(err, &multiError) || len(multiError.Errors) != 1 || multiError.Errors["SYSTEM/writable"] == nil {
+		t.Fatalf("expected only the READWRITE SYSTEM entry to be skipped, got: %v", err)
+	}
+	requests = mockServer.recordedRequests()
+	if strings.Count(requests, "POST /api/vApp/vm-2/metadata\n") != 2 || !strings.Contains(requests, "<Key>hidden</Key>") ||
+		strings.Contains(requests, "<Key>writable</Key>") {
+		t.Errorf("expected GENERAL and SYSTEM entries to be merged separately, got:\n%s", requests)
+	}
+}
+
+// Test_MetadataReturningTask checks that the blocking metadata operations return the finished task, also when it fails
+func Test_MetadataReturningTask(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	vm := NewVM(mockServer.client)
+	vm.VM.HREF = mockServer.URL + "/api/vApp/vm-1"
+
+	operations := map[string]func() (Task, error){
+		"Add": func() (Task, error) {
+			return vm.AddMetadataEntryWithVisibilityReturningTask("tier", "web", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+		},
+		"Merge": func() (Task, error) {
+			return vm.MergeMetadataWithMetadataValuesReturningTask(map[string]types.MetadataValue{
+				"tier": {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "web"}},
+			})
+		},
+		"Delete": func() (Task, error) {
+			return vm.DeleteMetadataEntryWithDomainReturningTask("tier", false)
+		},
+	}
+	for name, operation := range operations {
+		t.Run(name, func(t *testing.T) {
+			mockServer.taskStatus = "success"
+			task, err := operation()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if task.Task == nil || task.Task.Status != "success" || task.Task.HREF != mockServer.URL+"/api/task/1" {
+				t.Errorf("expected the finished task to be returned, got: %+v", task.Task)
+			}
+
+			mockServer.taskStatus = "error"
+			task, err = operation()
+			if err == nil {
+				t.Fatalf("expected an error for a failed task")
+			}
+			if task.Task == nil || task.Task.Status != "error" {
+				t.Errorf("expected the failed task to be returned, got: %+v", task.Task)
+			}
+		})
+	}
+}
+
+// Test_NewVMFromTypeMetadata checks that a VM definition wrapped with NewVMFromType, like a standalone VM, can manage
+// its metadata
+func Test_NewVMFromTypeMetadata(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+
+	_, err := NewVMFromType(mockServer.client, &types.Vm{Name: "standalone"})
+	if err == nil {
+		t.Fatalf("expected an error for a VM without HREF")
+	}
+	_, err = NewVMFromType(nil, &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"})
+	if err == nil {
+		t.Fatalf("expected an error for a nil client")
+	}
+
+	vm, err := NewVMFromType(mockServer.client, &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1", Name: "standalone"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	_, err = vm.GetMetadata()
+	if err != nil {
+		t.Fatalf("unexpected error getting metadata: %s", err)
+	}
+	err = vm.AddMetadataEntryWithVisibility("tier", "web", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	if err != nil {
+		t.Fatalf("unexpected error adding metadata: %s", err)
+	}
+	requests := mockServer.recordedRequests()
+	if !strings.Contains(requests, "GET /api/vApp/vm-1/metadata/") || !strings.Contains(requests, "PUT /api/vApp/vm-1/metadata/tier") {
+		t.Errorf("expected the metadata requests to use the VM HREF, got:\n%s", requests)
+	}
+}
+
+// Test_MetadataDryRun checks that the dry-run variants return the planned changes using only a GET request
+func Test_MetadataDryRun(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.metadataResponse = `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <MetadataEntry><Key>keep</Key><TypedValue xsi:type="MetadataStringValue"><Value>value</Value></TypedValue></MetadataEntry>
+  <MetadataEntry><Key>change</Key><TypedValue xsi:type="MetadataNumberValue"><Value>1</Value></TypedValue></MetadataEntry>
+  <MetadataEntry><Key>stale</Key><TypedValue xsi:type="MetadataStringValue"><Value>value</Value></TypedValue></MetadataEntry>
+  <MetadataEntry><Domain visibility="READONLY">SYSTEM</Domain><Key>system-stale</Key><TypedValue xsi:type="MetadataStringValue"><Value>value</Value></TypedValue></MetadataEntry>
+</Metadata>`
+
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+	desired := map[string]types.MetadataValue{
+		"keep":   {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "value"}},
+		"change": {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataNumberValue, Value: "2"}},
+		"new":    {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "value"}},
+	}
+
+	checkPlan := func(name string, plan MetadataChangePlan, expectedDelete []string) {
+		if len(plan.ToAdd) != 1 || plan.ToAdd["new"].TypedValue == nil {
+			t.Errorf("[%s] expected 'new' to be added, got: %v", name, plan.ToAdd)
+		}
+		change, found := plan.ToUpdate["change"]
+		if len(plan.ToUpdate) != 1 || !found || change.Old.TypedValue.Value != "1" || change.New.TypedValue.Value != "2" {
+			t.Errorf("[%s] expected 'change' to be updated from 1 to 2, got: %v", name, plan.ToUpdate)
+		}
+		if !reflect.DeepEqual(plan.ToDelete, expectedDelete) {
+			t.Errorf("[%s] expected %v to be deleted, got: %v", name, expectedDelete, plan.ToDelete)
+		}
+	}
+
+	plan, err := vm.MergeMetadataWithMetadataValuesDryRun(desired)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	checkPlan("merge", plan, nil)
+
+	plan, err = vm.ReplaceAllMetadataDryRun(desired, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	checkPlan("replace", plan, []string{"stale"})
+
+	requests := mockServer.recordedRequests()
+	if strings.Count(requests, "GET /api/vApp/vm-1/metadata/") != 2 || strings.Contains(requests, "PUT ") ||
+		strings.Contains(requests, "POST ") || strings.Contains(requests, "DELETE ") {
+		t.Errorf("expected a single GET per dry-run, got:\n%s", requests)
+	}
+
+	_, err = vm.MergeMetadataWithMetadataValuesDryRun(map[string]types.MetadataValue{"empty": {}})
+	if !errors.Is(err, ErrInvalidMetadataValue) {
+		t.Errorf("expected ErrInvalidMetadataValue for an empty value, got: %v", err)
+	}
+}
+
+// Test_NsxtAlbMetadata checks that NSX-T ALB Service Engine Group metadata is managed through the OpenAPI endpoint,
+// and that a MetadataNotSupportedError is returned when VCD doesn't support it
+func Test_NsxtAlbMetadata(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.setMaxSupportedVersion("38.0")
+	mockServer.openApiResponse = `[
+  {"id": "urn:vcloud:metadata:1", "keyValue": {"domain": "TENANT", "key": "tenant", "value": {"value": "org-a", "type": "StringEntry"}}}
+]`
+	vcdClient := &VCDClient{Client: *mockServer.client}
+
+	serviceEngineGroup := &NsxtAlbServiceEngineGroup{
+		NsxtAlbServiceEngineGroup: &types.NsxtAlbServiceEngineGroup{ID: "urn:vcloud:serviceEngineGroup:1", Name: "seg"},
+		vcdClient:                 vcdClient,
+	}
+	endpoint := "/cloudapi/1.0.0/loadBalancer/serviceEngineGroups/urn:vcloud:serviceEngineGroup:1/metadata/"
+
+	values, err := serviceEngineGroup.GetMetadataAsMap(false)
+	if err != nil {
+		t.Fatalf("error retrieving metadata: %s", err)
+	}
+	if fmt.Sprint(values) != "map[tenant:org-a]" {
+		t.Errorf("unexpected metadata: %v", values)
+	}
+	err = serviceEngineGroup.AddMetadataEntryWithVisibility("cost-center", "cc-1", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	if err != nil {
+		t.Fatalf("error adding metadata: %s", err)
+	}
+	err = serviceEngineGroup.DeleteMetadataEntryWithDomain("tenant", false)
+	if err != nil {
+		t.Fatalf("error deleting metadata: %s", err)
+	}
+	requests := mockServer.recordedRequests()
+	for _, expected := range []string{"GET " + endpoint + "\n", "POST " + endpoint + "\n", "DELETE " + endpoint + "urn:vcloud:metadata:1\n"} {
+		if !strings.Contains(requests, expected) {
+			t.Errorf("expected request '%s', got:\n%s", expected, requests)
+		}
+	}
+
+	mockServer.requests = nil
+	vcdClient.Client.supportedVersions = SupportedVersions{VersionInfos: VersionInfos{{Version: "37.2"}}}
+	_, err = serviceEngineGroup.GetMetadata()
+	assertMetadataNotSupported(t, err)
+	if requests := mockServer.recordedRequests(); requests != "" {
+		t.Errorf("expected no requests to be sent, got:\n%s", requests)
+	}
+}
+
+// Test_MetadataRequestTransports checks that metadataRequest produces the same results with the XML API and the
+// OpenAPI for the same logical operations
+func Test_MetadataRequestTransports(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.setMaxSupportedVersion("38.0")
+	mockServer.failingRequests = []string{"GET /api/vApp/vm-1/metadata/missing"}
+	mockServer.failureStatus = http.StatusNotFound
+	mockServer.openApiResponse = `[
+  {"id": "urn:vcloud:metadata:1", "keyValue": {"domain": "TENANT", "key": "tenant", "value": {"value": "org-a", "type": "StringEntry"}}},
+  {"id": "urn:vcloud:metadata:2", "readOnly": true, "keyValue": {"domain": "PROVIDER", "key": "replicas", "value": {"value": 3, "type": "NumberEntry"}}}
+]`
+	xmlMetadata := `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <MetadataEntry>
+    <Domain visibility="READWRITE">GENERAL</Domain>
+    <Key>tenant</Key>
+    <TypedValue xsi:type="MetadataStringValue"><Value>org-a</Value></TypedValue>
+  </MetadataEntry>
+  <MetadataEntry>
+    <Domain visibility="READONLY">SYSTEM</Domain>
+    <Key>replicas</Key>
+    <TypedValue xsi:type="MetadataNumberValue"><Value>3</Value></TypedValue>
+  </MetadataEntry>
+</Metadata>`
+	xmlMetadataValue := `<MetadataValue xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <Domain visibility="READONLY">SYSTEM</Domain>
+  <TypedValue xsi:type="MetadataNumberValue"><Value>3</Value></TypedValue>
+</MetadataValue>`
+
+	transports := map[metadataTransport]metadataRequestOptions{
+		metadataTransportXml:     {transport: metadataTransportXml, href: mockServer.URL + "/api/vApp/vm-1"},
+		metadataTransportOpenApi: {transport: metadataTransportOpenApi, endpoint: types.OpenApiEndpointOrgVdcNetworksMetadata, entityId: "urn:vcloud:network:1"},
+	}
+	summarize := func(entries ...*types.MetadataEntry) string {
+		var result []string
+		for _, entry := range entries {
+			result = append(result, fmt.Sprintf("%s/%s/%s=%s(%s)", entry.Domain.Domain, entry.Domain.Visibility, entry.Key, entry.TypedValue.Value, entry.TypedValue.XsiType))
+		}
+		sort.Strings(result)
+		return strings.Join(result, ",")
+	}
+
+	results := map[metadataTransport][]string{}
+	for transport, opts := range transports {
+		mockServer.metadataResponse = xmlMetadata
+		opts.operation = metadataOperationGet
+		response, err := metadataRequest(mockServer.client, opts)
+		if err != nil {
+			t.Fatalf("[%s] error retrieving metadata: %s", transport, err)
+		}
+		results[transport] = append(results[transport], summarize(response.metadata.MetadataEntry...))
+
+		mockServer.metadataResponse = xmlMetadataValue
+		opts.operation = metadataOperationGetByKey
+		opts.key = "replicas"
+		opts.isSystem = true
+		response, err = metadataRequest(mockServer.client, opts)
+		if err != nil {
+			t.Fatalf("[%s] error retrieving metadata by key: %s", transport, err)
+		}
+		results[transport] = append(results[transport], summarize(&types.MetadataEntry{Key: opts.key, Domain: response.value.Domain, TypedValue: response.value.TypedValue}))
+
+		opts.key = "missing"
+		opts.isSystem = false
+		_, err = metadataRequest(mockServer.client, opts)
+		var notFoundError *MetadataKeyNotFoundError
+		if !errors.As(err, &notFoundError) || notFoundError.Key != "missing" || !ContainsNotFound(err) {
+			t.Errorf("[%s] expected a *MetadataKeyNotFoundError for the missing key, got: %v", transport, err)
+		}
+
+		opts.operation = metadataOperationAdd
+		opts.key, opts.value, opts.typedValue, opts.visibility = "cost-center", "cc-1", types.MetadataStringValue, types.MetadataReadWriteVisibility
+		_, err = metadataRequest(mockServer.client, opts)
+		if err != nil {
+			t.Errorf("[%s] error adding metadata: %s", transport, err)
+		}
+
+		opts.operation = metadataOperationAdd
+		opts.key = ""
+		_, err = metadataRequest(mockServer.client, opts)
+		if err == nil {
+			t.Errorf("[%s] expected an error adding metadata without key", transport)
+		}
+
+		opts.operation = metadataOperationDelete
+		opts.key = "tenant"
+		_, err = metadataRequest(mockServer.client, opts)
+		if err != nil {
+			t.Errorf("[%s] error deleting metadata: %s", transport, err)
+		}
+	}
+
+	if !reflect.DeepEqual(results[metadataTransportXml], results[metadataTransportOpenApi]) {
+		t.Errorf("transports returned different results:\nXML:     %v\nOpenAPI: %v", results[metadataTransportXml], results[metadataTransportOpenApi])
+	}
+	requests := mockServer.recordedRequests()
+	for _, expected := range []string{
+		"PUT /api/vApp/vm-1/metadata/cost-center\n",
+		"DELETE /api/vApp/vm-1/metadata/tenant\n",
+		"POST /cloudapi/1.0.0/orgVdcNetworks/urn:vcloud:network:1/metadata/\n",
+		"DELETE /cloudapi/1.0.0/orgVdcNetworks/urn:vcloud:network:1/metadata/urn:vcloud:metadata:1\n",
+	} {
+		if !strings.Contains(requests, expected) {
+			t.Errorf("expected request '%s', got:\n%s", expected, requests)
+		}
+	}
+
+	_, err := metadataRequest(mockServer.client, metadataRequestOptions{transport: metadataTransportXml, operation: metadataOperationGet})
+	if err == nil {
+		t.Errorf("expected an error without the entity HREF")
+	}
+	_, err = metadataRequest(mockServer.client, metadataRequestOptions{transport: metadataTransport(99), operation: metadataOperationGet, href: "x"})
+	if err == nil || !strings.Contains(err.Error(), "unknown(99)") {
+		t.Errorf("expected an error for an unknown transport, got: %v", err)
+	}
+}
+
+// Test_MetadataVisibilityApiVersion checks that visibilities registered with a minimum API version are rejected with a
+// *MetadataNotSupportedError by older API versions, while the three classic visibilities keep working
+func Test_MetadataVisibilityApiVersion(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	vm := NewVM(mockServer.client)
+	vm.VM.HREF = mockServer.URL + "/api/vApp/vm-1"
+
+	metadataVisibilityMinApiVersions["FUTURE"] = "40.0"
+	defer delete(metadataVisibilityMinApiVersions, "FUTURE")
+
+	for _, visibility := range []string{types.MetadataReadOnlyVisibility, types.MetadataPrivateVisibility} {
+		err := vm.AddMetadataEntryWithVisibility("key", "value", types.MetadataStringValue, visibility, true)
+		if err != nil {
+			t.Errorf("error adding metadata with visibility '%s': %s", visibility, err)
+		}
+	}
+
+	err := vm.AddMetadataEntryWithVisibility("key", "value", types.MetadataStringValue, "FUTURE", true)
+	var notSupportedError *MetadataNotSupportedError
+	if !errors.As(err, &notSupportedError) {
+		t.Fatalf("expected a *MetadataNotSupportedError, got: %v", err)
+	}
+	if notSupportedError.MinimumApiVersion != "40.0" || !strings.Contains(err.Error(), "requires API version 40.0 or higher") {
+		t.Errorf("expected the minimum API version in the error, got: %s", err)
+	}
+	if strings.Count(mockServer.recordedRequests(), "PUT ") != 2 {
+		t.Errorf("expected no request for the unsupported visibility, got:\n%s", mockServer.recordedRequests())
+	}
+
+	mockServer.client.APIVersion = "40.0"
+	err = vm.AddMetadataEntryWithVisibility("key", "value", types.MetadataStringValue, "FUTURE", true)
+	if err != nil {
+		t.Errorf("error adding metadata with a visibility supported by the API version: %s", err)
+	}
+
+	err = vm.AddMetadataEntryWithVisibility("key", "value", types.MetadataStringValue, "UNKNOWN", true)
+	if !errors.Is(err, ErrInvalidMetadataValue) {
+		t.Errorf("expected ErrInvalidMetadataValue for an unknown visibility, got: %v", err)
+	}
+}
+
+// Test_GetMetadataByKeyWithDomain checks that a key is retrieved from both domains, leaving as nil the domains where it
+// is missing, and that other failures are returned as errors
+func Test_GetMetadataByKeyWithDomain(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	vm := NewVM(mockServer.client)
+	vm.VM.HREF = mockServer.URL + "/api/vApp/vm-1"
+	mockServer.metadataResponse = `<MetadataValue xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <Domain visibility="READWRITE">GENERAL</Domain>
+  <TypedValue xsi:type="MetadataStringValue"><Value>org-a</Value></TypedValue>
+</MetadataValue>`
+
+	keyDomains, err := vm.GetMetadataByKeyWithDomain("tenant")
+	if err != nil {
+		t.Fatalf("error retrieving metadata: %s", err)
+	}
+	if !keyDomains.IsPresent() || keyDomains.Key != "tenant" || keyDomains.System == nil || keyDomains.General == nil {
+		t.Errorf("expected the key in both domains, got: %+v", keyDomains)
+	}
+	requests := mockServer.recordedRequests()
+	for _, expected := range []string{"GET /api/vApp/vm-1/metadata/SYSTEM/tenant\n", "GET /api/vApp/vm-1/metadata/tenant\n"} {
+		if !strings.Contains(requests, expected) {
+			t.Errorf("expected request '%s', got:\n%s", expected, requests)
+		}
+	}
+
+	mockServer.failingRequests = []string{"GET /api/vApp/vm-1/metadata/SYSTEM/tenant"}
+	mockServer.failureStatus = http.StatusForbidden
+	mockServer.failureMessage = "The metadata entry tenant does not exist"
+	keyDomains, err = vm.GetMetadataByKeyWithDomain("tenant")
+	if err != nil {
+		t.Fatalf("error retrieving metadata: %s", err)
+	}
+	if keyDomains.System != nil || keyDomains.General == nil || keyDomains.General.TypedValue.Value != "org-a" {
+		t.Errorf("expected the key only in GENERAL domain, got: %+v", keyDomains)
+	}
+
+	mockServer.failingRequests = append(mockServer.failingRequests, "GET /api/vApp/vm-1/metadata/tenant")
+	keyDomains, err = vm.GetMetadataByKeyWithDomain("tenant")
+	if err != nil {
+		t.Fatalf("error retrieving metadata: %s", err)
+	}
+	if keyDomains.IsPresent() {
+		t.Errorf("expected the key to be missing in both domains, got: %+v", keyDomains)
+	}
+
+	mockServer.failureStatus = http.StatusInternalServerError
+	mockServer.failureMessage = "mock failure"
+	_, err = vm.GetMetadataByKeyWithDomain("tenant")
+	if err == nil || ContainsNotFound(err) {
+		t.Errorf("expected a server error, got: %v", err)
+	}
+}
+
+// Test_GetMetadataBulk checks that the metadata of several entities is retrieved concurrently, indexed by entity
+// identifier, and that failures are collected without aborting the batch
+func Test_GetMetadataBulk(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.metadataResponse = `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <MetadataEntry><Key>environment</Key><TypedValue xsi:type="MetadataStringValue"><Value>production</Value></TypedValue></MetadataEntry>
+</Metadata>`
+	mockServer.failingRequests = []string{"GET /api/vApp/vm-3/metadata/"}
+
+	var entities []MetadataCompatible
+	for index := 1; index <= 10; index++ {
+		vm := NewVM(mockServer.client)
+		vm.VM = &types.Vm{HREF: fmt.Sprintf("%s/api/vApp/vm-%d", mockServer.URL, index)}
+		entities = append(entities, vm)
+	}
+
+	result, err := GetMetadataBulk(entities, 4)
+	var multiError *MetadataMultiError
+	if !errors.As(err, &multiError) {
+		t.Fatalf("expected a *MetadataMultiError, got %T: %v", err, err)
+	}
+	failedHref := mockServer.URL + "/api/vApp/vm-3"
+	if len(multiError.Errors) != 1 || multiError.Errors[failedHref] == nil {
+		t.Errorf("expected a single error for '%s', got: %s", failedHref, multiError)
+	}
+	if len(result) != 9 || result[failedHref] != nil {
+		t.Errorf("expected the metadata of 9 entities, got %d", len(result))
+	}
+	for href, metadata := range result {
+		if len(metadata.MetadataEntry) != 1 || metadata.MetadataEntry[0].TypedValue.Value != "production" {
+			t.Errorf("unexpected metadata for '%s': %v", href, metadata.MetadataEntry)
+		}
+	}
+
+	result, err = GetMetadataBulk(nil, 4)
+	if err != nil || len(result) != 0 {
+		t.Errorf("expected no metadata and no error for an empty batch, got %v: %v", result, err)
+	}
+}
+
+// Test_OrgVDCNetworkMetadataTenant checks that Org administrators modify the metadata of Org VDC networks through the
+// tenant endpoint, that system administrators keep using the admin one unless they use the copy returned by
+// OrgVDCNetwork.WithTenantMetadataHref, and that permission errors tell apart the missing Org administrator rights from the operations restricted to
+// system administrators
+func Test_OrgVDCNetworkMetadataTenant(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	network := NewOrgVDCNetwork(mockServer.client)
+	network.OrgVDCNetwork.Name = "net1"
+	network.OrgVDCNetwork.HREF = mockServer.URL + "/api/network/net-1"
+
+	err := network.AddMetadataEntryWithVisibility("key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	if err != nil {
+		t.Fatalf("error adding metadata as Org administrator: %s", err)
+	}
+	err = network.DeleteMetadataEntryWithDomain("key", false)
+	if err != nil {
+		t.Fatalf("error deleting metadata as Org administrator: %s", err)
+	}
+	requests := mockServer.recordedRequests()
+	for _, expected := range []string{"PUT /api/network/net-1/metadata/key\n", "DELETE /api/network/net-1/metadata/key\n"} {
+		if !strings.Contains(requests, expected) {
+			t.Errorf("expected request '%s', got:\n%s", expected, requests)
+		}
+	}
+
+	mockServer.requests = nil
+	err = network.AddMetadataEntryWithVisibility("key", "value", types.MetadataStringValue, types.MetadataReadOnlyVisibility, true)
+	var permissionError *MetadataPermissionError
+	if !errors.As(err, &permissionError) || !permissionError.RequiresSystemAdmin {
+		t.Errorf("expected a *MetadataPermissionError that requires system administrator, got: %v", err)
+	}
+	err = network.MergeMetadataWithMetadataValues(map[string]types.MetadataValue{
+		"key": {Domain: &types.MetadataDomainTag{Domain: "SYSTEM", Visibility: types.MetadataReadOnlyVisibility}, TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "value"}},
+	})
+	if !errors.As(err, &permissionError) || !permissionError.RequiresSystemAdmin {
+		t.Errorf("expected a *MetadataPermissionError that requires system administrator, got: %v", err)
+	}
+	if requests := mockServer.recordedRequests(); requests != "" {
+		t.Errorf("expected no requests to be sent, got:\n%s", requests)
+	}
+
+	mockServer.failingRequests = []string{"PUT /api/network/net-1/metadata/key"}
+	mockServer.failureStatus = http.StatusForbidden
+	err = network.AddMetadataEntryWithVisibility("key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	if !errors.As(err, &permissionError) || permissionError.RequiresSystemAdmin || !strings.Contains(err.Error(), "missing Org administrator rights") {
+		t.Errorf("expected a *MetadataPermissionError for missing Org administrator rights, got: %v", err)
+	}
+
+	// Only the status code returned by VCD identifies a permission error, not the text of the message
+	mockServer.failureStatus = http.StatusInternalServerError
+	mockServer.failureMessage = "API Error: 403: forbidden by a backend"
+	err = network.AddMetadataEntryWithVisibility("key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	if err == nil || errors.As(err, &permissionError) {
+		t.Errorf("expected a plain error for a 500 error mentioning 403, got: %v", err)
+	}
+	mockServer.failureMessage = "mock failure"
+
+	mockServer.requests = nil
+	mockServer.client.IsSysAdmin = true
+	err = network.AddMetadataEntryWithVisibility("key", "value", types.MetadataStringValue, types.MetadataReadOnlyVisibility, true)
+	if err != nil {
+		t.Fatalf("error adding metadata as system administrator: %s", err)
+	}
+	if requests := mockServer.recordedRequests(); !strings.Contains(requests, "PUT /api/admin/network/net-1/metadata/SYSTEM/key\n") {
+		t.Errorf("expected the admin endpoint to be used, got:\n%s", requests)
+	}
+
+	mockServer.requests = nil
+	err = network.WithTenantMetadataHref().AddMetadataEntryWithVisibility("key", "value", types.MetadataStringValue, types.MetadataReadOnlyVisibility, true)
+	if err != nil {
+		t.Fatalf("error adding metadata as system administrator with the tenant HREF: %s", err)
+	}
+	if requests := mockServer.recordedRequests(); !strings.Contains(requests, "PUT /api/network/net-1/metadata/SYSTEM/key\n") {
+		t.Errorf("expected the tenant endpoint to be used, got:\n%s", requests)
+	}
+
+	// The option only applies to the copy, the receiver keeps using the admin HREF
+	mockServer.requests = nil
+	err = network.AddMetadataEntryWithVisibility("key", "value", types.MetadataStringValue, types.MetadataReadOnlyVisibility, true)
+	if err != nil {
+		t.Fatalf("error adding metadata as system administrator: %s", err)
+	}
+	if requests := mockServer.recordedRequests(); !strings.Contains(requests, "PUT /api/admin/network/net-1/metadata/SYSTEM/key\n") {
+		t.Errorf("expected the admin endpoint to be used after using the tenant HREF copy, got:\n%s", requests)
+	}
+}
+
+// Test_OnMetadataChange checks that Client.OnMetadataChange is called after every successful metadata change, with the
+// values before and after it, and that it is not called when the change fails
+func Test_OnMetadataChange(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.setMaxSupportedVersion("38.0")
+	vm := NewVM(mockServer.client)
+	vm.VM.HREF = mockServer.URL + "/api/vApp/vm-1"
+	mockServer.metadataResponse = `<MetadataValue xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <Domain visibility="READWRITE">GENERAL</Domain>
+  <TypedValue xsi:type="MetadataStringValue"><Value>old</Value></TypedValue>
+</MetadataValue>`
+
+	var changes []string
+	summarize := func(value *types.MetadataValue) string {
+		if value == nil {
+			return "<nil>"
+		}
+		return value.Domain.Domain + ":" + value.TypedValue.Value
+	}
+	mockServer.client.OnMetadataChange = func(entityHref, key, op string, oldValue, newValue *types.MetadataValue) {
+		changes = append(changes, fmt.Sprintf("%s %s %s %s -> %s", strings.TrimPrefix(entityHref, mockServer.URL), op, key, summarize(oldValue), summarize(newValue)))
+	}
+
+	err := vm.AddMetadataEntryWithVisibility("key", "new", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	if err != nil {
+		t.Fatalf("error adding metadata: %s", err)
+	}
+	err = vm.DeleteMetadataEntryWithDomain("key", false)
+	if err != nil {
+		t.Fatalf("error deleting metadata: %s", err)
+	}
+	mockServer.metadataResponse = `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <MetadataEntry><Domain visibility="READONLY">SYSTEM</Domain><Key>a</Key><TypedValue xsi:type="MetadataStringValue"><Value>old-a</Value></TypedValue></MetadataEntry>
+</Metadata>`
+	err = vm.MergeMetadataWithMetadataValues(map[string]types.MetadataValue{
+		"b": {Domain: &types.MetadataDomainTag{Domain: "GENERAL", Visibility: types.MetadataReadWriteVisibility}, TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "new-b"}},
+		"a": {Domain: &types.MetadataDomainTag{Domain: "SYSTEM", Visibility: types.MetadataReadOnlyVisibility}, TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "new-a"}},
+	})
+	if err != nil {
+		t.Fatalf("error merging metadata: %s", err)
+	}
+
+	mockServer.taskStatus = "error"
+	err = vm.DeleteMetadataEntryWithDomain("key", false)
+	if err == nil {
+		t.Fatalf("expected an error when the task fails")
+	}
+
+	mockServer.openApiResponse = `[{"id": "urn:vcloud:metadata:1", "keyValue": {"domain": "TENANT", "key": "tenant", "value": {"value": "org-a", "type": "StringEntry"}}}]`
+	vdcGroup := &VdcGroup{VdcGroup: &types.VdcGroup{Id: "urn:vcloud:vdcGroup:1"}, client: mockServer.client}
+	vdcGroup.initOpenApiMetadata()
+	err = vdcGroup.DeleteMetadataEntryWithDomain("tenant", false)
+	if err != nil {
+		t.Fatalf("error deleting OpenAPI metadata: %s", err)
+	}
+
+	expected := []string{
+		"/api/vApp/vm-1 add key GENERAL:old -> GENERAL:new",
+		"/api/vApp/vm-1 delete key GENERAL:old -> <nil>",
+		"/api/vApp/vm-1 merge a SYSTEM:old-a -> SYSTEM:new-a",
+		"/api/vApp/vm-1 merge b <nil> -> GENERAL:new-b",
+		"urn:vcloud:vdcGroup:1 delete tenant GENERAL:org-a -> <nil>",
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("unexpected changes:\n%s\nexpected:\n%s", strings.Join(changes, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+// Test_ParseMetadataProperties checks that .properties-style metadata is parsed with type annotations, skipping blank
+// and comment lines, and that errors report the offending line
+func Test_ParseMetadataProperties(t *testing.T) {
+	input := `# Metadata for the web tier
+! generated by ops
+
+environment = production
+replicas:number=3
+enabled:bool = true
+created:datetime=2023-01-02T03:04:05Z
+url=http://example.com/?a=b
+app\:tier=web
+app\:replicas:number=2
+escaped\=key\\=value
+`
+	metadata, err := ParseMetadataProperties(strings.NewReader(input), types.MetadataStringValue, types.MetadataReadOnlyVisibility, true)
+	if err != nil {
+		t.Fatalf("error parsing metadata properties: %s", err)
+	}
+	expected := map[string]string{
+		"environment":   types.MetadataStringValue + "=production",
+		"replicas":      types.MetadataNumberValue + "=3",
+		"enabled":       types.MetadataBooleanValue + "=true",
+		"created":       types.MetadataDateTimeValue + "=2023-01-02T03:04:05Z",
+		"url":           types.MetadataStringValue + "=http://example.com/?a=b",
+		"app:tier":      types.MetadataStringValue + "=web",
+		"app:replicas":  types.MetadataNumberValue + "=2",
+		"escaped=key\\": types.MetadataStringValue + "=value",
+	}
+	if len(metadata) != len(expected) {
+		t.Errorf("expected %d entries, got %d: %v", len(expected), len(metadata), metadata)
+	}
+	for key, expectedValue := range expected {
+		value, ok := metadata[key]
+		if !ok {
+			t.Errorf("missing key '%s'", key)
+			continue
+		}
+		if value.TypedValue.XsiType+"="+value.TypedValue.Value != expectedValue {
+			t.Errorf("unexpected value for key '%s': %s=%s", key, value.TypedValue.XsiType, value.TypedValue.Value)
+		}
+		if value.Domain.Domain != "SYSTEM" || value.Domain.Visibility != types.MetadataReadOnlyVisibility {
+			t.Errorf("unexpected domain for key '%s': %+v", key, value.Domain)
+		}
+	}
+
+	tests := []struct {
+		name          string
+		input         string
+		expectedError string
+	}{
+		{name: "MissingSeparator", input: "a=1\n\nb\n", expectedError: "line 3: missing '=' separator"},
+		{name: "UnknownType", input: "a:float=1.5\n", expectedError: "line 1: unknown type annotation 'float'"},
+		{name: "InvalidNumber", input: "# comment\na:number=many\n", expectedError: "line 2:"},
+		{name: "EmptyKey", input: "=value\n", expectedError: "line 1: invalid metadata key"},
+		{name: "DuplicatedKey", input: "a=1\na:number=2\n", expectedError: "line 2: key 'a' was already defined at line 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseMetadataProperties(strings.NewReader(tt.input), types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+			if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+				t.Errorf("expected error containing '%s', got: %v", tt.expectedError, err)
+			}
+		})
+	}
+
+	_, err = ParseMetadataProperties(strings.NewReader("a=1"), types.MetadataStringValue, types.MetadataReadWriteVisibility, true)
+	if err == nil {
+		t.Errorf("expected an error for a visibility not allowed in SYSTEM domain")
+	}
+}
+
+// Test_MetadataTypedEnums checks the parsing and validation of types.MetadataType and types.MetadataVisibility, and
+// that metadata can be added with them
+func Test_MetadataTypedEnums(t *testing.T) {
+	for _, value := range []string{types.MetadataStringValue, types.MetadataNumberValue, types.MetadataDateTimeValue, types.MetadataBooleanValue} {
+		metadataType, err := types.ParseMetadataType(value)
+		if err != nil || !metadataType.IsValid() || string(metadataType) != value {
+			t.Errorf("expected '%s' to be a valid metadata type, got '%s': %v", value, metadataType, err)
+		}
+	}
+	for _, value := range []string{types.MetadataReadOnlyVisibility, types.MetadataHiddenVisibility, types.MetadataReadWriteVisibility} {
+		visibility, err := types.ParseMetadataVisibility(value)
+		if err != nil || !visibility.IsValid() || string(visibility) != value {
+			t.Errorf("expected '%s' to be a valid metadata visibility, got '%s': %v", value, visibility, err)
+		}
+	}
+	if _, err := types.ParseMetadataType("MetadataStringvalue"); err == nil {
+		t.Errorf("expected an error parsing an invalid metadata type")
+	}
+	if _, err := types.ParseMetadataVisibility("readonly"); err == nil {
+		t.Errorf("expected an error parsing an invalid metadata visibility")
+	}
+
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	vm := NewVM(mockServer.client)
+	vm.VM.HREF = mockServer.URL + "/api/vApp/vm-1"
+
+	err := vm.AddMetadataEntryTyped("replicas", "3", types.MetadataTypeNumber, types.MetadataVisibilityReadOnly, true)
+	if err != nil {
+		t.Fatalf("error adding metadata: %s", err)
+	}
+	requests := mockServer.recordedRequests()
+	if !strings.Contains(requests, "PUT /api/vApp/vm-1/metadata/SYSTEM/replicas\n") || !strings.Contains(requests, `type="MetadataNumberValue"`) {
+		t.Errorf("unexpected requests:\n%s", requests)
+	}
+
+	mockServer.requests = nil
+	err = vm.AddMetadataEntryTyped("replicas", "3", types.MetadataType("Number"), types.MetadataVisibilityReadOnly, true)
+	if !errors.Is(err, ErrInvalidMetadataValue) {
+		t.Errorf("expected ErrInvalidMetadataValue for an invalid type, got: %v", err)
+	}
+	err = vm.AddMetadataEntryTyped("replicas", "3", types.MetadataTypeNumber, types.MetadataVisibility("HIDDEN"), true)
+	if !errors.Is(err, ErrInvalidMetadataValue) {
+		t.Errorf("expected ErrInvalidMetadataValue for an invalid visibility, got: %v", err)
+	}
+	if requests := mockServer.recordedRequests(); requests != "" {
+		t.Errorf("expected no requests to be sent, got:\n%s", requests)
+	}
+}
+
+// Test_CreateWithMetadataValidation checks that invalid metadata, protected SYSTEM keys and SYSTEM entries with
+// READWRITE visibility are rejected before creating a vApp or a VM
+func Test_CreateWithMetadataValidation(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	vdc := NewVdc(mockServer.client)
+	vdc.Vdc.HREF = mockServer.URL + "/api/vdc/vdc-1"
+	vapp := NewVApp(mockServer.client)
+	vapp.VApp.HREF = mockServer.URL + "/api/vApp/vapp-1"
+
+	invalidMetadata := map[string]types.MetadataValue{
+		"replicas": {TypedValue: &types.MetadataTypedValue{XsiType: "MetadataIntegerValue", Value: "3"}},
+	}
+	_, err := vdc.CreateRawVAppWithMetadata("vapp", "", invalidMetadata)
+	if err == nil || !strings.Contains(err.Error(), "unknown metadata type") {
+		t.Errorf("expected a validation error, got: %v", err)
+	}
+	_, err = vapp.AddRawVMWithMetadata(&types.ReComposeVAppParams{}, invalidMetadata)
+	if err == nil || !strings.Contains(err.Error(), "unknown metadata type") {
+		t.Errorf("expected a validation error, got: %v", err)
+	}
+
+	mockServer.client.ProtectSystemMetadataKeys = []string{"billing"}
+	systemValue := func(visibility string) types.MetadataValue {
+		return types.MetadataValue{
+			Domain:     &types.MetadataDomainTag{Domain: "SYSTEM", Visibility: visibility},
+			TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "value"},
+		}
+	}
+	tests := []struct {
+		metadata      map[string]types.MetadataValue
+		expectedError string
+	}{
+		{metadata: map[string]types.MetadataValue{"billing": systemValue(types.MetadataReadOnlyVisibility)}, expectedError: "protected metadata key 'billing'"},
+		{metadata: map[string]types.MetadataValue{"owner": systemValue(types.MetadataReadWriteVisibility)}, expectedError: "visibility READWRITE in SYSTEM domain"},
+	}
+	for _, tt := range tests {
+		_, err = vdc.CreateRawVAppWithMetadata("vapp", "", tt.metadata)
+		if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+			t.Errorf("expected an error containing \"%s\" creating a vApp, got: %v", tt.expectedError, err)
+		}
+		_, err = vapp.AddRawVMWithMetadata(&types.ReComposeVAppParams{}, tt.metadata)
+		if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+			t.Errorf("expected an error containing \"%s\" creating a VM, got: %v", tt.expectedError, err)
+		}
+	}
+	if requests := mockServer.recordedRequests(); requests != "" {
+		t.Errorf("expected no requests to be sent, got:\n%s", requests)
+	}
+}
+
+// Test_CreateWithMetadata checks that the metadata is merged into a vApp and a VM right after they are created
+func Test_CreateWithMetadata(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.setMaxSupportedVersion("37.0")
+
+	vappTemplate := `<VApp xmlns="http://www.vmware.com/vcloud/v1.5" name="vapp" href="%[1]s/api/vApp/vapp-1">
+  <Children><Vm name="vm" href="%[1]s/api/vApp/vm-1"></Vm></Children>
+</VApp>`
+	mockServer.responses = map[string]string{
+		"POST /api/vdc/vdc-1/action/composeVApp": fmt.Sprintf(vappTemplate, mockServer.URL),
+		"GET /api/vApp/vapp-1":                   fmt.Sprintf(vappTemplate, mockServer.URL),
+		"GET /api/vdc/vdc-1":                     fmt.Sprintf(`<Vdc xmlns="http://www.vmware.com/vcloud/v1.5" name="vdc" href="%s/api/vdc/vdc-1"></Vdc>`, mockServer.URL),
+		"GET /api/vApp/vm-1":                     fmt.Sprintf(`<Vm xmlns="http://www.vmware.com/vcloud/v1.5" name="vm" href="%s/api/vApp/vm-1"></Vm>`, mockServer.URL),
+	}
+	vdc := NewVdc(mockServer.client)
+	vdc.Vdc.HREF = mockServer.URL + "/api/vdc/vdc-1"
+	metadata := map[string]types.MetadataValue{
+		"key": {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "value"}},
+	}
+
+	vapp, err := vdc.CreateRawVAppWithMetadata("vapp", "", metadata)
+	if err != nil {
+		t.Fatalf("unexpected error creating vApp: %s", err)
+	}
+	vm, err := vapp.AddRawVMWithMetadata(&types.ReComposeVAppParams{
+		SourcedItem: &types.SourcedCompositionItemParam{Source: &types.Reference{Name: "vm"}},
+	}, metadata)
+	if err != nil {
+		t.Fatalf("unexpected error creating VM: %s", err)
+	}
+	if vm.VM.HREF != mockServer.URL+"/api/vApp/vm-1" {
+		t.Errorf("expected VM 'vm' to be returned, got %s", vm.VM.HREF)
+	}
+
+	requests := mockServer.recordedRequests()
+	for _, expected := range []string{
+		"POST /api/vdc/vdc-1/action/composeVApp\n",
+		"POST /api/vApp/vapp-1/metadata\n",
+		"POST /api/vApp/vapp-1/action/recomposeVApp\n",
+		"POST /api/vApp/vm-1/metadata\n",
+	} {
+		if !strings.Contains(requests, expected) {
+			t.Errorf("expected request %q, got:\n%s", expected, requests)
+		}
+	}
+	if strings.Index(requests, "POST /api/vApp/vapp-1/metadata") < strings.Index(requests, "POST /api/vdc/vdc-1/action/composeVApp") ||
+		strings.Index(requests, "POST /api/vApp/vm-1/metadata") < strings.Index(requests, "POST /api/vApp/vapp-1/action/recomposeVApp") {
+		t.Errorf("expected metadata to be merged after the creation, got:\n%s", requests)
+	}
+	if strings.Count(requests, "<Key>key</Key>") != 2 {
+		t.Errorf("expected the metadata to be sent once per entity, got:\n%s", requests)
+	}
+}
+
+// Test_SubscribedCatalogItemMetadata checks that metadata changes of items in a subscribed Catalog rejected by VCD
+// return a *MetadataNotSupportedError, while items of local Catalogs and any other failure return the original error
+func Test_SubscribedCatalogItemMetadata(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+
+	catalogTemplate := `<AdminCatalog xmlns="http://www.vmware.com/vcloud/v1.5" name="catalog">
+  <ExternalCatalogSubscriptionParams><SubscribeToExternalFeeds>%t</SubscribeToExternalFeeds></ExternalCatalogSubscriptionParams>
+</AdminCatalog>`
+	mockServer.failingRequests = []string{"DELETE /api/catalogItem/item-1/metadata/key"}
+	mockServer.failureStatus = http.StatusBadRequest
+
+	catalogItem := NewCatalogItem(mockServer.client)
+	catalogItem.CatalogItem.Name = "item"
+	catalogItem.CatalogItem.HREF = mockServer.URL + "/api/catalogItem/item-1"
+	catalogItem.CatalogItem.Link = types.LinkList{
+		{Rel: types.RelUp, Type: types.MimeCatalog, HREF: mockServer.URL + "/api/catalog/catalog-1"},
+	}
+
+	mockServer.metadataResponse = fmt.Sprintf(catalogTemplate, true)
+	err := catalogItem.DeleteMetadataEntryWithDomain("key", false)
+	assertMetadataNotSupported(t, err)
+	if err != nil && !strings.Contains(err.Error(), "read-only") {
+		t.Errorf("expected the error to explain that the metadata is read-only, got: %s", err)
+	}
+	if !strings.Contains(mockServer.recordedRequests(), "GET /api/admin/catalog/catalog-1") {
+		t.Errorf("expected the subscription to be checked in the admin catalog, got:\n%s", mockServer.recordedRequests())
+	}
+
+	mockServer.metadataResponse = fmt.Sprintf(catalogTemplate, false)
+	err = catalogItem.DeleteMetadataEntryWithDomain("key", false)
+	if err == nil {
+		t.Fatalf("expected an error deleting metadata")
+	}
+	if _, ok := err.(*MetadataNotSupportedError); ok {
+		t.Errorf("expected the original error for a local catalog, got: %s", err)
+	}
+
+	for _, status := range []int{http.StatusInternalServerError, http.StatusUnauthorized, http.StatusForbidden} {
+		mockServer.metadataResponse = fmt.Sprintf(catalogTemplate, true)
+		mockServer.failureStatus = status
+		mockServer.requests = nil
+		err = catalogItem.DeleteMetadataEntryWithDomain("key", false)
+		if err == nil {
+			t.Fatalf("expected an error deleting metadata with HTTP status %d", status)
+		}
+		if _, ok := err.(*MetadataNotSupportedError); ok {
+			t.Errorf("expected the original error for HTTP status %d in a subscribed catalog, got: %s", status, err)
+		}
+		if strings.Contains(mockServer.recordedRequests(), "/catalog/catalog-1") {
+			t.Errorf("expected the subscription not to be checked for HTTP status %d, got:\n%s", status, mockServer.recordedRequests())
+		}
+	}
+
+	mockServer.failingRequests = nil
+	mockServer.requests = nil
+	err = catalogItem.DeleteMetadataEntryWithDomain("key", false)
+	if err != nil {
+		t.Fatalf("error deleting metadata: %s", err)
+	}
+	if strings.Contains(mockServer.recordedRequests(), "/catalog/catalog-1") {
+		t.Errorf("expected the subscription not to be checked on success, got:\n%s", mockServer.recordedRequests())
+	}
+}
+
+// Test_RenameMetadataKey checks that a metadata entry is created with the new key, preserving its type and visibility,
+// before deleting the old key, and that an existing new key is only replaced when overwrite is set
+func Test_RenameMetadataKey(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.metadataResponse = `<MetadataValue xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <Domain visibility="READONLY">SYSTEM</Domain>
+  <TypedValue xsi:type="MetadataNumberValue"><Value>42</Value></TypedValue>
+</MetadataValue>`
+
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+
+	err := vm.RenameMetadataKey("owner", "ownerEmail", true)
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected an error for an existing new key, got: %v", err)
+	}
+	if strings.Contains(mockServer.recordedRequests(), "PUT ") {
+		t.Errorf("expected no changes when the new key exists, got:\n%s", mockServer.recordedRequests())
+	}
+
+	mockServer.requests = nil
+	mockServer.failingRequests = []string{"GET /api/vApp/vm-1/metadata/SYSTEM/ownerEmail"}
+	mockServer.failureStatus = http.StatusNotFound
+	err = vm.RenameMetadataKey("owner", "ownerEmail", true)
+	if err != nil {
+		t.Fatalf("error renaming metadata key: %s", err)
+	}
+	requests := mockServer.recordedRequests()
+	putIndex := strings.Index(requests, "PUT /api/vApp/vm-1/metadata/SYSTEM/ownerEmail")
+	deleteIndex := strings.Index(requests, "DELETE /api/vApp/vm-1/metadata/SYSTEM/owner\n")
+	if putIndex < 0 || deleteIndex < putIndex {
+		t.Fatalf("expected the new key to be created before deleting the old one, got:\n%s", requests)
+	}
+	putRequest := requests[putIndex:deleteIndex]
+	if !strings.Contains(putRequest, "MetadataNumberValue") || !strings.Contains(putRequest, `visibility="READONLY"`) {
+		t.Errorf("expected the type and visibility to be preserved, got:\n%s", putRequest)
+	}
+
+	mockServer.requests = nil
+	mockServer.failingRequests = []string{"DELETE /api/vApp/vm-1/metadata/SYSTEM/owner"}
+	mockServer.failureStatus = http.StatusInternalServerError
+	err = vm.RenameMetadataKeyWithOverwrite("owner", "ownerEmail", true, true)
+	if err == nil || !strings.Contains(err.Error(), "rolled back") {
+		t.Errorf("expected a rolled back error, got: %v", err)
+	}
+	if strings.Count(mockServer.recordedRequests(), "PUT /api/vApp/vm-1/metadata/SYSTEM/ownerEmail") != 2 {
+		t.Errorf("expected the previous value of the new key to be restored, got:\n%s", mockServer.recordedRequests())
+	}
+}
+
+// Test_SetMetadataIfValueEquals checks that the metadata is only written when the current value matches the expected
+// one, and that an empty expected value requires a missing key
+func Test_SetMetadataIfValueEquals(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.metadataResponse = `<MetadataValue xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <TypedValue xsi:type="MetadataNumberValue"><Value>7</Value></TypedValue>
+</MetadataValue>`
+
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+	keyPath := "/api/vApp/vm-1/metadata/counter"
+
+	tests := []struct {
+		name            string
+		keyMissing      bool
+		expectedCurrent string
+		wantSet         bool
+	}{
+		{name: "Matches", expectedCurrent: "7", wantSet: true},
+		{name: "MatchesCanonical", expectedCurrent: "07", wantSet: true},
+		{name: "Differs", expectedCurrent: "6", wantSet: false},
+		{name: "ExpectedMissingButPresent", expectedCurrent: "", wantSet: false},
+		{name: "MissingAndExpectedMissing", keyMissing: true, expectedCurrent: "", wantSet: true},
+		{name: "MissingButExpectedValue", keyMissing: true, expectedCurrent: "7", wantSet: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockServer.requests = nil
+			mockServer.failingRequests = nil
+			if tt.keyMissing {
+				mockServer.failingRequests = []string{"GET " + keyPath}
+				mockServer.failureStatus = http.StatusNotFound
+			}
+
+			set, err := vm.SetMetadataIfValueEquals("counter", tt.expectedCurrent, "8", types.MetadataNumberValue, types.MetadataReadWriteVisibility, false)
+			if err != nil {
+				t.Fatalf("error setting metadata: %s", err)
+			}
+			if set != tt.wantSet {
+				t.Errorf("expected set to be %t, got %t", tt.wantSet, set)
+			}
+			if strings.Contains(mockServer.recordedRequests(), "PUT "+keyPath) != tt.wantSet {
+				t.Errorf("unexpected requests:\n%s", mockServer.recordedRequests())
+			}
+		})
+	}
+}
+
+// Test_IncrementNumberMetadata checks that IncrementNumberMetadata adds delta to the current value, creates missing
+// keys, retries when the value is modified concurrently and gives up after metadataIncrementMaxAttempts attempts
+func Test_IncrementNumberMetadata(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	numberResponse := `<MetadataValue xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <Domain visibility="READONLY">SYSTEM</Domain>
+  <TypedValue xsi:type="MetadataNumberValue"><Value>%d</Value></TypedValue>
+</MetadataValue>`
+	// current is the value returned by every read, and concurrentWriter, when set, changes it after each read
+	current := int64(7)
+	var concurrentWriter func()
+	mockServer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mockServer.mutex.Lock()
+		if r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/metadata/SYSTEM/counter") {
+			mockServer.metadataResponse = fmt.Sprintf(numberResponse, current)
+			if concurrentWriter != nil {
+				concurrentWriter()
+			}
+		}
+		mockServer.mutex.Unlock()
+		mockServer.handler(w, r)
+	})
+
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+	keyPath := "/api/vApp/vm-1/metadata/SYSTEM/counter"
+
+	value, err := vm.IncrementNumberMetadata("counter", 3, true)
+	if err != nil {
+		t.Fatalf("error incrementing metadata: %s", err)
+	}
+	if value != 10 {
+		t.Errorf("expected value 10, got %d", value)
+	}
+	if requests := mockServer.recordedRequests(); strings.Count(requests, "PUT "+keyPath) != 1 {
+		t.Errorf("expected one write, got:\n%s", requests)
+	}
+
+	// The value changes once between the first read and the compare-and-set, so the increment is retried
+	mockServer.requests = nil
+	concurrentWriter = func() {
+		current = 9
+		concurrentWriter = nil
+	}
+	value, err = vm.IncrementNumberMetadata("counter", 1, true)
+	if err != nil {
+		t.Fatalf("error incrementing metadata: %s", err)
+	}
+	if value != 10 {
+		t.Errorf("expected value 10 after retrying, got %d", value)
+	}
+	requests := mockServer.recordedRequests()
+	if strings.Count(requests, "GET "+keyPath) != 4 || strings.Count(requests, "PUT "+keyPath) != 1 {
+		t.Errorf("expected two attempts and one write, got:\n%s", requests)
+	}
+
+	// The value changes after every read, so the increment gives up
+	mockServer.requests = nil
+	concurrentWriter = func() {
+		current++
+	}
+	_, err = vm.IncrementNumberMetadata("counter", 1, true)
+	if err == nil || !strings.Contains(err.Error(), "modified concurrently") {
+		t.Errorf("expected an error about concurrent modifications, got: %v", err)
+	}
+	if requests := mockServer.recordedRequests(); strings.Contains(requests, "PUT "+keyPath) {
+		t.Errorf("expected no writes, got:\n%s", requests)
+	}
+
+	// A missing key is created with delta as value
+	mockServer.requests = nil
+	concurrentWriter = nil
+	mockServer.failingRequests = []string{"GET " + keyPath}
+	mockServer.failureStatus = http.StatusNotFound
+	value, err = vm.IncrementNumberMetadata("counter", 5, true)
+	if err != nil {
+		t.Fatalf("error incrementing missing metadata: %s", err)
+	}
+	if value != 5 {
+		t.Errorf("expected value 5, got %d", value)
+	}
+	if requests := mockServer.recordedRequests(); !strings.Contains(requests, "PUT "+keyPath) {
+		t.Errorf("expected the key to be created, got:\n%s", requests)
+	}
+}
+
+// Test_AddMetadataEntryWithVisibilityVerified checks that the added entry is read back until it is visible with the
+// requested value, and that an error is returned without writing it again when it never converges
+func Test_AddMetadataEntryWithVisibilityVerified(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	valueTemplate := `<MetadataValue xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <Domain visibility="READWRITE">GENERAL</Domain>
+  <TypedValue xsi:type="MetadataStringValue"><Value>%s</Value></TypedValue>
+</MetadataValue>`
+	// staleReads is the number of reads that return the old value before the new one is visible
+	staleReads := 0
+	mockServer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mockServer.mutex.Lock()
+		if r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/metadata/owner") {
+			mockServer.metadataResponse = fmt.Sprintf(valueTemplate, "team-b")
+			if staleReads > 0 {
+				mockServer.metadataResponse = fmt.Sprintf(valueTemplate, "team-a")
+				staleReads--
+			}
+		}
+		mockServer.mutex.Unlock()
+		mockServer.handler(w, r)
+	})
+
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+	keyPath := "/api/vApp/vm-1/metadata/owner"
+
+	tests := []struct {
+		name       string
+		staleReads int
+		retries    int
+		wantReads  int
+		wantErr    bool
+	}{
+		{name: "VisibleImmediately", staleReads: 0, retries: 3, wantReads: 1},
+		{name: "VisibleAfterStaleRead", staleReads: 1, retries: 3, wantReads: 2},
+		{name: "NeverVisible", staleReads: 10, retries: 2, wantReads: 2, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockServer.requests = nil
+			staleReads = tt.staleReads
+			err := vm.AddMetadataEntryWithVisibilityVerified("owner", "team-b", types.MetadataStringValue, types.MetadataReadWriteVisibility, false, tt.retries)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error: %t, got: %v", tt.wantErr, err)
+			}
+			requests := mockServer.recordedRequests()
+			if strings.Count(requests, "PUT "+keyPath) != 1 || strings.Count(requests, "GET "+keyPath) != tt.wantReads {
+				t.Errorf("expected one write and %d reads, got:\n%s", tt.wantReads, requests)
+			}
+		})
+	}
+}
+
+// Test_MetadataGetByKeyAndKeys checks the lookup of metadata entries by key and the sorted keys of each domain
+func Test_MetadataGetByKeyAndKeys(t *testing.T) {
+	metadata := &types.Metadata{MetadataEntry: []*types.MetadataEntry{
+		{Key: "zone", TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "eu"}},
+		{Key: "app", Domain: &types.MetadataDomainTag{Domain: "GENERAL", Visibility: types.MetadataReadOnlyVisibility}},
+		{Key: "zone", Domain: &types.MetadataDomainTag{Domain: "SYSTEM", Visibility: types.MetadataReadOnlyVisibility}},
+		nil,
+	}}
+
+	entry, found := metadata.GetByKey("zone", false)
+	if !found || entry.TypedValue == nil || entry.TypedValue.Value != "eu" {
+		t.Errorf("expected the GENERAL entry 'zone', got: %v, %t", entry, found)
+	}
+	entry, found = metadata.GetByKey("zone", true)
+	if !found || entry.Domain == nil || entry.Domain.Domain != "SYSTEM" {
+		t.Errorf("expected the SYSTEM entry 'zone', got: %v, %t", entry, found)
+	}
+	if _, found = metadata.GetByKey("app", true); found {
+		t.Errorf("expected 'app' not to be found in SYSTEM domain")
+	}
+
+	if keys := metadata.Keys(false); !reflect.DeepEqual(keys, []string{"app", "zone"}) {
+		t.Errorf("unexpected GENERAL keys: %v", keys)
+	}
+	if keys := metadata.Keys(true); !reflect.DeepEqual(keys, []string{"zone"}) {
+		t.Errorf("unexpected SYSTEM keys: %v", keys)
+	}
+
+	var nilMetadata *types.Metadata
+	if _, found = nilMetadata.GetByKey("zone", false); found || nilMetadata.Keys(false) != nil {
+		t.Errorf("expected nil metadata to have no entries")
+	}
+}
+
+// Test_DiskMetadataTransport checks that disk metadata uses the XML HREF by default, and the OpenAPI endpoint only when
+// Client.OpenApiDiskMetadata is set, the disk has an OpenAPI ID and the negotiated API version supports it. The
+// asynchronous variants are only available with the XML API
+func Test_DiskMetadataTransport(t *testing.T) {
+	diskId := "urn:vcloud:disk:5a9b8c7d-6e5f-4a3b-2c1d-0e9f8a7b6c5d"
+	openApiPath := "/cloudapi/1.0.0/disks/" + diskId + "/metadata/"
+	tests := []struct {
+		name         string
+		apiVersion   string
+		id           string
+		openApi      bool
+		expectedPath string
+	}{
+		{name: "Default", apiVersion: "38.0", id: diskId, expectedPath: "/api/disk/disk-1/metadata/"},
+		{name: "OpenApi", apiVersion: "38.0", id: diskId, openApi: true, expectedPath: openApiPath},
+		{name: "OldApiVersion", apiVersion: "37.0", id: diskId, openApi: true, expectedPath: "/api/disk/disk-1/metadata/"},
+		{name: "NoOpenApiId", apiVersion: "38.0", id: "", openApi: true, expectedPath: "/api/disk/disk-1/metadata/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockServer := newMetadataMockServer(t)
+			defer mockServer.Close()
+			mockServer.setMaxSupportedVersion(tt.apiVersion)
+			mockServer.client.APIVersion = tt.apiVersion
+			mockServer.client.OpenApiDiskMetadata = tt.openApi
+			mockServer.openApiResponse = `[
+  {"id": "urn:vcloud:metadata:1", "keyValue": {"domain": "TENANT", "key": "key1", "value": {"value": "one", "type": "StringEntry"}}},
+  {"id": "urn:vcloud:metadata:2", "keyValue": {"domain": "TENANT", "key": "key2", "value": {"value": "two", "type": "StringEntry"}}}
+]`
+
+			disk := NewDisk(mockServer.client)
+			disk.Disk = &types.Disk{HREF: mockServer.URL + "/api/disk/disk-1", Id: tt.id}
+
+			_, err := disk.GetMetadata()
+			if err != nil {
+				t.Fatalf("error retrieving disk metadata: %s", err)
+			}
+			err = disk.AddMetadataEntryWithVisibility("key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+			if err != nil {
+				t.Fatalf("error adding disk metadata: %s", err)
+			}
+			_, err = disk.GetMetadataAsMap(false)
+			if err != nil {
+				t.Fatalf("error retrieving disk metadata as a map: %s", err)
+			}
+			err = disk.DeleteMetadataEntriesWithDomain([]string{"key1", "key2"}, false)
+			if err != nil {
+				t.Fatalf("error deleting disk metadata entries: %s", err)
+			}
+			// Only the request path matters here, the mock server doesn't return the value of the key
+			_, _ = disk.GetTypedMetadataByKey("key", false)
+
+			_, err = disk.DeleteMetadataEntryWithDomainAsync("key", false)
+			var notSupportedError *MetadataNotSupportedError
+			if tt.expectedPath == openApiPath {
+				if !errors.As(err, &notSupportedError) {
+					t.Errorf("expected a *MetadataNotSupportedError for an asynchronous OpenAPI operation, got: %v", err)
+				}
+			} else if err != nil {
+				t.Fatalf("error deleting disk metadata asynchronously: %s", err)
+			}
+
+			mockServer.mutex.Lock()
+			defer mockServer.mutex.Unlock()
+			for _, request := range mockServer.requests {
+				requestLine := strings.SplitN(request, "\n", 2)[0]
+				if !strings.Contains(requestLine, " "+tt.expectedPath) {
+					t.Errorf("expected a request to %s, got: %s", tt.expectedPath, requestLine)
+				}
+			}
+		})
+	}
+}
+
+// Test_CountMetadata checks that the metadata entries are counted by domain with a single request
+func Test_CountMetadata(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.metadataResponse = `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <MetadataEntry><Key>app</Key><TypedValue xsi:type="MetadataStringValue"><Value>web</Value></TypedValue></MetadataEntry>
+  <MetadataEntry><Domain visibility="READONLY">GENERAL</Domain><Key>tier</Key><TypedValue xsi:type="MetadataStringValue"><Value>gold</Value></TypedValue></MetadataEntry>
+  <MetadataEntry><Domain visibility="PRIVATE">SYSTEM</Domain><Key>cost</Key><TypedValue xsi:type="MetadataNumberValue"><Value>3</Value></TypedValue></MetadataEntry>
+</Metadata>`
+
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+
+	for isSystem, expected := range map[bool]int{false: 2, true: 1} {
+		mockServer.requests = nil
+		count, err := vm.CountMetadata(isSystem)
+		if err != nil {
+			t.Fatalf("error counting metadata: %s", err)
+		}
+		if count != expected {
+			t.Errorf("expected %d entries with isSystem=%t, got %d", expected, isSystem, count)
+		}
+		if len(mockServer.requests) != 1 {
+			t.Errorf("expected a single request, got:\n%s", mockServer.recordedRequests())
+		}
+	}
+}
+
+// Test_QueryByMetadata checks that the query filters by the metadata key and value of the requested domain, and that
+// unsupported query types are rejected
+func Test_QueryByMetadata(t *testing.T) {
+	var rawQueries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rawQueries = append(rawQueries, r.URL.RawQuery)
+		_, _ = fmt.Fprint(w, `<QueryResultRecords xmlns="http://www.vmware.com/vcloud/v1.5" page="1" pageSize="25" total="1">
+  <VMRecord name="vm-1" href="https://vcd.example.com/api/vApp/vm-1"/>
+</QueryResultRecords>`)
+	}))
+	defer server.Close()
+
+	vcdHref, err := url.ParseRequestURI(server.URL + "/api")
+	if err != nil {
+		t.Fatalf("error parsing server URL: %s", err)
+	}
+	vcdClient := &VCDClient{Client: Client{APIVersion: "37.0", VCDHREF: *vcdHref, Http: http.Client{}}}
+
+	tests := []struct {
+		isSystem       bool
+		expectedFilter string
+	}{
+		{isSystem: false, expectedFilter: "filter=metadata:team==STRING:web ops"},
+		{isSystem: true, expectedFilter: "filter=metadata@SYSTEM:team==STRING:web ops"},
+	}
+	for _, tt := range tests {
+		rawQueries = nil
+		records, err := vcdClient.QueryByMetadata(types.QtVm, "team", "web ops", tt.isSystem)
+		if err != nil {
+			t.Fatalf("error querying by metadata: %s", err)
+		}
+		if len(records) != 1 || len(records[0].VMRecord) != 1 || records[0].VMRecord[0].Name != "vm-1" {
+			t.Errorf("unexpected records: %+v", records)
+		}
+		if len(rawQueries) != 1 {
+			t.Fatalf("expected a single query, got: %v", rawQueries)
+		}
+		query, err := url.QueryUnescape(rawQueries[0])
+		if err != nil {
+			t.Fatalf("error unescaping query: %s", err)
+		}
+		query, err = url.QueryUnescape(query)
+		if err != nil {
+			t.Fatalf("error unescaping query: %s", err)
+		}
+		if !strings.Contains(query, tt.expectedFilter) || !strings.Contains(query, "type=vm") {
+			t.Errorf("expected query to contain '%s' and 'type=vm', got: %s", tt.expectedFilter, query)
+		}
+	}
+
+	rawQueries = nil
+	_, err = vcdClient.QueryByMetadata("unknownType", "team", "web", false)
+	if err == nil || len(rawQueries) != 0 {
+		t.Errorf("expected an error without queries for an unsupported type, got: %v, %v", err, rawQueries)
+	}
+}
+
+// Test_QueryListByMetadata checks that the metadata filters of the VM and vApp list queries are ANDed in the query
+// filter, together with the parent filter, and that empty filters are rejected without querying VCD
+func Test_QueryListByMetadata(t *testing.T) {
+	var rawQueries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rawQueries = append(rawQueries, r.URL.RawQuery)
+		_, _ = fmt.Fprint(w, `<QueryResultRecords xmlns="http://www.vmware.com/vcloud/v1.5" page="1" pageSize="25" total="2">
+  <VMRecord name="vm-1" href="https://vcd.example.com/api/vApp/vm-1"/>
+  <VAppRecord name="vapp-1" href="https://vcd.example.com/api/vApp/vapp-1"/>
+</QueryResultRecords>`)
+	}))
+	defer server.Close()
+
+	vcdHref, err := url.ParseRequestURI(server.URL + "/api")
+	if err != nil {
+		t.Fatalf("error parsing server URL: %s", err)
+	}
+	client := &Client{APIVersion: "37.0", VCDHREF: *vcdHref, Http: http.Client{}}
+	vdc := &Vdc{Vdc: &types.Vdc{HREF: "https://vcd.example.com/api/vdc/vdc-1"}, client: client}
+	metadataFilters := map[string]MetadataFilter{
+		"team": {Type: "STRING", Value: "web ops"},
+		"tier": {Type: "NUMBER", Value: "3"},
+	}
+
+	unescapedQuery := func() string {
+		if len(rawQueries) != 1 {
+			t.Fatalf("expected a single query, got: %v", rawQueries)
+		}
+		query, err := url.QueryUnescape(rawQueries[0])
+		if err != nil {
+			t.Fatalf("error unescaping query: %s", err)
+		}
+		query, err = url.QueryUnescape(query)
+		if err != nil {
+			t.Fatalf("error unescaping query: %s", err)
+		}
+		return query
+	}
+
+	rawQueries = nil
+	vms, err := vdc.QueryVmListByMetadata(types.VmQueryFilterOnlyDeployed, metadataFilters, false)
+	if err != nil {
+		t.Fatalf("error querying VMs by metadata: %s", err)
+	}
+	if len(vms) != 1 || vms[0].Name != "vm-1" {
+		t.Errorf("unexpected VMs: %+v", vms)
+	}
+	query := unescapedQuery()
+	expectedParts := []string{
+		"filter=(isVAppTemplate==false;vdc==https://vcd.example.com/api/vdc/vdc-1;",
+		"metadata:team==STRING:web ops",
+		"metadata:tier==NUMBER:3",
+		"type=vm",
+	}
+	for _, part := range expectedParts {
+		if !strings.Contains(query, part) {
+			t.Errorf("expected query to contain '%s', got: %s", part, query)
+		}
+	}
+
+	rawQueries = nil
+	vApps, err := client.QueryVappListByMetadata(map[string]MetadataFilter{"tier": {Type: "NUMBER", Value: "3"}}, true)
+	if err != nil {
+		t.Fatalf("error querying vApps by metadata: %s", err)
+	}
+	if len(vApps) != 1 || vApps[0].Name != "vapp-1" {
+		t.Errorf("unexpected vApps: %+v", vApps)
+	}
+	query = unescapedQuery()
+	expectedFilter := "filter=metadata@SYSTEM:tier==NUMBER:3"
+	if !strings.Contains(query, expectedFilter) || !strings.Contains(query, "type=vApp") {
+		t.Errorf("expected query to contain '%s' and 'type=vApp', got: %s", expectedFilter, query)
+	}
+
+	rawQueries = nil
+	_, err = vdc.QueryVmListByMetadata(types.VmQueryFilterAll, nil, false)
+	if err == nil || len(rawQueries) != 0 {
+		t.Errorf("expected an error without queries for empty VM metadata filters, got: %v, %v", err, rawQueries)
+	}
+	_, err = client.QueryVappListByMetadata(nil, false)
+	if err == nil || len(rawQueries) != 0 {
+		t.Errorf("expected an error without queries for empty vApp metadata filters, got: %v, %v", err, rawQueries)
+	}
+}
+
+// Test_GetMetadataHistory checks that the metadata history of a VM is built from its own metadata tasks, sorted by
+// start date, that the task names are grouped in the query filter, and that invalid task dates are reported
+func Test_GetMetadataHistory(t *testing.T) {
+	var rawQueries []string
+	endDate := `endDate="2023-05-02T10:00:01.500Z"`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rawQueries = append(rawQueries, r.URL.RawQuery)
+		_, _ = fmt.Fprintf(w, `<QueryResultRecords xmlns="http://www.vmware.com/vcloud/v1.5" page="1" pageSize="25" total="4">
+  <TaskRecord name="metadataDelete" operationFull="Deleting metadata" ownerName="bob" status="running" startDate="2023-05-03T08:00:00.000Z" object="https://vcd.example.com/api/vApp/vm-1" href="https://vcd.example.com/api/task/task-2"/>
+  <TaskRecord name="metadataUpdate" operationFull="Updating metadata" ownerName="alice" status="success" startDate="2023-05-02T10:00:00.000+02:00" %s object="https://vcd.example.com/api/vApp/vm-1" href="https://vcd.example.com/api/task/task-1"/>
+  <TaskRecord name="vappDeploy" operationFull="Deploying vApp" ownerName="alice" status="success" startDate="2023-05-01T10:00:00.000Z" object="https://vcd.example.com/api/vApp/vm-1" href="https://vcd.example.com/api/task/task-0"/>
+  <TaskRecord name="metadataDelete" operationFull="Deleting metadata" ownerName="bob" status="success" startDate="2023-05-01T09:00:00.000Z" object="https://vcd.example.com/api/vApp/vm-2" href="https://vcd.example.com/api/task/task-3"/>
+</QueryResultRecords>`, endDate)
+	}))
+	defer server.Close()
+
+	vcdHref, err := url.ParseRequestURI(server.URL + "/api")
+	if err != nil {
+		t.Fatalf("error parsing server URL: %s", err)
+	}
+	client := &Client{APIVersion: "37.0", VCDHREF: *vcdHref, Http: http.Client{}}
+	vm := NewVM(client)
+	vm.VM.HREF = "https://vcd.example.com/api/vApp/vm-1"
+
+	history, err := vm.GetMetadataHistory()
+	if err != nil {
+		t.Fatalf("error getting metadata history: %s", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("expected 2 metadata changes, got: %+v", history)
+	}
+	if history[0].TaskHref != "https://vcd.example.com/api/task/task-1" || history[0].Operation != MetadataTaskUpdate ||
+		history[0].User != "alice" || history[0].Status != "success" || history[0].Description != "Updating metadata" {
+		t.Errorf("unexpected first metadata change: %+v", history[0])
+	}
+	if !history[0].StartDate.Equal(time.Date(2023, 5, 2, 8, 0, 0, 0, time.UTC)) ||
+		!history[0].EndDate.Equal(time.Date(2023, 5, 2, 10, 0, 1, 500000000, time.UTC)) {
+		t.Errorf("unexpected dates of the first metadata change: %s, %s", history[0].StartDate, history[0].EndDate)
+	}
+	if history[1].Operation != MetadataTaskDelete || history[1].User != "bob" || !history[1].EndDate.IsZero() {
+		t.Errorf("unexpected second metadata change: %+v", history[1])
+	}
+
+	if len(rawQueries) != 1 {
+		t.Fatalf("expected a single query, got: %v", rawQueries)
+	}
+	query, err := url.QueryUnescape(rawQueries[0])
+	if err != nil {
+		t.Fatalf("error unescaping query: %s", err)
+	}
+	query, err = url.QueryUnescape(query)
+	if err != nil {
+		t.Fatalf("error unescaping query: %s", err)
+	}
+	for _, expected := range []string{"type=task", "filter=object==" + vm.VM.HREF + ";(name==metadataUpdate,name==metadataDelete)"} {
+		if !strings.Contains(query, expected) {
+			t.Errorf("expected query to contain '%s', got: %s", expected, query)
+		}
+	}
+
+	endDate = `endDate="yesterday"`
+	_, err = vm.GetMetadataHistory()
+	if err == nil || !strings.Contains(err.Error(), "task-1") {
+		t.Errorf("expected an error for the invalid end date of task-1, got: %v", err)
+	}
+
+	_, err = NewVM(client).GetMetadataHistory()
+	if err == nil {
+		t.Errorf("expected an error for a VM without HREF")
+	}
+}
+
+// Test_AddMetadataValueEntry checks that a pre-built metadata value is added with its own type, domain and visibility,
+// and that invalid values are rejected without contacting VCD
+func Test_AddMetadataValueEntry(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+
+	tests := []struct {
+		name         string
+		key          string
+		value        *types.MetadataValue
+		wantRequest  string
+		wantInBodies []string
+	}{
+		{
+			name: "SystemNumber",
+			key:  "tier",
+			value: &types.MetadataValue{
+				Domain:     &types.MetadataDomainTag{Domain: "SYSTEM", Visibility: types.MetadataReadOnlyVisibility},
+				TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataNumberValue, Value: "3"},
+			},
+			wantRequest:  "PUT /api/vApp/vm-1/metadata/SYSTEM/tier",
+			wantInBodies: []string{"MetadataNumberValue", ">3<", `visibility="READONLY"`, ">SYSTEM<"},
+		},
+		{
+			name: "WithoutDomain",
+			key:  "owner",
+			value: &types.MetadataValue{
+				TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "team-a"},
+			},
+			wantRequest:  "PUT /api/vApp/vm-1/metadata/owner",
+			wantInBodies: []string{"MetadataStringValue", ">team-a<", `visibility="READWRITE"`, ">GENERAL<"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockServer.requests = nil
+			err := vm.AddMetadataValueEntry(tt.key, tt.value)
+			if err != nil {
+				t.Fatalf("error adding metadata value: %s", err)
+			}
+			requests := mockServer.recordedRequests()
+			if !strings.HasPrefix(requests, tt.wantRequest+"\n") {
+				t.Errorf("expected request '%s', got: %s", tt.wantRequest, requests)
+			}
+			for _, wanted := range tt.wantInBodies {
+				if !strings.Contains(requests, wanted) {
+					t.Errorf("expected request body to contain '%s', got: %s", wanted, requests)
+				}
+			}
+		})
+	}
+
+	invalidValues := []*types.MetadataValue{
+		nil,
+		{Domain: &types.MetadataDomainTag{Domain: "GENERAL", Visibility: types.MetadataReadWriteVisibility}},
+		{
+			Domain:     &types.MetadataDomainTag{Domain: "OTHER", Visibility: types.MetadataReadWriteVisibility},
+			TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "v"},
+		},
+		{TypedValue: &types.MetadataTypedValue{XsiType: "MetadataUnknownValue", Value: "v"}},
+	}
+	for _, invalid := range invalidValues {
+		mockServer.requests = nil
+		err := vm.AddMetadataValueEntry("owner", invalid)
+		var validationError *MetadataValidationError
+		if !errors.As(err, &validationError) {
+			t.Errorf("expected a *MetadataValidationError for value %+v, got: %v", invalid, err)
+		}
+		if requests := mockServer.recordedRequests(); requests != "" {
+			t.Errorf("expected no requests for value %+v, got: %s", invalid, requests)
+		}
+	}
+}
+
+// Test_SyncSecurityTagsAndMetadata checks that security tags are mirrored into prefixed GENERAL metadata and back,
+// removing the stale ones on both sides without touching the rest of the metadata
+func Test_SyncSecurityTagsAndMetadata(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.setMaxSupportedVersion("37.0")
+	entryTemplate := `<MetadataEntry><Domain visibility="READWRITE">%s</Domain><Key>%s</Key><TypedValue xsi:type="MetadataStringValue"><Value>%s</Value></TypedValue></MetadataEntry>`
+	mockServer.metadataResponse = `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">` +
+		fmt.Sprintf(entryTemplate, "GENERAL", "sectag.web", "web") +
+		fmt.Sprintf(entryTemplate, "GENERAL", "sectag.old", "old") +
+		fmt.Sprintf(entryTemplate, "SYSTEM", "sectag.system", "system") +
+		fmt.Sprintf(entryTemplate, "GENERAL", "owner", "team-a") +
+		`</Metadata>`
+	securityTagsPath := "/cloudapi/1.0.0/securityTags/vm/urn:vcloud:vm:1"
+	securityTags := `{"tags": ["web", "db"]}`
+	mockServer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == securityTagsPath {
+			w.Header().Set("Content-Type", types.JSONMime)
+			_, _ = fmt.Fprint(w, securityTags)
+			return
+		}
+		mockServer.handler(w, r)
+	})
+
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{Name: "vm1", ID: "urn:vcloud:vm:1", HREF: mockServer.URL + "/api/vApp/vm-1"}
+
+	err := vm.SyncSecurityTagsToMetadata("sectag.")
+	if err != nil {
+		t.Fatalf("error syncing security tags to metadata: %s", err)
+	}
+	requests := mockServer.recordedRequests()
+	if !strings.Contains(requests, "POST /api/vApp/vm-1/metadata\n") || !strings.Contains(requests, "sectag.db") {
+		t.Errorf("expected a merge of 'sectag.db', got: %s", requests)
+	}
+	if !strings.Contains(requests, "DELETE /api/vApp/vm-1/metadata/sectag.old") {
+		t.Errorf("expected the deletion of 'sectag.old', got: %s", requests)
+	}
+	for _, untouched := range []string{"sectag.web", "sectag.system", "owner"} {
+		if strings.Contains(requests, untouched) {
+			t.Errorf("expected '%s' not to be modified, got: %s", untouched, requests)
+		}
+	}
+
+	mockServer.requests = nil
+	err = vm.SyncMetadataToSecurityTags("sectag.")
+	if err != nil {
+		t.Fatalf("error syncing metadata to security tags: %s", err)
+	}
+	// The JSON payload is indented, so whitespace is removed before comparing
+	requests = strings.Join(strings.Fields(mockServer.recordedRequests()), "")
+	expectedUpdate := "PUT" + securityTagsPath + `{"tags":["old","web"]}`
+	if !strings.Contains(requests, expectedUpdate) {
+		t.Errorf("expected request '%s', got: %s", expectedUpdate, requests)
+	}
+
+	mockServer.requests = nil
+	securityTags = `{"tags": ["WEB", "old"]}`
+	err = vm.SyncMetadataToSecurityTags("sectag.")
+	if err != nil {
+		t.Fatalf("error syncing metadata to security tags: %s", err)
+	}
+	if requests = mockServer.recordedRequests(); strings.Contains(requests, "PUT ") {
+		t.Errorf("expected no update of security tags that are already in sync, got: %s", requests)
+	}
+
+	if vm.SyncSecurityTagsToMetadata("") == nil || vm.SyncMetadataToSecurityTags("") == nil {
+		t.Errorf("expected an error for an empty prefix")
+	}
+}
+
+// Test_GetSystemAndGeneralMetadata checks that SYSTEM metadata is requested from its domain path, that GENERAL
+// metadata only contains the GENERAL entries, and that both stop when the context is cancelled
+func Test_GetSystemAndGeneralMetadata(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	entryTemplate := `<MetadataEntry><Domain visibility="%s">%s</Domain><Key>%s</Key><TypedValue xsi:type="MetadataStringValue"><Value>v</Value></TypedValue></MetadataEntry>`
+	mockServer.metadataResponse = `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">` +
+		fmt.Sprintf(entryTemplate, "READWRITE", "GENERAL", "owner") +
+		fmt.Sprintf(entryTemplate, "READONLY", "SYSTEM", "tier") +
+		`</Metadata>`
+
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+
+	_, err := vm.GetSystemMetadata()
+	if err != nil {
+		t.Fatalf("error getting SYSTEM metadata: %s", err)
+	}
+	if requests := mockServer.recordedRequests(); requests != "GET /api/vApp/vm-1/metadata/SYSTEM/\n" {
+		t.Errorf("expected a single request to the SYSTEM domain path, got: %s", requests)
+	}
+
+	mockServer.requests = nil
+	metadata, err := vm.GetGeneralMetadata()
+	if err != nil {
+		t.Fatalf("error getting GENERAL metadata: %s", err)
+	}
+	if requests := mockServer.recordedRequests(); requests != "GET /api/vApp/vm-1/metadata/\n" {
+		t.Errorf("expected a single request to the metadata path, got: %s", requests)
+	}
+	if len(metadata.MetadataEntry) != 1 || metadata.MetadataEntry[0].Key != "owner" {
+		t.Errorf("expected only the GENERAL entry 'owner', got: %+v", metadata.MetadataEntry)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = vm.GetSystemMetadataCtx(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled for SYSTEM metadata, got: %v", err)
+	}
+	_, err = vm.GetGeneralMetadataCtx(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled for GENERAL metadata, got: %v", err)
+	}
+}
+
+// Test_MetadataValueAndEntryEqual checks the comparison of metadata values and entries, including nil and partial
+// structs
+func Test_MetadataValueAndEntryEqual(t *testing.T) {
+	stringValue := func(value string) *types.MetadataTypedValue {
+		return &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: value}
+	}
+	general := &types.MetadataDomainTag{Domain: "GENERAL", Visibility: types.MetadataReadWriteVisibility}
+	systemReadOnly := &types.MetadataDomainTag{Domain: "SYSTEM", Visibility: types.MetadataReadOnlyVisibility}
+	systemPrivate := &types.MetadataDomainTag{Domain: "SYSTEM", Visibility: types.MetadataPrivateVisibility}
+
+	valueTests := []struct {
+		name  string
+		value *types.MetadataValue
+		other *types.MetadataValue
+		want  bool
+	}{
+		{"both nil", nil, nil, true},
+		{"one nil", &types.MetadataValue{}, nil, false},
+		{"other nil", nil, &types.MetadataValue{}, false},
+		{"both empty", &types.MetadataValue{}, &types.MetadataValue{}, true},
+		{"nil typed value", &types.MetadataValue{TypedValue: stringValue("a")}, &types.MetadataValue{}, false},
+		{"same value", &types.MetadataValue{TypedValue: stringValue("a")}, &types.MetadataValue{TypedValue: stringValue("a")}, true},
+		{"different value", &types.MetadataValue{TypedValue: stringValue("a")}, &types.MetadataValue{TypedValue: stringValue("b")}, false},
+		{"different type", &types.MetadataValue{TypedValue: stringValue("1")},
+			&types.MetadataValue{TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataNumberValue, Value: "1"}}, false},
+		{"not canonical", &types.MetadataValue{TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataNumberValue, Value: "01"}},
+			&types.MetadataValue{TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataNumberValue, Value: "1"}}, false},
+		{"nil domain is general", &types.MetadataValue{TypedValue: stringValue("a"), Domain: general},
+			&types.MetadataValue{TypedValue: stringValue("a")}, true},
+		{"empty domain is general", &types.MetadataValue{TypedValue: stringValue("a"), Domain: &types.MetadataDomainTag{}},
+			&types.MetadataValue{TypedValue: stringValue("a"), Domain: general}, true},
+		{"different domain", &types.MetadataValue{TypedValue: stringValue("a"), Domain: general},
+			&types.MetadataValue{TypedValue: stringValue("a"), Domain: systemReadOnly}, false},
+		{"different visibility", &types.MetadataValue{TypedValue: stringValue("a"), Domain: systemReadOnly},
+			&types.MetadataValue{TypedValue: stringValue("a"), Domain: systemPrivate}, false},
+	}
+	for _, tt := range valueTests {
+		t.Run("Value/"+tt.name, func(t *testing.T) {
+			if got := tt.value.Equal(tt.other); got != tt.want {
+				t.Errorf("Equal() = %t, want %t", got, tt.want)
+			}
+			if got := tt.other.Equal(tt.value); got != tt.want {
+				t.Errorf("Equal() is not symmetric, got %t, want %t", got, tt.want)
+			}
+		})
+	}
+
+	entryTests := []struct {
+		name  string
+		entry *types.MetadataEntry
+		other *types.MetadataEntry
+		want  bool
+	}{
+		{"both nil", nil, nil, true},
+		{"one nil", &types.MetadataEntry{Key: "a"}, nil, false},
+		{"same entry", &types.MetadataEntry{Key: "a", TypedValue: stringValue("x"), Domain: systemReadOnly},
+			&types.MetadataEntry{Key: "a", TypedValue: stringValue("x"), Domain: systemReadOnly}, true},
+		{"ignores HREF", &types.MetadataEntry{Key: "a", HREF: "https://vcd/1", TypedValue: stringValue("x")},
+			&types.MetadataEntry{Key: "a", TypedValue: stringValue("x")}, true},
+		{"different key", &types.MetadataEntry{Key: "a", TypedValue: stringValue("x")},
+			&types.MetadataEntry{Key: "b", TypedValue: stringValue("x")}, false},
+		{"different domain", &types.MetadataEntry{Key: "a", TypedValue: stringValue("x")},
+			&types.MetadataEntry{Key: "a", TypedValue: stringValue("x"), Domain: systemReadOnly}, false},
+	}
+	for _, tt := range entryTests {
+		t.Run("Entry/"+tt.name, func(t *testing.T) {
+			if got := tt.entry.Equal(tt.other); got != tt.want {
+				t.Errorf("Equal() = %t, want %t", got, tt.want)
+			}
+			if got := tt.other.Equal(tt.entry); got != tt.want {
+				t.Errorf("Equal() is not symmetric, got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+// Test_VappNetworkMetadata checks that vApp network metadata uses the HREF of the network cached in the vApp, and that
+// a 404 refreshes the vApp and retries with the new HREF of the network
+func Test_VappNetworkMetadata(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+
+	oldNetworkPath := "/api/network/11111111-1111-1111-1111-111111111111"
+	newNetworkPath := "/api/network/22222222-2222-2222-2222-222222222222"
+	// The refreshed vApp is parsed from the same response as the metadata, so it contains the new network HREF
+	mockServer.metadataResponse = fmt.Sprintf(`<Metadata xmlns="http://www.vmware.com/vcloud/v1.5">
+  <NetworkConfigSection><NetworkConfig networkName="net"><Link href="%s%s"/></NetworkConfig></NetworkConfigSection>
+</Metadata>`, mockServer.URL, newNetworkPath)
+
+	vapp := NewVApp(mockServer.client)
+	vapp.VApp = &types.VApp{
+		HREF: mockServer.URL + "/api/vApp/vapp-1",
+		NetworkConfigSection: &types.NetworkConfigSection{NetworkConfig: []types.VAppNetworkConfiguration{
+			{NetworkName: types.NoneNetwork},
+			{NetworkName: "net", Link: &types.Link{HREF: mockServer.URL + oldNetworkPath}},
+		}},
+	}
+
+	_, err := vapp.GetVappNetworkMetadata("net")
+	if err != nil {
+		t.Fatalf("error retrieving vApp network metadata: %s", err)
+	}
+	expected := fmt.Sprintf("GET %s/metadata/\n", oldNetworkPath)
+	if mockServer.recordedRequests() != expected {
+		t.Errorf("expected requests:\n%s\ngot:\n%s", expected, mockServer.recordedRequests())
+	}
+
+	mockServer.requests = nil
+	mockServer.failingRequests = []string{"PUT " + oldNetworkPath + "/metadata/key"}
+	mockServer.failureStatus = http.StatusNotFound
+	err = vapp.AddVappNetworkMetadataEntryWithVisibility("11111111-1111-1111-1111-111111111111", "key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	if err != nil {
+		t.Fatalf("error adding vApp network metadata after refresh: %s", err)
+	}
+	requests := mockServer.recordedRequests()
+	if !strings.Contains(requests, "GET /api/vApp/vapp-1") || !strings.Contains(requests, "PUT "+newNetworkPath+"/metadata/key") {
+		t.Errorf("expected the vApp to be refreshed and the request retried with the new HREF, got:\n%s", requests)
+	}
+
+	_, err = vapp.GetVappNetworkMetadataByKey("missing", "key", false)
+	if !ContainsNotFound(err) {
+		t.Errorf("expected a not found error for a missing vApp network, got: %v", err)
+	}
+}
+
+// Test_ExportAllMetadata checks that the metadata of all the entities of an Org is exported by URN, and that a
+// failure in a single entity is recorded without stopping the export
+func Test_ExportAllMetadata(t *testing.T) {
+	uuids := map[string]string{
+		"org":         "00000000-0000-0000-0000-000000000000",
+		"vdc":         "11111111-1111-1111-1111-111111111111",
+		"vapp":        "22222222-2222-2222-2222-222222222222",
+		"media":       "33333333-3333-3333-3333-333333333333",
+		"network":     "44444444-4444-4444-4444-444444444444",
+		"vm":          "55555555-5555-5555-5555-555555555555",
+		"catalog":     "66666666-6666-6666-6666-666666666666",
+		"catalogitem": "77777777-7777-7777-7777-777777777777",
+	}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/media/"+uuids["media"]+"/metadata/":
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = fmt.Fprint(w, `<Error xmlns="http://www.vmware.com/vcloud/v1.5" majorErrorCode="500" message="mock failure"></Error>`)
+		case strings.HasSuffix(r.URL.Path, "/metadata/"):
+			_, _ = fmt.Fprint(w, `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <MetadataEntry><Key>owner</Key><TypedValue xsi:type="MetadataStringValue"><Value>ops</Value></TypedValue></MetadataEntry>
+</Metadata>`)
+		case r.URL.Path == "/api/admin/vdc/"+uuids["vdc"]:
+			_, _ = fmt.Fprintf(w, `<AdminVdc xmlns="http://www.vmware.com/vcloud/v1.5">
+  <ResourceEntities>
+    <ResourceEntity href="%[1]s/api/vApp/vapp-%[2]s" name="vapp" type="%[3]s"/>
+    <ResourceEntity href="%[1]s/api/media/%[4]s" name="media" type="%[5]s"/>
+  </ResourceEntities>
+  <AvailableNetworks><Network href="%[1]s/api/network/%[6]s" name="network"/></AvailableNetworks>
+</AdminVdc>`, server.URL, uuids["vapp"], types.MimeVApp, uuids["media"], types.MimeMediaItem, uuids["network"])
+		case r.URL.Path == "/api/vApp/vapp-"+uuids["vapp"]:
+			_, _ = fmt.Fprintf(w, `<VApp xmlns="http://www.vmware.com/vcloud/v1.5">
+  <Children><Vm href="%s/api/vApp/vm-%s" name="vm"/></Children>
+</VApp>`, server.URL, uuids["vm"])
+		case r.URL.Path == "/api/admin/catalog/"+uuids["catalog"]:
+			_, _ = fmt.Fprintf(w, `<AdminCatalog xmlns="http://www.vmware.com/vcloud/v1.5">
+  <CatalogItems><CatalogItem href="%s/api/catalogItem/%s" name="item"/></CatalogItems>
+</AdminCatalog>`, server.URL, uuids["catalogitem"])
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = fmt.Fprint(w, `<Error xmlns="http://www.vmware.com/vcloud/v1.5" majorErrorCode="404" message="not found"></Error>`)
+		}
+	}))
+	defer server.Close()
+
+	vcdHref, err := url.ParseRequestURI(server.URL + "/api")
+	if err != nil {
+		t.Fatalf("error parsing server URL: %s", err)
+	}
+	adminOrg := NewAdminOrg(&Client{APIVersion: "37.0", VCDHREF: *vcdHref, Http: http.Client{}})
+	adminOrg.AdminOrg = &types.AdminOrg{
+		HREF:     server.URL + "/api/admin/org/" + uuids["org"],
+		Name:     "org",
+		Vdcs:     &types.VDCList{Vdcs: []*types.Reference{{HREF: server.URL + "/api/admin/vdc/" + uuids["vdc"], Name: "vdc"}}},
+		Catalogs: &types.CatalogsList{Catalog: []*types.Reference{{HREF: server.URL + "/api/admin/catalog/" + uuids["catalog"], Name: "catalog"}}},
+	}
+
+	snapshot, err := adminOrg.ExportAllMetadata()
+	if _, ok := err.(*MetadataMultiError); !ok {
+		t.Fatalf("expected a *MetadataMultiError, got %T: %v", err, err)
+	}
+	mediaUrn := "urn:vcloud:media:" + uuids["media"]
+	if len(snapshot.Errors) != 1 || snapshot.Errors[mediaUrn] == "" {
+		t.Errorf("expected a single error for %s, got: %v", mediaUrn, snapshot.Errors)
+	}
+	for namespace, uuid := range uuids {
+		urn := fmt.Sprintf("urn:vcloud:%s:%s", namespace, uuid)
+		if urn == mediaUrn {
+			continue
+		}
+		entity, found := snapshot.Entities[urn]
+		if !found {
+			t.Errorf("expected %s to be exported", urn)
+			continue
+		}
+		if entity.Type != namespace || entity.Metadata == nil || len(entity.Metadata.MetadataEntry) != 1 {
+			t.Errorf("unexpected exported entity %s: %+v", urn, entity)
+		}
+	}
+	if len(snapshot.Entities) != len(uuids)-1 {
+		t.Errorf("expected %d exported entities, got %d", len(uuids)-1, len(snapshot.Entities))
+	}
+
+	if _, err = json.Marshal(snapshot); err != nil {
+		t.Errorf("error serializing the snapshot: %s", err)
+	}
+}
+
+// Test_ApplyMetadataSnapshot checks that a snapshot is merged or replaced on the live entities, skipping the ones that
+// no longer exist and the SYSTEM entries when not running as System administrator
+func Test_ApplyMetadataSnapshot(t *testing.T) {
+	vappUuid := "22222222-2222-2222-2222-222222222222"
+	vmUuid := "55555555-5555-5555-5555-555555555555"
+	snapshot := &OrgMetadataSnapshot{
+		OrgName: "org",
+		Entities: map[string]*OrgMetadataSnapshotEntity{
+			"urn:vcloud:vapp:" + vappUuid: {Name: "vapp", Type: "vapp", Metadata: &types.Metadata{MetadataEntry: []*types.MetadataEntry{
+				{Key: "owner", TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "ops"}},
+				{Key: "tier", TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "gold"},
+					Domain: &types.MetadataDomainTag{Domain: "SYSTEM", Visibility: types.MetadataReadOnlyVisibility}},
+			}}},
+			"urn:vcloud:vm:" + vmUuid: {Name: "vm", Type: "vm", Metadata: &types.Metadata{}},
+		},
+	}
+
+	tests := []struct {
+		name             string
+		mode             ApplyMode
+		isSysAdmin       bool
+		expectedWarnings int
+		expectedMethods  []string
+		unexpectedBodies []string
+	}{
+		{name: "merge as tenant", mode: ApplyModeMerge, expectedWarnings: 2, expectedMethods: []string{"POST"}, unexpectedBodies: []string{"gold"}},
+		{name: "merge as sysadmin", mode: ApplyModeMerge, isSysAdmin: true, expectedWarnings: 1, expectedMethods: []string{"POST"}},
+		{name: "replace as tenant", mode: ApplyModeReplace, expectedWarnings: 2, expectedMethods: []string{"POST", "DELETE"}, unexpectedBodies: []string{"gold", "SYSTEM/system-key"}},
+		{name: "replace as sysadmin", mode: ApplyModeReplace, isSysAdmin: true, expectedWarnings: 1, expectedMethods: []string{"POST", "DELETE"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockServer := newMetadataMockServer(t)
+			defer mockServer.Close()
+			mockServer.metadataResponse = `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <MetadataEntry><Key>stale</Key><TypedValue xsi:type="MetadataStringValue"><Value>old</Value></TypedValue></MetadataEntry>
+  <MetadataEntry><Domain visibility="READONLY">SYSTEM</Domain><Key>system-key</Key><TypedValue xsi:type="MetadataStringValue"><Value>old</Value></TypedValue></MetadataEntry>
+</Metadata>`
+			mockServer.failingRequests = []string{"GET /api/vApp/vm-" + vmUuid + "/metadata/"}
+			mockServer.failureStatus = http.StatusNotFound
+			mockServer.client.IsSysAdmin = tt.isSysAdmin
+			adminOrg := NewAdminOrg(mockServer.client)
+			adminOrg.AdminOrg = &types.AdminOrg{Name: "org"}
+
+			warnings, err := adminOrg.ApplyMetadataSnapshotWithWarnings(snapshot, tt.mode)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(warnings) != tt.expectedWarnings {
+				t.Errorf("expected %d warnings, got: %v", tt.expectedWarnings, warnings)
+			}
+			if !strings.Contains(strings.Join(warnings, "\n"), "urn:vcloud:vm:"+vmUuid) {
+				t.Errorf("expected a warning about the VM that no longer exists, got: %v", warnings)
+			}
+
+			methods := map[string]bool{}
+			for _, request := range mockServer.requests {
+				if strings.Contains(request, "/vm-"+vmUuid) && !strings.HasPrefix(request, "GET ") {
+					t.Errorf("unexpected request for the VM that no longer exists: %s", request)
+				}
+				for _, unexpected := range tt.unexpectedBodies {
+					if strings.Contains(request, unexpected) && !strings.HasPrefix(request, "GET ") {
+						t.Errorf("unexpected request containing '%s': %s", unexpected, request)
+					}
+				}
+				methods[strings.Split(request, " ")[0]] = true
+			}
+			for _, method := range tt.expectedMethods {
+				if !methods[method] {
+					t.Errorf("expected a %s request, got: %v", method, mockServer.recordedRequests())
+				}
+			}
+		})
+	}
+
+	adminOrg := NewAdminOrg(&Client{})
+	adminOrg.AdminOrg = &types.AdminOrg{Name: "org"}
+	if err := adminOrg.ApplyMetadataSnapshot(nil, ApplyModeMerge); err == nil {
+		t.Errorf("expected an error applying a nil snapshot")
+	}
+	if err := adminOrg.ApplyMetadataSnapshot(snapshot, ApplyMode(42)); err == nil || !strings.Contains(err.Error(), "unknown mode 42") {
+		t.Errorf("expected an error about the invalid mode, got: %v", err)
+	}
+}
+
+// Test_MetadataWithContextHttpHeaders checks that the HTTP header values set with ContextWithHttpHeaders are sent
+// with the get, add, merge and delete requests of both the XML API and OpenAPI, including the reads done before a
+// change for Client.OnMetadataChange. XML API requests replace the custom header values of the client, while OpenAPI
+// requests send them next to the custom ones, as any other additional header
+func Test_MetadataWithContextHttpHeaders(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	receivedHeaders := map[string][]string{}
+	mockServer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/task/") {
+			api := "XML"
+			if strings.HasPrefix(r.URL.Path, "/cloudapi/") {
+				api = "OpenAPI"
+			}
+			mockServer.mutex.Lock()
+			receivedHeaders[api+" "+r.Method] = append(receivedHeaders[api+" "+r.Method], strings.Join(r.Header.Values("X-Request-ID"), ","))
+			mockServer.mutex.Unlock()
+		}
+		mockServer.handler(w, r)
+	})
+	mockServer.client.SetCustomHeader(map[string]string{"X-Request-ID": "client"})
+	mockServer.client.OnMetadataChange = func(entityHref, key, op string, oldValue, newValue *types.MetadataValue) {}
+
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+	parentCtx := ContextWithHttpHeaders(context.Background(), map[string]string{"X-Request-ID": "parent", "X-Other": "other"})
+	ctx := ContextWithHttpHeaders(parentCtx, map[string]string{"X-Request-ID": "trace-1"})
+
+	_, err := vm.GetMetadataCtx(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error retrieving metadata: %s", err)
+	}
+	err = vm.AddMetadataEntryWithVisibilityCtx(ctx, "key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	if err != nil {
+		t.Fatalf("unexpected error adding metadata: %s", err)
+	}
+	err = vm.MergeMetadataWithMetadataValuesCtx(ctx, map[string]types.MetadataValue{
+		"key": {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "value"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error merging metadata: %s", err)
+	}
+	err = vm.DeleteMetadataEntryWithDomainCtx(ctx, "key", false)
+	if err != nil {
+		t.Fatalf("unexpected error deleting metadata: %s", err)
+	}
+
+	// The OpenAPI metadata entry is created by the merge and updated by the add
+	mockServer.setMaxSupportedVersion("38.0")
+	entityId := "urn:vcloud:entity:vmware:test:1.0.0:5e1b2c3d-4f5a-4b6c-8d7e-9f0a1b2c3d4e"
+	entity := NewOpenApiMetadataEntity(mockServer.client, types.OpenApiEndpointRdeEntitiesMetadata, entityId, nil)
+	_, err = entity.GetMetadataCtx(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error retrieving OpenAPI metadata: %s", err)
+	}
+	err = entity.MergeMetadataWithMetadataValuesCtx(ctx, map[string]types.MetadataValue{
+		"key": {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "value"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error merging OpenAPI metadata: %s", err)
+	}
+	mockServer.openApiResponse = `[{"id": "urn:vcloud:metadata:1", "keyValue": {"domain": "TENANT", "key": "key", "value": {"value": "value", "type": "StringEntry"}}}]`
+	err = entity.AddMetadataEntryWithVisibilityCtx(ctx, "key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	if err != nil {
+		t.Fatalf("unexpected error adding OpenAPI metadata: %s", err)
+	}
+	err = entity.DeleteMetadataEntryWithDomainCtx(ctx, "key", false)
+	if err != nil {
+		t.Fatalf("unexpected error deleting OpenAPI metadata: %s", err)
+	}
+
+	expectedHeaders := map[string]string{
+		"XML " + http.MethodGet:        "trace-1",
+		"XML " + http.MethodPut:        "trace-1",
+		"XML " + http.MethodPost:       "trace-1",
+		"XML " + http.MethodDelete:     "trace-1",
+		"OpenAPI " + http.MethodGet:    "client,trace-1",
+		"OpenAPI " + http.MethodPut:    "client,trace-1",
+		"OpenAPI " + http.MethodPost:   "client,trace-1",
+		"OpenAPI " + http.MethodDelete: "client,trace-1",
+	}
+	for request, expectedHeader := range expectedHeaders {
+		if len(receivedHeaders[request]) == 0 {
+			t.Errorf("expected a %s request", request)
+			continue
+		}
+		for _, header := range receivedHeaders[request] {
+			if header != expectedHeader {
+				t.Errorf("expected X-Request-ID '%s' in %s requests, got '%s'", expectedHeader, request, header)
+			}
+		}
+	}
+
+	// No OpenAPI request is sent once the context is done
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	mockServer.requests = nil
+	err = entity.DeleteMetadataEntryWithDomainCtx(cancelledCtx, "key", false)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+	if requests := mockServer.recordedRequests(); requests != "" {
+		t.Errorf("expected no requests with a cancelled context, got:\n%s", requests)
+	}
+
+	parentHeaders, ok := parentCtx.Value(httpHeadersContextKey{}).(http.Header)
+	if !ok || parentHeaders.Get("X-Request-ID") != "parent" {
+		t.Errorf("expected the parent context not to be modified")
+	}
+}
+
+// Test_DeleteExpiredMetadata checks that the entries whose companion expiry is in the past are deleted together with
+// their companion, and that invalid expiries are reported without deleting anything
+func Test_DeleteExpiredMetadata(t *testing.T) {
+	now := time.Date(2023, 1, 31, 12, 0, 0, 0, time.UTC)
+	if MetadataExpiryKey("owner") != "owner.expiresAt" {
+		t.Errorf("unexpected expiry key: %s", MetadataExpiryKey("owner"))
+	}
+	encoded := EncodeMetadataExpiry(now.In(time.FixedZone("CET", 3600)))
+	if encoded != "2023-01-31T12:00:00Z" {
+		t.Errorf("unexpected encoded expiry: %s", encoded)
+	}
+	decoded, err := DecodeMetadataExpiry(encoded)
+	if err != nil || !decoded.Equal(now) {
+		t.Errorf("expected %s decoding '%s', got %s, %v", now, encoded, decoded, err)
+	}
+
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.metadataResponse = `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <MetadataEntry><Key>expired</Key><TypedValue xsi:type="MetadataStringValue"><Value>a</Value></TypedValue></MetadataEntry>
+  <MetadataEntry><Key>expired.expiresAt</Key><TypedValue xsi:type="MetadataStringValue"><Value>2023-01-31T11:59:59Z</Value></TypedValue></MetadataEntry>
+  <MetadataEntry><Key>valid</Key><TypedValue xsi:type="MetadataStringValue"><Value>b</Value></TypedValue></MetadataEntry>
+  <MetadataEntry><Key>valid.expiresAt</Key><TypedValue xsi:type="MetadataStringValue"><Value>2023-01-31T14:00:00+01:00</Value></TypedValue></MetadataEntry>
+  <MetadataEntry><Key>invalid</Key><TypedValue xsi:type="MetadataStringValue"><Value>c</Value></TypedValue></MetadataEntry>
+  <MetadataEntry><Key>invalid.expiresAt</Key><TypedValue xsi:type="MetadataStringValue"><Value>tomorrow</Value></TypedValue></MetadataEntry>
+  <MetadataEntry><Key>orphan.expiresAt</Key><TypedValue xsi:type="MetadataStringValue"><Value>2023-01-31T12:00:00Z</Value></TypedValue></MetadataEntry>
+  <MetadataEntry><Domain visibility="READONLY">SYSTEM</Domain><Key>system.expiresAt</Key><TypedValue xsi:type="MetadataStringValue"><Value>2000-01-01T00:00:00Z</Value></TypedValue></MetadataEntry>
+</Metadata>`
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+
+	removed, err := vm.DeleteExpiredMetadata(now, false)
+	multiError, ok := err.(*MetadataMultiError)
+	if !ok || len(multiError.Errors) != 1 || multiError.Errors["invalid.expiresAt"] == nil {
+		t.Errorf("expected a *MetadataMultiError for 'invalid.expiresAt', got: %v", err)
+	}
+	if !reflect.DeepEqual(removed, []string{"expired", "orphan"}) {
+		t.Errorf("unexpected removed keys: %v", removed)
+	}
+
+	var deleted []string
+	for _, request := range mockServer.requests {
+		if strings.HasPrefix(request, "DELETE ") {
+			deleted = append(deleted, strings.TrimPrefix(strings.Split(request, "\n")[0], "DELETE /api/vApp/vm-1/metadata/"))
+		}
+	}
+	sort.Strings(deleted)
+	expectedDeleted := []string{"expired", "expired.expiresAt", "orphan.expiresAt"}
+	if !reflect.DeepEqual(deleted, expectedDeleted) {
+		t.Errorf("expected deletions %v, got %v", expectedDeleted, deleted)
+	}
+}
+
+// Test_GetMetadataByKeyDomainAndHref checks the path used for every domain, and that unknown domains are only sent
+// when Client.AllowUnknownMetadataDomains is true
+func Test_GetMetadataByKeyDomainAndHref(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.metadataResponse = `<MetadataValue xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"><TypedValue xsi:type="MetadataStringValue"><Value>value</Value></TypedValue></MetadataValue>`
+	vcdClient := &VCDClient{Client: *mockServer.client}
+	href := mockServer.URL + "/api/vApp/vm-1"
+
+	tests := []struct {
+		domain       string
+		allowUnknown bool
+		expectedPath string
+		expectedErr  string
+	}{
+		{domain: "GENERAL", expectedPath: "/api/vApp/vm-1/metadata/key"},
+		{domain: "system", expectedPath: "/api/vApp/vm-1/metadata/SYSTEM/key"},
+		{domain: "FUTURE", expectedErr: "unknown metadata domain 'FUTURE'"},
+		{domain: "FUTURE", allowUnknown: true, expectedPath: "/api/vApp/vm-1/metadata/FUTURE/key"},
+		{domain: "", allowUnknown: true, expectedErr: "invalid metadata domain ''"},
+		{domain: "SYSTEM/other", allowUnknown: true, expectedErr: "invalid metadata domain 'SYSTEM/other'"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s-%t", tt.domain, tt.allowUnknown), func(t *testing.T) {
+			mockServer.requests = nil
+			vcdClient.Client.AllowUnknownMetadataDomains = tt.allowUnknown
+			value, err := vcdClient.GetMetadataByKeyDomainAndHref(href, "key", tt.domain)
+			if tt.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+					t.Errorf("expected error containing '%s', got: %v", tt.expectedErr, err)
+				}
+				if len(mockServer.requests) != 0 {
+					t.Errorf("expected no requests, got: %v", mockServer.requests)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if value.TypedValue == nil || value.TypedValue.Value != "value" {
+				t.Errorf("unexpected value: %+v", value)
+			}
+			if len(mockServer.requests) != 1 || !strings.HasPrefix(mockServer.requests[0], "GET "+tt.expectedPath+"\n") {
+				t.Errorf("expected a single GET %s, got: %v", tt.expectedPath, mockServer.requests)
+			}
+		})
+	}
+
+	vcdClient.Client.AllowUnknownMetadataDomains = false
+	mockServer.requests = nil
+	_, err := vcdClient.GetMetadataByKeyAndHref(href, "key", true)
+	if err != nil || len(mockServer.requests) != 1 || !strings.HasPrefix(mockServer.requests[0], "GET /api/vApp/vm-1/metadata/SYSTEM/key\n") {
+		t.Errorf("expected GetMetadataByKeyAndHref to retrieve the SYSTEM key, got: %v, %v", err, mockServer.requests)
+	}
+}
+
+// Test_MetadataOperationLogging checks that metadata operations of both the XML API and OpenAPI are logged when they
+// start and when they end, with their entity, keys, domain and task, and that values are redacted unless
+// util.LogPasswords is enabled
+func Test_MetadataOperationLogging(t *testing.T) {
+	previousLogger, previousEnableLogging, previousLogPasswords := util.Logger, util.EnableLogging, util.LogPasswords
+	previousLogHttpRequest, previousLogHttpResponse := util.LogHttpRequest, util.LogHttpResponse
+	defer func() {
+		util.Logger, util.EnableLogging, util.LogPasswords = previousLogger, previousEnableLogging, previousLogPasswords
+		util.LogHttpRequest, util.LogHttpResponse = previousLogHttpRequest, previousLogHttpResponse
+	}()
+	var buffer bytes.Buffer
+	util.Logger = log.New(&buffer, "", 0)
+	util.EnableLogging = true
+	util.LogPasswords = false
+	// Only the metadata operation logs are checked, not the HTTP ones
+	util.LogHttpRequest, util.LogHttpResponse = false, false
+
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+
+	err := vm.AddMetadataEntryWithVisibility("key", "secret-value", types.MetadataStringValue, types.MetadataReadOnlyVisibility, true)
+	if err != nil {
+		t.Fatalf("unexpected error adding metadata: %s", err)
+	}
+	err = vm.DeleteMetadataEntryWithDomain("key", false)
+	if err != nil {
+		t.Fatalf("unexpected error deleting metadata: %s", err)
+	}
+	logs := buffer.String()
+	for _, expected := range []string{
+		"metadata operation=add phase=start entity=" + vm.VM.HREF + " entityType=vm keys=[key] domain=SYSTEM values=[key=<redacted 12 characters>]",
+		"metadata operation=add phase=end entity=" + vm.VM.HREF + " entityType=vm keys=[key] domain=SYSTEM task= error=<nil>",
+		"metadata operation=delete phase=start entity=" + vm.VM.HREF + " entityType=vm keys=[key] domain=GENERAL values=[]",
+	} {
+		if !strings.Contains(logs, expected) {
+			t.Errorf("expected log line containing '%s', got:\n%s", expected, logs)
+		}
+	}
+	if strings.Contains(logs, "secret-value") {
+		t.Errorf("expected the metadata value to be redacted, got:\n%s", logs)
+	}
+
+	buffer.Reset()
+	util.LogPasswords = true
+	err = vm.MergeMetadataWithMetadataValues(map[string]types.MetadataValue{
+		"b": {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "2"}},
+		"a": {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "1"},
+			Domain: &types.MetadataDomainTag{Domain: "SYSTEM", Visibility: types.MetadataReadOnlyVisibility}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error merging metadata: %s", err)
+	}
+	expected := "metadata operation=merge phase=start entity=" + vm.VM.HREF + " entityType=vm keys=[a b] domain=GENERAL,SYSTEM values=[a=1 b=2]"
+	if !strings.Contains(buffer.String(), expected) {
+		t.Errorf("expected log line containing '%s', got:\n%s", expected, buffer.String())
+	}
+
+	buffer.Reset()
+	_, err = vm.GetMetadata()
+	if err != nil {
+		t.Fatalf("unexpected error retrieving metadata: %s", err)
+	}
+	expected = "metadata operation=get phase=end entity=" + vm.VM.HREF + " entityType=vm keys=[] domain=GENERAL,SYSTEM task= error=<nil>"
+	if !strings.Contains(buffer.String(), expected) {
+		t.Errorf("expected log line containing '%s', got:\n%s", expected, buffer.String())
+	}
+
+	buffer.Reset()
+	mockServer.setMaxSupportedVersion("38.0")
+	gatewayId := "urn:vcloud:gateway:5e1b2c3d-4f5a-4b6c-8d7e-9f0a1b2c3d4e"
+	entity := NewOpenApiMetadataEntity(mockServer.client, types.OpenApiEndpointEdgeGatewaysMetadata, gatewayId, nil)
+	err = entity.AddMetadataEntryWithVisibility("key", "1", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	if err != nil {
+		t.Fatalf("unexpected error adding OpenAPI metadata: %s", err)
+	}
+	err = entity.DeleteMetadataEntryWithDomain("missing", true)
+	if err == nil {
+		t.Fatalf("expected an error deleting missing OpenAPI metadata")
+	}
+	for _, expected := range []string{
+		"metadata operation=add phase=start entity=" + gatewayId + " entityType=gateway keys=[key] domain=GENERAL values=[key=1]",
+		"metadata operation=add phase=end entity=" + gatewayId + " entityType=gateway keys=[key] domain=GENERAL task= error=<nil>",
+		"metadata operation=delete phase=end entity=" + gatewayId + " entityType=gateway keys=[missing] domain=SYSTEM task= error=" + ErrorEntityNotFound.Error(),
+	} {
+		if !strings.Contains(buffer.String(), expected) {
+			t.Errorf("expected log line containing '%s', got:\n%s", expected, buffer.String())
+		}
+	}
+
+	buffer.Reset()
+	util.EnableLogging = false
+	_, err = vm.GetMetadata()
+	if err != nil {
+		t.Fatalf("unexpected error retrieving metadata: %s", err)
+	}
+	if strings.Contains(buffer.String(), "metadata operation=") {
+		t.Errorf("expected no metadata operation logs with logging disabled, got:\n%s", buffer.String())
+	}
+}
+
+// Test_MetadataWithContextApiVersion checks that the API version set with ContextWithApiVersion is sent in the Accept
+// header of the get, add, merge and delete requests of both the XML API and OpenAPI, including the reads done before a
+// change for Client.OnMetadataChange, without modifying the version of the client
+func Test_MetadataWithContextApiVersion(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	acceptHeaders := map[string][]string{}
+	mockServer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/task/") {
+			mockServer.mutex.Lock()
+			acceptHeaders[r.Method] = append(acceptHeaders[r.Method], r.Header.Get("Accept"))
+			mockServer.mutex.Unlock()
+		}
+		mockServer.handler(w, r)
+	})
+	// The Accept header is only sent by authenticated clients
+	mockServer.client.VCDAuthHeader = AuthorizationHeader
+	mockServer.client.VCDToken = "token"
+	mockServer.client.OnMetadataChange = func(entityHref, key, op string, oldValue, newValue *types.MetadataValue) {}
+
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+	ctx := ContextWithApiVersion(context.Background(), "36.0")
+
+	_, err := vm.GetMetadataCtx(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error retrieving metadata: %s", err)
+	}
+	metadataResponse := mockServer.metadataResponse
+	mockServer.metadataResponse = `<MetadataValue xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"><TypedValue xsi:type="MetadataStringValue"><Value>value</Value></TypedValue></MetadataValue>`
+	_, err = vm.GetMetadataByKeyCtx(ctx, "key", false)
+	if err != nil {
+		t.Fatalf("unexpected error retrieving metadata by key: %s", err)
+	}
+	mockServer.metadataResponse = metadataResponse
+	err = vm.AddMetadataEntryWithVisibilityCtx(ctx, "key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	if err != nil {
+		t.Fatalf("unexpected error adding metadata: %s", err)
+	}
+	err = vm.MergeMetadataWithMetadataValuesCtx(ctx, map[string]types.MetadataValue{
+		"key": {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "value"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error merging metadata: %s", err)
+	}
+	err = vm.DeleteMetadataEntryWithDomainCtx(ctx, "key", false)
+	if err != nil {
+		t.Fatalf("unexpected error deleting metadata: %s", err)
+	}
+
+	expectedAccept := "application/*+xml;version=36.0"
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete} {
+		if len(acceptHeaders[method]) == 0 {
+			t.Errorf("expected a %s request", method)
+		}
+		for _, accept := range acceptHeaders[method] {
+			if accept != expectedAccept {
+				t.Errorf("expected Accept '%s' in %s requests, got %v", expectedAccept, method, acceptHeaders[method])
+				break
+			}
+		}
+	}
+
+	acceptHeaders = map[string][]string{}
+	mockServer.setMaxSupportedVersion("38.0")
+	mockServer.openApiResponse = `[{"id": "urn:vcloud:metadata:1", "keyValue": {"domain": "TENANT", "key": "key", "value": {"value": "value", "type": "StringEntry"}}}]`
+	entity := NewOpenApiMetadataEntity(mockServer.client, types.OpenApiEndpointEdgeGatewaysMetadata, "urn:vcloud:gateway:5e1b2c3d-4f5a-4b6c-8d7e-9f0a1b2c3d4e", nil)
+	err = entity.AddMetadataEntryWithVisibilityCtx(ctx, "key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	if err != nil {
+		t.Fatalf("unexpected error adding OpenAPI metadata: %s", err)
+	}
+	err = entity.DeleteMetadataEntryWithDomainCtx(ctx, "key", false)
+	if err != nil {
+		t.Fatalf("unexpected error deleting OpenAPI metadata: %s", err)
+	}
+	expectedAccept = types.JSONMime + ";version=36.0"
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		if len(acceptHeaders[method]) == 0 || acceptHeaders[method][0] != expectedAccept {
+			t.Errorf("expected Accept '%s' in OpenAPI %s requests, got %v", expectedAccept, method, acceptHeaders[method])
+		}
+	}
+	if mockServer.client.APIVersion != "37.0" {
+		t.Errorf("expected the client API version not to be modified, got %s", mockServer.client.APIVersion)
+	}
+
+	acceptHeaders = map[string][]string{}
+	_, err = vm.GetMetadataCtx(ContextWithApiVersion(context.Background(), ""))
+	if err != nil {
+		t.Fatalf("unexpected error retrieving metadata: %s", err)
+	}
+	if acceptHeaders[http.MethodGet][0] != "application/*+xml;version=37.0" {
+		t.Errorf("expected the client API version with an empty context version, got %v", acceptHeaders[http.MethodGet])
+	}
+}
+
+// Test_OpenApiMetadataEntity checks that an OpenApiMetadataEntity manages the metadata of any OpenAPI entity given its
+// endpoint and ID, sending its tenant context, and that the types that embed it use their current ID and tenant context
+func Test_OpenApiMetadataEntity(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.setMaxSupportedVersion("38.0")
+	mockServer.openApiResponse = `[
+  {"id": "urn:vcloud:metadata:1", "keyValue": {"domain": "TENANT", "key": "owner", "value": {"value": "team-a", "type": "StringEntry"}}}
+]`
+	var tenantHeaders []string
+	mockServer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mockServer.mutex.Lock()
+		tenantHeaders = append(tenantHeaders, r.Header.Get(types.HeaderAuthContext)+"/"+r.Header.Get(types.HeaderTenantContext))
+		mockServer.mutex.Unlock()
+		mockServer.handler(w, r)
+	})
+	tenantContext := &TenantContext{OrgId: "urn:vcloud:org:6127c856-7315-46b8-b774-f2b8f1686c80", OrgName: "tenant"}
+	expectedHeader := "tenant/6127c856-7315-46b8-b774-f2b8f1686c80"
+
+	networkId := "urn:vcloud:network:4f2b6c8e-1a3d-4e5f-8a9b-0c1d2e3f4a5b"
+	entity := NewOpenApiMetadataEntity(mockServer.client, types.OpenApiEndpointOrgVdcNetworksMetadata, networkId, tenantContext)
+	value, err := entity.GetMetadataByKey("owner", false)
+	if err != nil {
+		t.Fatalf("error retrieving metadata by key: %s", err)
+	}
+	if value.TypedValue.Value != "team-a" {
+		t.Errorf("expected value 'team-a', got: %s", value.TypedValue.Value)
+	}
+	err = entity.AddMetadataEntryWithVisibility("owner", "team-b", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	if err != nil {
+		t.Fatalf("error adding metadata: %s", err)
+	}
+	err = entity.DeleteMetadataEntryWithDomain("owner", false)
+	if err != nil {
+		t.Fatalf("error deleting metadata: %s", err)
+	}
+	endpoint := "/cloudapi/1.0.0/orgVdcNetworks/" + networkId + "/metadata/"
+	requests := mockServer.recordedRequests()
+	for _, expected := range []string{"GET " + endpoint, "PUT " + endpoint + "urn:vcloud:metadata:1", "DELETE " + endpoint + "urn:vcloud:metadata:1"} {
+		if !strings.Contains(requests, expected+"\n") {
+			t.Errorf("expected request '%s', got:\n%s", expected, requests)
+		}
+	}
+	for _, header := range tenantHeaders {
+		if header != expectedHeader {
+			t.Errorf("expected tenant context '%s' in every request, got: %v", expectedHeader, tenantHeaders)
+			break
+		}
+	}
+
+	// The ID and tenant context of the embedding type are read when the operation starts
+	mockServer.requests, tenantHeaders = nil, nil
+	egw := &NsxtEdgeGateway{EdgeGateway: &types.OpenAPIEdgeGateway{}, client: mockServer.client}
+	egw.initOpenApiMetadata()
+	egw.EdgeGateway = &types.OpenAPIEdgeGateway{
+		ID:  "urn:vcloud:gateway:5e1b2c3d-4f5a-4b6c-8d7e-9f0a1b2c3d4e",
+		Org: &types.OpenApiReference{ID: tenantContext.OrgId, Name: tenantContext.OrgName},
+	}
+	_, err = egw.GetMetadataAsMap(false)
+	if err != nil {
+		t.Fatalf("error retrieving Edge Gateway metadata: %s", err)
+	}
+	if !strings.Contains(mockServer.recordedRequests(), "GET /cloudapi/1.0.0/edgeGateways/"+egw.EdgeGateway.ID+"/metadata/\n") {
+		t.Errorf("expected the metadata of the current Edge Gateway to be retrieved, got:\n%s", mockServer.recordedRequests())
+	}
+	if len(tenantHeaders) != 1 || tenantHeaders[0] != expectedHeader {
+		t.Errorf("expected tenant context '%s', got: %v", expectedHeader, tenantHeaders)
+	}
+
+	// A VDC Group without parent is managed without tenant context
+	tenantHeaders = nil
+	vdcGroup := &VdcGroup{VdcGroup: &types.VdcGroup{Id: "urn:vcloud:vdcGroup:1"}, client: mockServer.client}
+	vdcGroup.initOpenApiMetadata()
+	_, err = vdcGroup.GetMetadata()
+	if err != nil {
+		t.Fatalf("error retrieving VDC Group metadata: %s", err)
+	}
+	if len(tenantHeaders) != 1 || tenantHeaders[0] != "/" {
+		t.Errorf("expected no tenant context, got: %v", tenantHeaders)
+	}
+
+	// Types whose embedded OpenApiMetadataEntity was not initialized are managed with their current fields
+	mockServer.requests = nil
+	uninitializedGroup := &VdcGroup{VdcGroup: &types.VdcGroup{Id: "urn:vcloud:vdcGroup:1"}, client: mockServer.client}
+	_, err = uninitializedGroup.GetMetadata()
+	if err != nil {
+		t.Fatalf("error retrieving metadata of an uninitialized VDC Group: %s", err)
+	}
+	uninitializedEgw := &NsxtEdgeGateway{EdgeGateway: egw.EdgeGateway, client: mockServer.client}
+	err = uninitializedEgw.DeleteMetadataEntryWithDomain("owner", false)
+	if err != nil {
+		t.Fatalf("error deleting metadata of an uninitialized Edge Gateway: %s", err)
+	}
+	requests = mockServer.recordedRequests()
+	for _, expected := range []string{
+		"GET /cloudapi/1.0.0/vdcGroups/urn:vcloud:vdcGroup:1/metadata/",
+		"DELETE /cloudapi/1.0.0/edgeGateways/" + egw.EdgeGateway.ID + "/metadata/urn:vcloud:metadata:1",
+	} {
+		if !strings.Contains(requests, expected+"\n") {
+			t.Errorf("expected request '%s', got:\n%s", expected, requests)
+		}
+	}
+
+	// Errors retrieving the tenant context are returned
+	mockServer.requests = nil
+	incompleteEgw := &NsxtEdgeGateway{EdgeGateway: &types.OpenAPIEdgeGateway{ID: egw.EdgeGateway.ID, Org: &types.OpenApiReference{Name: "tenant"}}, client: mockServer.client}
+	_, err = incompleteEgw.GetMetadata()
+	if err == nil || !strings.Contains(err.Error(), "tenant context") {
+		t.Errorf("expected an error retrieving the tenant context, got: %v", err)
+	}
+	if requests := mockServer.recordedRequests(); requests != "" {
+		t.Errorf("expected no requests without tenant context, got:\n%s", requests)
+	}
+}
+
+// Test_MergeMetadataReturningValues checks that the values stored by VCD are returned after merging, looked up in the
+// domain of every merged value
+func Test_MergeMetadataReturningValues(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.metadataResponse = `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <MetadataEntry><Key>owner</Key><TypedValue xsi:type="MetadataStringValue"><Value>team-a</Value></TypedValue></MetadataEntry>
+  <MetadataEntry><Domain visibility="READONLY">SYSTEM</Domain><Key>owner</Key><TypedValue xsi:type="MetadataStringValue"><Value>provider</Value></TypedValue></MetadataEntry>
+  <MetadataEntry><Domain visibility="PRIVATE">SYSTEM</Domain><Key>tier</Key><TypedValue xsi:type="MetadataNumberValue"><Value>1</Value></TypedValue></MetadataEntry>
+</Metadata>`
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+
+	values, err := vm.MergeMetadataWithMetadataValuesReturningValues(map[string]types.MetadataValue{
+		"owner": {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "team-a"}},
+		"tier": {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataNumberValue, Value: "1"},
+			Domain: &types.MetadataDomainTag{Domain: "SYSTEM", Visibility: types.MetadataReadOnlyVisibility}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(values) != 2 || values["owner"].TypedValue.Value != "team-a" || values["owner"].Domain != nil {
+		t.Errorf("expected the GENERAL 'owner' entry, got: %+v", values["owner"])
+	}
+	if values["tier"] == nil || values["tier"].Domain == nil || values["tier"].Domain.Visibility != types.MetadataHiddenVisibility {
+		t.Errorf("expected the stored visibility of 'tier', got: %+v", values["tier"])
+	}
+	if !strings.Contains(mockServer.recordedRequests(), "POST /api/vApp/vm-1/metadata\n") {
+		t.Errorf("expected the metadata to be merged, got:\n%s", mockServer.recordedRequests())
+	}
+
+	values, err = vm.MergeMetadataWithMetadataValuesReturningValues(map[string]types.MetadataValue{
+		"tier":    {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataNumberValue, Value: "1"}},
+		"missing": {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "value"}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "[missing tier]") {
+		t.Errorf("expected an error about the missing GENERAL keys, got: %v", err)
+	}
+	if len(values) != 0 {
+		t.Errorf("expected no values, got: %v", values)
+	}
+}
+
+// Test_NormalizeMetadataDomains checks that only the entries of the requested domain without visibility are rewritten,
+// that the entries without Domain element, which VCD returns for GENERAL entries with READWRITE visibility, are never
+// rewritten nor moved to another domain, and that running it again only sends the GET request
+func Test_NormalizeMetadataDomains(t *testing.T) {
+	metadataTemplate := `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <MetadataEntry><Key>noDomain</Key><TypedValue xsi:type="MetadataStringValue"><Value>a</Value></TypedValue></MetadataEntry>
+  <MetadataEntry>%s<Key>general</Key><TypedValue xsi:type="MetadataStringValue"><Value>b</Value></TypedValue></MetadataEntry>
+  <MetadataEntry>%s<Key>system</Key><TypedValue xsi:type="MetadataStringValue"><Value>c</Value></TypedValue></MetadataEntry>
+</Metadata>`
+	incompleteMetadata := fmt.Sprintf(metadataTemplate, `<Domain>GENERAL</Domain>`, `<Domain>SYSTEM</Domain>`)
+
+	for _, isSystem := range []bool{false, true} {
+		t.Run(fmt.Sprintf("isSystem=%t", isSystem), func(t *testing.T) {
+			mockServer := newMetadataMockServer(t)
+			defer mockServer.Close()
+			mockServer.metadataResponse = incompleteMetadata
+			vm := NewVM(mockServer.client)
+			vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+
+			fixed, err := vm.NormalizeMetadataDomains(isSystem)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if fixed != 1 {
+				t.Errorf("expected 1 entry to be rewritten, got %d", fixed)
+			}
+			requests := mockServer.recordedRequests()
+			expectedKey, otherKey := "general", "system"
+			expectedDomain := `<Domain visibility="READWRITE">GENERAL</Domain>`
+			if isSystem {
+				expectedKey, otherKey = "system", "general"
+				expectedDomain = `<Domain visibility="READONLY">SYSTEM</Domain>`
+			}
+			if !strings.Contains(requests, "POST /api/vApp/vm-1/metadata\n") || !strings.Contains(requests, expectedDomain) ||
+				!strings.Contains(requests, "<Key>"+expectedKey+"</Key>") || strings.Contains(requests, "<Key>"+otherKey+"</Key>") ||
+				strings.Contains(requests, "noDomain") {
+				t.Errorf("expected only '%s' to be merged with %s, got:\n%s", expectedKey, expectedDomain, requests)
+			}
+			if strings.Contains(requests, "DELETE ") {
+				t.Errorf("expected no entries to be deleted, got:\n%s", requests)
+			}
+
+			// VCD omits the Domain element of the GENERAL entries with READWRITE visibility, so after normalizing
+			// the GENERAL domain the entry comes back without it
+			mockServer.requests = nil
+			mockServer.metadataResponse = fmt.Sprintf(metadataTemplate, "", `<Domain visibility="READONLY">SYSTEM</Domain>`)
+			fixed, err = vm.NormalizeMetadataDomains(isSystem)
+			if err != nil || fixed != 0 {
+				t.Errorf("expected no entries to be rewritten, got %d, %v", fixed, err)
+			}
+			if len(mockServer.requests) != 1 {
+				t.Errorf("expected only a GET request, got: %v", mockServer.requests)
+			}
+		})
+	}
+}
+
+// Test_MetadataWithTimeout checks that the metadata functions with timeout give up waiting for a running task once the
+// timeout expires, reporting the last known status and HREF of the task, and that they succeed when the task finishes
+func Test_MetadataWithTimeout(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.taskStatus = "running"
+
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+
+	startTime := time.Now()
+	err := vm.AddMetadataEntryWithVisibilityWithTimeout("key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false, 100*time.Millisecond)
+	if err == nil {
+		t.Fatalf("expected a timeout error, got none")
+	}
+	if elapsed := time.Since(startTime); elapsed > 2*time.Second {
+		t.Errorf("expected the wait to stop when the timeout expired, but it took %s", elapsed)
+	}
+	for _, expected := range []string{"timeout of 100ms", "'running'", mockServer.URL + "/api/task/"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to contain %q, got: %s", expected, err)
+		}
+	}
+
+	err = vm.MergeMetadataWithMetadataValuesWithTimeout(map[string]types.MetadataValue{
+		"key": {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "value"}},
+	}, 100*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "last known status 'running'") {
+		t.Errorf("expected a timeout error from merge, got: %v", err)
+	}
+
+	// An invalid timeout is rejected before sending any request, so nothing is changed in VCD
+	mockServer.requests = nil
+	for _, timeout := range []time.Duration{0, -time.Second} {
+		err = vm.AddMetadataEntryWithVisibilityWithTimeout("key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false, timeout)
+		if err == nil || !strings.Contains(err.Error(), "invalid timeout") {
+			t.Errorf("expected an invalid timeout error from add, got: %v", err)
+		}
+		err = vm.MergeMetadataWithMetadataValuesWithTimeout(map[string]types.MetadataValue{
+			"key": {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "value"}},
+		}, timeout)
+		if err == nil || !strings.Contains(err.Error(), "invalid timeout") {
+			t.Errorf("expected an invalid timeout error from merge, got: %v", err)
+		}
+		err = vm.DeleteMetadataEntryWithDomainWithTimeout("key", false, timeout)
+		if err == nil || !strings.Contains(err.Error(), "invalid timeout") {
+			t.Errorf("expected an invalid timeout error from delete, got: %v", err)
+		}
+	}
+	if len(mockServer.requests) != 0 {
+		t.Errorf("expected no requests with an invalid timeout, got: %v", mockServer.requests)
+	}
+
+	mockServer.taskStatus = "success"
+	err = vm.DeleteMetadataEntryWithDomainWithTimeout("key", false, time.Second)
+	if err != nil {
+		t.Errorf("expected no error with a finished task, got: %s", err)
+	}
+}
+
+// Test_TagEntities checks that the same entry is only added to the entities that don't have it yet, and that the
+// failures are reported per entity
+func Test_TagEntities(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.metadataResponse = `<MetadataValue xmlns="http://www.vmware.com/vcloud/v1.5"><TypedValue xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="MetadataStringValue"><Value>1234</Value></TypedValue></MetadataValue>`
+	mockServer.failureStatus = http.StatusNotFound
+	mockServer.failingRequests = []string{
+		"GET /api/vApp/vm-2/metadata/costCenter",
+		"GET /api/vApp/vm-3/metadata/costCenter",
+		"PUT /api/vApp/vm-3/metadata/costCenter",
+	}
+
+	var entities []MetadataCompatible
+	for _, id := range []string{"vm-1", "vm-2", "vm-3"} {
+		vm := NewVM(mockServer.client)
+		vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/" + id}
+		entities = append(entities, vm)
+	}
+
+	err := TagEntities(entities, "costCenter", "1234", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	multiError, ok := err.(*MetadataMultiError)
+	if !ok {
+		t.Fatalf("expected a *MetadataMultiError, got %T: %v", err, err)
+	}
+	if len(multiError.Errors) != 1 || multiError.Errors[mockServer.URL+"/api/vApp/vm-3"] == nil {
+		t.Errorf("expected only vm-3 to fail, got: %s", multiError)
+	}
+
+	requests := mockServer.recordedRequests()
+	if strings.Contains(requests, "PUT /api/vApp/vm-1/metadata/costCenter") {
+		t.Errorf("expected vm-1 to be left untouched, got:\n%s", requests)
+	}
+	if !strings.Contains(requests, "PUT /api/vApp/vm-2/metadata/costCenter") {
+		t.Errorf("expected vm-2 to be tagged, got:\n%s", requests)
+	}
+
+	mockServer.requests = nil
+	err = TagEntities(entities, "costCenter", "1234", "MetadataUnknownValue", types.MetadataReadWriteVisibility, false)
+	if err == nil {
+		t.Errorf("expected an error with an invalid type")
+	}
+	if requests := mockServer.recordedRequests(); requests != "" {
+		t.Errorf("expected no requests with an invalid entry, got:\n%s", requests)
+	}
+}
+
+// Test_MergeMetadataBulk checks that the same metadata is merged into every entity with one request each, that
+// failures are aggregated per entity and that invalid metadata is rejected before sending any request
+func Test_MergeMetadataBulk(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.failingRequests = []string{"POST /api/vApp/vm-2/metadata"}
+
+	var entities []MetadataCompatible
+	for _, id := range []string{"vm-1", "vm-2", "vm-3"} {
+		vm := NewVM(mockServer.client)
+		vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/" + id}
+		entities = append(entities, vm)
+	}
+	metadata := map[string]types.MetadataValue{
+		"env":  {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "production"}},
+		"tier": {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataNumberValue, Value: "1"}},
+	}
+
+	err := MergeMetadataBulk(entities, metadata, 2)
+	multiError, ok := err.(*MetadataMultiError)
+	if !ok {
+		t.Fatalf("expected a *MetadataMultiError, got %T: %v", err, err)
+	}
+	if len(multiError.Errors) != 1 || multiError.Errors[mockServer.URL+"/api/vApp/vm-2"] == nil {
+		t.Errorf("expected only vm-2 to fail, got: %s", multiError)
+	}
+	requests := mockServer.recordedRequests()
+	for _, id := range []string{"vm-1", "vm-3"} {
+		if strings.Count(requests, "POST /api/vApp/"+id+"/metadata\n") != 1 {
+			t.Errorf("expected one merge request for %s, got:\n%s", id, requests)
+		}
+	}
+
+	mockServer.requests = nil
+	metadata["tier"] = types.MetadataValue{TypedValue: &types.MetadataTypedValue{XsiType: "MetadataUnknownValue", Value: "1"}}
+	err = MergeMetadataBulk(entities, metadata, 2)
+	if err == nil {
+		t.Errorf("expected an error with an invalid type")
+	}
+	if requests := mockServer.recordedRequests(); requests != "" {
+		t.Errorf("expected no requests with invalid metadata, got:\n%s", requests)
+	}
+}
+
+// Test_ExternalNetworkV2Metadata checks that the metadata of external networks is managed with their OpenAPI metadata
+// endpoint when VCD supports it, and with the XML API of the external network otherwise
+func Test_ExternalNetworkV2Metadata(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.setMaxSupportedVersion("38.0")
+	mockServer.openApiResponse = `[
+  {"id": "urn:vcloud:metadata:1", "keyValue": {"domain": "TENANT", "key": "physicalNetwork", "value": {"value": "vlan-100", "type": "StringEntry"}}}
+]`
+
+	networkId := "urn:vcloud:network:9b1f2a3c-4d5e-4f60-8a7b-1c2d3e4f5a6b"
+	extNet := &ExternalNetworkV2{ExternalNetwork: &types.ExternalNetworkV2{ID: networkId}, client: mockServer.client}
+	extNet.initOpenApiMetadata()
+
+	value, err := extNet.GetMetadataByKey("physicalNetwork", false)
+	if err != nil {
+		t.Fatalf("error retrieving metadata by key: %s", err)
+	}
+	if value.TypedValue.Value != "vlan-100" {
+		t.Errorf("expected value 'vlan-100', got: %s", value.TypedValue.Value)
+	}
+	err = extNet.AddMetadataEntryWithVisibility("physicalNetwork", "vlan-200", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	if err != nil {
+		t.Fatalf("error adding metadata: %s", err)
+	}
+	endpoint := "/cloudapi/1.0.0/externalNetworks/" + networkId + "/metadata/"
+	requests := mockServer.recordedRequests()
+	for _, expected := range []string{"GET " + endpoint, "PUT " + endpoint + "urn:vcloud:metadata:1"} {
+		if !strings.Contains(requests, expected+"\n") {
+			t.Errorf("expected request '%s', got:\n%s", expected, requests)
+		}
+	}
+
+	for _, version := range []string{"37.0", "38.0"} {
+		t.Run("version "+version, func(t *testing.T) {
+			mockServer.requests = nil
+			mockServer.setMaxSupportedVersion(version)
+			mockServer.metadataResponse = `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <MetadataEntry><Key>physicalNetwork</Key><TypedValue xsi:type="MetadataStringValue"><Value>vlan-100</Value></TypedValue></MetadataEntry>
+</Metadata>`
+
+			metadataMap, err := extNet.GetMetadataAsMap(false)
+			if err != nil {
+				t.Fatalf("error retrieving metadata: %s", err)
+			}
+			if metadataMap["physicalNetwork"] != "vlan-100" {
+				t.Errorf("expected value 'vlan-100', got: %v", metadataMap)
+			}
+			err = extNet.DeleteMetadataEntryWithDomain("physicalNetwork", false)
+			if err != nil {
+				t.Fatalf("error deleting metadata: %s", err)
+			}
+
+			expected := []string{"GET " + endpoint, "DELETE " + endpoint + "urn:vcloud:metadata:1"}
+			if version == "37.0" {
+				xmlHref := "/api/admin/extension/externalnet/9b1f2a3c-4d5e-4f60-8a7b-1c2d3e4f5a6b/metadata"
+				expected = []string{"GET " + xmlHref + "/", "DELETE " + xmlHref + "/physicalNetwork"}
+			}
+			requests := mockServer.recordedRequests()
+			for _, expectedRequest := range expected {
+				if !strings.Contains(requests, expectedRequest+"\n") {
+					t.Errorf("expected request '%s', got:\n%s", expectedRequest, requests)
+				}
+			}
+		})
+	}
+}
+
+// Test_VdcComputePolicyV2Metadata checks that the metadata of VDC Compute Policies, like VM Sizing and VM Placement
+// Policies, is managed with their OpenAPI metadata endpoint without tenant context, and that a
+// *MetadataNotSupportedError is returned when VCD doesn't support it
+func Test_VdcComputePolicyV2Metadata(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.setMaxSupportedVersion("38.0")
+	mockServer.openApiResponse = `[
+  {"id": "urn:vcloud:metadata:1", "keyValue": {"domain": "TENANT", "key": "approvedBy", "value": {"value": "cab-1", "type": "StringEntry"}}}
+]`
+
+	policyId := "urn:vcloud:vdcComputePolicy:6d7e8f90-1a2b-4c3d-8e4f-5a6b7c8d9e0f"
+	policy := &VdcComputePolicyV2{VdcComputePolicyV2: &types.VdcComputePolicyV2{}, client: mockServer.client}
+	policy.VdcComputePolicyV2.ID = policyId
+	policy.initOpenApiMetadata()
+
+	value, err := policy.GetMetadataByKey("approvedBy", false)
+	if err != nil {
+		t.Fatalf("error retrieving metadata by key: %s", err)
+	}
+	if value.TypedValue.Value != "cab-1" {
+		t.Errorf("expected value 'cab-1', got: %s", value.TypedValue.Value)
+	}
+	err = policy.AddMetadataEntryWithVisibility("approvedBy", "cab-2", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	if err != nil {
+		t.Fatalf("error adding metadata: %s", err)
+	}
+	err = policy.DeleteMetadataEntryWithDomain("approvedBy", false)
+	if err != nil {
+		t.Fatalf("error deleting metadata: %s", err)
+	}
+	endpoint := "/cloudapi/1.0.0/vdcComputePolicies/" + policyId + "/metadata/"
+	requests := mockServer.recordedRequests()
+	for _, expected := range []string{"GET " + endpoint, "PUT " + endpoint + "urn:vcloud:metadata:1", "DELETE " + endpoint + "urn:vcloud:metadata:1"} {
+		if !strings.Contains(requests, expected+"\n") {
+			t.Errorf("expected request '%s', got:\n%s", expected, requests)
+		}
+	}
+	_, _, header, err := policy.openApiMetadataTarget()
+	if err != nil {
+		t.Fatalf("error retrieving the metadata target: %s", err)
+	}
+	if len(header) != 0 {
+		t.Errorf("expected no tenant context, got: %v", header)
+	}
+
+	mockServer.requests = nil
+	mockServer.setMaxSupportedVersion("37.0")
+	_, err = policy.GetMetadata()
+	assertMetadataNotSupported(t, err)
+	if requests := mockServer.recordedRequests(); requests != "" {
+		t.Errorf("expected no requests to be sent, got:\n%s", requests)
+	}
+}
+
+// Test_DefinedEntityMetadata checks that the VCD metadata of Runtime Defined Entities is managed with their OpenAPI
+// metadata endpoint, and that a *MetadataNotSupportedError is returned when VCD doesn't support it
+func Test_DefinedEntityMetadata(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.setMaxSupportedVersion("38.0")
+	mockServer.openApiResponse = `[
+  {"id": "urn:vcloud:metadata:1", "keyValue": {"domain": "TENANT", "key": "owner", "value": {"value": "team-a", "type": "StringEntry"}}}
+]`
+	vcdClient := &VCDClient{Client: *mockServer.client}
+
+	entityId := "urn:vcloud:entity:vmware:kubernetes:5a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d"
+	entity := vcdClient.GetDefinedEntityMetadata(entityId, nil)
+	metadata, err := entity.GetMetadata()
+	if err != nil {
+		t.Fatalf("error retrieving metadata: %s", err)
+	}
+	if len(metadata.MetadataEntry) != 1 || metadata.MetadataEntry[0].Key != "owner" {
+		t.Errorf("expected the metadata key 'owner', got: %v", metadata.MetadataEntry)
+	}
+	err = entity.DeleteMetadataEntryWithDomain("owner", false)
+	if err != nil {
+		t.Fatalf("error deleting metadata: %s", err)
+	}
+	endpoint := "/cloudapi/1.0.0/entities/" + entityId + "/metadata/"
+	requests := mockServer.recordedRequests()
+	for _, expected := range []string{"GET " + endpoint, "DELETE " + endpoint + "urn:vcloud:metadata:1"} {
+		if !strings.Contains(requests, expected+"\n") {
+			t.Errorf("expected request '%s', got:\n%s", expected, requests)
+		}
+	}
+
+	mockServer.requests = nil
+	mockServer.setMaxSupportedVersion("37.0")
+	vcdClient.Client.supportedVersions = mockServer.client.supportedVersions
+	err = entity.AddMetadataEntryWithVisibility("owner", "team-b", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	assertMetadataNotSupported(t, err)
+	if requests := mockServer.recordedRequests(); requests != "" {
+		t.Errorf("expected no requests to be sent, got:\n%s", requests)
+	}
+}
+
+// Test_CertificateMetadata checks that the metadata of certificate library items is managed with their OpenAPI
+// metadata endpoint in the tenant context they were retrieved with, and that a *MetadataNotSupportedError is returned
+// when VCD doesn't support it
+func Test_CertificateMetadata(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.setMaxSupportedVersion("38.0")
+	mockServer.openApiResponse = `[
+  {"id": "urn:vcloud:metadata:1", "keyValue": {"domain": "TENANT", "key": "owner", "value": {"value": "team-a", "type": "StringEntry"}}}
+]`
+
+	certificateId := "urn:vcloud:certificateLibraryItem:3c4d5e6f-7a8b-4c9d-8e0f-1a2b3c4d5e6f"
+	certificate := &Certificate{
+		CertificateLibrary: &types.CertificateLibraryItem{Id: certificateId},
+		client:             mockServer.client,
+		tenantContext:      &TenantContext{OrgId: "urn:vcloud:org:11111111-2222-3333-4444-555555555555", OrgName: "org1"},
+	}
+	certificate.initOpenApiMetadata()
+
+	value, err := certificate.GetMetadataByKey("owner", false)
+	if err != nil {
+		t.Fatalf("error retrieving metadata by key: %s", err)
+	}
+	if value.TypedValue.Value != "team-a" {
+		t.Errorf("expected value 'team-a', got: %s", value.TypedValue.Value)
+	}
+	err = certificate.AddMetadataEntryWithVisibility("owner", "team-b", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	if err != nil {
+		t.Fatalf("error adding metadata: %s", err)
+	}
+	endpoint := "/cloudapi/1.0.0/ssl/certificateLibrary/" + certificateId + "/metadata/"
+	requests := mockServer.recordedRequests()
+	for _, expected := range []string{"GET " + endpoint, "PUT " + endpoint + "urn:vcloud:metadata:1"} {
+		if !strings.Contains(requests, expected+"\n") {
+			t.Errorf("expected request '%s', got:\n%s", expected, requests)
+		}
+	}
+	_, _, header, err := certificate.openApiMetadataTarget()
+	if err != nil {
+		t.Fatalf("error retrieving the metadata target: %s", err)
+	}
+	if header[types.HeaderTenantContext] != "11111111-2222-3333-4444-555555555555" {
+		t.Errorf("expected the tenant context of the certificate, got: %v", header)
+	}
+
+	mockServer.requests = nil
+	mockServer.setMaxSupportedVersion("37.0")
+	_, err = certificate.GetMetadata()
+	assertMetadataNotSupported(t, err)
+	if requests := mockServer.recordedRequests(); requests != "" {
+		t.Errorf("expected no requests to be sent, got:\n%s", requests)
+	}
+}
+
+// Test_VmMetadataCache checks that the metadata methods of VM only send one request while the metadata cache is
+// enabled, and that modifying the metadata of the VM, with any instance or function, or invalidating the cache makes
+// it retrieve the metadata again. Writes must not be tracked once the cache is disabled.
+func Test_VmMetadataCache(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.metadataResponse = `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"><MetadataEntry><Key>owner</Key><TypedValue xsi:type="MetadataStringValue"><Value>team-a</Value></TypedValue></MetadataEntry></Metadata>`
+
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+	countGets := func() int {
+		return strings.Count(mockServer.recordedRequests(), "GET /api/vApp/vm-1/metadata")
+	}
+	readMetadata := func() *types.Metadata {
+		metadata, err := vm.GetMetadata()
+		if err != nil {
+			t.Fatalf("error retrieving metadata: %s", err)
+		}
+		return metadata
+	}
+
+	readMetadata()
+	readMetadata()
+	if gets := countGets(); gets != 2 {
+		t.Errorf("expected 2 requests with the cache disabled, got %d", gets)
+	}
+
+	mockServer.requests = nil
+	vm.EnableMetadataCache()
+	metadata := readMetadata()
+	metadata.MetadataEntry[0].TypedValue.Value = "modified"
+	metadata = readMetadata()
+	if gets := countGets(); gets != 1 {
+		t.Errorf("expected 1 request with the cache enabled, got %d", gets)
+	}
+	if metadata.MetadataEntry[0].TypedValue.Value != "team-a" {
+		t.Errorf("expected the cached metadata not to be modified by the caller, got: %s", metadata.MetadataEntry[0].TypedValue.Value)
+	}
+
+	// All the read paths use the cache
+	value, err := vm.GetMetadataByKey("owner", false)
+	if err != nil || value.TypedValue.Value != "team-a" {
+		t.Errorf("expected the cached value 'team-a', got %v, %v", value, err)
+	}
+	_, err = vm.GetMetadataByKey("owner", true)
+	if !errors.Is(err, ErrorEntityNotFound) {
+		t.Errorf("expected a not found error for a key missing in the cache, got: %v", err)
+	}
+	keyDomains, err := vm.GetMetadataByKeyWithDomain("owner")
+	if err != nil || keyDomains.General == nil || keyDomains.System != nil {
+		t.Errorf("expected the key only in the GENERAL domain, got %v, %v", keyDomains, err)
+	}
+	metadataMap, err := vm.GetMetadataAsMap(false)
+	if err != nil || metadataMap["owner"] != "team-a" {
+		t.Errorf("expected the cached map, got %v, %v", metadataMap, err)
+	}
+	count, err := vm.CountMetadata(true)
+	if err != nil || count != 0 {
+		t.Errorf("expected no SYSTEM entries, got %d, %v", count, err)
+	}
+	systemMetadata, err := vm.GetSystemMetadata()
+	if err != nil || len(systemMetadata.MetadataEntry) != 0 {
+		t.Errorf("expected no SYSTEM entries, got %v, %v", systemMetadata, err)
+	}
+	if requests := mockServer.recordedRequests(); strings.Count(requests, "GET ") != 1 {
+		t.Errorf("expected a single request with the cache enabled, got:\n%s", requests)
+	}
+
+	// A write through another instance or the HREF makes the cache stale too
+	otherVm := NewVM(mockServer.client)
+	otherVm.VM = &types.Vm{HREF: vm.VM.HREF}
+	err = otherVm.DeleteMetadataEntryWithDomain("owner", false)
+	if err != nil {
+		t.Fatalf("error deleting metadata: %s", err)
+	}
+	mockServer.requests = nil
+	readMetadata()
+	if gets := countGets(); gets != 1 {
+		t.Errorf("expected the cache to be stale after a write through another instance, got %d requests", gets)
+	}
+
+	err = vm.AddMetadataEntryWithVisibility("owner", "team-b", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	if err != nil {
+		t.Fatalf("error adding metadata: %s", err)
+	}
+	readMetadata()
+	if gets := countGets(); gets != 2 {
+		t.Errorf("expected the cache to be stale after adding metadata, got %d requests", gets)
+	}
+
+	vm.InvalidateMetadataCache()
+	readMetadata()
+	readMetadata()
+	if gets := countGets(); gets != 3 {
+		t.Errorf("expected the cache to be invalidated manually, got %d requests", gets)
+	}
+
+	vm.DisableMetadataCache()
+	readMetadata()
+	if gets := countGets(); gets != 4 {
+		t.Errorf("expected a request with the cache disabled, got %d", gets)
+	}
+
+	// Writes are only tracked while the entity has an enabled cache
+	isTracked := func(href string) bool {
+		metadataCacheTrackingsMutex.Lock()
+		defer metadataCacheTrackingsMutex.Unlock()
+		_, ok := metadataCacheTrackings[href]
+		return ok
+	}
+	if isTracked(vm.VM.HREF) {
+		t.Errorf("expected the VM not to be tracked once its cache is disabled")
+	}
+	err = otherVm.DeleteMetadataEntryWithDomain("owner", false)
+	if err != nil {
+		t.Fatalf("error deleting metadata: %s", err)
+	}
+	if isTracked(vm.VM.HREF) {
+		t.Errorf("expected writes not to be tracked for a VM without an enabled cache")
+	}
+}
+
+// Test_GetMetadataBothDomains checks that VM.GetMetadata retrieves the metadata of both domains with a single request
+func Test_GetMetadataBothDomains(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.metadataResponse = `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <MetadataEntry><Key>owner</Key><TypedValue xsi:type="MetadataStringValue"><Value>team-a</Value></TypedValue></MetadataEntry>
+  <MetadataEntry><Domain visibility="READONLY">SYSTEM</Domain><Key>tier</Key><TypedValue xsi:type="MetadataStringValue"><Value>gold</Value></TypedValue></MetadataEntry>
+</Metadata>`
+
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+	metadata, err := vm.GetMetadata()
+	if err != nil {
+		t.Fatalf("error retrieving metadata: %s", err)
+	}
+	if len(FilterMetadata(metadata, FilterByDomain(true)).MetadataEntry) != 1 || len(FilterMetadata(metadata, FilterByDomain(false)).MetadataEntry) != 1 {
+		t.Errorf("expected one entry of each domain, got: %#v", metadata.MetadataEntry)
+	}
+	if requests := mockServer.recordedRequests(); strings.Count(requests, "GET ") != 1 {
+		t.Errorf("expected a single request, got:\n%s", requests)
+	}
+}
+
+// Test_MetadataRequestError checks that the errors returned by VCD are converted to the typed metadata error of their
+// category, keeping their message unchanged and the original VCD error
+func Test_MetadataRequestError(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+
+	isPermissionError := func(err error) bool {
+		var permissionError *MetadataPermissionError
+		return errors.As(err, &permissionError)
+	}
+	isNotFoundError := func(err error) bool {
+		var notFoundError *MetadataKeyNotFoundError
+		return errors.As(err, &notFoundError) && errors.Is(err, ErrorEntityNotFound)
+	}
+	isValidationError := func(err error) bool {
+		var validationError *MetadataValidationError
+		return errors.As(err, &validationError) && errors.Is(err, ErrInvalidMetadataValue)
+	}
+
+	tests := []struct {
+		name            string
+		status          int
+		request         string
+		operation       func() error
+		expectedMessage string
+		expectedType    func(error) bool
+	}{
+		{
+			name:            "GetForbidden",
+			status:          http.StatusForbidden,
+			request:         "GET /api/vApp/vm-1/metadata/",
+			operation:       func() error { _, err := vm.GetMetadata(); return err },
+			expectedMessage: "error retrieving metadata: API Error: 403: mock failure",
+			expectedType:    isPermissionError,
+		},
+		{
+			name:            "GetNotFound",
+			status:          http.StatusNotFound,
+			request:         "GET /api/vApp/vm-1/metadata/",
+			operation:       func() error { _, err := vm.GetMetadata(); return err },
+			expectedMessage: "error retrieving metadata: API Error: 404: mock failure",
+			expectedType:    isNotFoundError,
+		},
+		{
+			name:    "AddBadRequest",
+			status:  http.StatusBadRequest,
+			request: "PUT /api/vApp/vm-1/metadata/key",
+			operation: func() error {
+				_, err := vm.AddMetadataEntryWithVisibilityAsync("key", "value", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+				return err
+			},
+			expectedMessage: "error adding metadata: API Error: 400: mock failure",
+			expectedType:    isValidationError,
+		},
+		{
+			name:    "MergeUnauthorized",
+			status:  http.StatusUnauthorized,
+			request: "POST /api/vApp/vm-1/metadata",
+			operation: func() error {
+				_, err := vm.MergeMetadataWithMetadataValuesAsync(map[string]types.MetadataValue{
+					"key": {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "value"}},
+				})
+				return err
+			},
+			expectedMessage: "error adding metadata: API Error: 401: mock failure",
+			expectedType:    isPermissionError,
+		},
+		{
+			name:            "DeleteForbidden",
+			status:          http.StatusForbidden,
+			request:         "DELETE /api/vApp/vm-1/metadata/key",
+			operation:       func() error { _, err := vm.DeleteMetadataEntryWithDomainAsync("key", false); return err },
+			expectedMessage: "error deleting metadata: API Error: 403: mock failure",
+			expectedType:    isPermissionError,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockServer.failingRequests = []string{test.request}
+			mockServer.failureStatus = test.status
+			err := test.operation()
+			if err == nil || err.Error() != test.expectedMessage {
+				t.Fatalf("expected error '%s', got: %v", test.expectedMessage, err)
+			}
+			if !test.expectedType(err) {
+				t.Errorf("unexpected error type: %#v", err)
+			}
+			var vcdError *types.Error
+			if !errors.As(err, &vcdError) || vcdError.MajorErrorCode != test.status {
+				t.Errorf("expected the original VCD error with status %d to be wrapped", test.status)
+			}
+		})
+	}
+
+	mockServer.failingRequests = []string{"GET /api/vApp/vm-1/metadata/"}
+	mockServer.failureStatus = http.StatusInternalServerError
+	_, err := vm.GetMetadata()
+	if err == nil || isPermissionError(err) || isNotFoundError(err) || isValidationError(err) {
+		t.Errorf("expected a plain error for a 500 error, got: %#v", err)
+	}
+}
+
+// Test_ProtectSystemMetadataKeys checks that the SYSTEM domain keys that match Client.ProtectSystemMetadataKeys can't
+// be added, merged or deleted, and that GENERAL domain keys and other SYSTEM keys are not affected
+func Test_ProtectSystemMetadataKeys(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.client.ProtectSystemMetadataKeys = []string{"owner.*", "billing"}
+
+	vm := NewVM(mockServer.client)
+	vm.VM = &types.Vm{HREF: mockServer.URL + "/api/vApp/vm-1"}
+	assertProtected := func(t *testing.T, err error) {
+		t.Helper()
+		if !errors.Is(err, ErrProtectedMetadataKey) {
+			t.Errorf("expected ErrProtectedMetadataKey, got: %v", err)
+		}
+	}
+
+	_, err := vm.AddMetadataEntryWithVisibilityAsync("owner.team", "a", types.MetadataStringValue, types.MetadataReadOnlyVisibility, true)
+	assertProtected(t, err)
+	err = vm.DeleteMetadataEntryWithDomain("billing", true)
+	assertProtected(t, err)
+	err = vm.MergeMetadataWithMetadataValues(map[string]types.MetadataValue{
+		"free":    {Domain: &types.MetadataDomainTag{Domain: "SYSTEM", Visibility: types.MetadataReadOnlyVisibility}, TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "a"}},
+		"billing": {Domain: &types.MetadataDomainTag{Domain: "SYSTEM", Visibility: types.MetadataReadOnlyVisibility}, TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "a"}},
+	})
+	assertProtected(t, err)
+	err = vm.AddMetadataEntries([]types.MetadataEntry{
+		{Key: "owner.name", Domain: &types.MetadataDomainTag{Domain: "SYSTEM", Visibility: types.MetadataHiddenVisibility}, TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "a"}},
+	})
+	multiError, ok := err.(*MetadataMultiError)
+	if !ok {
+		t.Fatalf("expected a *MetadataMultiError, got %T: %v", err, err)
+	}
+	assertProtected(t, multiError.Errors["SYSTEM/owner.name"])
+	if requests := mockServer.recordedRequests(); strings.Contains(requests, "PUT ") || strings.Contains(requests, "POST ") || strings.Contains(requests, "DELETE ") {
+		t.Errorf("expected no modifications to be sent, got:\n%s", requests)
+	}
+
+	_, err = vm.AddMetadataEntryWithVisibilityAsync("owner.team", "a", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	if err != nil {
+		t.Errorf("expected GENERAL domain keys not to be protected, got: %s", err)
+	}
+	_, err = vm.AddMetadataEntryWithVisibilityAsync("owners", "a", types.MetadataStringValue, types.MetadataReadOnlyVisibility, true)
+	if err != nil {
+		t.Errorf("expected SYSTEM domain keys that don't match not to be protected, got: %s", err)
+	}
+
+	mockServer.client.ProtectSystemMetadataKeys = []string{"owner["}
+	_, err = vm.AddMetadataEntryWithVisibilityAsync("owners", "a", types.MetadataStringValue, types.MetadataReadOnlyVisibility, true)
+	assertProtected(t, err)
+}
+
+// Test_StorageProfileMetadata checks that the metadata of storage profiles is managed through their HREF
+func Test_StorageProfileMetadata(t *testing.T) {
+	mockServer := newMetadataMockServer(t)
+	defer mockServer.Close()
+	mockServer.metadataResponse = `<Metadata xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <MetadataEntry><Key>cost-center</Key><TypedValue xsi:type="MetadataStringValue"><Value>cc-1</Value></TypedValue></MetadataEntry>
+</Metadata>`
+	vcdClient := &VCDClient{Client: *mockServer.client}
+	storageProfileHref := mockServer.URL + "/api/admin/pvdcStorageProfile/sp-1"
+
+	metadata, err := vcdClient.GetStorageProfileMetadata(storageProfileHref)
+	if err != nil || len(metadata.MetadataEntry) != 1 {
+		t.Fatalf("expected a single metadata entry, got: %v, %v", metadata, err)
+	}
+	mockServer.metadataResponse = `<MetadataValue xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <TypedValue xsi:type="MetadataStringValue"><Value>cc-1</Value></TypedValue>
+</MetadataValue>`
+	_, err = vcdClient.GetStorageProfileMetadataByKey(storageProfileHref, "cost-center", false)
+	if err != nil {
+		t.Fatalf("error getting metadata by key: %s", err)
+	}
+	err = vcdClient.AddStorageProfileMetadataEntryWithVisibility(storageProfileHref, "cost-center", "cc-2", types.MetadataStringValue, types.MetadataReadWriteVisibility, false)
+	if err != nil {
+		t.Fatalf("error adding metadata: %s", err)
+	}
+	err = vcdClient.MergeStorageProfileMetadataWithMetadataValues(storageProfileHref, map[string]types.MetadataValue{
+		"tier": {TypedValue: &types.MetadataTypedValue{XsiType: types.MetadataStringValue, Value: "gold"}},
+	})
+	if err != nil {
+		t.Fatalf("error merging metadata: %s", err)
+	}
+	err = vcdClient.DeleteStorageProfileMetadataEntryWithDomain(storageProfileHref, "cost-center", false)
+	if err != nil {
+		t.Fatalf("error deleting metadata: %s", err)
+	}
+
+	requests := mockServer.recordedRequests()
+	for _, expected := range []string{
+		"GET /api/admin/pvdcStorageProfile/sp-1/metadata/\n",
+		"GET /api/admin/pvdcStorageProfile/sp-1/metadata/cost-center\n",
+		"PUT /api/admin/pvdcStorageProfile/sp-1/metadata/cost-center\n",
+		"POST /api/admin/pvdcStorageProfile/sp-1/metadata\n",
+		"DELETE /api/admin/pvdcStorageProfile/sp-1/metadata/cost-center\n",
+	} {
+		if !strings.Contains(requests, expected) {
+			t.Errorf("expected request %q, got:\n%s", expected, requests)
+		}
+	}
+}
@@ -15,6 +15,7 @@ import (
 type NsxtEdgeGateway struct {
 	EdgeGateway *types.OpenAPIEdgeGateway
 	client      *Client
+	OpenApiMetadataEntity
 }
 
 // GetNsxtEdgeGatewayById allows retrieving NSX-T edge gateway by ID for Org admins
@@ -167,6 +168,7 @@ func (adminOrg *AdminOrg) CreateNsxtEdgeGateway(edgeGatewayConfig *types.OpenAPI
 		EdgeGateway: &types.OpenAPIEdgeGateway{},
 		client:      adminOrg.client,
 	}
+	returnEgw.initOpenApiMetadata()
 
 	err = adminOrg.client.OpenApiPostItem(minimumApiVersion, urlRef, nil, edgeGatewayConfig, returnEgw.EdgeGateway, nil)
 	if err != nil {
@@ -201,6 +203,7 @@ func (egw *NsxtEdgeGateway) Update(edgeGatewayConfig *types.OpenAPIEdgeGateway)
 		EdgeGateway: &types.OpenAPIEdgeGateway{},
 		client:      egw.client,
 	}
+	returnEgw.initOpenApiMetadata()
 
 	err = egw.client.OpenApiPutItem(minimumApiVersion, urlRef, nil, edgeGatewayConfig, returnEgw.EdgeGateway, nil)
 	if err != nil {
@@ -280,6 +283,7 @@ func getNsxtEdgeGatewayById(client *Client, id string, queryParameters url.Value
 		EdgeGateway: &types.OpenAPIEdgeGateway{},
 		client:      client,
 	}
+	egw.initOpenApiMetadata()
 
 	err = client.OpenApiGetItem(minimumApiVersion, urlRef, queryParameters, egw.EdgeGateway, nil)
 	if err != nil {
@@ -337,6 +341,7 @@ func getAllNsxtEdgeGateways(client *Client, queryParameters url.Values) ([]*Nsxt
 			EdgeGateway: typeResponses[sliceIndex],
 			client:      client,
 		}
+		wrappedResponses[sliceIndex].initOpenApiMetadata()
 	}
 
 	onlyNsxtEdges := filterOnlyNsxtEdges(wrappedResponses)
@@ -359,3 +364,27 @@ func filterOnlyNsxtEdges(allEdges []*NsxtEdgeGateway) []*NsxtEdgeGateway {
 
 	return filteredEdges
 }
+
+// initOpenApiMetadata makes the embedded OpenApiMetadataEntity manage the metadata of the receiver NsxtEdgeGateway
+func (egw *NsxtEdgeGateway) initOpenApiMetadata() {
+	egw.OpenApiMetadataEntity = *egw.openApiMetadata()
+}
+
+// openApiMetadata returns an OpenApiMetadataEntity that manages the metadata of the receiver NsxtEdgeGateway, built from its
+// current fields, so the metadata methods also work when the embedded one was not initialized
+func (egw *NsxtEdgeGateway) openApiMetadata() *OpenApiMetadataEntity {
+	return newOpenApiMetadataEntity(egw.client, types.OpenApiEndpointEdgeGatewaysMetadata,
+		func() string {
+			if egw.EdgeGateway == nil {
+				return ""
+			}
+			return egw.EdgeGateway.ID
+		},
+		func() (*TenantContext, error) {
+			// An Edge Gateway without Org reference is managed without tenant context
+			if egw.EdgeGateway == nil || egw.EdgeGateway.Org == nil {
+				return nil, nil
+			}
+			return egw.getTenantContext()
+		})
+}
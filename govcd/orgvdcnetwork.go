@@ -23,6 +23,9 @@ import (
 type OrgVDCNetwork struct {
 	OrgVDCNetwork *types.OrgVDCNetwork
 	client        *Client
+	// tenantMetadataHref makes system administrators modify metadata through the tenant HREF.
+	// See OrgVDCNetwork.WithTenantMetadataHref
+	tenantMetadataHref bool
 }
 
 var reErrorBusy2 = regexp.MustCompile("is busy, cannot proceed with the operation.$")
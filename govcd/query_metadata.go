@@ -338,3 +338,23 @@ func (client *Client) queryByMetadataFilter(queryType string, params, notEncoded
 
 	return client.cumulativeQuery(queryType, params, notEncodedParams)
 }
+
+// QueryByMetadata runs a query of the given type, returning the records that have a metadata entry with the given key
+// and value, in the SYSTEM domain if isSystem is true, or in the GENERAL domain otherwise. The filter is applied by
+// VCD, so there is no need to retrieve the metadata of every entity. The value is compared as a string.
+// The supported entity types are the ones supported by cumulativeQuery, such as types.QtVm, types.QtVapp,
+// types.QtOrgVdc, types.QtCatalog, types.QtMedia or their admin counterparts like types.QtAdminVm.
+// All the pages of the query are collected in the returned records.
+func (vcdClient *VCDClient) QueryByMetadata(entityType, key, value string, isSystem bool) ([]*types.QueryResultRecordsType, error) {
+	if key == "" {
+		return nil, fmt.Errorf("[QueryByMetadata] metadata key is empty")
+	}
+	metadataFilters := map[string]MetadataFilter{
+		key: {Type: "STRING", Value: value},
+	}
+	results, err := vcdClient.Client.queryByMetadataFilter(entityType, nil, nil, metadataFilters, isSystem)
+	if err != nil {
+		return nil, fmt.Errorf("[QueryByMetadata] error querying type '%s' by metadata key '%s': %s", entityType, key, err)
+	}
+	return []*types.QueryResultRecordsType{results.Results}, nil
+}
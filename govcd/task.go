@@ -5,6 +5,7 @@
 package govcd
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -54,6 +55,11 @@ func (task *Task) getErrorMessage(err error) string {
 
 // Refresh retrieves a fresh copy of the task
 func (task *Task) Refresh() error {
+	return task.refreshWithContext(context.Background())
+}
+
+// refreshWithContext is the implementation of Refresh, with the request bound to the given context
+func (task *Task) refreshWithContext(ctx context.Context) error {
 
 	if task.Task == nil {
 		return fmt.Errorf("cannot refresh, Object is empty")
@@ -63,7 +69,7 @@ func (task *Task) Refresh() error {
 
 	req := task.client.NewRequest(map[string]string{}, http.MethodGet, *refreshUrl, nil)
 
-	resp, err := checkResp(task.client.Http.Do(req))
+	resp, err := checkResp(task.client.Http.Do(req.WithContext(ctx)))
 	if err != nil {
 		return fmt.Errorf("%s: %s", errorRetrievingTask, err)
 	}
@@ -96,6 +102,12 @@ type TaskMonitoringFunc func(*types.Task)
 // Users can define the sleeping duration and an optional callback function for
 // extra monitoring.
 func (task *Task) WaitInspectTaskCompletion(inspectionFunc InspectionFunc, delay time.Duration) error {
+	return task.WaitInspectTaskCompletionWithContext(context.Background(), inspectionFunc, delay)
+}
+
+// WaitInspectTaskCompletionWithContext behaves like WaitInspectTaskCompletion, but it stops polling the task as soon
+// as the given context is cancelled, returning the context error. Cancelling the context doesn't cancel the task in VCD.
+func (task *Task) WaitInspectTaskCompletionWithContext(ctx context.Context, inspectionFunc InspectionFunc, delay time.Duration) error {
 
 	if task.Task == nil {
 		return fmt.Errorf("cannot refresh, Object is empty")
@@ -105,9 +117,15 @@ func (task *Task) WaitInspectTaskCompletion(inspectionFunc InspectionFunc, delay
 	howManyTimesRefreshed := 0
 	startTime := time.Now()
 	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		howManyTimesRefreshed++
 		elapsed := time.Since(startTime)
-		err := task.Refresh()
+		err := task.refreshWithContext(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		if err != nil {
 			return fmt.Errorf("%s : %s", errorRetrievingTask, err)
 		}
@@ -163,8 +181,12 @@ func (task *Task) WaitInspectTaskCompletion(inspectionFunc InspectionFunc, delay
 			)
 		}
 
-		// Sleep for a given period and try again.
-		time.Sleep(delay)
+		// Sleep for a given period and try again, unless the context is cancelled in the meantime.
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
 	}
 }
 
@@ -174,6 +196,12 @@ func (task *Task) WaitTaskCompletion() error {
 	return task.WaitInspectTaskCompletion(nil, 3*time.Second)
 }
 
+// WaitTaskCompletionWithContext behaves like WaitTaskCompletion, but it stops polling the task as soon as the given
+// context is cancelled, returning the context error. Cancelling the context doesn't cancel the task in VCD.
+func (task *Task) WaitTaskCompletionWithContext(ctx context.Context) error {
+	return task.WaitInspectTaskCompletionWithContext(ctx, nil, 3*time.Second)
+}
+
 // GetTaskProgress retrieves the task progress as a string
 func (task *Task) GetTaskProgress() (string, error) {
 	if task.Task == nil {
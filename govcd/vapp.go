@@ -185,6 +185,31 @@ func (vapp *VApp) AddRawVM(vAppComposition *types.ReComposeVAppParams) (*VM, err
 
 }
 
+// AddRawVMWithMetadata creates a VM with raw types.ReComposeVAppParams, like AddRawVM, and adds the given metadata to it
+// before returning. The VCD schema of RecomposeVAppParams has no Metadata element, neither in the vApp nor in its
+// SourcedItem, so the metadata is merged in a single task right after the VM is created. The metadata is checked with
+// validateNewEntityMetadata before creating the VM, so entries that would be rejected don't leave an untagged VM
+// behind. If merging the metadata fails, the created VM is returned together with the error, so it can be cleaned up.
+func (vapp *VApp) AddRawVMWithMetadata(vAppComposition *types.ReComposeVAppParams, metadata map[string]types.MetadataValue) (*VM, error) {
+	err := validateNewEntityMetadata(vapp.client, metadata)
+	if err != nil {
+		return nil, fmt.Errorf("error validating metadata for new VM in vApp %s: %s", vapp.VApp.Name, err)
+	}
+
+	vm, err := vapp.AddRawVM(vAppComposition)
+	if err != nil {
+		return nil, err
+	}
+	if len(metadata) == 0 {
+		return vm, nil
+	}
+	err = mergeMetadataAndWait(vm.client, vm.VM.HREF, metadata)
+	if err != nil {
+		return vm, fmt.Errorf("error adding metadata to VM %s: %s", vm.VM.Name, err)
+	}
+	return vm, nil
+}
+
 // AddNewVM adds VM from vApp template with custom NetworkConnectionSection
 func (vapp *VApp) AddNewVM(name string, vappTemplate VAppTemplate, network *types.NetworkConnectionSection, acceptAllEulas bool) (Task, error) {
 	return vapp.AddNewVMWithStorageProfile(name, vappTemplate, network, nil, acceptAllEulas)
@@ -1401,6 +1426,27 @@ func (client *Client) QueryVappList() ([]*types.QueryResultVAppRecordType, error
 	return vappList, nil
 }
 
+// QueryVappListByMetadata returns a list of the vApps in all the organizations available to the caller that match
+// all the given metadata filters, in the SYSTEM domain if isSystem is true, or in the GENERAL domain otherwise.
+// The map key is the metadata key.
+func (client *Client) QueryVappListByMetadata(metadataFilters map[string]MetadataFilter, isSystem bool) ([]*types.QueryResultVAppRecordType, error) {
+	var vappList []*types.QueryResultVAppRecordType
+	queryType := client.GetQueryType(types.QtVapp)
+	params := map[string]string{
+		"type":          queryType,
+		"filterEncoded": "true",
+	}
+	vappResult, err := client.queryByMetadataFilter(queryType, nil, params, metadataFilters, isSystem)
+	if err != nil {
+		return nil, fmt.Errorf("error getting vApp list : %s", err)
+	}
+	vappList = vappResult.Results.VAppRecord
+	if client.IsSysAdmin {
+		vappList = vappResult.Results.AdminVAppRecord
+	}
+	return vappList, nil
+}
+
 // getOrgInfo finds the organization to which the vApp belongs (through the VDC), and returns its name and ID
 func (vapp *VApp) getOrgInfo() (*TenantContext, error) {
 	previous, exists := orgInfoCache[vapp.VApp.ID]
@@ -555,6 +555,32 @@ func (vdc *Vdc) CreateRawVApp(name string, description string) (*VApp, error) {
 	return vapp, nil
 }
 
+// CreateRawVAppWithMetadata creates an empty vApp, like CreateRawVApp, and adds the given metadata to it before
+// returning. The VCD schema of ComposeVAppParams has no Metadata element, and VCD rejects unknown elements in the
+// compose request, so the metadata is merged in a single task right after the vApp is created. The metadata is
+// checked with validateNewEntityMetadata before creating the vApp, so entries that would be rejected don't leave an
+// untagged vApp behind. If merging the metadata fails, the created vApp is returned together with the error, so it
+// can be cleaned up.
+func (vdc *Vdc) CreateRawVAppWithMetadata(name string, description string, metadata map[string]types.MetadataValue) (*VApp, error) {
+	err := validateNewEntityMetadata(vdc.client, metadata)
+	if err != nil {
+		return nil, fmt.Errorf("error validating metadata for vApp %s: %s", name, err)
+	}
+
+	vapp, err := vdc.CreateRawVApp(name, description)
+	if err != nil {
+		return nil, err
+	}
+	if len(metadata) == 0 {
+		return vapp, nil
+	}
+	err = mergeMetadataAndWait(vapp.client, vapp.VApp.HREF, metadata)
+	if err != nil {
+		return vapp, fmt.Errorf("error adding metadata to vApp %s: %s", name, err)
+	}
+	return vapp, nil
+}
+
 // ComposeVApp creates a vapp with the given template, name, and description
 // that uses the storageprofile and networks given. If you want all eulas
 // to be accepted set acceptalleulas to true. Returns a successful task
@@ -18,6 +18,7 @@ type VdcGroup struct {
 	Href     string
 	client   *Client
 	parent   organization
+	OpenApiMetadataEntity
 }
 
 // CreateNsxtVdcGroup create NSX-T VDC group with provided VDC IDs.
@@ -119,6 +120,7 @@ func createVdcGroup(adminOrg *AdminOrg, vdcGroup *types.VdcGroup,
 		Href:     urlRef.String(),
 		parent:   adminOrg,
 	}
+	typeResponse.initOpenApiMetadata()
 
 	err = adminOrg.client.OpenApiPostItem(apiVersion, urlRef, nil,
 		vdcGroup, typeResponse.VdcGroup, additionalHeader)
@@ -228,6 +230,7 @@ func (adminOrg *AdminOrg) GetAllVdcGroups(queryParameters url.Values) ([]*VdcGro
 			Href:     urlRef.String(),
 			parent:   adminOrg,
 		}
+		wrappedVdcGroup.initOpenApiMetadata()
 		wrappedVdcGroups = append(wrappedVdcGroups, wrappedVdcGroup)
 	}
 
@@ -308,6 +311,7 @@ func (adminOrg *AdminOrg) GetVdcGroupById(id string) (*VdcGroup, error) {
 		Href:     urlRef.String(),
 		parent:   adminOrg,
 	}
+	vdcGroup.initOpenApiMetadata()
 
 	err = adminOrg.client.OpenApiGetItem(minimumApiVersion, urlRef, nil, vdcGroup.VdcGroup, getTenantContextHeader(tenantContext))
 	if err != nil {
@@ -345,6 +349,7 @@ func (org *Org) GetVdcGroupById(id string) (*VdcGroup, error) {
 		Href:     urlRef.String(),
 		parent:   org,
 	}
+	vdcGroup.initOpenApiMetadata()
 
 	err = org.client.OpenApiGetItem(minimumApiVersion, urlRef, nil, vdcGroup.VdcGroup, getTenantContextHeader(tenantContext))
 	if err != nil {
@@ -398,6 +403,7 @@ func (vdcGroup *VdcGroup) GenericUpdate() (*VdcGroup, error) {
 		Href:     vdcGroup.Href,
 		parent:   vdcGroup.parent,
 	}
+	returnVdcGroup.initOpenApiMetadata()
 
 	err = vdcGroup.client.OpenApiPutItem(minimumApiVersion, urlRef, nil, vdcGroup.VdcGroup,
 		returnVdcGroup.VdcGroup, getTenantContextHeader(tenantContext))
@@ -615,3 +621,27 @@ func (vdcGroup *VdcGroup) IsNsxt() bool {
 	networkProviderCapability := getCapabilityValue(vdcCapabilities, "networkProvider")
 	return networkProviderCapability == types.VdcCapabilityNetworkProviderNsxt
 }
+
+// initOpenApiMetadata makes the embedded OpenApiMetadataEntity manage the metadata of the receiver VdcGroup
+func (vdcGroup *VdcGroup) initOpenApiMetadata() {
+	vdcGroup.OpenApiMetadataEntity = *vdcGroup.openApiMetadata()
+}
+
+// openApiMetadata returns an OpenApiMetadataEntity that manages the metadata of the receiver VdcGroup, built from its
+// current fields, so the metadata methods also work when the embedded one was not initialized
+func (vdcGroup *VdcGroup) openApiMetadata() *OpenApiMetadataEntity {
+	return newOpenApiMetadataEntity(vdcGroup.client, types.OpenApiEndpointVdcGroupsMetadata,
+		func() string {
+			if vdcGroup.VdcGroup == nil {
+				return ""
+			}
+			return vdcGroup.VdcGroup.Id
+		},
+		func() (*TenantContext, error) {
+			// A VDC Group retrieved without its parent Org is managed without tenant context
+			if vdcGroup.parent == nil {
+				return nil, nil
+			}
+			return vdcGroup.getTenantContext()
+		})
+}
@@ -19,6 +19,7 @@ type VdcComputePolicyV2 struct {
 	VdcComputePolicyV2 *types.VdcComputePolicyV2
 	Href               string
 	client             *Client
+	OpenApiMetadataEntity
 }
 
 // GetVdcComputePolicyV2ById retrieves VDC Compute Policy (V2) by given ID
@@ -49,6 +50,7 @@ func getVdcComputePolicyV2ById(client *VCDClient, id string) (*VdcComputePolicyV
 		Href:               urlRef.String(),
 		client:             &client.Client,
 	}
+	vdcComputePolicy.initOpenApiMetadata()
 
 	err = client.Client.OpenApiGetItem(minimumApiVersion, urlRef, nil, vdcComputePolicy.VdcComputePolicyV2, nil)
 	if err != nil {
@@ -91,6 +93,7 @@ func getAllVdcComputePoliciesV2(client *VCDClient, queryParameters url.Values) (
 			client:             &client.Client,
 			VdcComputePolicyV2: response,
 		}
+		wrappedVdcComputePolicy.initOpenApiMetadata()
 		wrappedVdcComputePolicies = append(wrappedVdcComputePolicies, wrappedVdcComputePolicy)
 	}
 
@@ -114,6 +117,7 @@ func (client *VCDClient) CreateVdcComputePolicyV2(newVdcComputePolicy *types.Vdc
 		VdcComputePolicyV2: &types.VdcComputePolicyV2{},
 		client:             &client.Client,
 	}
+	returnVdcComputePolicy.initOpenApiMetadata()
 
 	err = client.Client.OpenApiPostItem(minimumApiVersion, urlRef, nil, newVdcComputePolicy, returnVdcComputePolicy.VdcComputePolicyV2, nil)
 	if err != nil {
@@ -144,6 +148,7 @@ func (vdcComputePolicy *VdcComputePolicyV2) Update() (*VdcComputePolicyV2, error
 		VdcComputePolicyV2: &types.VdcComputePolicyV2{},
 		client:             vdcComputePolicy.client,
 	}
+	returnVdcComputePolicy.initOpenApiMetadata()
 
 	err = vdcComputePolicy.client.OpenApiPutItem(minimumApiVersion, urlRef, nil, vdcComputePolicy.VdcComputePolicyV2, returnVdcComputePolicy.VdcComputePolicyV2, nil)
 	if err != nil {
@@ -222,6 +227,7 @@ func getAllAssignedVdcComputePoliciesV2(client *Client, vdcId string, queryParam
 			client:             client,
 			VdcComputePolicyV2: response,
 		}
+		wrappedVdcComputePolicy.initOpenApiMetadata()
 		wrappedVdcComputePolicies = append(wrappedVdcComputePolicies, wrappedVdcComputePolicy)
 	}
 
@@ -269,3 +275,25 @@ func getFriendlyErrorIfVmPlacementPolicyAlreadyExists(vmPlacementPolicyName stri
 	}
 	return err
 }
+
+// initOpenApiMetadata makes the embedded OpenApiMetadataEntity manage the metadata of the receiver VDC Compute Policy,
+// such as a VM Sizing Policy or a VM Placement Policy. Compute Policies are managed by the System administrator, so
+// the requests are sent without tenant context, and they require the rights to view or edit the Compute Policies.
+// The OpenAPI metadata endpoint of VDC Compute Policies requires VCD 10.5+, and a *MetadataNotSupportedError is
+// returned for older versions.
+func (vdcComputePolicy *VdcComputePolicyV2) initOpenApiMetadata() {
+	vdcComputePolicy.OpenApiMetadataEntity = *vdcComputePolicy.openApiMetadata()
+}
+
+// openApiMetadata returns an OpenApiMetadataEntity that manages the metadata of the receiver VdcComputePolicyV2, built from its
+// current fields, so the metadata methods also work when the embedded one was not initialized
+func (vdcComputePolicy *VdcComputePolicyV2) openApiMetadata() *OpenApiMetadataEntity {
+	return newOpenApiMetadataEntity(vdcComputePolicy.client, types.OpenApiEndpointVdcComputePoliciesMetadata,
+		func() string {
+			if vdcComputePolicy.VdcComputePolicyV2 == nil {
+				return ""
+			}
+			return vdcComputePolicy.VdcComputePolicyV2.ID
+		},
+		nil)
+}
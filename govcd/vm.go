@@ -19,6 +19,12 @@ import (
 type VM struct {
 	VM     *types.Vm
 	client *Client
+
+	// metadataCacheEnabled, metadataCache and metadataCacheGeneration hold the metadata cache of the VM.
+	// See VM.EnableMetadataCache
+	metadataCacheEnabled    bool
+	metadataCache           *types.Metadata
+	metadataCacheGeneration uint64
 }
 
 type VMRecord struct {
@@ -33,6 +39,26 @@ func NewVM(cli *Client) *VM {
 	}
 }
 
+// NewVMFromType wraps the given VM definition, like one obtained from a task or from another structure, in a VM that
+// uses the given client, so the VM methods can be called without retrieving the VM again. The wrapped definition is
+// not refreshed: only its HREF is required for the metadata methods, like GetMetadata or AddMetadataEntryWithVisibility,
+// which work the same for standalone VMs and for VMs that belong to a vApp.
+// Note: Standalone VMs use the same metadata endpoint as any other VM, available in all the supported API versions.
+// Managing metadata requires the rights to view or edit the VM, and SYSTEM metadata requires system administrator
+// privileges.
+func NewVMFromType(client *Client, vm *types.Vm) (*VM, error) {
+	if client == nil {
+		return nil, fmt.Errorf("cannot wrap VM: client is nil")
+	}
+	if vm == nil || vm.HREF == "" {
+		return nil, fmt.Errorf("cannot wrap VM: its definition is empty or has no HREF")
+	}
+	return &VM{
+		VM:     vm,
+		client: client,
+	}, nil
+}
+
 // NewVMRecord creates an instance with reference to types.QueryResultVMRecordType
 func NewVMRecord(cli *Client) *VMRecord {
 	return &VMRecord{
@@ -1650,8 +1676,32 @@ func (vdc *Vdc) QueryVmList(filter types.VmQueryFilter) ([]*types.QueryResultVMR
 	return queryVmList(filter, vdc.client, "vdc", vdc.Vdc.HREF)
 }
 
+// QueryVmListByMetadata returns a list of the VMs in a given Org that match all the given metadata filters, in the
+// SYSTEM domain if isSystem is true, or in the GENERAL domain otherwise. The map key is the metadata key.
+func (org *Org) QueryVmListByMetadata(filter types.VmQueryFilter, metadataFilters map[string]MetadataFilter, isSystem bool) ([]*types.QueryResultVMRecordType, error) {
+	if len(metadataFilters) == 0 {
+		return nil, fmt.Errorf("no metadata filters provided")
+	}
+	return queryVmListByMetadata(filter, org.client, "org", org.Org.HREF, metadataFilters, isSystem)
+}
+
+// QueryVmListByMetadata returns a list of the VMs in a given VDC that match all the given metadata filters, in the
+// SYSTEM domain if isSystem is true, or in the GENERAL domain otherwise. The map key is the metadata key.
+func (vdc *Vdc) QueryVmListByMetadata(filter types.VmQueryFilter, metadataFilters map[string]MetadataFilter, isSystem bool) ([]*types.QueryResultVMRecordType, error) {
+	if len(metadataFilters) == 0 {
+		return nil, fmt.Errorf("no metadata filters provided")
+	}
+	return queryVmListByMetadata(filter, vdc.client, "vdc", vdc.Vdc.HREF, metadataFilters, isSystem)
+}
+
 // queryVmList is extracted and used by org.QueryVmList and vdc.QueryVmList to adjust filtering scope
 func queryVmList(filter types.VmQueryFilter, client *Client, filterParent, filterParentHref string) ([]*types.QueryResultVMRecordType, error) {
+	return queryVmListByMetadata(filter, client, filterParent, filterParentHref, nil, false)
+}
+
+// queryVmListByMetadata works like queryVmList, running the query with queryByMetadataFilter when there are metadata
+// filters
+func queryVmListByMetadata(filter types.VmQueryFilter, client *Client, filterParent, filterParentHref string, metadataFilters map[string]MetadataFilter, isSystem bool) ([]*types.QueryResultVMRecordType, error) {
 	var vmList []*types.QueryResultVMRecordType
 	queryType := client.GetQueryType(types.QtVm)
 	params := map[string]string{
@@ -1668,7 +1718,13 @@ func queryVmList(filter types.VmQueryFilter, client *Client, filterParent, filte
 		filterText = fmt.Sprintf("%s;%s==%s", filterText, filterParent, filterParentHref)
 	}
 	params["filter"] = filterText
-	vmResult, err := client.cumulativeQuery(queryType, nil, params)
+	var vmResult Results
+	var err error
+	if len(metadataFilters) == 0 {
+		vmResult, err = client.cumulativeQuery(queryType, nil, params)
+	} else {
+		vmResult, err = client.queryByMetadataFilter(queryType, nil, params, metadataFilters, isSystem)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error getting VM list : %s", err)
 	}
@@ -359,27 +359,34 @@ const (
 	OpenApiEndpointImportableSwitches                 = "/network/orgvdcnetworks/importableswitches"
 	OpenApiEndpointEdgeClusters                       = "nsxTResources/edgeClusters"
 	OpenApiEndpointExternalNetworks                   = "externalNetworks/"
+	OpenApiEndpointExternalNetworksMetadata           = "externalNetworks/%s/metadata/"
 	OpenApiEndpointVdcComputePolicies                 = "vdcComputePolicies/"
+	OpenApiEndpointVdcComputePoliciesMetadata         = "vdcComputePolicies/%s/metadata/"
 	OpenApiEndpointVdcAssignedComputePolicies         = "vdcs/%s/computePolicies"
 	OpenApiEndpointVdcCapabilities                    = "vdcs/%s/capabilities"
 	OpenApiEndpointVdcNetworkProfile                  = "vdcs/%s/networkProfile"
 	OpenApiEndpointEdgeGateways                       = "edgeGateways/"
 	OpenApiEndpointNsxtFirewallRules                  = "edgeGateways/%s/firewall/rules"
+	OpenApiEndpointEdgeGatewaysMetadata               = "edgeGateways/%s/metadata/"
 	OpenApiEndpointFirewallGroups                     = "firewallGroups/"
 	OpenApiEndpointOrgVdcNetworks                     = "orgVdcNetworks/"
 	OpenApiEndpointOrgVdcNetworksDhcp                 = "orgVdcNetworks/%s/dhcp"
+	OpenApiEndpointOrgVdcNetworksMetadata             = "orgVdcNetworks/%s/metadata/"
+	OpenApiEndpointDisksMetadata                      = "disks/%s/metadata/"
 	OpenApiEndpointNsxtNatRules                       = "edgeGateways/%s/nat/rules/"
 	OpenApiEndpointAppPortProfiles                    = "applicationPortProfiles/"
 	OpenApiEndpointIpSecVpnTunnel                     = "edgeGateways/%s/ipsec/tunnels/"
 	OpenApiEndpointIpSecVpnTunnelConnectionProperties = "edgeGateways/%s/ipsec/tunnels/%s/connectionProperties"
 	OpenApiEndpointIpSecVpnTunnelStatus               = "edgeGateways/%s/ipsec/tunnels/%s/status"
 	OpenApiEndpointSSLCertificateLibrary              = "ssl/certificateLibrary/"
+	OpenApiEndpointSSLCertificateLibraryMetadata      = "ssl/certificateLibrary/%s/metadata/"
 	OpenApiEndpointSSLCertificateLibraryOld           = "ssl/cetificateLibrary/"
 	OpenApiEndpointSessionCurrent                     = "sessions/current"
 	OpenApiEndpointVdcGroups                          = "vdcGroups/"
 	OpenApiEndpointVdcGroupsCandidateVdcs             = "vdcGroups/networkingCandidateVdcs"
 	OpenApiEndpointVdcGroupsDfwPolicies               = "vdcGroups/%s/dfwPolicies"
 	OpenApiEndpointVdcGroupsDfwDefaultPolicies        = "vdcGroups/%s/dfwPolicies/default"
+	OpenApiEndpointVdcGroupsMetadata                  = "vdcGroups/%s/metadata/"
 	OpenApiEndpointVdcGroupsDfwRules                  = "vdcGroups/%s/dfwPolicies/%s/rules"
 	OpenApiEndpointLogicalVmGroups                    = "logicalVmGroups/"
 	OpenApiEndpointNetworkContextProfiles             = "networkContextProfiles"
@@ -390,6 +397,7 @@ const (
 	OpenApiEndpointEdgeBgpConfigPrefixLists           = "edgeGateways/%s/routing/bgp/prefixLists/" // '%s' is NSX-T Edge Gateway ID
 	OpenApiEndpointEdgeBgpConfig                      = "edgeGateways/%s/routing/bgp"              // '%s' is NSX-T Edge Gateway ID
 	OpenApiEndpointRdeInterfaces                      = "interfaces/"
+	OpenApiEndpointRdeEntitiesMetadata                = "entities/%s/metadata/"
 
 	// NSX-T ALB related endpoints
 
@@ -400,6 +408,7 @@ const (
 	OpenApiEndpointAlbImportableServiceEngineGroups = "nsxAlbResources/importableServiceEngineGroups"
 	OpenApiEndpointAlbCloud                         = "loadBalancer/clouds/"
 	OpenApiEndpointAlbServiceEngineGroups           = "loadBalancer/serviceEngineGroups/"
+	OpenApiEndpointAlbServiceEngineGroupsMetadata   = "loadBalancer/serviceEngineGroups/%s/metadata/"
 	OpenApiEndpointAlbPools                         = "loadBalancer/pools/"
 	// OpenApiEndpointAlbPoolSummaries returns a limited subset of data provided by OpenApiEndpointAlbPools
 	// however only the summary endpoint can list all available pools for an edge gateway
@@ -522,6 +531,19 @@ const (
 	MetadataReadOnlyVisibility  string = "READONLY"
 	MetadataHiddenVisibility    string = "PRIVATE"
 	MetadataReadWriteVisibility string = "READWRITE"
+
+	// MetadataPrivateVisibility is the name given by VCD to MetadataHiddenVisibility: SYSTEM entries that tenants can't see
+	MetadataPrivateVisibility string = MetadataHiddenVisibility
+)
+
+// OpenAPI metadata constants
+const (
+	OpenApiMetadataStringEntry  string = "StringEntry"
+	OpenApiMetadataNumberEntry  string = "NumberEntry"
+	OpenApiMetadataBooleanEntry string = "BoolEntry"
+
+	OpenApiMetadataTenantDomain   string = "TENANT"
+	OpenApiMetadataProviderDomain string = "PROVIDER"
 )
 
 const (
@@ -436,3 +436,26 @@ type DefinedInterface struct {
 	Vendor     string `json:"vendor,omitempty"`   // The vendor name
 	IsReadOnly bool   `json:"readonly,omitempty"` // True if the entity type cannot be modified
 }
+
+// OpenApiMetadataEntry represents a metadata entry of an entity in the OpenAPI. Its structure differs from
+// MetadataEntry, which is used by the XML API
+type OpenApiMetadataEntry struct {
+	ID           string                  `json:"id,omitempty"`         // UUID of the metadata entry
+	IsPersistent bool                    `json:"persistent,omitempty"` // If true, the entry is kept when the entity is copied
+	IsReadOnly   bool                    `json:"readOnly,omitempty"`   // True if the entry can't be modified by tenants
+	KeyValue     OpenApiMetadataKeyValue `json:"keyValue"`
+}
+
+// OpenApiMetadataKeyValue contains the key, value and domain of an OpenApiMetadataEntry
+type OpenApiMetadataKeyValue struct {
+	Domain    string                    `json:"domain,omitempty"`    // One of TENANT or PROVIDER
+	Key       string                    `json:"key,omitempty"`       // Key of the metadata entry
+	Value     OpenApiMetadataTypedValue `json:"value"`               // Value of the metadata entry
+	Namespace string                    `json:"namespace,omitempty"` // Optional namespace of the metadata entry
+}
+
+// OpenApiMetadataTypedValue contains the value of an OpenApiMetadataEntry and its type
+type OpenApiMetadataTypedValue struct {
+	Value interface{} `json:"value,omitempty"` // A string, number or boolean, depending on Type
+	Type  string      `json:"type,omitempty"`  // One of StringEntry, NumberEntry or BoolEntry
+}
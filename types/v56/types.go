@@ -1458,6 +1458,16 @@ type MetadataValue struct {
 	TypedValue *MetadataTypedValue `xml:"TypedValue"`
 }
 
+// Equal returns true if both metadata values have the same type, value, domain and visibility. Values are compared
+// as they are written, so "01" and "1" are different numbers. A missing Domain is the same as GENERAL domain with
+// MetadataReadWriteVisibility, as VCD omits it in that case. Two nil values are equal.
+func (mv *MetadataValue) Equal(other *MetadataValue) bool {
+	if mv == nil || other == nil {
+		return mv == other
+	}
+	return metadataTypedValuesEqual(mv.TypedValue, other.TypedValue) && metadataDomainsEqual(mv.Domain, other.Domain)
+}
+
 // MetadataTypedValue is the content of a metadata entry.
 // Type: MetadataTypedValue
 // Namespace: http://www.vmware.com/vcloud/v1.5
@@ -1486,6 +1496,36 @@ type Metadata struct {
 	MetadataEntry []*MetadataEntry `xml:"MetadataEntry,omitempty"`
 }
 
+// GetByKey returns the metadata entry with the given key in the SYSTEM domain if isSystem=true, or in the GENERAL
+// domain otherwise, and whether it was found. Entries without a Domain tag belong to the GENERAL domain.
+func (m *Metadata) GetByKey(key string, isSystem bool) (*MetadataEntry, bool) {
+	if m == nil {
+		return nil, false
+	}
+	for _, entry := range m.MetadataEntry {
+		if entry != nil && entry.Key == key && entry.isSystem() == isSystem {
+			return entry, true
+		}
+	}
+	return nil, false
+}
+
+// Keys returns the keys of the metadata entries in the SYSTEM domain if isSystem=true, or in the GENERAL domain
+// otherwise, sorted alphabetically
+func (m *Metadata) Keys(isSystem bool) []string {
+	if m == nil {
+		return nil
+	}
+	var keys []string
+	for _, entry := range m.MetadataEntry {
+		if entry != nil && entry.isSystem() == isSystem {
+			keys = append(keys, entry.Key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // MetadataEntry is a single metadata entry.
 // Type: MetadataEntryType
 // Namespace: http://www.vmware.com/vcloud/v1.5
@@ -1500,6 +1540,45 @@ type MetadataEntry struct {
 	TypedValue *MetadataTypedValue `xml:"TypedValue"`
 }
 
+// isSystem returns true if the metadata entry belongs to the SYSTEM domain
+func (entry *MetadataEntry) isSystem() bool {
+	return entry.Domain != nil && entry.Domain.Domain == "SYSTEM"
+}
+
+// Equal returns true if both metadata entries have the same key, type, value, domain and visibility, with the same
+// rules as MetadataValue.Equal. Other fields, like HREF or Link, are ignored. Two nil entries are equal.
+func (entry *MetadataEntry) Equal(other *MetadataEntry) bool {
+	if entry == nil || other == nil {
+		return entry == other
+	}
+	return entry.Key == other.Key && metadataTypedValuesEqual(entry.TypedValue, other.TypedValue) &&
+		metadataDomainsEqual(entry.Domain, other.Domain)
+}
+
+// metadataTypedValuesEqual returns true if both typed values have the same type and value, or if both are nil
+func metadataTypedValuesEqual(value, other *MetadataTypedValue) bool {
+	if value == nil || other == nil {
+		return value == other
+	}
+	return value.XsiType == other.XsiType && value.Value == other.Value
+}
+
+// metadataDomainsEqual returns true if both domain tags have the same domain and visibility. A missing tag or domain
+// is GENERAL, and a missing visibility is MetadataReadWriteVisibility, as VCD omits them in that case.
+func metadataDomainsEqual(domain, other *MetadataDomainTag) bool {
+	effectiveDomain := func(tag *MetadataDomainTag) MetadataDomainTag {
+		effective := MetadataDomainTag{Domain: "GENERAL", Visibility: MetadataReadWriteVisibility}
+		if tag != nil && tag.Domain != "" {
+			effective.Domain = tag.Domain
+		}
+		if tag != nil && tag.Visibility != "" {
+			effective.Visibility = tag.Visibility
+		}
+		return effective
+	}
+	return effectiveDomain(domain) == effectiveDomain(other)
+}
+
 // MetadataDomainTag contains both the visibility and the domain of the metadata.
 // Type: MetadataDomainTagType
 // Namespace: http://www.vmware.com/vcloud/v1.5
@@ -1510,6 +1589,69 @@ type MetadataDomainTag struct {
 	Domain     string `xml:",chardata"`
 }
 
+// MetadataType is the type of a metadata value, as it is set in MetadataTypedValue.XsiType. It allows checking at
+// compile time what is otherwise passed as a bare string.
+type MetadataType string
+
+// Valid metadata types
+const (
+	MetadataTypeString   MetadataType = MetadataType(MetadataStringValue)
+	MetadataTypeNumber   MetadataType = MetadataType(MetadataNumberValue)
+	MetadataTypeDateTime MetadataType = MetadataType(MetadataDateTimeValue)
+	MetadataTypeBoolean  MetadataType = MetadataType(MetadataBooleanValue)
+)
+
+// IsValid returns true if the MetadataType is one of the types supported by VCD. It's the list of valid types used
+// to validate every metadata entry, including the ones given as plain strings.
+func (metadataType MetadataType) IsValid() bool {
+	switch metadataType {
+	case MetadataTypeString, MetadataTypeNumber, MetadataTypeDateTime, MetadataTypeBoolean:
+		return true
+	}
+	return false
+}
+
+// ParseMetadataType converts the given string, like MetadataStringValue, to a MetadataType, returning an error if it
+// is not a valid type
+func ParseMetadataType(value string) (MetadataType, error) {
+	metadataType := MetadataType(value)
+	if !metadataType.IsValid() {
+		return "", fmt.Errorf("invalid metadata type '%s'", value)
+	}
+	return metadataType, nil
+}
+
+// MetadataVisibility is the visibility of a metadata entry, as it is set in MetadataDomainTag.Visibility. It allows
+// checking at compile time what is otherwise passed as a bare string.
+type MetadataVisibility string
+
+// Valid metadata visibilities
+const (
+	MetadataVisibilityReadOnly  MetadataVisibility = MetadataVisibility(MetadataReadOnlyVisibility)
+	MetadataVisibilityHidden    MetadataVisibility = MetadataVisibility(MetadataHiddenVisibility)
+	MetadataVisibilityReadWrite MetadataVisibility = MetadataVisibility(MetadataReadWriteVisibility)
+)
+
+// IsValid returns true if the MetadataVisibility is one of the visibilities supported by VCD. It's the list of valid
+// visibilities used to validate every metadata entry, including the ones given as plain strings.
+func (visibility MetadataVisibility) IsValid() bool {
+	switch visibility {
+	case MetadataVisibilityReadOnly, MetadataVisibilityHidden, MetadataVisibilityReadWrite:
+		return true
+	}
+	return false
+}
+
+// ParseMetadataVisibility converts the given string, like MetadataReadOnlyVisibility, to a MetadataVisibility,
+// returning an error if it is not a valid visibility
+func ParseMetadataVisibility(value string) (MetadataVisibility, error) {
+	visibility := MetadataVisibility(value)
+	if !visibility.IsValid() {
+		return "", fmt.Errorf("invalid metadata visibility '%s'", value)
+	}
+	return visibility, nil
+}
+
 // VAppChildren is a container for virtual machines included in this vApp.
 // Type: VAppChildrenType
 // Namespace: http://www.vmware.com/vcloud/v1.5